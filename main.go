@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
@@ -54,6 +55,18 @@ func main() {
 		logger.Fatalf("%s", err)
 	}
 
+	if opts.Providers[0].ClientSecretFileWatch {
+		if err := opts.GetProvider().Data().WatchClientSecretFile(context.Background()); err != nil {
+			logger.Fatalf("ERROR: Failed to watch client secret file: %v", err)
+		}
+	}
+
+	if opts.GetProvider().Data().AllowedGroupsURL != nil {
+		if err := opts.GetProvider().Data().WatchAllowedGroupsURL(context.Background()); err != nil {
+			logger.Fatalf("ERROR: Failed to fetch the allowed groups: %v", err)
+		}
+	}
+
 	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
 	oauthproxy, err := NewOAuthProxy(opts, validator)
 	if err != nil {
@@ -65,6 +65,8 @@ providers:
   clientSecret: b2F1dGgyLXByb3h5LWNsaWVudC1zZWNyZXQK
   clientID: oauth2-proxy
   approvalPrompt: force
+  hstsMaxAge: 8760h
+  profileURLCacheTTL: 30s
   azureConfig:
     tenant: common
   oidcConfig:
@@ -144,7 +146,9 @@ redirect_url="http://localhost:4180/oauth2/callback"
 					UserIDClaim:       "email",
 					InsecureSkipNonce: true,
 				},
-				ApprovalPrompt: "force",
+				ApprovalPrompt:     "force",
+				HSTSMaxAge:         options.Duration(365 * 24 * time.Hour),
+				ProfileURLCacheTTL: options.Duration(30 * time.Second),
 			},
 		}
 		return opts
@@ -230,7 +234,7 @@ redirect_url="http://localhost:4180/oauth2/callback"
 			configContent:      testCoreConfig,
 			alphaConfigContent: testAlphaConfig + ":",
 			expectedOptions:    func() *options.Options { return nil },
-			expectedErr:        errors.New("failed to load alpha options: error unmarshalling config: error converting YAML to JSON: yaml: line 49: did not find expected key"),
+			expectedErr:        errors.New("failed to load alpha options: error unmarshalling config: error converting YAML to JSON: yaml: line 51: did not find expected key"),
 		}),
 		Entry("with alpha configuration and bad core configuration", loadConfigurationTableInput{
 			configContent:      testCoreConfig + "unknown_field=\"something\"",
@@ -181,6 +181,15 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		Validator:   redirectValidator,
 	})
 
+	// The validator only matches exact email domains, subdomains of the
+	// email domains starting with a `.` are matched by the provider
+	if provider := opts.GetProvider(); provider != nil && len(provider.Data().EmailDomainSuffix) > 0 {
+		domainValidator := validator
+		validator = func(email string) bool {
+			return domainValidator(email) || provider.Data().MatchesEmailDomain(email)
+		}
+	}
+
 	p := &OAuthProxy{
 		CookieOptions: &opts.Cookie,
 		Validator:     validator,
@@ -270,12 +279,12 @@ func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 	r.Use(p.preAuthChain.Then)
 
 	// Register the robots path writer
-	r.Path(robotsPath).HandlerFunc(p.pageWriter.WriteRobotsTxt)
+	r.Path(robotsPath).Handler(p.hstsMiddleware(http.HandlerFunc(p.pageWriter.WriteRobotsTxt)))
 
 	// The authonly path should be registered separately to prevent it from getting no-cache headers.
 	// We do this to allow users to have a short cache (via nginx) of the response to reduce the
 	// likelihood of multiple reuests trying to referesh sessions simultaneously.
-	r.Path(proxyPrefix + authOnlyPath).Handler(p.sessionChain.ThenFunc(p.AuthOnly))
+	r.Path(proxyPrefix + authOnlyPath).Handler(p.hstsMiddleware(p.sessionChain.ThenFunc(p.AuthOnly)))
 
 	// This will register all of the paths under the proxy prefix, except the auth only path so that no cache headers
 	// are not applied.
@@ -288,7 +297,7 @@ func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 }
 
 func (p *OAuthProxy) buildProxySubrouter(s *mux.Router) {
-	s.Use(prepareNoCacheMiddleware)
+	s.Use(prepareNoCacheMiddleware, p.hstsMiddleware)
 
 	s.Path(signInPath).HandlerFunc(p.SignIn)
 	s.Path(signOutPath).HandlerFunc(p.SignOut)
@@ -356,15 +365,21 @@ func buildSessionChain(opts *options.Options, sessionStore sessionsapi.SessionSt
 		chain = chain.Append(middleware.NewJwtSessionLoader(sessionLoaders))
 	}
 
+	if alb, ok := opts.GetProvider().(*providers.ALBProvider); ok {
+		chain = chain.Append(middleware.NewRequestSessionLoader(alb.CreateSessionFromRequest))
+	}
+
 	if validator != nil {
 		chain = chain.Append(middleware.NewBasicAuthSessionLoader(validator, opts.HtpasswdUserGroups, opts.LegacyPreferEmailToUser))
 	}
 
 	chain = chain.Append(middleware.NewStoredSessionLoader(&middleware.StoredSessionLoaderOptions{
-		SessionStore:    sessionStore,
-		RefreshPeriod:   opts.Cookie.Refresh,
-		RefreshSession:  opts.GetProvider().RefreshSession,
-		ValidateSession: opts.GetProvider().ValidateSession,
+		SessionStore:       sessionStore,
+		RefreshPeriod:      opts.Cookie.Refresh,
+		RefreshSession:     opts.GetProvider().RefreshSession,
+		ValidateSession:    opts.GetProvider().ValidateSession,
+		RefreshOnActivity:  opts.GetProvider().Data().CookieRefreshOnActivity,
+		MaxSessionDuration: opts.GetProvider().Data().MaxSessionDuration,
 	}))
 
 	return chain
@@ -381,7 +396,11 @@ func buildHeadersChain(opts *options.Options) (alice.Chain, error) {
 		return alice.Chain{}, fmt.Errorf("error constructing request header injector: %v", err)
 	}
 
-	return alice.New(requestInjector, responseInjector), nil
+	chain := alice.New(requestInjector, responseInjector)
+	if provider := opts.GetProvider(); provider != nil && provider.Data().ForwardAllClaims {
+		chain = chain.Append(middleware.NewAllClaimsRequestHeaderInjector(provider.Data().ForwardExtraClaimsPrefix))
+	}
+	return chain, nil
 }
 
 func buildSignInMessage(opts *options.Options) string {
@@ -648,6 +667,7 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
+	p.revokeSessionTokens(req)
 	err = p.ClearSessionCookie(rw, req)
 	if err != nil {
 		logger.Errorf("Error clearing session cookie: %v", err)
@@ -657,11 +677,29 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 	http.Redirect(rw, req, redirect, http.StatusFound)
 }
 
+// revokeSessionTokens revokes the refresh and access tokens of the session
+// being signed out. Failures are only logged so that users can always sign
+// out.
+func (p *OAuthProxy) revokeSessionTokens(req *http.Request) {
+	if revokeURL := p.provider.Data().RevokeEndpoint; revokeURL == nil || revokeURL.String() == "" {
+		return
+	}
+	session, err := p.LoadCookiedSession(req)
+	if err != nil || session == nil {
+		return
+	}
+	for _, token := range []string{session.RefreshToken, session.AccessToken} {
+		if err := p.provider.RevokeToken(req.Context(), token); err != nil {
+			logger.Errorf("Error revoking token of %s: %v", session.Email, err)
+		}
+	}
+}
+
 // OAuthStart starts the OAuth2 authentication flow
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 	prepareNoCache(rw)
 
-	csrf, err := cookies.NewCSRF(p.CookieOptions)
+	csrf, err := cookies.NewCSRF(p.CookieOptions, p.provider.Data().GetNonceLength())
 	if err != nil {
 		logger.Errorf("Error creating CSRF nonce: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
@@ -678,9 +716,22 @@ func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 	callbackRedirect := p.getOAuthRedirectURI(req)
 	loginURL := p.provider.GetLoginURL(
 		callbackRedirect,
-		encodeState(csrf.HashOAuthState(), appRedirect),
+		encodeState(p.provider.Data().NewStateToken(csrf.HashOAuthState()), appRedirect),
 		csrf.HashOIDCNonce(),
 	)
+	if p.provider.Data().PKCEEnabled {
+		verifier, err := providers.NewCodeVerifier()
+		if err != nil {
+			logger.Errorf("Error creating PKCE code verifier: %v", err)
+			p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+			return
+		}
+		csrf.SetCodeVerifier(verifier)
+		loginURL = p.provider.Data().AddCodeChallenge(loginURL, verifier)
+	}
+	if scope := middlewareapi.GetRequestScope(req); scope != nil {
+		loginURL = p.provider.Data().AddLoginHintFromSession(loginURL, scope.ExpiredSession)
+	}
 
 	if _, err := csrf.SetCookie(rw, req); err != nil {
 		logger.Errorf("Error setting CSRF cookie: %v", err)
@@ -712,6 +763,13 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	err = p.provider.Data().ValidateCallbackURI(req.URL, p.getOAuthRedirectURI(req))
+	if err != nil {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Invalid authentication via OAuth2: %v, potential attack", err)
+		p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: The callback URL is invalid. Please try again.")
+		return
+	}
+
 	session, err := p.redeemCode(req)
 	if err != nil {
 		logger.Errorf("Error redeeming code during OAuth2 callback: %v", err)
@@ -719,8 +777,9 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = p.enrichSessionState(req.Context(), session)
+	err = p.enrichSessionState(providers.ContextWithRequest(req.Context(), req), session)
 	if err != nil {
+		p.provider.Data().AuditDenied(p.auditContext(req), session, err.Error())
 		logger.Errorf("Error creating session during OAuth2 callback: %v", err)
 		p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
@@ -742,7 +801,13 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if !csrf.CheckOAuthState(nonce) {
+	err = p.provider.Data().ValidateStateToken(nonce, csrf.HashOAuthState())
+	if errors.Is(err, providers.ErrStateExpired) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: OAuth state expired")
+		p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: The login took too long to complete. Please try again.")
+		return
+	}
+	if err != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: CSRF token mismatch, potential attack")
 		p.ErrorPage(rw, req, http.StatusForbidden, "CSRF token mismatch, potential attack", "Login Failed: Unable to find a valid CSRF token. Please try again.")
 		return
@@ -756,11 +821,11 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// set cookie, or deny
-	authorized, err := p.provider.Authorize(req.Context(), session)
+	authorized, err := p.authorizeSession(req, session, !p.Validator(session.Email))
 	if err != nil {
 		logger.Errorf("Error with authorization: %v", err)
 	}
-	if p.Validator(session.Email) && authorized {
+	if authorized {
 		logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via OAuth2: %s", session)
 		err := p.SaveSession(rw, req, session)
 		if err != nil {
@@ -781,8 +846,21 @@ func (p *OAuthProxy) redeemCode(req *http.Request) (*sessionsapi.SessionState, e
 		return nil, providers.ErrMissingCode
 	}
 
+	ctx := p.auditContext(req)
+	if p.provider.Data().PKCEEnabled {
+		// The code verifier is kept in the CSRF cookie until the code is
+		// redeemed, the cookie itself is validated after redemption
+		csrf, err := cookies.LoadCSRFCookie(req, p.CookieOptions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to obtain PKCE code verifier: %v", err)
+		}
+		ctx = providers.ContextWithCodeVerifier(ctx, csrf.GetCodeVerifier())
+	}
+
 	redirectURI := p.getOAuthRedirectURI(req)
-	s, err := p.provider.Redeem(req.Context(), redirectURI, code)
+	s, err := p.provider.Data().RedeemOnce(ctx, redirectURI, code, func(ctx context.Context) (*sessionsapi.SessionState, error) {
+		return p.provider.Redeem(ctx, redirectURI, code)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -798,6 +876,22 @@ func (p *OAuthProxy) redeemCode(req *http.Request) (*sessionsapi.SessionState, e
 	return s, nil
 }
 
+// authorizeSession authorizes the session with the provider, unless its email
+// was already rejected, so that the audited decision is the final one
+func (p *OAuthProxy) authorizeSession(req *http.Request, s *sessionsapi.SessionState, invalidEmail bool) (bool, error) {
+	if invalidEmail {
+		p.provider.Data().AuditDenied(p.auditContext(req), s, "email is not allowed")
+		return false, nil
+	}
+	return p.provider.Authorize(p.auditContext(req), s)
+}
+
+// auditContext returns the context of the request with the client IP added,
+// for the provider's AuditLogger
+func (p *OAuthProxy) auditContext(req *http.Request) context.Context {
+	return providers.ContextWithSourceIP(req.Context(), ip.GetClientString(p.realClientIPParser, req, false))
+}
+
 func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.SessionState) error {
 	var err error
 	if s.Email == "" {
@@ -890,6 +984,19 @@ func prepareNoCacheMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// hstsMiddleware sets the Strict-Transport-Security header on responses from
+// the proxy's own endpoints. Proxied responses are left to the upstream.
+func (p *OAuthProxy) hstsMiddleware(next http.Handler) http.Handler {
+	hsts := p.provider.Data().HSTSHeader()
+	if hsts == "" {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Strict-Transport-Security", hsts)
+		next.ServeHTTP(rw, req)
+	})
+}
+
 // getOAuthRedirectURI returns the redirectURL that the upstream OAuth Provider will
 // redirect clients to once authenticated.
 // This is usually the OAuthProxy callback URL.
@@ -935,12 +1042,12 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 	}
 
 	invalidEmail := session.Email != "" && !p.Validator(session.Email)
-	authorized, err := p.provider.Authorize(req.Context(), session)
+	authorized, err := p.authorizeSession(req, session, invalidEmail)
 	if err != nil {
 		logger.Errorf("Error with authorization: %v", err)
 	}
 
-	if invalidEmail || !authorized {
+	if !authorized {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authorization via session: removing session %s", session)
 		// Invalid session, clear it
 		err := p.ClearSessionCookie(rw, req)
@@ -57,6 +57,50 @@ func TestRobotsTxt(t *testing.T) {
 	assert.Equal(t, "User-agent: *\nDisallow: /\n", rw.Body.String())
 }
 
+func TestHSTSHeader(t *testing.T) {
+	testCases := map[string]struct {
+		maxAge            time.Duration
+		includeSubdomains bool
+		expectedHeader    string
+	}{
+		"default max age": {
+			maxAge:         providers.DefaultHSTSMaxAge,
+			expectedHeader: "max-age=31536000",
+		},
+		"include subdomains": {
+			maxAge:            time.Hour,
+			includeSubdomains: true,
+			expectedHeader:    "max-age=3600; includeSubDomains",
+		},
+		"disabled": {
+			maxAge:         0,
+			expectedHeader: "",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := baseTestOptions()
+			opts.Providers[0].HSTSMaxAge = options.Duration(tc.maxAge)
+			opts.Providers[0].HSTSIncludeSubdomains = tc.includeSubdomains
+			err := validation.Validate(opts)
+			assert.NoError(t, err)
+
+			proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, path := range []string{"/robots.txt", "/oauth2/sign_in"} {
+				rw := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", path, nil)
+				proxy.ServeHTTP(rw, req)
+				assert.Equal(t, tc.expectedHeader, rw.Header().Get("Strict-Transport-Security"), path)
+			}
+		})
+	}
+}
+
 type TestProvider struct {
 	*providers.ProviderData
 	EmailAddress   string
@@ -398,7 +442,7 @@ func (patTest *PassAccessTokenTest) Close() {
 func (patTest *PassAccessTokenTest) getCallbackEndpoint() (httpCode int, cookie string) {
 	rw := httptest.NewRecorder()
 
-	csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions)
+	csrf, err := cookies.NewCSRF(patTest.proxy.CookieOptions, patTest.proxy.provider.Data().GetNonceLength())
 	if err != nil {
 		panic(err)
 	}
@@ -407,7 +451,7 @@ func (patTest *PassAccessTokenTest) getCallbackEndpoint() (httpCode int, cookie
 		http.MethodGet,
 		fmt.Sprintf(
 			"/oauth2/callback?code=callback_code&state=%s",
-			encodeState(csrf.HashOAuthState(), "%2F"),
+			encodeState(patTest.proxy.provider.Data().NewStateToken(csrf.HashOAuthState()), "%2F"),
 		),
 		strings.NewReader(""),
 	)
@@ -755,6 +799,58 @@ func TestLoadCookiedSession(t *testing.T) {
 	assert.Equal(t, startSession.AccessToken, session.AccessToken)
 }
 
+func TestSignOutRevokesTokens(t *testing.T) {
+	var revoked []string
+	revokeServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		revoked = append(revoked, req.FormValue("token"))
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer revokeServer.Close()
+	revokeURL, err := url.Parse(revokeServer.URL)
+	assert.NoError(t, err)
+
+	pcTest, err := NewProcessCookieTestWithDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcTest.proxy.provider.Data().RevokeEndpoint = revokeURL
+
+	created := time.Now()
+	err = pcTest.SaveSession(&sessions.SessionState{
+		Email:        "john.doe@example.com",
+		AccessToken:  "my_access_token",
+		RefreshToken: "my_refresh_token",
+		CreatedAt:    &created,
+	})
+	assert.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/oauth2/sign_out", nil)
+	for _, cookie := range pcTest.req.Cookies() {
+		req.AddCookie(cookie)
+	}
+	pcTest.proxy.SignOut(rw, req)
+
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, []string{"my_refresh_token", "my_access_token"}, revoked)
+}
+
+func TestEmailDomainSuffixValidator(t *testing.T) {
+	opts := baseTestOptions()
+	opts.EmailDomains = []string{"example.com", ".internal.corp.example.com"}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, NewValidator(opts.EmailDomains, ""))
+	assert.NoError(t, err)
+
+	assert.True(t, proxy.Validator("jane@example.com"))
+	assert.True(t, proxy.Validator("jane@eu.internal.corp.example.com"))
+	assert.False(t, proxy.Validator("jane@evilinternal.corp.example.com"))
+	assert.False(t, proxy.Validator("jane@internal.corp.example.com"))
+	assert.False(t, proxy.Validator("jane@example.org"))
+}
+
 func TestProcessCookieNoCookieError(t *testing.T) {
 	pcTest, err := NewProcessCookieTestWithDefaults()
 	if err != nil {
@@ -39,6 +39,10 @@ type RequestScope struct {
 	// it was loaded or not.
 	SessionRevalidated bool
 
+	// ExpiredSession holds a stored session that was loaded but could not be
+	// refreshed or validated, so that a new login can reuse its details.
+	ExpiredSession *sessions.SessionState
+
 	// Upstream tracks which upstream was used for this request
 	Upstream string
 }
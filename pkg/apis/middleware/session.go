@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/coreos/go-oidc"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -11,6 +12,11 @@ import (
 // TokenToSessionFunc takes a raw ID Token and converts it into a SessionState.
 type TokenToSessionFunc func(ctx context.Context, token string) (*sessionsapi.SessionState, error)
 
+// RequestToSessionFunc converts the headers of a request, set by a trusted
+// upstream such as a load balancer, into a SessionState.
+// It returns a nil session if the request holds no session.
+type RequestToSessionFunc func(req *http.Request) (*sessionsapi.SessionState, error)
+
 // VerifyFunc takes a raw bearer token and verifies it returning the converted
 // oidc.IDToken representation of the token.
 type VerifyFunc func(ctx context.Context, token string) (*oidc.IDToken, error)
@@ -55,6 +55,8 @@ func NewLegacyOptions() *LegacyOptions {
 			OIDCEmailClaim:        "email",
 			OIDCGroupsClaim:       "groups",
 			InsecureOIDCSkipNonce: true,
+			HSTSMaxAge:            providers.DefaultHSTSMaxAge,
+			ProfileURLCacheTTL:    providers.DefaultProfileCacheTTL,
 		},
 
 		Options: *NewOptions(),
@@ -466,9 +468,11 @@ func legacyServerFlagset() *pflag.FlagSet {
 }
 
 type LegacyProvider struct {
-	ClientID         string `flag:"client-id" cfg:"client_id"`
-	ClientSecret     string `flag:"client-secret" cfg:"client_secret"`
-	ClientSecretFile string `flag:"client-secret-file" cfg:"client_secret_file"`
+	ClientID              string        `flag:"client-id" cfg:"client_id"`
+	ClientSecret          string        `flag:"client-secret" cfg:"client_secret"`
+	ClientSecretFile      string        `flag:"client-secret-file" cfg:"client_secret_file"`
+	ClientSecretFileTTL   time.Duration `flag:"client-secret-file-ttl" cfg:"client_secret_file_ttl"`
+	ClientSecretFileWatch bool          `flag:"client-secret-file-watch" cfg:"client_secret_file_watch"`
 
 	KeycloakGroups           []string `flag:"keycloak-group" cfg:"keycloak_groups"`
 	AzureTenant              string   `flag:"azure-tenant" cfg:"azure_tenant"`
@@ -484,23 +488,51 @@ type LegacyProvider struct {
 	GoogleGroups             []string `flag:"google-group" cfg:"google_group"`
 	GoogleAdminEmail         string   `flag:"google-admin-email" cfg:"google_admin_email"`
 	GoogleServiceAccountJSON string   `flag:"google-service-account-json" cfg:"google_service_account_json"`
+	ALBRegion                string   `flag:"alb-region" cfg:"alb_region"`
+	ALBSignerARN             string   `flag:"alb-signer-arn" cfg:"alb_signer_arn"`
 
 	// These options allow for other providers besides Google, with
 	// potential overrides.
 	ProviderType                       string   `flag:"provider" cfg:"provider"`
 	ProviderName                       string   `flag:"provider-display-name" cfg:"provider_display_name"`
 	ProviderCAFiles                    []string `flag:"provider-ca-file" cfg:"provider_ca_files"`
+	ProviderTLSMinVersion              string   `flag:"provider-tls-min-version" cfg:"provider_tls_min_version"`
+	ProviderTLSClientCertFile          string   `flag:"provider-tls-client-cert-file" cfg:"provider_tls_client_cert_file"`
+	ProviderTLSClientKeyFile           string   `flag:"provider-tls-client-key-file" cfg:"provider_tls_client_key_file"`
 	OIDCIssuerURL                      string   `flag:"oidc-issuer-url" cfg:"oidc_issuer_url"`
 	InsecureOIDCAllowUnverifiedEmail   bool     `flag:"insecure-oidc-allow-unverified-email" cfg:"insecure_oidc_allow_unverified_email"`
+	OIDCRequireVerifiedEmailOrEmpty    bool     `flag:"oidc-require-verified-email-or-empty" cfg:"oidc_require_verified_email_or_empty"`
+	OIDCRequireEmailVerified           bool     `flag:"oidc-require-email-verified" cfg:"oidc_require_email_verified"`
 	InsecureOIDCSkipIssuerVerification bool     `flag:"insecure-oidc-skip-issuer-verification" cfg:"insecure_oidc_skip_issuer_verification"`
 	InsecureOIDCSkipNonce              bool     `flag:"insecure-oidc-skip-nonce" cfg:"insecure_oidc_skip_nonce"`
 	SkipOIDCDiscovery                  bool     `flag:"skip-oidc-discovery" cfg:"skip_oidc_discovery"`
+	OIDCDiscoveryCacheFile             string   `flag:"oidc-discovery-cache-file" cfg:"oidc_discovery_cache_file"`
+	OIDCDiscoveryExtraFields           []string `flag:"oidc-discovery-extra-field" cfg:"oidc_discovery_extra_fields"`
 	OIDCJwksURL                        string   `flag:"oidc-jwks-url" cfg:"oidc_jwks_url"`
 	OIDCEmailClaim                     string   `flag:"oidc-email-claim" cfg:"oidc_email_claim"`
+	OIDCEmailClaims                    []string `flag:"oidc-email-claims" cfg:"oidc_email_claims"`
+	OIDCEmailVerifiedClaim             string   `flag:"oidc-email-verified-claim" cfg:"oidc_email_verified_claim"`
 	OIDCGroupsClaim                    string   `flag:"oidc-groups-claim" cfg:"oidc_groups_claim"`
+	OIDCGroupsClaims                   []string `flag:"oidc-groups-claims" cfg:"oidc_groups_claims"`
+	OIDCGroupsClaimRequired            bool     `flag:"oidc-groups-claim-required" cfg:"oidc_groups_claim_required"`
+	OIDCRolesClaim                     string   `flag:"oidc-roles-claim" cfg:"oidc_roles_claim"`
+	OIDCActiveOrgClaim                 string   `flag:"oidc-active-org-claim" cfg:"oidc_active_org_claim"`
+	OIDCCostCenterClaim                string   `flag:"oidc-cost-center-claim" cfg:"oidc_cost_center_claim"`
+	OIDCAccessTokenRoles               bool     `flag:"oidc-access-token-roles" cfg:"oidc_access_token_roles"`
+	OIDCAccessTokenSubjectClaim        string   `flag:"oidc-access-token-subject-claim" cfg:"oidc_access_token_subject_claim"`
+	OIDCGroupsFromAccessToken          bool     `flag:"oidc-groups-from-access-token" cfg:"oidc_groups_from_access_token"`
+	OIDCGroupsJMESPath                 string   `flag:"oidc-groups-jmespath" cfg:"oidc_groups_jmespath"`
+	OIDCGroupsFlattenMap               bool     `flag:"oidc-groups-flatten-map" cfg:"oidc_groups_flatten_map"`
+	OIDCClaimPrecedence                string   `flag:"oidc-claim-precedence" cfg:"oidc_claim_precedence"`
+	OIDCClaimTypeConflict              string   `flag:"oidc-claim-type-conflict" cfg:"oidc_claim_type_conflict"`
+	OIDCPreferNonEmptyClaims           bool     `flag:"oidc-prefer-non-empty-claims" cfg:"oidc_prefer_non_empty_claims"`
+	OIDCMaxIDTokenBytes                int      `flag:"oidc-max-id-token-bytes" cfg:"oidc_max_id_token_bytes"`
+	OIDCEmailFromSubject               bool     `flag:"oidc-email-from-subject" cfg:"oidc_email_from_subject"`
 	LoginURL                           string   `flag:"login-url" cfg:"login_url"`
 	RedeemURL                          string   `flag:"redeem-url" cfg:"redeem_url"`
+	RevokeURL                          string   `flag:"revoke-url" cfg:"revoke_url"`
 	ProfileURL                         string   `flag:"profile-url" cfg:"profile_url"`
+	ProfileURLFailovers                []string `flag:"profile-url-failover" cfg:"profile_url_failovers"`
 	ProtectedResource                  string   `flag:"resource" cfg:"resource"`
 	ValidateURL                        string   `flag:"validate-url" cfg:"validate_url"`
 	Scope                              string   `flag:"scope" cfg:"scope"`
@@ -508,11 +540,64 @@ type LegacyProvider struct {
 	ApprovalPrompt                     string   `flag:"approval-prompt" cfg:"approval_prompt"` // Deprecated by OIDC 1.0
 	UserIDClaim                        string   `flag:"user-id-claim" cfg:"user_id_claim"`
 	AllowedGroups                      []string `flag:"allowed-group" cfg:"allowed_groups"`
-
-	AcrValues  string `flag:"acr-values" cfg:"acr_values"`
-	JWTKey     string `flag:"jwt-key" cfg:"jwt_key"`
-	JWTKeyFile string `flag:"jwt-key-file" cfg:"jwt_key_file"`
-	PubJWKURL  string `flag:"pubjwk-url" cfg:"pubjwk_url"`
+	AllowedGroupsRegex                 []string `flag:"allowed-group-regex" cfg:"allowed_groups_regex"`
+	AllowedSubjects                    []string `flag:"allowed-subject" cfg:"allowed_subjects"`
+	DeniedSubjects                     []string `flag:"denied-subject" cfg:"denied_subjects"`
+	AllowedOrgs                        []string `flag:"allowed-org" cfg:"allowed_orgs"`
+	SubjectPrefix                      string   `flag:"subject-prefix" cfg:"subject_prefix"`
+	SubjectPrefixMigration             bool     `flag:"subject-prefix-migration" cfg:"subject_prefix_migration"`
+	RequireGroupsSubsetOf              []string `flag:"require-groups-subset-of" cfg:"require_groups_subset_of"`
+	GroupMatchMode                     string   `flag:"group-match-mode" cfg:"group_match_mode"`
+	NormalizeUnicodeGroups             bool     `flag:"normalize-unicode-groups" cfg:"normalize_unicode_groups"`
+	StrictRedirectURIMatch             bool     `flag:"strict-redirect-uri-match" cfg:"strict_redirect_uri_match"`
+	UseHostCookiePrefix                bool     `flag:"use-host-cookie-prefix" cfg:"use_host_cookie_prefix"`
+	PKCEEnabled                        bool     `flag:"pkce-enabled" cfg:"pkce_enabled"`
+	PKCEMethod                         string   `flag:"pkce-method" cfg:"pkce_method"`
+	TokenIntrospectionEnabled          bool     `flag:"token-introspection-enabled" cfg:"token_introspection_enabled"`
+	SkipProfileFetchUserAgents         []string `flag:"skip-profile-fetch-user-agent" cfg:"skip_profile_fetch_user_agents"`
+	RequiredTokenResponseFields        []string `flag:"required-token-response-field" cfg:"required_token_response_fields"`
+	GroupResolverURL                   string   `flag:"group-resolver-url" cfg:"group_resolver_url"`
+	GroupResolverNameField             string   `flag:"group-resolver-name-field" cfg:"group_resolver_name_field"`
+	GroupChangeInvalidatesSession      bool     `flag:"group-change-invalidates-session" cfg:"group_change_invalidates_session"`
+	AutoLoginHint                      bool     `flag:"auto-login-hint" cfg:"auto_login_hint"`
+	ForwardAllClaims                   bool     `flag:"forward-all-claims" cfg:"forward_all_claims"`
+	SaveRawClaims                      bool     `flag:"save-raw-claims" cfg:"save_raw_claims"`
+	ForwardExtraClaimsPrefix           string   `flag:"forward-extra-claims-prefix" cfg:"forward_extra_claims_prefix"`
+
+	AcrValues   string `flag:"acr-values" cfg:"acr_values"`
+	NonceLength int    `flag:"nonce-length" cfg:"nonce_length"`
+	JWTKey      string `flag:"jwt-key" cfg:"jwt_key"`
+	JWTKeyFile  string `flag:"jwt-key-file" cfg:"jwt_key_file"`
+	PubJWKURL   string `flag:"pubjwk-url" cfg:"pubjwk_url"`
+
+	OAuthStateMaxAge  time.Duration `flag:"oauth-state-max-age" cfg:"oauth_state_max_age"`
+	OIDCSkewTolerance time.Duration `flag:"oidc-skew-tolerance" cfg:"oidc_skew_tolerance"`
+
+	OIDCIssuerURLNormalize   bool     `flag:"oidc-issuer-url-normalize" cfg:"oidc_issuer_url_normalize"`
+	OIDCAdditionalAudiences  []string `flag:"oidc-additional-audience" cfg:"oidc_additional_audiences"`
+	OIDCRequireAudienceExact bool     `flag:"oidc-require-audience-exact" cfg:"oidc_require_audience_exact"`
+
+	OIDCDiscoveryMaxRetries    int           `flag:"oidc-discovery-max-retries" cfg:"oidc_discovery_max_retries"`
+	OIDCDiscoveryRetryInterval time.Duration `flag:"oidc-discovery-retry-interval" cfg:"oidc_discovery_retry_interval"`
+
+	AllowedGroupsURL                string        `flag:"allowed-groups-url" cfg:"allowed_groups_url"`
+	AllowedGroupsURLRefreshInterval time.Duration `flag:"allowed-groups-url-refresh-interval" cfg:"allowed_groups_url_refresh_interval"`
+
+	ProfileURLCacheTTL     time.Duration `flag:"profile-url-cache-ttl" cfg:"profile_url_cache_ttl"`
+	ProfileURLCacheSize    int           `flag:"profile-url-cache-size" cfg:"profile_url_cache_size"`
+	ProfileURLMaxRetries   int           `flag:"profile-url-max-retries" cfg:"profile_url_max_retries"`
+	ProfileURLRetryBackoff time.Duration `flag:"profile-url-retry-backoff" cfg:"profile_url_retry_backoff"`
+	ProfileURLTimeout      time.Duration `flag:"profile-url-timeout" cfg:"profile_url_timeout"`
+	ProfileURLUnwrapArray  bool          `flag:"profile-url-unwrap-array" cfg:"profile_url_unwrap_array"`
+	ProfileURLTokenRefresh bool          `flag:"profile-url-token-refresh" cfg:"profile_url_token_refresh"`
+	ProfileURLAuthScheme   string        `flag:"profile-url-auth-scheme" cfg:"profile_url_auth_scheme"`
+	ClaimExtractionTimeout time.Duration `flag:"claim-extraction-timeout" cfg:"claim_extraction_timeout"`
+
+	HSTSMaxAge            time.Duration `flag:"hsts-max-age" cfg:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `flag:"hsts-include-subdomains" cfg:"hsts_include_subdomains"`
+
+	CookieRefreshOnActivity bool          `flag:"cookie-refresh-on-activity" cfg:"cookie_refresh_on_activity"`
+	MaxSessionDuration      time.Duration `flag:"max-session-duration" cfg:"max_session_duration"`
 }
 
 func legacyProviderFlagSet() *pflag.FlagSet {
@@ -532,24 +617,74 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 	flagSet.StringSlice("google-group", []string{}, "restrict logins to members of this google group (may be given multiple times).")
 	flagSet.String("google-admin-email", "", "the google admin to impersonate for api calls")
 	flagSet.String("google-service-account-json", "", "the path to the service account json credentials")
+	flagSet.String("alb-region", "", "the AWS region of the ALB, used to fetch the keys its user claims are signed with (default \"us-east-1\")")
+	flagSet.String("alb-signer-arn", "", "the ARN of the ALB expected to sign the user claims")
 	flagSet.String("client-id", "", "the OAuth Client ID: ie: \"123456.apps.googleusercontent.com\"")
 	flagSet.String("client-secret", "", "the OAuth Client Secret")
 	flagSet.String("client-secret-file", "", "the file with OAuth Client Secret")
+	flagSet.Bool("client-secret-file-watch", false, "watch client-secret-file for changes and reload the secret as soon as it is updated")
+	flagSet.Duration("client-secret-file-ttl", time.Duration(0), "how long the secret read from client-secret-file is cached before the file is read again (0 uses the default of 60s)")
 
 	flagSet.String("provider", "google", "OAuth provider")
 	flagSet.String("provider-display-name", "", "Provider display name")
 	flagSet.StringSlice("provider-ca-file", []string{}, "One or more paths to CA certificates that should be used when connecting to the provider.  If not specified, the default Go trust sources are used instead.")
+	flagSet.String("provider-tls-client-cert-file", "", "path to a client certificate presented to the provider for mutual TLS, reloaded when it changes")
+	flagSet.String("provider-tls-client-key-file", "", "path to the key of the provider-tls-client-cert-file")
+	flagSet.String("provider-tls-min-version", "", "oldest TLS version used when connecting to the provider: TLS1.0, TLS1.1, TLS1.2 or TLS1.3 (default \"TLS1.2\")")
 	flagSet.String("oidc-issuer-url", "", "OpenID Connect issuer URL (ie: https://accounts.google.com)")
 	flagSet.Bool("insecure-oidc-allow-unverified-email", false, "Don't fail if an email address in an id_token is not verified")
+	flagSet.Bool("oidc-require-verified-email-or-empty", false, "leave the user's email empty when the email in an id_token is not verified, rather than failing the login")
+	flagSet.Bool("oidc-require-email-verified", false, "check email_verified for emails taken from claims other than email too, unless oidc-email-verified-claim is set")
 	flagSet.Bool("insecure-oidc-skip-issuer-verification", false, "Do not verify if issuer matches OIDC discovery URL")
 	flagSet.Bool("insecure-oidc-skip-nonce", true, "skip verifying the OIDC ID Token's nonce claim")
 	flagSet.Bool("skip-oidc-discovery", false, "Skip OIDC discovery and use manually supplied Endpoints")
+	flagSet.String("oidc-discovery-cache-file", "", "file to persist the OIDC discovery document to, used as a fallback if discovery fails on startup")
+	flagSet.Int("oidc-discovery-max-retries", 0, "retries for an OIDC discovery that fails on startup")
+	flagSet.Duration("oidc-discovery-retry-interval", time.Duration(0), "wait before the first OIDC discovery retry, doubling for each later retry (0 uses the default of 1s)")
+	flagSet.StringSlice("oidc-discovery-extra-field", []string{}, "non-standard OIDC discovery document field to extract for use by the provider (may be given multiple times)")
 	flagSet.String("oidc-jwks-url", "", "OpenID Connect JWKS URL (ie: https://www.googleapis.com/oauth2/v3/certs)")
-	flagSet.String("oidc-groups-claim", providers.OIDCGroupsClaim, "which OIDC claim contains the user groups")
+	flagSet.String("oidc-groups-claim", providers.OIDCGroupsClaim, "which OIDC claim contains the user groups, or a comma separated list of claims to try in order")
+	flagSet.StringSlice("oidc-groups-claims", []string{}, "OIDC claims whose groups are all merged into the user groups, deduplicated (overrides oidc-groups-claim)")
+	flagSet.Bool("oidc-groups-claim-required", false, "fail logins when neither the ID token nor the profile URL has the groups claim")
+	flagSet.String("oidc-roles-claim", "", "which OIDC claim contains the user roles, roles are only added to the session when set (default \"roles\" with --oidc-access-token-roles)")
+	flagSet.String("oidc-active-org-claim", "", "which OIDC claim contains the organization the user is acting for, the active organization is only added to the session when set")
+	flagSet.String("oidc-cost-center-claim", "", "which OIDC claim contains the cost center the user's usage is attributed to, the cost center is only added to the session when set")
+	flagSet.Bool("oidc-access-token-roles", false, "merge the roles from a verified access token with those from the id_token")
+	flagSet.String("oidc-access-token-subject-claim", "", "claim of a verified access token used as the session user instead of the id_token subject")
+	flagSet.Bool("oidc-groups-from-access-token", false, "merge the groups from the JWT access token with those from the id_token, the access token is not verified")
+	flagSet.String("oidc-groups-jmespath", "", "JMESPath expression used to extract the user groups from the OIDC claims (overrides oidc-groups-claim)")
+	flagSet.Bool("oidc-groups-flatten-map", false, "flatten a map valued groups claim into `group:role` entries")
 	flagSet.String("oidc-email-claim", providers.OIDCEmailClaim, "which OIDC claim contains the user's email")
+	flagSet.StringSlice("oidc-email-claims", []string{}, "OIDC claims tried in order for the user's email, the first that is set is used (overrides oidc-email-claim)")
+	flagSet.String("oidc-email-verified-claim", "", "OIDC claim checked in place of email_verified to verify emails")
+	flagSet.Int("oidc-max-id-token-bytes", 0, "maximum size of an OIDC ID token in bytes, larger tokens are rejected (0 disables the limit)")
+	flagSet.Duration("oidc-skew-tolerance", time.Duration(0), "clock skew allowed between the proxy and the IdP when checking the ID token issue and expiry times")
+	flagSet.Bool("oidc-issuer-url-normalize", false, "ignore trailing slashes when comparing the OIDC issuer URL with the issuer of the discovery document and ID tokens")
+	flagSet.StringSlice("oidc-additional-audience", []string{}, "audience accepted in the aud claim of OIDC ID tokens as well as the client ID (may be given multiple times)")
+	flagSet.Bool("oidc-require-audience-exact", false, "reject OIDC ID tokens with an audience other than the client ID and oidc-additional-audience")
+	flagSet.Bool("oidc-email-from-subject", false, "use the OIDC subject as the user's email when there is no email claim and the subject is an email address")
+	flagSet.String("oidc-claim-precedence", "", "whether id_token or profile URL claims take precedence: id_token_first (default) or userinfo_first")
+	flagSet.String("oidc-claim-type-conflict", "", "how a claim that is an array in one of the id_token and profile URL claims and a single value in the other is merged: union (default) or precedence")
+	flagSet.Bool("oidc-prefer-non-empty-claims", false, "treat null, empty string and empty array claims as missing, so that the claim from the id_token or profile URL that doesn't take precedence is used")
 	flagSet.String("login-url", "", "Authentication endpoint")
 	flagSet.String("redeem-url", "", "Token redemption endpoint")
+	flagSet.String("revoke-url", "", "Token revocation endpoint, the session tokens are revoked on sign out when set")
 	flagSet.String("profile-url", "", "Profile access endpoint")
+	flagSet.StringSlice("profile-url-failover", []string{}, "replica of the profile URL, tried in order when the profile URL fails with a network or server error (may be given multiple times)")
+	flagSet.Duration("profile-url-cache-ttl", providers.DefaultProfileCacheTTL, "cache profile URL responses for the same access token for this duration; 0 to disable")
+	flagSet.StringSlice("skip-profile-fetch-user-agent", []string{}, "don't request the profile URL for requests with a User-Agent matching this regex, unless authorization depends on it (may be given multiple times)")
+	flagSet.Int("profile-url-cache-size", 0, "maximum number of cached profile URL responses (0 uses the default of 10000)")
+	flagSet.Int("profile-url-max-retries", 0, "retries for profile URL requests that fail to connect, are rate limited or are server errors (0 uses the default of 3, negative disables retries)")
+	flagSet.Duration("profile-url-retry-backoff", time.Duration(0), "wait before the first profile URL retry, doubling for each later retry (0 uses the default of 100ms)")
+	flagSet.Duration("profile-url-timeout", time.Duration(0), "how long each profile URL request may take before it is abandoned (0 uses the default of 10s)")
+	flagSet.Bool("profile-url-unwrap-array", false, "accept profile URL responses that are a JSON array of a single object")
+	flagSet.Bool("profile-url-token-refresh", false, "refresh the access token and retry once when the profile URL responds 401 Unauthorized (oidc provider only)")
+	flagSet.String("profile-url-auth-scheme", "", "how profile URL requests are authenticated: bearer (default) with the access token or basic with the client credentials")
+	flagSet.Duration("claim-extraction-timeout", time.Duration(0), "how long fetching the profile URL claims may take in total, including retries (0 is unlimited)")
+	flagSet.Duration("hsts-max-age", providers.DefaultHSTSMaxAge, "max-age of the Strict-Transport-Security header set on responses from the proxy's own endpoints; 0 to disable")
+	flagSet.Bool("hsts-include-subdomains", false, "add includeSubDomains to the Strict-Transport-Security header")
+	flagSet.Bool("cookie-refresh-on-activity", false, "extend the session cookie expiry on every request when cookie-refresh is not set")
+	flagSet.Duration("max-session-duration", time.Duration(0), "end sessions extended on activity once the user logged in longer than this ago, required by cookie-refresh-on-activity")
 	flagSet.String("resource", "", "The resource that is protected (Azure AD only)")
 	flagSet.String("validate-url", "", "Access token validation endpoint")
 	flagSet.String("scope", "", "OAuth scope specification")
@@ -557,12 +692,38 @@ func legacyProviderFlagSet() *pflag.FlagSet {
 	flagSet.String("approval-prompt", "force", "OAuth approval_prompt")
 
 	flagSet.String("acr-values", "", "acr values string:  optional")
+	flagSet.Int("nonce-length", 0, "length in bytes of the OAuth state and OIDC nonce, at least 8 (0 uses the default of 32)")
+	flagSet.Duration("oauth-state-max-age", time.Duration(0), "how long a login has to complete before its OAuth state expires (0 uses the default of 15m)")
+	flagSet.Bool("strict-redirect-uri-match", false, "reject OAuth callbacks that don't exactly match the redirect URL, including its query parameters")
+	flagSet.Bool("use-host-cookie-prefix", false, "name the session and CSRF cookies with the __Host- prefix, forcing them to be secure with the path / and no domain (requires HTTPS)")
+	flagSet.Bool("pkce-enabled", false, "send a PKCE code challenge with each login and the code verifier when redeeming the code")
+	flagSet.String("pkce-method", "", "PKCE code challenge method: S256 or plain (empty uses the default of S256)")
+	flagSet.Bool("token-introspection-enabled", false, "validate sessions with RFC 7662 token introspection, POSTing the access token to the validate-url")
 	flagSet.String("jwt-key", "", "private key in PEM format used to sign JWT, so that you can say something like -jwt-key=\"${OAUTH2_PROXY_JWT_KEY}\": required by login.gov")
 	flagSet.String("jwt-key-file", "", "path to the private key file in PEM format used to sign the JWT so that you can say something like -jwt-key-file=/etc/ssl/private/jwt_signing_key.pem: required by login.gov")
 	flagSet.String("pubjwk-url", "", "JWK pubkey access endpoint: required by login.gov")
 
 	flagSet.String("user-id-claim", providers.OIDCEmailClaim, "(DEPRECATED for `oidc-email-claim`) which claim contains the user ID")
 	flagSet.StringSlice("allowed-group", []string{}, "restrict logins to members of this group (may be given multiple times)")
+	flagSet.String("group-match-mode", "", "how allowed groups are matched, either \"exact\" or \"glob\" to allow wildcards, eg. \"team:*:admin\" (default \"exact\")")
+	flagSet.Bool("normalize-unicode-groups", false, "convert group names and allowed groups to Unicode NFC before comparing them")
+	flagSet.StringSlice("allowed-group-regex", []string{}, "restrict logins to members of groups whose whole name matches this regex (may be given multiple times)")
+	flagSet.String("allowed-groups-url", "", "URL serving a JSON array of groups that are allowed in addition to the allowed-group list, refetched periodically")
+	flagSet.Duration("allowed-groups-url-refresh-interval", time.Duration(0), "how often the allowed groups are refetched from the allowed-groups-url (0 uses the default of 5m)")
+	flagSet.StringSlice("allowed-subject", []string{}, "restrict logins to this ID token subject (may be given multiple times)")
+	flagSet.StringSlice("denied-subject", []string{}, "reject logins of this ID token subject (may be given multiple times)")
+	flagSet.String("subject-prefix", "", "prefix the session user as <prefix>|<subject> so that users of different providers issuing the same subjects don't collide")
+	flagSet.Bool("subject-prefix-migration", false, "authorize sessions created before the subject-prefix was set, prefixing their user instead of rejecting them")
+	flagSet.StringSlice("allowed-org", []string{}, "restrict logins to users whose active organization from the oidc-active-org-claim is this organization (may be given multiple times)")
+	flagSet.StringSlice("require-groups-subset-of", []string{}, "only authorize users whose groups are all in this set (may be given multiple times)")
+	flagSet.StringSlice("required-token-response-field", []string{}, "reject token endpoint responses missing this field, eg. access_token (may be given multiple times)")
+	flagSet.String("group-resolver-url", "", "URL returning the name of a group, with {id} replaced by the group ID, for providers that only list group IDs in the groups claim")
+	flagSet.String("group-resolver-name-field", "", "field of the group-resolver-url JSON response holding the group name (default \"name\")")
+	flagSet.Bool("group-change-invalidates-session", false, "force re-authentication when a session refresh returns different groups")
+	flagSet.Bool("auto-login-hint", false, "send the email of an expired session to the provider as the login_hint")
+	flagSet.Bool("forward-all-claims", false, "pass every claim in the session to upstream as a header named by forward-extra-claims-prefix and the claim name")
+	flagSet.Bool("save-raw-claims", false, "store every claim from the ID token and profile URL in the session")
+	flagSet.String("forward-extra-claims-prefix", "", "header name prefix used by forward-all-claims (default \"X-Claim-\")")
 
 	return flagSet
 }
@@ -611,34 +772,108 @@ func (l *LegacyProvider) convert() (Providers, error) {
 	providers := Providers{}
 
 	provider := Provider{
-		ClientID:          l.ClientID,
-		ClientSecret:      l.ClientSecret,
-		ClientSecretFile:  l.ClientSecretFile,
-		Type:              l.ProviderType,
-		CAFiles:           l.ProviderCAFiles,
-		LoginURL:          l.LoginURL,
-		RedeemURL:         l.RedeemURL,
-		ProfileURL:        l.ProfileURL,
-		ProtectedResource: l.ProtectedResource,
-		ValidateURL:       l.ValidateURL,
-		Scope:             l.Scope,
-		Prompt:            l.Prompt,
-		ApprovalPrompt:    l.ApprovalPrompt,
-		AllowedGroups:     l.AllowedGroups,
-		AcrValues:         l.AcrValues,
+		ClientID:                      l.ClientID,
+		ClientSecret:                  l.ClientSecret,
+		ClientSecretFile:              l.ClientSecretFile,
+		ClientSecretFileTTL:           Duration(l.ClientSecretFileTTL),
+		ClientSecretFileWatch:         l.ClientSecretFileWatch,
+		NonceLength:                   l.NonceLength,
+		OAuthStateMaxAge:              Duration(l.OAuthStateMaxAge),
+		StrictRedirectURIMatch:        l.StrictRedirectURIMatch,
+		UseHostCookiePrefix:           l.UseHostCookiePrefix,
+		PKCEEnabled:                   l.PKCEEnabled,
+		PKCEMethod:                    l.PKCEMethod,
+		TokenIntrospectionEnabled:     l.TokenIntrospectionEnabled,
+		Type:                          l.ProviderType,
+		CAFiles:                       l.ProviderCAFiles,
+		TLSClientCertFile:             l.ProviderTLSClientCertFile,
+		TLSClientKeyFile:              l.ProviderTLSClientKeyFile,
+		TLSMinVersion:                 l.ProviderTLSMinVersion,
+		LoginURL:                      l.LoginURL,
+		RedeemURL:                     l.RedeemURL,
+		RevokeURL:                     l.RevokeURL,
+		ProfileURL:                    l.ProfileURL,
+		ProfileURLFailovers:           l.ProfileURLFailovers,
+		ProfileURLCacheTTL:            Duration(l.ProfileURLCacheTTL),
+		ProfileURLCacheSize:           l.ProfileURLCacheSize,
+		ProfileURLMaxRetries:          l.ProfileURLMaxRetries,
+		ProfileURLRetryBackoff:        Duration(l.ProfileURLRetryBackoff),
+		ProfileURLTimeout:             Duration(l.ProfileURLTimeout),
+		ProfileURLUnwrapArray:         l.ProfileURLUnwrapArray,
+		ProfileURLTokenRefresh:        l.ProfileURLTokenRefresh,
+		ProfileURLAuthScheme:          l.ProfileURLAuthScheme,
+		ClaimExtractionTimeout:        Duration(l.ClaimExtractionTimeout),
+		SkipProfileFetchUserAgents:    l.SkipProfileFetchUserAgents,
+		ProtectedResource:             l.ProtectedResource,
+		ValidateURL:                   l.ValidateURL,
+		Scope:                         l.Scope,
+		Prompt:                        l.Prompt,
+		ApprovalPrompt:                l.ApprovalPrompt,
+		AllowedGroups:                 l.AllowedGroups,
+		AllowedGroupsRegex:            l.AllowedGroupsRegex,
+		AllowedGroupsURL:              l.AllowedGroupsURL,
+		AllowedSubjects:               l.AllowedSubjects,
+		AllowedOrgs:                   l.AllowedOrgs,
+		SubjectPrefix:                 l.SubjectPrefix,
+		SubjectPrefixMigration:        l.SubjectPrefixMigration,
+		DeniedSubjects:                l.DeniedSubjects,
+		RequireGroupsSubsetOf:         l.RequireGroupsSubsetOf,
+		RequiredTokenResponseFields:   l.RequiredTokenResponseFields,
+		GroupResolverURL:              l.GroupResolverURL,
+		GroupResolverNameField:        l.GroupResolverNameField,
+		GroupMatchMode:                l.GroupMatchMode,
+		NormalizeUnicodeGroups:        l.NormalizeUnicodeGroups,
+		AcrValues:                     l.AcrValues,
+		GroupChangeInvalidatesSession: l.GroupChangeInvalidatesSession,
+		AutoLoginHint:                 l.AutoLoginHint,
+		ForwardAllClaims:              l.ForwardAllClaims,
+		SaveRawClaims:                 l.SaveRawClaims,
+		ForwardExtraClaimsPrefix:      l.ForwardExtraClaimsPrefix,
+		HSTSMaxAge:                    Duration(l.HSTSMaxAge),
+		HSTSIncludeSubdomains:         l.HSTSIncludeSubdomains,
+		CookieRefreshOnActivity:       l.CookieRefreshOnActivity,
+		MaxSessionDuration:            Duration(l.MaxSessionDuration),
 	}
+	provider.AllowedGroupsURLRefreshInterval = Duration(l.AllowedGroupsURLRefreshInterval)
 
 	// This part is out of the switch section for all providers that support OIDC
 	provider.OIDCConfig = OIDCOptions{
 		IssuerURL:                      l.OIDCIssuerURL,
 		InsecureAllowUnverifiedEmail:   l.InsecureOIDCAllowUnverifiedEmail,
+		RequireVerifiedEmailOrEmpty:    l.OIDCRequireVerifiedEmailOrEmpty,
+		RequireEmailVerified:           l.OIDCRequireEmailVerified,
 		InsecureSkipIssuerVerification: l.InsecureOIDCSkipIssuerVerification,
 		InsecureSkipNonce:              l.InsecureOIDCSkipNonce,
 		SkipDiscovery:                  l.SkipOIDCDiscovery,
 		JwksURL:                        l.OIDCJwksURL,
 		UserIDClaim:                    l.UserIDClaim,
 		EmailClaim:                     l.OIDCEmailClaim,
+		EmailClaims:                    l.OIDCEmailClaims,
+		EmailVerifiedClaim:             l.OIDCEmailVerifiedClaim,
 		GroupsClaim:                    l.OIDCGroupsClaim,
+		GroupsClaims:                   l.OIDCGroupsClaims,
+		GroupsClaimRequired:            l.OIDCGroupsClaimRequired,
+		RolesClaim:                     l.OIDCRolesClaim,
+		ActiveOrgClaim:                 l.OIDCActiveOrgClaim,
+		CostCenterClaim:                l.OIDCCostCenterClaim,
+		AccessTokenRoles:               l.OIDCAccessTokenRoles,
+		AccessTokenSubjectClaim:        l.OIDCAccessTokenSubjectClaim,
+		GroupsFromAccessToken:          l.OIDCGroupsFromAccessToken,
+		GroupsJMESPath:                 l.OIDCGroupsJMESPath,
+		DiscoveryCacheFile:             l.OIDCDiscoveryCacheFile,
+		DiscoveryMaxRetries:            l.OIDCDiscoveryMaxRetries,
+		DiscoveryRetryInterval:         Duration(l.OIDCDiscoveryRetryInterval),
+		DiscoveryExtraFields:           l.OIDCDiscoveryExtraFields,
+		GroupsFlattenMap:               l.OIDCGroupsFlattenMap,
+		ClaimPrecedence:                l.OIDCClaimPrecedence,
+		ClaimTypeConflict:              l.OIDCClaimTypeConflict,
+		PreferNonEmptyClaims:           l.OIDCPreferNonEmptyClaims,
+		MaxIDTokenBytes:                l.OIDCMaxIDTokenBytes,
+		EmailFromSubject:               l.OIDCEmailFromSubject,
+		SkewTolerance:                  Duration(l.OIDCSkewTolerance),
+		IssuerURLNormalize:             l.OIDCIssuerURLNormalize,
+		AdditionalAudiences:            l.OIDCAdditionalAudiences,
+		RequireAudienceExact:           l.OIDCRequireAudienceExact,
 	}
 
 	// This part is out of the switch section because azure has a default tenant
@@ -682,6 +917,11 @@ func (l *LegacyProvider) convert() (Providers, error) {
 			AdminEmail:         l.GoogleAdminEmail,
 			ServiceAccountJSON: l.GoogleServiceAccountJSON,
 		}
+	case "alb":
+		provider.ALBConfig = ALBOptions{
+			Region:    l.ALBRegion,
+			SignerARN: l.ALBSignerARN,
+		}
 	}
 
 	if l.ProviderName != "" {
@@ -43,6 +43,8 @@ var _ = Describe("Load", func() {
 			OIDCEmailClaim:        "email",
 			OIDCGroupsClaim:       "groups",
 			InsecureOIDCSkipNonce: true,
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			ProfileURLCacheTTL:    30 * time.Second,
 		},
 
 		Options: Options{
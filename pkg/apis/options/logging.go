@@ -18,6 +18,8 @@ type Logging struct {
 	LocalTime       bool           `flag:"logging-local-time" cfg:"logging_local_time"`
 	SilencePing     bool           `flag:"silence-ping-logging" cfg:"silence_ping_logging"`
 	RequestIDHeader string         `flag:"request-id-header" cfg:"request_id_header"`
+	ProviderJSON    bool           `flag:"provider-logging-json" cfg:"provider_logging_json"`
+	AuditFilename   string         `flag:"audit-logging-filename" cfg:"audit_logging_filename"`
 	File            LogFileOptions `cfg:",squash"`
 }
 
@@ -45,6 +47,8 @@ func loggingFlagSet() *pflag.FlagSet {
 	flagSet.Bool("logging-local-time", true, "If the time in log files and backup filenames are local or UTC time")
 	flagSet.Bool("silence-ping-logging", false, "Disable logging of requests to ping endpoint")
 	flagSet.String("request-id-header", "X-Request-Id", "Request header to use as the request ID")
+	flagSet.Bool("provider-logging-json", false, "Log provider messages as JSON lines with their context as fields")
+	flagSet.String("audit-logging-filename", "", "File to append every login and authorization decision to as JSON lines, empty to disable")
 
 	flagSet.String("logging-filename", "", "File to log requests to, empty for stdout")
 	flagSet.Int("logging-max-size", 100, "Maximum size in megabytes of the log file before rotation")
@@ -69,6 +73,8 @@ func loggingDefaults() Logging {
 		StandardEnabled: true,
 		StandardFormat:  logger.DefaultStandardLoggingFormat,
 		ErrToInfo:       false,
+		ProviderJSON:    false,
+		AuditFilename:   "",
 		File: LogFileOptions{
 			Filename:   "",
 			MaxSize:    100,
@@ -16,6 +16,72 @@ type Provider struct {
 	// ClientSecretFile is the name of the file
 	// containing the OAuth Client Secret, it will be used if ClientSecret is not set.
 	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+	// ClientSecretFileTTL is how long the secret read from ClientSecretFile
+	// is cached before the file is read again.
+	// default set to '60s'
+	ClientSecretFileTTL Duration `json:"clientSecretFileTTL,omitempty"`
+	// ClientSecretFileWatch watches ClientSecretFile for changes and reloads
+	// the secret as soon as it is updated, rather than after the TTL.
+	ClientSecretFileWatch bool `json:"clientSecretFileWatch,omitempty"`
+
+	// SkipProfileFetchUserAgents is a list of regular expressions, the profile
+	// URL isn't requested for requests with a matching User-Agent, eg. API
+	// clients, unless the authorization of the session depends on it
+	SkipProfileFetchUserAgents []string `json:"skipProfileFetchUserAgents,omitempty"`
+
+	// HSTSMaxAge sets the Strict-Transport-Security header on responses from
+	// the proxy's own endpoints (not proxied responses). 0 disables it.
+	// default set to '8760h' (1 year)
+	HSTSMaxAge Duration `json:"hstsMaxAge,omitempty"`
+	// HSTSIncludeSubdomains adds includeSubDomains to the
+	// Strict-Transport-Security header
+	HSTSIncludeSubdomains bool `json:"hstsIncludeSubdomains,omitempty"`
+
+	// CookieRefreshOnActivity extends the session cookie expiry on every
+	// request, so that active users aren't logged out. Only applies when
+	// sessions aren't refreshed by the cookie refresh period. The session
+	// is saved at most once a minute. Requires a MaxSessionDuration.
+	CookieRefreshOnActivity bool `json:"cookieRefreshOnActivity,omitempty"`
+	// MaxSessionDuration ends the sessions extended on activity once
+	// the user logged in longer than this ago.
+	MaxSessionDuration Duration `json:"maxSessionDuration,omitempty"`
+
+	// NonceLength is the length in bytes of the OAuth state and OIDC nonce
+	// generated for each login. Must be at least 8.
+	// default set to '32'
+	NonceLength int `json:"nonceLength,omitempty"`
+	// OAuthStateMaxAge is how long a login has to complete before its OAuth
+	// state expires and the callback is rejected.
+	// default set to '15m'
+	OAuthStateMaxAge Duration `json:"oauthStateMaxAge,omitempty"`
+	// StrictRedirectURIMatch rejects callbacks whose path and query don't
+	// exactly match the redirect URL once the authorization response
+	// parameters (code, state, etc.) are removed, eg. callbacks with
+	// parameters appended by an attacker.
+	// default set to 'false'
+	StrictRedirectURIMatch bool `json:"strictRedirectURIMatch,omitempty"`
+	// UseHostCookiePrefix names the session and CSRF cookies with the
+	// '__Host-' prefix, so that browsers only accept them when they are
+	// Secure, have the path '/' and no domain. The cookie path, secure flag
+	// and domains are set to match, which requires the proxy to be served
+	// over HTTPS.
+	// default set to 'false'
+	UseHostCookiePrefix bool `json:"useHostCookiePrefix,omitempty"`
+	// PKCEEnabled sends a PKCE (RFC 7636) code challenge with each login and
+	// the code verifier when redeeming the code, as required by many
+	// identity providers for public clients.
+	// default set to 'false'
+	PKCEEnabled bool `json:"pkceEnabled,omitempty"`
+	// PKCEMethod is the PKCE code challenge method, either `S256` or
+	// `plain`.
+	// default set to 'S256'
+	PKCEMethod string `json:"pkceMethod,omitempty"`
+	// TokenIntrospectionEnabled validates sessions with RFC 7662 token
+	// introspection, POSTing the access token to the ValidateURL, for
+	// providers issuing opaque access tokens. Not supported by providers
+	// that validate sessions with their own API, eg. github or azure.
+	// default set to 'false'
+	TokenIntrospectionEnabled bool `json:"tokenIntrospectionEnabled,omitempty"`
 
 	// KeycloakConfig holds all configurations for Keycloak provider.
 	KeycloakConfig KeycloakOptions `json:"keycloakConfig,omitempty"`
@@ -36,6 +102,8 @@ type Provider struct {
 	OIDCConfig OIDCOptions `json:"oidcConfig,omitempty"`
 	// LoginGovConfig holds all configurations for LoginGov provider.
 	LoginGovConfig LoginGovOptions `json:"loginGovConfig,omitempty"`
+	// ALBConfig holds all configurations for AWS ALB provider.
+	ALBConfig ALBOptions `json:"albConfig,omitempty"`
 
 	// ID should be a unique identifier for the provider.
 	// This value is required for all providers.
@@ -50,13 +118,69 @@ type Provider struct {
 	// CAFiles is a list of paths to CA certificates that should be used when connecting to the provider.
 	// If not specified, the default Go trust sources are used instead
 	CAFiles []string `json:"caFiles,omitempty"`
+	// TLSMinVersion is the oldest TLS version used when connecting to the
+	// provider, one of 'TLS1.0', 'TLS1.1', 'TLS1.2' or 'TLS1.3'.
+	// default set to 'TLS1.2'
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+	// TLSClientCertFile and TLSClientKeyFile are the paths to a client
+	// certificate and its key, presented to the provider's token, profile
+	// and validation endpoints for mutual TLS (RFC 8705). They are reloaded
+	// when the files change.
+	TLSClientCertFile string `json:"tlsClientCertFile,omitempty"`
+	TLSClientKeyFile  string `json:"tlsClientKeyFile,omitempty"`
 
 	// LoginURL is the authentication endpoint
 	LoginURL string `json:"loginURL,omitempty"`
 	// RedeemURL is the token redemption endpoint
 	RedeemURL string `json:"redeemURL,omitempty"`
+	// RevokeURL is the RFC 7009 token revocation endpoint. When set, the
+	// session's refresh and access tokens are revoked when users sign out.
+	RevokeURL string `json:"revokeURL,omitempty"`
 	// ProfileURL is the profile access endpoint
 	ProfileURL string `json:"profileURL,omitempty"`
+	// ProfileURLFailovers are replicas of the ProfileURL, tried in order when
+	// the ProfileURL fails with a network error or server error (5xx)
+	ProfileURLFailovers []string `json:"profileURLFailovers,omitempty"`
+	// ProfileURLCacheTTL is how long responses from the ProfileURL are cached
+	// for the same access token, 0 disables caching.
+	// default set to '30s'
+	ProfileURLCacheTTL Duration `json:"profileURLCacheTTL,omitempty"`
+	// ProfileURLCacheSize is the maximum number of ProfileURL responses cached,
+	// the least recently used are evicted first.
+	// default set to '10000'
+	ProfileURLCacheSize int `json:"profileURLCacheSize,omitempty"`
+	// ProfileURLMaxRetries is how many times a ProfileURL request is retried
+	// when it fails with a connection error, is rate limited (429) or fails
+	// with a server error (5xx).
+	// A negative value disables retries.
+	// default set to '3'
+	ProfileURLMaxRetries int `json:"profileURLMaxRetries,omitempty"`
+	// ProfileURLRetryBackoff is how long to wait before the first ProfileURL
+	// retry, doubling for each later retry.
+	// default set to '100ms'
+	ProfileURLRetryBackoff Duration `json:"profileURLRetryBackoff,omitempty"`
+	// ProfileURLTimeout is how long each ProfileURL request may take before
+	// it is abandoned.
+	// default set to '10s'
+	ProfileURLTimeout Duration `json:"profileURLTimeout,omitempty"`
+	// ProfileURLUnwrapArray accepts ProfileURL responses that are a JSON
+	// array of a single object, using the object as the profile. Any other
+	// response that isn't a JSON object is rejected.
+	ProfileURLUnwrapArray bool `json:"profileURLUnwrapArray,omitempty"`
+	// ProfileURLTokenRefresh refreshes the access token with the session's
+	// refresh token and retries once when the ProfileURL responds 401
+	// Unauthorized, eg. as the access token has expired.
+	// Only the OIDC provider supports this.
+	ProfileURLTokenRefresh bool `json:"profileURLTokenRefresh,omitempty"`
+	// ProfileURLAuthScheme is either 'bearer' or 'basic'. With 'basic' the
+	// ProfileURL requests authenticate with HTTP Basic auth of the client
+	// credentials instead of the access token, for legacy profile endpoints.
+	// default set to 'bearer'
+	ProfileURLAuthScheme string `json:"profileURLAuthScheme,omitempty"`
+	// ClaimExtractionTimeout is how long fetching the ProfileURL claims may
+	// take in total, including retries, so that a slow profile URL doesn't
+	// use up the time of the whole login. Unlimited when not set.
+	ClaimExtractionTimeout Duration `json:"claimExtractionTimeout,omitempty"`
 	// ProtectedResource is the resource that is protected (Azure AD and ADFS only)
 	ProtectedResource string `json:"resource,omitempty"`
 	// ValidateURL is the access token validation endpoint
@@ -70,11 +194,125 @@ type Provider struct {
 	ApprovalPrompt string `json:"approvalPrompt,omitempty"`
 	// AllowedGroups is a list of restrict logins to members of this group
 	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// GroupMatchMode is either 'exact' or 'glob'. With 'glob' the
+	// AllowedGroups may contain wildcards, eg. 'team:*:admin'
+	// default set to 'exact'
+	GroupMatchMode string `json:"groupMatchMode,omitempty"`
+	// NormalizeUnicodeGroups converts group names and AllowedGroups to
+	// Unicode NFC before comparing them, so that equivalent names encoded
+	// differently by different sources still match
+	NormalizeUnicodeGroups bool `json:"normalizeUnicodeGroups,omitempty"`
+	// AutoLoginHint sends the email of an expired session to the provider as
+	// the login_hint when the user is redirected to log in again
+	AutoLoginHint bool `json:"autoLoginHint,omitempty"`
+	// AllowedGroupsRegex is a list of regular expressions, logins are also
+	// restricted to members of groups whose whole name matches one of them
+	AllowedGroupsRegex []string `json:"allowedGroupsRegex,omitempty"`
+	// AllowedGroupsURL serves a JSON array of group names that are allowed
+	// in addition to the AllowedGroups, so that they can be managed in a
+	// central directory. The groups are fetched at startup and refetched
+	// every AllowedGroupsURLRefreshInterval, keeping the last fetched groups
+	// when a refetch fails.
+	AllowedGroupsURL string `json:"allowedGroupsURL,omitempty"`
+	// AllowedGroupsURLRefreshInterval is how often the AllowedGroupsURL is
+	// refetched.
+	// default set to '5m'
+	AllowedGroupsURLRefreshInterval Duration `json:"allowedGroupsURLRefreshInterval,omitempty"`
+	// AllowedSubjects restricts logins to these ID token subjects when set
+	AllowedSubjects []string `json:"allowedSubjects,omitempty"`
+	// DeniedSubjects are ID token subjects that are never allowed to log in,
+	// eg. to lock out a compromised account before the IdP disables it.
+	// Existing sessions of these subjects are rejected as well.
+	DeniedSubjects []string `json:"deniedSubjects,omitempty"`
+	// SubjectPrefix namespaces the users of this provider, the session user
+	// becomes '<prefix>|<subject>'. This stops users of different providers
+	// that issue the same subjects from sharing sessions. Only supported by
+	// the oidc and adfs providers.
+	SubjectPrefix string `json:"subjectPrefix,omitempty"`
+	// SubjectPrefixMigration authorizes sessions created before the
+	// SubjectPrefix was set, prefixing their user instead of rejecting them
+	SubjectPrefixMigration bool `json:"subjectPrefixMigration,omitempty"`
+	// AllowedOrgs restricts logins to users whose active organization, taken
+	// from the OIDCConfig's ActiveOrgClaim, is one of these when set
+	AllowedOrgs []string `json:"allowedOrgs,omitempty"`
+	// RequireGroupsSubsetOf is the closed set of groups users may belong
+	// to, users with a group that isn't in it are not authorized
+	RequireGroupsSubsetOf []string `json:"requireGroupsSubsetOf,omitempty"`
+	// RequiredTokenResponseFields are the fields token endpoint responses
+	// are rejected without, eg. access_token or token_type
+	RequiredTokenResponseFields []string `json:"requiredTokenResponseFields,omitempty"`
+	// GroupResolverURL returns the name of a group as JSON, with `{id}`
+	// replaced by the group ID. It is for providers that only list group
+	// IDs in the groups claim. Only supported by the oidc and adfs providers.
+	GroupResolverURL string `json:"groupResolverURL,omitempty"`
+	// GroupResolverNameField is the field of the GroupResolverURL response
+	// holding the group name, defaults to `name`
+	GroupResolverNameField string `json:"groupResolverNameField,omitempty"`
+	// GroupChangeInvalidatesSession forces users to re-authenticate when
+	// a session refresh returns a different set of groups
+	GroupChangeInvalidatesSession bool `json:"groupChangeInvalidatesSession,omitempty"`
+	// ClaimMappings maps additional ID token claims into the session so that
+	// they can be used as claim sources in headers.
+	// Keys are the claim names (nested claims can be referenced with a dot
+	// separated path), values are the names the claims are stored under.
+	ClaimMappings map[string]string `json:"claimMappings,omitempty"`
+	// TokenEndpointHeaders are extra HTTP headers sent with the requests to
+	// the RedeemURL that redeem and refresh tokens, for IdPs that require
+	// non-standard headers (eg. X-Tenant-ID) on their token endpoint.
+	// Keys are the header names, values are the header values. Headers that
+	// the token request already sets, such as the Authorization of the
+	// client credentials, take precedence and aren't replaced.
+	TokenEndpointHeaders map[string]string `json:"tokenEndpointHeaders,omitempty"`
+	// ClaimRules declares how ID token claims are extracted into the session.
+	// The rules are validated at startup and applied in order, after the
+	// other claim options.
+	ClaimRules []ClaimRule `json:"claimRules,omitempty"`
+	// ForwardAllClaims injects every claim held by the session into upstream
+	// requests as a header named by ForwardExtraClaimsPrefix followed by the
+	// lowercased, hyphenated claim name, eg. 'X-Claim-preferred-username'
+	ForwardAllClaims bool `json:"forwardAllClaims,omitempty"`
+	// ForwardExtraClaimsPrefix is the header name prefix used by ForwardAllClaims
+	// default set to 'X-Claim-'
+	ForwardExtraClaimsPrefix string `json:"forwardExtraClaimsPrefix,omitempty"`
+	// SaveRawClaims stores every claim from the ID token and profile URL in
+	// the session. ID token claims win conflicts unless the OIDC
+	// ClaimPrecedence is 'userinfo_first'.
+	// This can considerably increase the size of cookie sessions.
+	SaveRawClaims bool `json:"saveRawClaims,omitempty"`
 
 	// AcrValues is a string of acr values
 	AcrValues string `json:"acrValues,omitempty"`
 }
 
+// ClaimRule declares how a single ID token claim is extracted into the session
+type ClaimRule struct {
+	// Claim is the name of the source claim.
+	// Nested claims can be referenced with a dot separated path. Values
+	// prefixed with 'jmespath:' or 'jsonpath:' are evaluated as JMESPath or
+	// JSONPath expressions.
+	Claim string `json:"claim,omitempty"`
+	// Target is the session field the claim is stored in, one of 'user',
+	// 'email', 'preferred_username', 'groups' or 'roles'. Any other name
+	// stores the claim under that name so that it can be used as a claim
+	// source in headers.
+	Target string `json:"target,omitempty"`
+	// Type is how the claim is extracted, either 'string' or 'list'.
+	// Lists stored under other names are stored as JSON.
+	// default set to 'list' for groups and roles and 'string' otherwise
+	Type string `json:"type,omitempty"`
+	// Required rejects logins where the claim is missing or empty
+	Required bool `json:"required,omitempty"`
+	// Transform is applied to each extracted value, one of 'lowercase',
+	// 'uppercase' or 'trim'
+	Transform string `json:"transform,omitempty"`
+	// Source overrides the OIDC ClaimPrecedence for the claim, either
+	// 'token' to only extract it from the ID token or 'profile' to only
+	// extract it from the profile URL. Required claims can't have the
+	// 'profile' source.
+	// default follows the ClaimPrecedence
+	Source string `json:"source,omitempty"`
+}
+
 type KeycloakOptions struct {
 	// Group enables to restrict login to members of indicated group
 	Groups []string `json:"groups,omitempty"`
@@ -137,6 +375,17 @@ type OIDCOptions struct {
 	// InsecureAllowUnverifiedEmail prevents failures if an email address in an id_token is not verified
 	// default set to 'false'
 	InsecureAllowUnverifiedEmail bool `json:"insecureAllowUnverifiedEmail,omitempty"`
+	// RequireVerifiedEmailOrEmpty leaves the session email empty when the
+	// email in an id_token is not verified, rather than failing the login.
+	// It can't be combined with InsecureAllowUnverifiedEmail.
+	// default set to 'false'
+	RequireVerifiedEmailOrEmpty bool `json:"requireVerifiedEmailOrEmpty,omitempty"`
+	// RequireEmailVerified checks the 'email_verified' claim for emails taken
+	// from claims other than 'email' too, eg. with an EmailClaim of 'mail',
+	// which are otherwise only verified with an EmailVerifiedClaim. The
+	// EmailVerifiedClaim is checked instead when it is set. Emails are
+	// unverified when the verified claim is missing.
+	RequireEmailVerified bool `json:"requireEmailVerified,omitempty"`
 	// InsecureSkipIssuerVerification skips verification of ID token issuers. When false, ID Token Issuers must match the OIDC discovery URL
 	// default set to 'false'
 	InsecureSkipIssuerVerification bool `json:"insecureSkipIssuerVerification,omitempty"`
@@ -152,15 +401,149 @@ type OIDCOptions struct {
 	// JwksURL is the OpenID Connect JWKS URL
 	// eg: https://www.googleapis.com/oauth2/v3/certs
 	JwksURL string `json:"jwksURL,omitempty"`
-	// EmailClaim indicates which claim contains the user email,
+	// EmailClaim indicates which claim contains the user email.
+	// Nested claims can be referenced with a dot separated path
 	// default set to 'email'
 	EmailClaim string `json:"emailClaim,omitempty"`
-	// GroupsClaim indicates which claim contains the user groups
+	// EmailClaims are tried in order for the user email, taking the first
+	// that is set, eg. ['email', 'mail', 'upn']. When set, EmailClaim is
+	// ignored. Emails taken from claims other than 'email' are only
+	// verified with an EmailVerifiedClaim or RequireEmailVerified.
+	EmailClaims []string `json:"emailClaims,omitempty"`
+	// EmailVerifiedClaim is checked in place of 'email_verified' to verify
+	// emails, including those taken from the 'email' claim, eg.
+	// 'verified_email'. Boolean strings and the strings 'verified' and
+	// 'unverified' are accepted as well as booleans. Emails are rejected when
+	// this claim is unverified, unless InsecureAllowUnverifiedEmail is set.
+	EmailVerifiedClaim string `json:"emailVerifiedClaim,omitempty"`
+	// GroupsClaim indicates which claim contains the user groups.
+	// Nested claims can be referenced with a dot separated path,
+	// eg. 'resource_access.my-client.roles'. Values prefixed with 'jmespath:'
+	// or 'jsonpath:' are evaluated as JMESPath or JSONPath expressions.
+	// Several claims can be given as a comma separated list, eg.
+	// 'groups,roles,realm_access.roles', the first non-empty one is used.
 	// default set to 'groups'
 	GroupsClaim string `json:"groupsClaim,omitempty"`
+	// GroupsClaims are all extracted for the user groups and merged in
+	// order, deduplicated, eg. ['groups', 'roles']. Claims that are missing
+	// are skipped. When set, GroupsClaim is ignored.
+	GroupsClaims []string `json:"groupsClaims,omitempty"`
+	// GroupsClaimPrefixes are prepended to the groups extracted from a
+	// claim, so that groups of the same name from different claims can be
+	// told apart, eg. {'roles': 'role:'} makes the 'admin' role the
+	// 'role:admin' group. Keys are the groups claims, values the prefixes.
+	// Once one claim is prefixed, every groups claim needs a distinct prefix.
+	// AllowedGroups must use the prefixed groups.
+	GroupsClaimPrefixes map[string]string `json:"groupsClaimPrefixes,omitempty"`
+	// GroupsClaimRequired fails logins when neither the ID token nor the
+	// ProfileURL has the GroupsClaim, so that authorization policies that
+	// depend on groups always have groups to work with. An empty groups
+	// claim in the ID token is accepted.
+	GroupsClaimRequired bool `json:"groupsClaimRequired,omitempty"`
+	// RolesClaim indicates which claim contains the user roles.
+	// Nested claims can be referenced with a dot separated path.
+	// Roles are only added to the session when set, or when
+	// AccessTokenRoles is enabled, which defaults it to 'roles'
+	RolesClaim string `json:"rolesClaim,omitempty"`
+	// ActiveOrgClaim indicates which claim contains the organization the user
+	// is acting for, for users that belong to several organizations.
+	// Nested claims can be referenced with a dot separated path.
+	// The active organization is only added to the session when set.
+	ActiveOrgClaim string `json:"activeOrgClaim,omitempty"`
+	// CostCenterClaim indicates which claim contains the billing code or
+	// cost center the user's usage is attributed to.
+	// Nested claims can be referenced with a dot separated path.
+	// The cost center is only added to the session when set.
+	CostCenterClaim string `json:"costCenterClaim,omitempty"`
+	// AccessTokenRoles merges the roles from the access token into the
+	// session, deduplicated with those from the id_token. The access token
+	// is only used when it is a JWT that passes the id_token verification.
+	// default set to 'false'
+	AccessTokenRoles bool `json:"accessTokenRoles,omitempty"`
+	// AccessTokenSubjectClaim is a claim of the access token used as the
+	// session user instead of the id_token subject, eg. for Keycloak service
+	// account tokens. The access token is only used when it is a JWT that
+	// passes the id_token verification.
+	AccessTokenSubjectClaim string `json:"accessTokenSubjectClaim,omitempty"`
+	// GroupsFromAccessToken merges the GroupsClaim of the access token into
+	// the session groups, deduplicated with those from the id_token, for
+	// providers such as Azure that only put groups in the access token.
+	// The access token must be a JWT, it is not verified.
+	// default set to 'false'
+	GroupsFromAccessToken bool `json:"groupsFromAccessToken,omitempty"`
 	// UserIDClaim indicates which claim contains the user ID
 	// default set to 'email'
 	UserIDClaim string `json:"userIDClaim,omitempty"`
+	// GroupsJMESPath is a JMESPath expression used to extract the user groups
+	// from the claims, eg. 'resource_access.*.roles[]'.
+	// When set, this takes precedence over GroupsClaim
+	GroupsJMESPath string `json:"groupsJMESPath,omitempty"`
+	// DiscoveryCacheFile is the path of a file the OIDC discovery document is
+	// persisted to after a successful discovery. If discovery fails on startup,
+	// the cached document is used instead.
+	DiscoveryCacheFile string `json:"discoveryCacheFile,omitempty"`
+	// DiscoveryMaxRetries is how many times a failed OIDC discovery on startup
+	// is retried before giving up, eg. to ride out a network blip.
+	// default set to '0'
+	DiscoveryMaxRetries int `json:"discoveryMaxRetries,omitempty"`
+	// DiscoveryRetryInterval is how long to wait before the first OIDC
+	// discovery retry, doubling for each later retry.
+	// default set to '1s'
+	DiscoveryRetryInterval Duration `json:"discoveryRetryInterval,omitempty"`
+	// DiscoveryExtraFields are non-standard fields of the OIDC discovery
+	// document, eg. 'tenant_region_scope', extracted for use by the provider.
+	// Nested fields can be referenced with a dot separated path.
+	DiscoveryExtraFields []string `json:"discoveryExtraFields,omitempty"`
+	// GroupsFlattenMap converts a groups claim that is a map of group to role,
+	// eg. {"eng": "admin"}, into qualified group names, eg. 'eng:admin'
+	// default set to 'false'
+	GroupsFlattenMap bool `json:"groupsFlattenMap,omitempty"`
+	// ClaimPrecedence controls whether claims from the id_token or the
+	// profile URL (userinfo) take precedence when both are available.
+	// Either 'id_token_first' or 'userinfo_first'. With 'userinfo_first' the
+	// profile URL is always requested.
+	// default set to 'id_token_first'
+	ClaimPrecedence string `json:"claimPrecedence,omitempty"`
+	// ClaimTypeConflict controls how a claim that is an array in one of the
+	// id_token and profile URL claims and a single value in the other is
+	// merged. Either 'union', which merges both into an array of their
+	// distinct values, or 'precedence', which uses the claim from the source
+	// that takes precedence. Conflicting claim types are always logged.
+	// default set to 'union'
+	ClaimTypeConflict string `json:"claimTypeConflict,omitempty"`
+	// PreferNonEmptyClaims treats claims that are null, an empty string or an
+	// empty array as missing from the source that takes precedence, so that
+	// the claim from the other source is used. For example the groups are
+	// requested from the profile URL when the id_token's groups are '[]'.
+	// default set to 'false'
+	PreferNonEmptyClaims bool `json:"preferNonEmptyClaims,omitempty"`
+	// MaxIDTokenBytes is the maximum size of a raw ID token. Larger tokens are
+	// rejected during redemption and refresh.
+	// default set to '0' (no limit)
+	MaxIDTokenBytes int `json:"maxIDTokenBytes,omitempty"`
+	// EmailFromSubject uses the subject as the user's email when there is no
+	// email claim and the subject is an email address
+	// default set to 'false'
+	EmailFromSubject bool `json:"emailFromSubject,omitempty"`
+	// SkewTolerance is the clock skew allowed between the proxy and the
+	// IdP when checking the ID token issue and expiry times. The issue
+	// time is only checked when it is set.
+	// default set to '0s'
+	SkewTolerance Duration `json:"skewTolerance,omitempty"`
+	// IssuerURLNormalize ignores trailing slashes when comparing the
+	// IssuerURL with the issuer of the discovery document and ID tokens,
+	// for providers that add or drop one.
+	// default set to 'false'
+	IssuerURLNormalize bool `json:"issuerURLNormalize,omitempty"`
+	// AdditionalAudiences are accepted in the 'aud' claim of ID tokens as
+	// well as the ClientID, for providers that issue tokens for several
+	// audiences, eg. ['api.example.com'].
+	AdditionalAudiences []string `json:"additionalAudiences,omitempty"`
+	// RequireAudienceExact rejects ID tokens with an 'aud' entry other than
+	// the ClientID and AdditionalAudiences, preventing tokens issued for
+	// other services from being accepted.
+	// default set to 'false'
+	RequireAudienceExact bool `json:"requireAudienceExact,omitempty"`
 }
 
 type LoginGovOptions struct {
@@ -172,12 +555,23 @@ type LoginGovOptions struct {
 	PubJWKURL string `json:"pubjwkURL,omitempty"`
 }
 
+type ALBOptions struct {
+	// Region is the AWS region of the ALB, used to fetch its public keys
+	// Default value is 'us-east-1'
+	Region string `json:"region,omitempty"`
+	// SignerARN is the ARN of the ALB expected to sign the user claims.
+	// Required, as the ALB public keys are shared by every ALB in a region.
+	SignerARN string `json:"signerARN,omitempty"`
+}
+
 func providerDefaults() Providers {
 	providers := Providers{
 		{
-			Type:           "google",
-			Prompt:         "", // Change to "login" when ApprovalPrompt officially deprecated
-			ApprovalPrompt: "force",
+			Type:               "google",
+			Prompt:             "", // Change to "login" when ApprovalPrompt officially deprecated
+			ApprovalPrompt:     "force",
+			HSTSMaxAge:         Duration(providers.DefaultHSTSMaxAge),
+			ProfileURLCacheTTL: Duration(providers.DefaultProfileCacheTTL),
 			AzureConfig: AzureOptions{
 				Tenant: "common",
 			},
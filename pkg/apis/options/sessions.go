@@ -32,6 +32,10 @@ type RedisStoreOptions struct {
 	ClusterConnectionURLs  []string `flag:"redis-cluster-connection-urls" cfg:"redis_cluster_connection_urls"`
 	CAPath                 string   `flag:"redis-ca-path" cfg:"redis_ca_path"`
 	InsecureSkipTLSVerify  bool     `flag:"redis-insecure-skip-tls-verify" cfg:"redis_insecure_skip_tls_verify"`
+
+	// KeyPrefix is prepended to the keys of the sessions so that multiple
+	// proxy instances can share one redis database
+	KeyPrefix string `flag:"redis-key-prefix" cfg:"redis_key_prefix"`
 }
 
 func sessionOptionsDefaults() SessionOptions {
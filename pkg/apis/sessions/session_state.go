@@ -18,6 +18,11 @@ import (
 type SessionState struct {
 	CreatedAt *time.Time `msgpack:"ca,omitempty"`
 	ExpiresOn *time.Time `msgpack:"eo,omitempty"`
+	// AuthTime is when the user last actively authenticated with the provider
+	AuthTime *time.Time `msgpack:"au,omitempty"`
+	// LoggedInAt is when the user logged in to the proxy, kept while the
+	// session is extended on activity
+	LoggedInAt *time.Time `msgpack:"li,omitempty"`
 
 	AccessToken  string `msgpack:"at,omitempty"`
 	IDToken      string `msgpack:"it,omitempty"`
@@ -28,11 +33,29 @@ type SessionState struct {
 	Email             string   `msgpack:"e,omitempty"`
 	User              string   `msgpack:"u,omitempty"`
 	Groups            []string `msgpack:"g,omitempty"`
+	Roles             []string `msgpack:"ro,omitempty"`
 	PreferredUsername string   `msgpack:"pu,omitempty"`
+	// ActiveOrg is the organization the user is acting for, for users that
+	// belong to several organizations
+	ActiveOrg string `msgpack:"ao,omitempty"`
+	// CostCenter is the billing code the user's usage is attributed to
+	CostCenter string `msgpack:"cc,omitempty"`
+
+	// Extra holds additional claims mapped into the session by the provider
+	Extra map[string]string `msgpack:"x,omitempty"`
+	// RawClaims holds the JSON of all claims from the id_token and profile URL
+	// when the provider is configured to save them
+	RawClaims []byte `msgpack:"rc,omitempty"`
 
 	// Internal helpers, not serialized
 	Clock clock.Clock `msgpack:"-"`
 	Lock  Lock        `msgpack:"-"`
+	// EmailUnverified is set when the provider cleared an unverified email,
+	// so that it isn't filled in from elsewhere while creating the session
+	EmailUnverified bool `msgpack:"-"`
+	// GroupsIncomplete is set when the groups were to be fetched while
+	// creating the session but the fetch failed or was skipped
+	GroupsIncomplete bool `msgpack:"-"`
 }
 
 func (s *SessionState) ObtainLock(ctx context.Context, expiration time.Duration) error {
@@ -121,6 +144,12 @@ func (s *SessionState) String() string {
 	if len(s.Groups) > 0 {
 		o += fmt.Sprintf(" groups:%v", s.Groups)
 	}
+	if s.ActiveOrg != "" {
+		o += fmt.Sprintf(" active_org:%s", s.ActiveOrg)
+	}
+	if s.CostCenter != "" {
+		o += fmt.Sprintf(" cost_center:%s", s.CostCenter)
+	}
 	return o + "}"
 }
 
@@ -147,13 +176,58 @@ func (s *SessionState) GetClaim(claim string) []string {
 		groups := make([]string, len(s.Groups))
 		copy(groups, s.Groups)
 		return groups
+	case "roles":
+		roles := make([]string, len(s.Roles))
+		copy(roles, s.Roles)
+		return roles
 	case "preferred_username":
 		return []string{s.PreferredUsername}
+	case "active_org":
+		return []string{s.ActiveOrg}
+	case "cost_center":
+		if s.CostCenter != "" {
+			return []string{s.CostCenter}
+		}
+		// A cost center claim may also be mapped into the Extra claims
+		if value, ok := s.Extra[claim]; ok {
+			return []string{value}
+		}
+		return []string{}
 	default:
+		if value, ok := s.Extra[claim]; ok {
+			return []string{value}
+		}
 		return []string{}
 	}
 }
 
+// GetAllClaims returns all identity claims held by the session, including
+// any Extra claims, keyed by claim name. Tokens and timestamps are excluded.
+func (s *SessionState) GetAllClaims() map[string][]string {
+	claims := map[string][]string{}
+	if s == nil {
+		return claims
+	}
+
+	for _, claim := range []string{"user", "email", "groups", "roles", "preferred_username", "active_org", "cost_center"} {
+		for _, value := range s.GetClaim(claim) {
+			if value != "" {
+				claims[claim] = append(claims[claim], value)
+			}
+		}
+	}
+	for claim, value := range s.Extra {
+		// The session fields take precedence over Extra claims of the same name
+		if _, ok := claims[claim]; ok {
+			continue
+		}
+		if value != "" {
+			claims[claim] = []string{value}
+		}
+	}
+	return claims
+}
+
 // CheckNonce compares the Nonce against a potential hash of it
 func (s *SessionState) CheckNonce(hashed string) bool {
 	return encryption.CheckNonce(s.Nonce, hashed)
@@ -135,6 +135,45 @@ func TestString(t *testing.T) {
 	}
 }
 
+func TestCostCenterClaim(t *testing.T) {
+	testCases := map[string]struct {
+		sessionState *SessionState
+		expected     []string
+	}{
+		"From the session": {
+			sessionState: &SessionState{CostCenter: "cc-1234"},
+			expected:     []string{"cc-1234"},
+		},
+		"From a mapped extra claim": {
+			sessionState: &SessionState{Extra: map[string]string{"cost_center": "cc-5678"}},
+			expected:     []string{"cc-5678"},
+		},
+		"Session takes precedence": {
+			sessionState: &SessionState{
+				CostCenter: "cc-1234",
+				Extra:      map[string]string{"cost_center": "cc-5678"},
+			},
+			expected: []string{"cc-1234"},
+		},
+		"Not set": {
+			sessionState: &SessionState{},
+			expected:     []string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			gs := NewWithT(t)
+			gs.Expect(tc.sessionState.GetClaim("cost_center")).To(Equal(tc.expected))
+			if len(tc.expected) > 0 {
+				gs.Expect(tc.sessionState.GetAllClaims()).To(HaveKeyWithValue("cost_center", tc.expected))
+			} else {
+				gs.Expect(tc.sessionState.GetAllClaims()).ToNot(HaveKey("cost_center"))
+			}
+		})
+	}
+}
+
 func TestIsExpired(t *testing.T) {
 	s := &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(-1) * time.Minute))}
 	assert.Equal(t, true, s.IsExpired())
@@ -224,6 +263,76 @@ func TestEncodeAndDecodeSessionState(t *testing.T) {
 			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
 			Groups:            []string{"group-a", "group-b"},
 		},
+		"With roles": {
+			Email:             "username@example.com",
+			User:              "username",
+			PreferredUsername: "preferred.username",
+			AccessToken:       "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:           "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:         &created,
+			ExpiresOn:         &expires,
+			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
+			Groups:            []string{"group-a", "group-b"},
+			Roles:             []string{"role-a", "role-b"},
+		},
+		"With raw claims": {
+			Email:             "username@example.com",
+			User:              "username",
+			PreferredUsername: "preferred.username",
+			AccessToken:       "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:           "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:         &created,
+			ExpiresOn:         &expires,
+			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
+			RawClaims:         []byte(`{"sub":"username","department":"engineering"}`),
+		},
+		"With extra claims": {
+			Email:             "username@example.com",
+			User:              "username",
+			PreferredUsername: "preferred.username",
+			AccessToken:       "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:           "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:         &created,
+			ExpiresOn:         &expires,
+			RefreshToken:      "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			Nonce:             []byte("abcdef1234567890abcdef1234567890"),
+			Extra: map[string]string{
+				"department":  "engineering",
+				"cost_center": "12345",
+			},
+		},
+		"With cost center": {
+			Email:        "username@example.com",
+			User:         "username",
+			AccessToken:  "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:      "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:    &created,
+			ExpiresOn:    &expires,
+			RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CostCenter:   "cc-1234",
+		},
+		"With auth time": {
+			Email:        "username@example.com",
+			User:         "username",
+			AccessToken:  "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:      "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:    &created,
+			ExpiresOn:    &expires,
+			AuthTime:     &created,
+			RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+		},
+		"With logged in time": {
+			Email:        "username@example.com",
+			User:         "username",
+			AccessToken:  "AccessToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			IDToken:      "IDToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+			CreatedAt:    &created,
+			ExpiresOn:    &expires,
+			LoggedInAt:   &created,
+			RefreshToken: "RefreshToken.12349871293847fdsaihf9238h4f91h8fr.1349f831y98fd7",
+		},
 	}
 
 	for _, secretSize := range []int{16, 24, 32} {
@@ -297,13 +406,29 @@ func compareSessionStates(t *testing.T, expected *SessionState, actual *SessionS
 	} else {
 		assert.Nil(t, actual.ExpiresOn)
 	}
+	if expected.AuthTime != nil {
+		assert.NotNil(t, actual.AuthTime)
+		assert.Equal(t, true, expected.AuthTime.Equal(*actual.AuthTime))
+	} else {
+		assert.Nil(t, actual.AuthTime)
+	}
+	if expected.LoggedInAt != nil {
+		assert.NotNil(t, actual.LoggedInAt)
+		assert.Equal(t, true, expected.LoggedInAt.Equal(*actual.LoggedInAt))
+	} else {
+		assert.Nil(t, actual.LoggedInAt)
+	}
 
 	// Compare sessions without *time.Time fields
 	exp := *expected
 	exp.CreatedAt = nil
 	exp.ExpiresOn = nil
+	exp.AuthTime = nil
+	exp.LoggedInAt = nil
 	act := *actual
 	act.CreatedAt = nil
 	act.ExpiresOn = nil
+	act.AuthTime = nil
+	act.LoggedInAt = nil
 	assert.Equal(t, exp, act)
 }
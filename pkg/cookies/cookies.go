@@ -12,6 +12,10 @@ import (
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 )
 
+// HostCookiePrefix is the cookie name prefix that browsers only accept on
+// cookies that are Secure, have the path / and no domain
+const HostCookiePrefix = "__Host-"
+
 // MakeCookieFromOptions constructs a cookie based on the given *options.CookieOptions,
 // value and creation time
 func MakeCookieFromOptions(req *http.Request, name string, value string, opts *options.Cookie, expiration time.Duration, now time.Time) *http.Cookie {
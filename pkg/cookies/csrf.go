@@ -23,6 +23,9 @@ type CSRF interface {
 
 	SetSessionNonce(s *sessions.SessionState)
 
+	SetCodeVerifier(verifier string)
+	GetCodeVerifier() string
+
 	SetCookie(http.ResponseWriter, *http.Request) (*http.Cookie, error)
 	ClearCookie(http.ResponseWriter, *http.Request)
 }
@@ -38,17 +41,22 @@ type csrf struct {
 	// is used to mitigate replay attacks.
 	OIDCNonce []byte `msgpack:"n,omitempty"`
 
+	// CodeVerifier holds the PKCE code verifier whose code challenge was sent
+	// in the initial authentication request, it is sent to the IdP when the
+	// code is redeemed.
+	CodeVerifier string `msgpack:"v,omitempty"`
+
 	cookieOpts *options.Cookie
 	time       clock.Clock
 }
 
-// NewCSRF creates a CSRF with random nonces
-func NewCSRF(opts *options.Cookie) (CSRF, error) {
-	state, err := encryption.Nonce()
+// NewCSRF creates a CSRF with random nonces of nonceLength bytes
+func NewCSRF(opts *options.Cookie, nonceLength int) (CSRF, error) {
+	state, err := encryption.NonceWithLength(nonceLength)
 	if err != nil {
 		return nil, err
 	}
-	nonce, err := encryption.Nonce()
+	nonce, err := encryption.NonceWithLength(nonceLength)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +105,17 @@ func (c *csrf) SetSessionNonce(s *sessions.SessionState) {
 	s.Nonce = c.OIDCNonce
 }
 
+// SetCodeVerifier sets the PKCE code verifier of the authentication flow
+func (c *csrf) SetCodeVerifier(verifier string) {
+	c.CodeVerifier = verifier
+}
+
+// GetCodeVerifier returns the PKCE code verifier of the authentication flow,
+// empty when PKCE isn't used
+func (c *csrf) GetCodeVerifier() string {
+	return c.CodeVerifier
+}
+
 // SetCookie encodes the CSRF to a signed cookie and sets it on the ResponseWriter
 func (c *csrf) SetCookie(rw http.ResponseWriter, req *http.Request) (*http.Cookie, error) {
 	encoded, err := c.encodeCookie()
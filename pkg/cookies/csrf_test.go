@@ -33,7 +33,7 @@ var _ = Describe("CSRF Cookie Tests", func() {
 		}
 
 		var err error
-		publicCSRF, err = NewCSRF(cookieOpts)
+		publicCSRF, err = NewCSRF(cookieOpts, encryption.DefaultNonceLength)
 		Expect(err).ToNot(HaveOccurred())
 
 		privateCSRF = publicCSRF.(*csrf)
@@ -47,12 +47,20 @@ var _ = Describe("CSRF Cookie Tests", func() {
 		})
 
 		It("makes unique nonces between multiple CSRFs", func() {
-			other, err := NewCSRF(cookieOpts)
+			other, err := NewCSRF(cookieOpts, encryption.DefaultNonceLength)
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(privateCSRF.OAuthState).ToNot(Equal(other.(*csrf).OAuthState))
 			Expect(privateCSRF.OIDCNonce).ToNot(Equal(other.(*csrf).OIDCNonce))
 		})
+
+		It("makes nonces of the requested length", func() {
+			other, err := NewCSRF(cookieOpts, 16)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(other.(*csrf).OAuthState).To(HaveLen(16))
+			Expect(other.(*csrf).OIDCNonce).To(HaveLen(16))
+		})
 	})
 
 	Context("CheckOAuthState and CheckOIDCNonce", func() {
@@ -103,6 +111,22 @@ var _ = Describe("CSRF Cookie Tests", func() {
 			Expect(decoded.OIDCNonce).To(Equal([]byte(csrfNonce)))
 		})
 
+		It("encodes and decodes the PKCE code verifier", func() {
+			publicCSRF.SetCodeVerifier("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+			encoded, err := privateCSRF.encodeCookie()
+			Expect(err).ToNot(HaveOccurred())
+
+			cookie := &http.Cookie{
+				Name:  privateCSRF.cookieName(),
+				Value: encoded,
+			}
+			decoded, err := decodeCSRFCookie(cookie, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(decoded.GetCodeVerifier()).To(Equal("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+		})
+
 		It("signs the encoded cookie value", func() {
 			encoded, err := privateCSRF.encodeCookie()
 			Expect(err).ToNot(HaveOccurred())
@@ -8,9 +8,18 @@ import (
 	"golang.org/x/crypto/blake2b"
 )
 
+// DefaultNonceLength is the length in bytes of nonces generated by Nonce
+const DefaultNonceLength = 32
+
 // Nonce generates a random 32-byte slice to be used as a nonce
 func Nonce() ([]byte, error) {
-	b := make([]byte, 32)
+	return NonceWithLength(DefaultNonceLength)
+}
+
+// NonceWithLength generates a random slice of the given length to be used
+// as a nonce
+func NonceWithLength(length int) ([]byte, error) {
+	b := make([]byte, length)
 	_, err := rand.Read(b)
 	if err != nil {
 		return nil, err
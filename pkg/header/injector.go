@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options/util"
@@ -39,6 +40,38 @@ func NewInjector(headers []options.Header) (Injector, error) {
 	return &injector{valueInjectors: injectors}, nil
 }
 
+// NewAllClaimsInjector creates an Injector that adds every claim held by the
+// session as a header, named by the prefix followed by the claim name.
+func NewAllClaimsInjector(prefix string) Injector {
+	allClaimsInjector := newInjectorFunc(func(header http.Header, session *sessionsapi.SessionState) {
+		for claim, values := range session.GetAllClaims() {
+			name := ClaimHeaderName(prefix, claim)
+			for _, value := range values {
+				header.Add(name, value)
+			}
+		}
+	})
+	return &injector{valueInjectors: []valueInjector{allClaimsInjector}}
+}
+
+// ClaimHeaderName converts a claim name into a header name with the given
+// prefix. The claim name is lowercased and any character that isn't a letter
+// or a digit is replaced with a hyphen, eg. `preferred_username` becomes
+// `preferred-username`.
+func ClaimHeaderName(prefix, claim string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, claim)
+	return prefix + name
+}
+
 type valueInjector interface {
 	inject(http.Header, *sessionsapi.SessionState)
 }
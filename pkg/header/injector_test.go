@@ -414,4 +414,41 @@ var _ = Describe("Injector Suite", func() {
 			}),
 		)
 	})
+
+	Context("NewAllClaimsInjector", func() {
+		It("injects every claim with the prefix", func() {
+			injector := NewAllClaimsInjector("X-Claim-")
+
+			headers := http.Header{
+				"Foo": []string{"bar"},
+			}
+			injector.Inject(headers, &sessionsapi.SessionState{
+				User:              "user-123",
+				Email:             "user@example.com",
+				Groups:            []string{"a", "b"},
+				PreferredUsername: "",
+				AccessToken:       "access-token",
+				Extra: map[string]string{
+					"Cost_Center": "12345",
+				},
+			})
+			Expect(headers).To(Equal(http.Header{
+				"Foo":                 []string{"bar"},
+				"X-Claim-User":        []string{"user-123"},
+				"X-Claim-Email":       []string{"user@example.com"},
+				"X-Claim-Groups":      []string{"a", "b"},
+				"X-Claim-Cost-Center": []string{"12345"},
+			}))
+		})
+	})
+
+	DescribeTable("ClaimHeaderName",
+		func(prefix, claim, expected string) {
+			Expect(ClaimHeaderName(prefix, claim)).To(Equal(expected))
+		},
+		Entry("with a simple claim", "X-Claim-", "email", "X-Claim-email"),
+		Entry("with an underscored claim", "X-Claim-", "preferred_username", "X-Claim-preferred-username"),
+		Entry("with an uppercase claim", "X-Claim-", "CostCenter", "X-Claim-costcenter"),
+		Entry("with a URL claim", "X-", "https://example.com/roles", "X-https---example-com-roles"),
+	)
 })
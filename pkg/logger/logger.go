@@ -425,6 +425,20 @@ func SetOutput(w io.Writer) {
 	std.writer = w
 }
 
+// StandardWriter returns a writer to the standard logger's default channel,
+// eg. for a JSONLogger writing alongside the standard logs
+func StandardWriter() io.Writer {
+	return standardWriter{}
+}
+
+type standardWriter struct{}
+
+func (standardWriter) Write(p []byte) (int, error) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	return std.writer.Write(p)
+}
+
 // SetErrOutput sets the output destination for the standard logger's error channel.
 func SetErrOutput(w io.Writer) {
 	std.mu.Lock()
@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructuredLogger logs messages along with key-value pairs of context,
+// eg. Errorw("unable to format claim", "claim", "groups"), so that log
+// aggregators can parse the context rather than the message
+type StructuredLogger interface {
+	// Infow logs a message with key-value pairs at the default level
+	Infow(msg string, keysAndValues ...interface{})
+	// Errorw logs a message with key-value pairs at the error level
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// StandardStructuredLogger returns a StructuredLogger that writes to the
+// standard logger, with the key-value pairs appended to the message as
+// `key=value`
+func StandardStructuredLogger() StructuredLogger {
+	return standardStructuredLogger{logger: std}
+}
+
+type standardStructuredLogger struct {
+	logger *Logger
+}
+
+func (l standardStructuredLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.logger.Output(DEFAULT, 2, formatKeysAndValues(msg, keysAndValues))
+}
+
+func (l standardStructuredLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.logger.Output(ERROR, 2, formatKeysAndValues(msg, keysAndValues))
+}
+
+// formatKeysAndValues appends the key-value pairs to the message as
+// `key=value`, quoting values that are empty or contain spaces or quotes
+func formatKeysAndValues(msg string, keysAndValues []interface{}) string {
+	b := new(strings.Builder)
+	b.WriteString(msg)
+	for _, kv := range pairKeysAndValues(keysAndValues) {
+		value := fmt.Sprint(kv.value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(b, " %s=%s", kv.key, value)
+	}
+	return b.String()
+}
+
+// keyValue is a single key-value pair of context
+type keyValue struct {
+	key   string
+	value interface{}
+}
+
+// pairKeysAndValues pairs up the alternating keys and values in order.
+// A trailing key without a value is given the value `(MISSING)`.
+func pairKeysAndValues(keysAndValues []interface{}) []keyValue {
+	pairs := make([]keyValue, 0, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		pairs = append(pairs, keyValue{key: fmt.Sprint(keysAndValues[i]), value: value})
+	}
+	return pairs
+}
+
+// JSONLogger is a StructuredLogger that writes each message as a single
+// line JSON object. The key-value pairs become fields of the object
+// alongside the RFC 5424 `severity` (3 for errors, 6 for informational
+// messages), an RFC 3339 `timestamp` and the `msg`, which take precedence
+// over context of the same name.
+type JSONLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	now    func() time.Time
+}
+
+// NewJSONLogger creates a JSONLogger writing to the writer
+func NewJSONLogger(writer io.Writer) *JSONLogger {
+	return &JSONLogger{
+		writer: writer,
+		now:    time.Now,
+	}
+}
+
+const (
+	// severityError is the RFC 5424 severity of error conditions
+	severityError = 3
+	// severityInformational is the RFC 5424 severity of informational
+	// messages
+	severityInformational = 6
+)
+
+// Infow writes the message with the informational severity
+func (l *JSONLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.write(severityInformational, msg, keysAndValues)
+}
+
+// Errorw writes the message with the error severity
+func (l *JSONLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.write(severityError, msg, keysAndValues)
+}
+
+func (l *JSONLogger) write(severity int, msg string, keysAndValues []interface{}) {
+	line, err := json.Marshal(l.newEntry(severity, msg, keysAndValues, false))
+	if err != nil {
+		// Fall back to the formatted values when the context can't be
+		// encoded as JSON, eg. it contains channels or functions
+		line, _ = json.Marshal(l.newEntry(severity, msg, keysAndValues, true))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.writer.Write(append(line, '\n')); err != nil {
+		panic(err)
+	}
+}
+
+// newEntry builds the JSON object of a message, errors in the context are
+// replaced by their message and everything is formatted when stringify is
+// set
+func (l *JSONLogger) newEntry(severity int, msg string, keysAndValues []interface{}, stringify bool) map[string]interface{} {
+	entry := make(map[string]interface{}, len(keysAndValues)/2+3)
+	for _, kv := range pairKeysAndValues(keysAndValues) {
+		switch value := kv.value.(type) {
+		case error:
+			entry[kv.key] = value.Error()
+		default:
+			if stringify {
+				entry[kv.key] = fmt.Sprint(value)
+			} else {
+				entry[kv.key] = value
+			}
+		}
+	}
+	entry["timestamp"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["severity"] = severity
+	entry["msg"] = msg
+	return entry
+}
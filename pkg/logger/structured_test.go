@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatKeysAndValues(t *testing.T) {
+	testCases := map[string]struct {
+		keysAndValues []interface{}
+		expected      string
+	}{
+		"no context": {
+			keysAndValues: nil,
+			expected:      "message",
+		},
+		"simple values": {
+			keysAndValues: []interface{}{"provider", "OpenID Connect", "claim", "groups", "retries", 3},
+			expected:      `message provider="OpenID Connect" claim=groups retries=3`,
+		},
+		"empty value": {
+			keysAndValues: []interface{}{"email", ""},
+			expected:      `message email=""`,
+		},
+		"error value": {
+			keysAndValues: []interface{}{"error", errors.New("bad claim")},
+			expected:      `message error="bad claim"`,
+		},
+		"missing value": {
+			keysAndValues: []interface{}{"claim", "groups", "email"},
+			expected:      "message claim=groups email=(MISSING)",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, formatKeysAndValues("message", tc.keysAndValues))
+		})
+	}
+}
+
+func TestStandardStructuredLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := New(Lshortfile)
+	l.writer = out
+	l.errWriter = out
+
+	standardStructuredLogger{logger: l}.Errorw("unable to format claim", "claim", "groups")
+
+	assert.Regexp(t, `^\[.*\] \[structured_test.go:\d+\] unable to format claim claim=groups\n$`, out.String())
+}
+
+func TestJSONLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	l := NewJSONLogger(out)
+	l.now = func() time.Time { return time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC) }
+
+	l.Errorw("unable to format claim", "provider", "OpenID Connect", "claim", "groups", "error", errors.New("bad claim"))
+	l.Infow("skipping claims", "count", 2, "msg", "ignored", "channel", make(chan int))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, map[string]interface{}{
+		"timestamp": "2021-03-04T05:06:07Z",
+		"severity":  float64(3),
+		"msg":       "unable to format claim",
+		"provider":  "OpenID Connect",
+		"claim":     "groups",
+		"error":     "bad claim",
+	}, entry)
+
+	entry = nil
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, float64(6), entry["severity"])
+	assert.Equal(t, "skipping claims", entry["msg"])
+	assert.Equal(t, "2", entry["count"])
+	assert.Contains(t, entry["channel"], "0x")
+}
+
+func BenchmarkStructuredLoggers(b *testing.B) {
+	std := New(LstdFlags)
+	std.writer = ioutil.Discard
+	std.errWriter = ioutil.Discard
+	err := errors.New("bad claim")
+
+	b.Run("Errorf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			std.Output(ERROR, 1, fmt.Sprintf("Warning: unable to format claim %q with error %s", "groups", err))
+		}
+	})
+	b.Run("StandardStructuredLogger", func(b *testing.B) {
+		l := standardStructuredLogger{logger: std}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Errorw("Warning: unable to format claim", "provider", "OpenID Connect", "claim", "groups", "error", err)
+		}
+	})
+	b.Run("JSONLogger", func(b *testing.B) {
+		l := NewJSONLogger(ioutil.Discard)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Errorw("Warning: unable to format claim", "provider", "OpenID Connect", "claim", "groups", "error", err)
+		}
+	})
+}
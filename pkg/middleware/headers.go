@@ -82,6 +82,33 @@ func injectRequestHeaders(injector header.Injector, next http.Handler) http.Hand
 	})
 }
 
+// NewAllClaimsRequestHeaderInjector returns a middleware that adds every claim
+// in the session as a request header named by the prefix followed by the
+// claim name. Any headers with the prefix already on the request are removed
+// so that they cannot be spoofed by the client.
+func NewAllClaimsRequestHeaderInjector(prefix string) alice.Constructor {
+	injector := header.NewAllClaimsInjector(prefix)
+	lowerPrefix := strings.ToLower(prefix)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			for name := range req.Header {
+				if strings.HasPrefix(strings.ToLower(name), lowerPrefix) {
+					req.Header.Del(name)
+				}
+			}
+
+			scope := middlewareapi.GetRequestScope(req)
+
+			// If scope is nil, this will panic.
+			// A scope should always be injected before this handler is called.
+			injector.Inject(req.Header, scope.Session)
+			flattenHeaders(req.Header)
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
 func NewResponseHeaderInjector(headers []options.Header) (alice.Constructor, error) {
 	headerInjector, err := newResponseHeaderInjector(headers)
 	if err != nil {
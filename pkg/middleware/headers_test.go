@@ -208,6 +208,34 @@ var _ = Describe("Headers Suite", func() {
 		}),
 	)
 
+	It("injects all claims and strips spoofed claim headers", func() {
+		scope := &middlewareapi.RequestScope{
+			Session: &sessionsapi.SessionState{
+				User:   "user-123",
+				Groups: []string{"a", "b"},
+			},
+		}
+
+		req := httptest.NewRequest("", "/", nil)
+		req = middlewareapi.AddRequestScope(req, scope)
+		req.Header = http.Header{
+			"Foo":           []string{"bar"},
+			"X-Claim-Email": []string{"spoofed@example.com"},
+		}
+
+		var gotHeaders http.Header
+		handler := NewAllClaimsRequestHeaderInjector("X-Claim-")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotHeaders = r.Header.Clone()
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(gotHeaders).To(Equal(http.Header{
+			"Foo":            []string{"bar"},
+			"X-Claim-User":   []string{"user-123"},
+			"X-Claim-Groups": []string{"a,b"},
+		}))
+	})
+
 	DescribeTable("the response header injector",
 		func(in headersTableInput) {
 			scope := &middlewareapi.RequestScope{
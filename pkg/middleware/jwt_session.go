@@ -10,6 +10,7 @@ import (
 	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
 	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
@@ -74,7 +75,7 @@ func (j *jwtSessionLoader) getJwtSession(req *http.Request) (*sessionsapi.Sessio
 	// This leading error message only occurs if all session loaders fail
 	errs := []error{errors.New("unable to verify bearer token")}
 	for _, loader := range j.sessionLoaders {
-		session, err := loader(req.Context(), token)
+		session, err := loader(providers.ContextWithRequest(req.Context(), req), token)
 		if err != nil {
 			errs = append(errs, err)
 			continue
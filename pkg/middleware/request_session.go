@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/justinas/alice"
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// NewRequestSessionLoader creates a new middleware that loads sessions from
+// request headers set by a trusted upstream, such as an AWS ALB.
+func NewRequestSessionLoader(sessionLoader middlewareapi.RequestToSessionFunc) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return loadRequestSession(sessionLoader, next)
+	}
+}
+
+// loadRequestSession attempts to load a session from the request using the
+// session loader.
+// If no session is found, or the session is invalid, no session will be
+// loaded and the request will be passed to the next handler.
+// If a session was loaded by a previous handler, it will not be replaced.
+func loadRequestSession(sessionLoader middlewareapi.RequestToSessionFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middlewareapi.GetRequestScope(req)
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		if scope.Session != nil {
+			// The session was already loaded, pass to the next handler
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		session, err := sessionLoader(req)
+		if err != nil {
+			logger.Errorf("Error retrieving session from request headers: %v", err)
+		}
+
+		// Add the session to the scope if it was found
+		scope.Session = session
+		next.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	middlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request Session Suite", func() {
+	Context("RequestSessionLoader", func() {
+		const dataHeader = "X-Test-Data"
+
+		sessionLoader := func(req *http.Request) (*sessionsapi.SessionState, error) {
+			switch data := req.Header.Get(dataHeader); data {
+			case "":
+				return nil, nil
+			case "invalid":
+				return nil, errors.New("invalid data")
+			default:
+				return &sessionsapi.SessionState{User: data}, nil
+			}
+		}
+
+		type requestSessionLoaderTableInput struct {
+			dataHeader      string
+			existingSession *sessionsapi.SessionState
+			expectedSession *sessionsapi.SessionState
+		}
+
+		DescribeTable("with a data header",
+			func(in requestSessionLoaderTableInput) {
+				scope := &middlewareapi.RequestScope{
+					Session: in.existingSession,
+				}
+
+				// Set up the request with the data header and a request scope
+				req := httptest.NewRequest("", "/", nil)
+				req.Header.Set(dataHeader, in.dataHeader)
+				req = middlewareapi.AddRequestScope(req, scope)
+
+				rw := httptest.NewRecorder()
+
+				// Create the handler with a next handler that will capture the session
+				// from the scope
+				var gotSession *sessionsapi.SessionState
+				handler := NewRequestSessionLoader(sessionLoader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotSession = middlewareapi.GetRequestScope(r).Session
+				}))
+				handler.ServeHTTP(rw, req)
+
+				Expect(gotSession).To(Equal(in.expectedSession))
+			},
+			Entry("<no value>", requestSessionLoaderTableInput{
+				dataHeader:      "",
+				existingSession: nil,
+				expectedSession: nil,
+			}),
+			Entry("invalid", requestSessionLoaderTableInput{
+				dataHeader:      "invalid",
+				existingSession: nil,
+				expectedSession: nil,
+			}),
+			Entry("valid", requestSessionLoaderTableInput{
+				dataHeader:      "user1",
+				existingSession: nil,
+				expectedSession: &sessionsapi.SessionState{User: "user1"},
+			}),
+			Entry("valid (with existing session)", requestSessionLoaderTableInput{
+				dataHeader:      "user1",
+				existingSession: &sessionsapi.SessionState{User: "user"},
+				expectedSession: &sessionsapi.SessionState{User: "user"},
+			}),
+		)
+	})
+})
@@ -14,6 +14,10 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
 )
 
+// activityExtendInterval is how often at most a session is saved to extend
+// it on activity, rather than on every request
+const activityExtendInterval = time.Minute
+
 // StoredSessionLoaderOptions contains all of the requirements to construct
 // a stored session loader.
 // All options must be provided.
@@ -31,6 +35,14 @@ type StoredSessionLoaderOptions struct {
 	// If the sesssion is older than `RefreshPeriod` but the provider doesn't
 	// refresh it, we must re-validate using this validation.
 	ValidateSession func(context.Context, *sessionsapi.SessionState) bool
+
+	// Extend the session cookie expiry on every request when sessions aren't
+	// refreshed periodically
+	RefreshOnActivity bool
+
+	// Sessions extended on activity are rejected once the user logged in
+	// longer than this ago, 0 is unlimited
+	MaxSessionDuration time.Duration
 }
 
 // NewStoredSessionLoader creates a new storedSessionLoader which loads
@@ -39,10 +51,12 @@ type StoredSessionLoaderOptions struct {
 // If a session was loader by a previous handler, it will not be replaced.
 func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor {
 	ss := &storedSessionLoader{
-		store:            opts.SessionStore,
-		refreshPeriod:    opts.RefreshPeriod,
-		sessionRefresher: opts.RefreshSession,
-		sessionValidator: opts.ValidateSession,
+		store:              opts.SessionStore,
+		refreshPeriod:      opts.RefreshPeriod,
+		sessionRefresher:   opts.RefreshSession,
+		sessionValidator:   opts.ValidateSession,
+		refreshOnActivity:  opts.RefreshOnActivity,
+		maxSessionDuration: opts.MaxSessionDuration,
 	}
 	return ss.loadSession
 }
@@ -50,10 +64,12 @@ func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor
 // storedSessionLoader is responsible for loading sessions from cookie
 // identified sessions in the session store.
 type storedSessionLoader struct {
-	store            sessionsapi.SessionStore
-	refreshPeriod    time.Duration
-	sessionRefresher func(context.Context, *sessionsapi.SessionState) (bool, error)
-	sessionValidator func(context.Context, *sessionsapi.SessionState) bool
+	store              sessionsapi.SessionStore
+	refreshPeriod      time.Duration
+	sessionRefresher   func(context.Context, *sessionsapi.SessionState) (bool, error)
+	sessionValidator   func(context.Context, *sessionsapi.SessionState) bool
+	refreshOnActivity  bool
+	maxSessionDuration time.Duration
 }
 
 // loadSession attempts to load a session as identified by the request cookies.
@@ -101,12 +117,76 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 
 	err = s.refreshSessionIfNeeded(rw, req, session)
 	if err != nil {
+		// Keep the session so that the login flow can reuse its details
+		middlewareapi.GetRequestScope(req).ExpiredSession = session
 		return nil, fmt.Errorf("error refreshing access token for session (%s): %v", session, err)
 	}
 
+	if s.exceedsMaxSessionDuration(session) {
+		return nil, fmt.Errorf("session (%s) exceeded the max session duration of %s", session, s.maxSessionDuration)
+	}
+
+	if err := s.extendSessionOnActivity(rw, req, session); err != nil {
+		// The session is still valid until its current expiry
+		logger.Errorf("Unable to extend session: %v", err)
+	}
+
 	return session, nil
 }
 
+// extendSessionOnActivity saves the session with a new CreatedAt so that
+// its cookie expiry restarts from this request.
+// Only sessions that aren't refreshed periodically are extended, refreshes
+// already extend the cookie and resetting CreatedAt would postpone them.
+// Sessions are no longer extended once the user logged in longer than
+// the max session duration ago, and are extended at most once per
+// activityExtendInterval.
+func (s *storedSessionLoader) extendSessionOnActivity(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
+	if !s.extendsOnActivity() {
+		return nil
+	}
+	if session.CreatedAt != nil && session.Clock.Since(*session.CreatedAt) < activityExtendInterval {
+		return nil
+	}
+
+	if session.LoggedInAt == nil {
+		// Until the session is first extended, CreatedAt is when the user
+		// logged in
+		session.LoggedInAt = session.CreatedAt
+	}
+	if s.exceedsMaxSessionDuration(session) {
+		return nil
+	}
+
+	session.CreatedAtNow()
+	if err := s.store.Save(rw, req, session); err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// extendsOnActivity is true when sessions are extended on activity, as they
+// aren't refreshed periodically
+func (s *storedSessionLoader) extendsOnActivity() bool {
+	return s.refreshOnActivity && s.refreshPeriod <= time.Duration(0)
+}
+
+// exceedsMaxSessionDuration is true when a session extended on activity was
+// logged in to the proxy longer than the max session duration ago. The
+// provider's auth time isn't used, it may predate the login by far. Each extension keeps
+// the cookie valid for another cookie expiry, so the session must be
+// rejected rather than just no longer extended to end at the max duration.
+func (s *storedSessionLoader) exceedsMaxSessionDuration(session *sessionsapi.SessionState) bool {
+	if !s.extendsOnActivity() || s.maxSessionDuration <= 0 {
+		return false
+	}
+	loggedIn := session.LoggedInAt
+	if loggedIn == nil {
+		loggedIn = session.CreatedAt
+	}
+	return loggedIn != nil && session.Clock.Since(*loggedIn) >= s.maxSessionDuration
+}
+
 // refreshSessionIfNeeded will attempt to refresh a session if the session
 // is older than the refresh period.
 // Success or fail, we will then validate the session.
@@ -118,6 +198,11 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 
 	logger.Printf("Refreshing session - User: %s; SessionAge: %s", session.User, session.Age())
 	err := s.refreshSession(rw, req, session)
+	if errors.Is(err, providers.ErrGroupMembershipChanged) {
+		// The user's groups no longer match the session, force them to
+		// re-authenticate by invalidating the session.
+		return err
+	}
 	if err != nil {
 		// If a preemptive refresh fails, we still keep the session
 		// if validateSession succeeds.
@@ -125,13 +210,30 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 	}
 
 	// Validate all sessions after any Redeem/Refresh operation (fail or success)
-	return s.validateSession(req.Context(), session)
+	accessToken := session.AccessToken
+	if err := s.validateSession(providers.ContextWithRequest(req.Context(), req), session); err != nil {
+		return err
+	}
+
+	// Providers may refresh the tokens while validating the session, eg. when
+	// a token introspection endpoint challenges an expired access token
+	if session.AccessToken != accessToken {
+		session.CreatedAtNow()
+		if err := s.store.Save(rw, req, session); err != nil {
+			logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+			return fmt.Errorf("error saving session: %v", err)
+		}
+	}
+	return nil
 }
 
 // refreshSession attempts to refresh the session with the provider
 // and will save the session if it was updated.
 func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
-	refreshed, err := s.sessionRefresher(req.Context(), session)
+	refreshed, err := s.sessionRefresher(providers.ContextWithRequest(req.Context(), req), session)
+	if errors.Is(err, providers.ErrGroupMembershipChanged) {
+		return err
+	}
 	if err != nil && !errors.Is(err, providers.ErrNotImplemented) {
 		return fmt.Errorf("error refreshing tokens: %v", err)
 	}
@@ -266,6 +266,29 @@ var _ = Describe("Stored Session Suite", func() {
 				validateSession: defaultValidateFunc,
 			}),
 		)
+
+		It("keeps a session that has expired as the expired session", func() {
+			scope := &middlewareapi.RequestScope{}
+			req := httptest.NewRequest("", "/", nil)
+			req.Header.Set("Cookie", "_oauth2_proxy=ExpiredNoRefreshSession")
+			req = middlewareapi.AddRequestScope(req, scope)
+
+			opts := &StoredSessionLoaderOptions{
+				SessionStore:    defaultSessionStore,
+				RefreshPeriod:   1 * time.Minute,
+				RefreshSession:  defaultRefreshFunc,
+				ValidateSession: defaultValidateFunc,
+			}
+			handler := NewStoredSessionLoader(opts)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			Expect(scope.Session).To(BeNil())
+			Expect(scope.ExpiredSession).To(Equal(&sessionsapi.SessionState{
+				RefreshToken: noRefresh,
+				CreatedAt:    &createdPast,
+				ExpiresOn:    &createdPast,
+			}))
+		})
 	})
 
 	Context("refreshSessionIfNeeded", func() {
@@ -297,6 +320,8 @@ var _ = Describe("Stored Session Suite", func() {
 							return false, nil
 						case notImplemented:
 							return false, providers.ErrNotImplemented
+						case "GroupsChanged":
+							return false, providers.ErrGroupMembershipChanged
 						default:
 							return false, errors.New("error refreshing session")
 						}
@@ -388,6 +413,17 @@ var _ = Describe("Stored Session Suite", func() {
 				expectRefreshed: true,
 				expectValidated: true,
 			}),
+			Entry("when the provider reports the group membership changed", refreshSessionIfNeededTableInput{
+				refreshPeriod: 1 * time.Minute,
+				session: &sessionsapi.SessionState{
+					RefreshToken: "GroupsChanged",
+					CreatedAt:    &createdPast,
+					ExpiresOn:    &createdFuture,
+				},
+				expectedErr:     providers.ErrGroupMembershipChanged,
+				expectRefreshed: true,
+				expectValidated: false,
+			}),
 			Entry("when the session is not refreshed by the provider and validation fails", refreshSessionIfNeededTableInput{
 				refreshPeriod: 1 * time.Minute,
 				session: &sessionsapi.SessionState{
@@ -491,6 +527,166 @@ var _ = Describe("Stored Session Suite", func() {
 		)
 	})
 
+	Context("extendSessionOnActivity", func() {
+		type extendSessionOnActivityTableInput struct {
+			refreshOnActivity  bool
+			refreshPeriod      time.Duration
+			maxSessionDuration time.Duration
+			createdAt          time.Time
+			loggedInAt         *time.Time
+			expectSaved        bool
+		}
+
+		now := time.Now()
+		loggedIn := now.Add(-2 * time.Hour)
+
+		BeforeEach(func() {
+			clock.Set(now)
+		})
+
+		AfterEach(func() {
+			clock.Reset()
+		})
+
+		DescribeTable("with a session",
+			func(in extendSessionOnActivityTableInput) {
+				saved := false
+				s := &storedSessionLoader{
+					refreshOnActivity:  in.refreshOnActivity,
+					refreshPeriod:      in.refreshPeriod,
+					maxSessionDuration: in.maxSessionDuration,
+					store: &fakeSessionStore{
+						SaveFunc: func(_ http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+							saved = true
+							return nil
+						},
+					},
+				}
+
+				createdAt := in.createdAt
+				session := &sessionsapi.SessionState{
+					CreatedAt:  &createdAt,
+					LoggedInAt: in.loggedInAt,
+				}
+				req := httptest.NewRequest("", "/", nil)
+				Expect(s.extendSessionOnActivity(nil, req, session)).To(Succeed())
+
+				Expect(saved).To(Equal(in.expectSaved))
+				if in.expectSaved {
+					Expect(*session.CreatedAt).To(Equal(now))
+					Expect(session.LoggedInAt).ToNot(BeNil())
+				} else {
+					Expect(*session.CreatedAt).To(Equal(in.createdAt))
+				}
+			},
+			Entry("when refreshing on activity is disabled", extendSessionOnActivityTableInput{
+				refreshOnActivity: false,
+				createdAt:         loggedIn,
+				expectSaved:       false,
+			}),
+			Entry("when the session is extended", extendSessionOnActivityTableInput{
+				refreshOnActivity: true,
+				createdAt:         loggedIn,
+				expectSaved:       true,
+			}),
+			Entry("when the session was extended less than a minute ago", extendSessionOnActivityTableInput{
+				refreshOnActivity: true,
+				createdAt:         now.Add(-30 * time.Second),
+				expectSaved:       false,
+			}),
+			Entry("when the session is refreshed periodically", extendSessionOnActivityTableInput{
+				refreshOnActivity: true,
+				refreshPeriod:     time.Hour,
+				createdAt:         loggedIn,
+				expectSaved:       false,
+			}),
+			Entry("when the session is within the max session duration", extendSessionOnActivityTableInput{
+				refreshOnActivity:  true,
+				maxSessionDuration: 3 * time.Hour,
+				createdAt:          now.Add(-time.Minute),
+				loggedInAt:         &loggedIn,
+				expectSaved:        true,
+			}),
+			Entry("when the user logged in longer than the max session duration ago", extendSessionOnActivityTableInput{
+				refreshOnActivity:  true,
+				maxSessionDuration: time.Hour,
+				createdAt:          now.Add(-time.Minute),
+				loggedInAt:         &loggedIn,
+				expectSaved:        false,
+			}),
+			Entry("when the session without a login time is older than the max session duration", extendSessionOnActivityTableInput{
+				refreshOnActivity:  true,
+				maxSessionDuration: time.Hour,
+				createdAt:          loggedIn,
+				expectSaved:        false,
+			}),
+		)
+
+		It("keeps the login time when it is extended", func() {
+			s := &storedSessionLoader{
+				refreshOnActivity:  true,
+				maxSessionDuration: 3 * time.Hour,
+				store:              &fakeSessionStore{},
+			}
+			createdAt := loggedIn
+			session := &sessionsapi.SessionState{CreatedAt: &createdAt}
+			req := httptest.NewRequest("", "/", nil)
+
+			Expect(s.extendSessionOnActivity(nil, req, session)).To(Succeed())
+			Expect(*session.LoggedInAt).To(Equal(loggedIn))
+			Expect(session.AuthTime).To(BeNil())
+			Expect(*session.CreatedAt).To(Equal(now))
+
+			clock.Set(now.Add(2 * time.Hour))
+			Expect(s.extendSessionOnActivity(nil, req, session)).To(Succeed())
+			Expect(*session.CreatedAt).To(Equal(now))
+		})
+
+		It("rejects the session once the user logged in longer than the max session duration ago", func() {
+			createdAt := now.Add(-time.Minute)
+			saved := false
+			s := &storedSessionLoader{
+				refreshOnActivity:  true,
+				maxSessionDuration: time.Hour,
+				store: &fakeSessionStore{
+					LoadFunc: func(_ *http.Request) (*sessionsapi.SessionState, error) {
+						return &sessionsapi.SessionState{CreatedAt: &createdAt, LoggedInAt: &loggedIn}, nil
+					},
+					SaveFunc: func(_ http.ResponseWriter, _ *http.Request, _ *sessionsapi.SessionState) error {
+						saved = true
+						return nil
+					},
+				},
+			}
+			req := httptest.NewRequest("", "/", nil)
+
+			session, err := s.getValidatedSession(nil, req)
+			Expect(err).To(MatchError(ContainSubstring("exceeded the max session duration of 1h0m0s")))
+			Expect(session).To(BeNil())
+			Expect(saved).To(BeFalse())
+		})
+
+		It("doesn't count an earlier provider auth time against the max session duration", func() {
+			createdAt := now.Add(-time.Minute)
+			authTime := now.Add(-48 * time.Hour)
+			s := &storedSessionLoader{
+				refreshOnActivity:  true,
+				maxSessionDuration: time.Hour,
+				store: &fakeSessionStore{
+					LoadFunc: func(_ *http.Request) (*sessionsapi.SessionState, error) {
+						return &sessionsapi.SessionState{CreatedAt: &createdAt, AuthTime: &authTime}, nil
+					},
+				},
+			}
+			req := httptest.NewRequest("", "/", nil)
+
+			session, err := s.getValidatedSession(nil, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session).ToNot(BeNil())
+			Expect(*session.AuthTime).To(Equal(authTime))
+		})
+	})
+
 	Context("validateSession", func() {
 		var s *storedSessionLoader
 
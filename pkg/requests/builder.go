@@ -18,6 +18,7 @@ type Builder interface {
 	WithMethod(string) Builder
 	WithHeaders(http.Header) Builder
 	SetHeader(key, value string) Builder
+	WithClient(*http.Client) Builder
 	Do() Result
 }
 
@@ -27,6 +28,7 @@ type builder struct {
 	endpoint string
 	body     io.Reader
 	header   http.Header
+	client   *http.Client
 	result   *result
 }
 
@@ -73,6 +75,13 @@ func (r *builder) SetHeader(key, value string) Builder {
 	return r
 }
 
+// WithClient sets the client used to perform the request.
+// If no client is provided, http.DefaultClient is used instead.
+func (r *builder) WithClient(client *http.Client) Builder {
+	r.client = client
+	return r
+}
+
 // Do performs the request and returns the response in its raw form.
 // If the request has already been performed, returns the previous result.
 // This will not allow you to repeat a request.
@@ -100,7 +109,11 @@ func (r *builder) do() Result {
 	}
 	req.Header = r.header
 
-	resp, err := http.DefaultClient.Do(req)
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		r.result = &result{err: fmt.Errorf("error performing request: %v", err)}
 		return r.result
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -212,6 +213,29 @@ var _ = Describe("Builder suite", func() {
 		})
 	})
 
+	Context("with a client", func() {
+		BeforeEach(func() {
+			b = b.WithClient(&http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("client transport used")
+			})})
+		})
+
+		assertRequestError(getBuilder, "client transport used")
+
+		Context("that is nil", func() {
+			BeforeEach(func() {
+				b = b.WithClient(nil)
+			})
+
+			assertSuccessfulRequest(getBuilder, testHTTPRequest{
+				Method:     "GET",
+				Header:     baseHeaders,
+				Body:       []byte{},
+				RequestURI: "/json/path",
+			})
+		})
+	})
+
 	Context("if the request has been completed and then modified", func() {
 		BeforeEach(func() {
 			result := b.Do()
@@ -374,3 +398,9 @@ func assertJSONError(builder func() Builder, errorMessage string) {
 		})
 	})
 }
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -18,6 +18,9 @@ import (
 // interface that stores sessions in redis
 type SessionStore struct {
 	Client Client
+
+	// KeyPrefix is prepended to every key stored in redis
+	KeyPrefix string
 }
 
 // NewRedisSessionStore initialises a new instance of the SessionStore and wraps
@@ -29,7 +32,8 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 	}
 
 	rs := &SessionStore{
-		Client: client,
+		Client:    client,
+		KeyPrefix: opts.Redis.KeyPrefix,
 	}
 	return persistence.NewManager(rs, cookieOpts), nil
 }
@@ -37,7 +41,7 @@ func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.Cook
 // Save takes a sessions.SessionState and stores the information from it
 // to redis, and adds a new persistence cookie on the HTTP response writer
 func (store *SessionStore) Save(ctx context.Context, key string, value []byte, exp time.Duration) error {
-	err := store.Client.Set(ctx, key, value, exp)
+	err := store.Client.Set(ctx, store.prefixKey(key), value, exp)
 	if err != nil {
 		return fmt.Errorf("error saving redis session: %v", err)
 	}
@@ -47,7 +51,7 @@ func (store *SessionStore) Save(ctx context.Context, key string, value []byte, e
 // Load reads sessions.SessionState information from a persistence
 // cookie within the HTTP request object
 func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error) {
-	value, err := store.Client.Get(ctx, key)
+	value, err := store.Client.Get(ctx, store.prefixKey(key))
 	if err != nil {
 		return nil, fmt.Errorf("error loading redis session: %v", err)
 	}
@@ -57,7 +61,7 @@ func (store *SessionStore) Load(ctx context.Context, key string) ([]byte, error)
 // Clear clears any saved session information for a given persistence cookie
 // from redis, and then clears the session
 func (store *SessionStore) Clear(ctx context.Context, key string) error {
-	err := store.Client.Del(ctx, key)
+	err := store.Client.Del(ctx, store.prefixKey(key))
 	if err != nil {
 		return fmt.Errorf("error clearing the session from redis: %v", err)
 	}
@@ -66,7 +70,12 @@ func (store *SessionStore) Clear(ctx context.Context, key string) error {
 
 // Lock creates a lock object for sessions.SessionState
 func (store *SessionStore) Lock(key string) sessions.Lock {
-	return store.Client.Lock(key)
+	return store.Client.Lock(store.prefixKey(key))
+}
+
+// prefixKey returns the redis key of a session key
+func (store *SessionStore) prefixKey(key string) string {
+	return store.KeyPrefix + key
 }
 
 // NewRedisClient makes a redis.Client (either standalone, sentinel aware, or
@@ -142,6 +142,48 @@ var _ = Describe("Redis SessionStore Tests", func() {
 		)
 	})
 
+	Context("with a key prefix", func() {
+		tests.RunSessionStoreTests(
+			func(opts *options.SessionOptions, cookieOpts *options.Cookie) (sessionsapi.SessionStore, error) {
+				// Set the connection URL
+				opts.Type = options.RedisSessionStoreType
+				opts.Redis.ConnectionURL = "redis://" + mr.Addr()
+				opts.Redis.KeyPrefix = "instance1:"
+
+				// Capture the session store so that we can close the client
+				var err error
+				ss, err = NewRedisSessionStore(opts, cookieOpts)
+				return ss, err
+			},
+			func(d time.Duration) error {
+				mr.FastForward(d)
+				return nil
+			},
+		)
+
+		It("prefixes the keys stored in redis", func() {
+			var err error
+			ss, err = NewRedisSessionStore(&options.SessionOptions{
+				Redis: options.RedisStoreOptions{
+					ConnectionURL: "redis://" + mr.Addr(),
+					KeyPrefix:     "instance1:",
+				},
+			}, &options.Cookie{})
+			Expect(err).ToNot(HaveOccurred())
+
+			store := ss.(*persistence.Manager).Store
+			Expect(store.Save(context.Background(), "_oauth2_proxy-1234", []byte("value"), time.Hour)).To(Succeed())
+			Expect(mr.Keys()).To(ConsistOf("instance1:_oauth2_proxy-1234"))
+
+			value, err := store.Load(context.Background(), "_oauth2_proxy-1234")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal([]byte("value")))
+
+			Expect(store.Clear(context.Background(), "_oauth2_proxy-1234")).To(Succeed())
+			Expect(mr.Keys()).To(BeEmpty())
+		})
+	})
+
 	Context("with a redis password", func() {
 		BeforeEach(func() {
 			mr.RequireAuth(redisPassword)
@@ -1,11 +1,21 @@
 package util
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 )
 
+// tlsVersions maps the TLS version names accepted in configuration to their
+// crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
 func GetCertPool(paths []string) (*x509.CertPool, error) {
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("invalid empty list of Root CAs file paths")
@@ -23,3 +33,16 @@ func GetCertPool(paths []string) (*x509.CertPool, error) {
 	}
 	return pool, nil
 }
+
+// ParseTLSVersion converts a TLS version name, eg. "TLS1.2", into its
+// crypto/tls constant. An empty name returns 0.
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected one of TLS1.0, TLS1.1, TLS1.2 or TLS1.3", version)
+	}
+	return v, nil
+}
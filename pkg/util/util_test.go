@@ -1,6 +1,7 @@
 package util
 
 import (
+	"crypto/tls"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"io/ioutil"
@@ -95,3 +96,20 @@ func TestGetCertPool(t *testing.T) {
 	expectedSubjects := []string{testCA1Subj, testCA2Subj}
 	assert.Equal(t, expectedSubjects, got)
 }
+
+func TestParseTLSVersion(t *testing.T) {
+	version, err := ParseTLSVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), version)
+
+	version, err = ParseTLSVersion("TLS1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	version, err = ParseTLSVersion("TLS1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = ParseTLSVersion("SSLv3")
+	assert.EqualError(t, err, "unknown TLS version \"SSLv3\", expected one of TLS1.0, TLS1.1, TLS1.2 or TLS1.3")
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/cookies"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 )
 
 func validateCookie(o options.Cookie) []string {
@@ -34,6 +37,27 @@ func validateCookie(o options.Cookie) []string {
 	return msgs
 }
 
+// applyHostCookiePrefix prefixes the cookie name with __Host-, and sets the
+// cookie attributes browsers require of cookies with the prefix. The redirect
+// URL must have been parsed before.
+func applyHostCookiePrefix(o *options.Options) []string {
+	redirectURL := o.GetRedirectURL()
+	if o.Server.SecureBindAddress == "" && (redirectURL == nil || redirectURL.Scheme != "https") {
+		return []string{"invalid setting: use-host-cookie-prefix requires HTTPS, set an https redirect-url or an https-address"}
+	}
+
+	if !strings.HasPrefix(o.Cookie.Name, cookies.HostCookiePrefix) {
+		o.Cookie.Name = cookies.HostCookiePrefix + o.Cookie.Name
+	}
+	if len(o.Cookie.Domains) > 0 {
+		logger.Printf("WARNING: cookie-domain is ignored with use-host-cookie-prefix")
+		o.Cookie.Domains = nil
+	}
+	o.Cookie.Path = "/"
+	o.Cookie.Secure = true
+	return nil
+}
+
 func validateCookieName(name string) []string {
 	msgs := []string{}
 
@@ -3,11 +3,15 @@ package validation
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/coreos/go-oidc"
@@ -19,6 +23,8 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/providers"
+	"golang.org/x/net/http/httpguts"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 // Validate checks that required options are set and validates those that they
@@ -33,26 +39,36 @@ func Validate(o *options.Options) error {
 	msgs = configureLogger(o.Logging, msgs)
 	msgs = parseSignatureKey(o, msgs)
 
+	tlsMinVersion, err := util.ParseTLSVersion(o.Providers[0].TLSMinVersion)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: provider-tls-min-version: %v", err))
+	}
+	if tlsMinVersion == 0 {
+		tlsMinVersion = providers.DefaultTLSMinVersion
+	}
+
 	if o.SSLInsecureSkipVerify {
 		// InsecureSkipVerify is a configurable option we allow
 		/* #nosec G402 */
 		insecureTransport := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				MinVersion:         tlsMinVersion,
+			},
 		}
 		http.DefaultClient = &http.Client{Transport: insecureTransport}
-	} else if len(o.Providers[0].CAFiles) > 0 {
-		pool, err := util.GetCertPool(o.Providers[0].CAFiles)
-		if err == nil {
-			transport := http.DefaultTransport.(*http.Transport).Clone()
-			transport.TLSClientConfig = &tls.Config{
-				RootCAs:    pool,
-				MinVersion: tls.VersionTLS12,
-			}
-
-			http.DefaultClient = &http.Client{Transport: transport}
-		} else {
-			msgs = append(msgs, fmt.Sprintf("unable to load provider CA file(s): %v", err))
+	} else {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion: tlsMinVersion,
 		}
+		if len(o.Providers[0].CAFiles) > 0 {
+			// Errors loading the CA files are reported with the provider's CA pool
+			pool, _ := util.GetCertPool(o.Providers[0].CAFiles)
+			transport.TLSClientConfig.RootCAs = pool
+		}
+
+		http.DefaultClient = &http.Client{Transport: transport}
 	}
 
 	if o.AuthenticatedEmailsFile == "" && len(o.EmailDomains) == 0 && o.HtpasswdFile == "" {
@@ -63,8 +79,11 @@ func Validate(o *options.Options) error {
 	if o.Providers[0].OIDCConfig.IssuerURL != "" {
 
 		ctx := context.Background()
+		skipIssuerCheck := o.Providers[0].OIDCConfig.InsecureSkipIssuerVerification || o.Providers[0].OIDCConfig.IssuerURLNormalize
+		// The audience is checked by the provider instead, see ProviderData.SkipClientIDCheck
+		skipClientIDCheck := len(o.Providers[0].OIDCConfig.AdditionalAudiences) > 0 || o.Providers[0].OIDCConfig.RequireAudienceExact
 
-		if o.Providers[0].OIDCConfig.InsecureSkipIssuerVerification && !o.Providers[0].OIDCConfig.SkipDiscovery {
+		if skipIssuerCheck && !o.Providers[0].OIDCConfig.SkipDiscovery {
 			// go-oidc doesn't let us pass bypass the issuer check this in the oidc.NewProvider call
 			// (which uses discovery to get the URLs), so we'll do a quick check ourselves and if
 			// we get the URLs, we'll just use the non-discovery path.
@@ -78,6 +97,11 @@ func Validate(o *options.Options) error {
 				UnmarshalJSON()
 			if err != nil {
 				logger.Errorf("error: failed to discover OIDC configuration: %v", err)
+			} else if issuer := body.Get("issuer").MustString(); !o.Providers[0].OIDCConfig.InsecureSkipIssuerVerification &&
+				strings.TrimRight(issuer, "/") != strings.TrimRight(o.Providers[0].OIDCConfig.IssuerURL, "/") {
+				// Leave the mismatch to be reported by the discovery below
+				logger.Errorf("error: OIDC issuer %q doesn't match the discovered issuer %q",
+					o.Providers[0].OIDCConfig.IssuerURL, issuer)
 			} else {
 				// Prefer manually configured URLs. It's a bit unclear
 				// why you'd be doing discovery and also providing the URLs
@@ -118,27 +142,67 @@ func Validate(o *options.Options) error {
 			}
 			keySet := oidc.NewRemoteKeySet(ctx, o.Providers[0].OIDCConfig.JwksURL)
 			o.SetOIDCVerifier(oidc.NewVerifier(o.Providers[0].OIDCConfig.IssuerURL, keySet, &oidc.Config{
-				ClientID:        o.Providers[0].ClientID,
-				SkipIssuerCheck: o.Providers[0].OIDCConfig.InsecureSkipIssuerVerification,
+				ClientID:          o.Providers[0].ClientID,
+				SkipClientIDCheck: skipClientIDCheck,
+				SkipIssuerCheck:   skipIssuerCheck,
+				SkipExpiryCheck:   providers.SkipVerifierExpiryCheck(o.Providers[0].OIDCConfig.SkewTolerance.Duration()),
 			}))
 		} else {
 			// Configure discoverable provider data.
-			provider, err := oidc.NewProvider(ctx, o.Providers[0].OIDCConfig.IssuerURL)
-			if err != nil {
+			cacheFile := o.Providers[0].OIDCConfig.DiscoveryCacheFile
+			provider, err := providers.DiscoverOIDCProvider(ctx, o.Providers[0].OIDCConfig.IssuerURL,
+				o.Providers[0].OIDCConfig.DiscoveryMaxRetries, o.Providers[0].OIDCConfig.DiscoveryRetryInterval.Duration())
+			switch {
+			case err != nil && cacheFile == "":
 				return err
-			}
-			o.SetOIDCVerifier(provider.Verifier(&oidc.Config{
-				ClientID:        o.Providers[0].ClientID,
-				SkipIssuerCheck: o.Providers[0].OIDCConfig.InsecureSkipIssuerVerification,
-			}))
+			case err != nil:
+				// Fall back to the last successfully discovered document
+				doc, cacheErr := readOIDCDiscoveryCache(cacheFile)
+				if cacheErr != nil {
+					return fmt.Errorf("%v (unable to fall back to OIDC discovery cache: %v)", err, cacheErr)
+				}
+				logger.Errorf("WARNING: OIDC discovery failed: %v", err)
+				logger.Errorf("WARNING: using cached OIDC discovery document from %s, endpoints may be out of date", cacheFile)
+
+				keySet := oidc.NewRemoteKeySet(ctx, doc.JWKSURL)
+				o.SetOIDCVerifier(oidc.NewVerifier(doc.Issuer, keySet, &oidc.Config{
+					ClientID:          o.Providers[0].ClientID,
+					SkipClientIDCheck: skipClientIDCheck,
+					SkipIssuerCheck:   skipIssuerCheck,
+					SkipExpiryCheck:   providers.SkipVerifierExpiryCheck(o.Providers[0].OIDCConfig.SkewTolerance.Duration()),
+				}))
+
+				o.Providers[0].LoginURL = doc.AuthURL
+				o.Providers[0].RedeemURL = doc.TokenURL
+				o.SetOIDCDiscovery(doc.raw)
+			default:
+				if cacheFile != "" {
+					if err := writeOIDCDiscoveryCache(cacheFile, provider); err != nil {
+						logger.Errorf("error writing OIDC discovery cache file %s: %v", cacheFile, err)
+					}
+				}
+
+				o.SetOIDCVerifier(provider.Verifier(&oidc.Config{
+					ClientID:          o.Providers[0].ClientID,
+					SkipClientIDCheck: skipClientIDCheck,
+					SkipIssuerCheck:   skipIssuerCheck,
+					SkipExpiryCheck:   providers.SkipVerifierExpiryCheck(o.Providers[0].OIDCConfig.SkewTolerance.Duration()),
+				}))
+
+				o.Providers[0].LoginURL = provider.Endpoint().AuthURL
+				o.Providers[0].RedeemURL = provider.Endpoint().TokenURL
 
-			o.Providers[0].LoginURL = provider.Endpoint().AuthURL
-			o.Providers[0].RedeemURL = provider.Endpoint().TokenURL
+				var document json.RawMessage
+				if err := provider.Claims(&document); err == nil {
+					o.SetOIDCDiscovery(document)
+				}
+			}
 		}
 		if o.Providers[0].Scope == "" {
 			o.Providers[0].Scope = "openid email profile"
 
-			if len(o.Providers[0].AllowedGroups) > 0 {
+			if len(o.Providers[0].AllowedGroups) > 0 || len(o.Providers[0].AllowedGroupsRegex) > 0 ||
+				o.Providers[0].AllowedGroupsURL != "" || len(o.Providers[0].RequireGroupsSubsetOf) > 0 {
 				o.Providers[0].Scope += " groups"
 			}
 		}
@@ -204,17 +268,176 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 		Prompt:           o.Providers[0].Prompt,
 		ApprovalPrompt:   o.Providers[0].ApprovalPrompt,
 		AcrValues:        o.Providers[0].AcrValues,
+		NonceLength:      o.Providers[0].NonceLength,
+	}
+	// Invalid versions are reported when the provider transport is configured
+	p.TLSMinVersion, _ = util.ParseTLSVersion(o.Providers[0].TLSMinVersion)
+	if len(o.Providers[0].CAFiles) > 0 {
+		pool, err := util.GetCertPool(o.Providers[0].CAFiles)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("unable to load provider CA file(s): %v", err))
+		}
+		p.CAPool = pool
+	}
+	p.InsecureSkipTLSVerify = o.SSLInsecureSkipVerify
+	p.TLSClientCertFile = o.Providers[0].TLSClientCertFile
+	p.TLSClientKeyFile = o.Providers[0].TLSClientKeyFile
+	switch {
+	case (p.TLSClientCertFile == "") != (p.TLSClientKeyFile == ""):
+		msgs = append(msgs, "invalid setting: provider-tls-client-cert-file and provider-tls-client-key-file must be set together")
+	case p.TLSClientCertFile != "":
+		if _, err := tls.LoadX509KeyPair(p.TLSClientCertFile, p.TLSClientKeyFile); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: unable to load provider-tls-client-cert-file: %v", err))
+		}
+	}
+	if p.NonceLength != 0 && p.NonceLength < providers.MinNonceLength {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: nonce-length must be at least %d bytes", providers.MinNonceLength))
+	}
+	p.OAuthStateSecret = o.Cookie.Secret
+	p.OAuthStateMaxAge = o.Providers[0].OAuthStateMaxAge.Duration()
+	if p.OAuthStateMaxAge < 0 {
+		msgs = append(msgs, "invalid setting: oauth-state-max-age must not be negative")
+	}
+	p.StrictRedirectURIMatch = o.Providers[0].StrictRedirectURIMatch
+	p.UseHostCookiePrefix = o.Providers[0].UseHostCookiePrefix
+	if p.UseHostCookiePrefix {
+		msgs = append(msgs, applyHostCookiePrefix(o)...)
+	}
+	p.PKCEEnabled = o.Providers[0].PKCEEnabled
+	p.PKCEMethod = o.Providers[0].PKCEMethod
+	p.TokenIntrospectionEnabled = o.Providers[0].TokenIntrospectionEnabled
+	switch p.GetPKCEMethod() {
+	case providers.PKCEMethodS256, providers.PKCEMethodPlain:
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid setting: pkce-method %q must be %q or %q", p.PKCEMethod, providers.PKCEMethodS256, providers.PKCEMethodPlain))
+	}
+	if p.ClientSecret == "" && p.ClientSecretFile != "" {
+		p.ClientSecretCache = providers.NewSecretFileCache(p.ClientSecretFile, o.Providers[0].ClientSecretFileTTL.Duration())
 	}
 	p.LoginURL, msgs = parseURL(o.Providers[0].LoginURL, "login", msgs)
 	p.RedeemURL, msgs = parseURL(o.Providers[0].RedeemURL, "redeem", msgs)
 	p.ProfileURL, msgs = parseURL(o.Providers[0].ProfileURL, "profile", msgs)
+	if failovers := o.Providers[0].ProfileURLFailovers; len(failovers) > 0 {
+		if o.Providers[0].ProfileURL == "" {
+			msgs = append(msgs, "missing setting: profile-url is required with profile-url-failover")
+		}
+		p.ProfileURLs = []*url.URL{p.ProfileURL}
+		for _, failover := range failovers {
+			var failoverURL *url.URL
+			failoverURL, msgs = parseURL(failover, "profile-failover", msgs)
+			p.ProfileURLs = append(p.ProfileURLs, failoverURL)
+		}
+	}
 	p.ValidateURL, msgs = parseURL(o.Providers[0].ValidateURL, "validate", msgs)
+	p.RevokeEndpoint, msgs = parseURL(o.Providers[0].RevokeURL, "revoke", msgs)
 	p.ProtectedResource, msgs = parseURL(o.Providers[0].ProtectedResource, "resource", msgs)
 
 	// Make the OIDC options available to all providers that support it
 	p.AllowUnverifiedEmail = o.Providers[0].OIDCConfig.InsecureAllowUnverifiedEmail
+	p.SkipNonceVerification = o.Providers[0].OIDCConfig.InsecureSkipNonce
+	p.RequireVerifiedEmailOrEmpty = o.Providers[0].OIDCConfig.RequireVerifiedEmailOrEmpty
+	if p.AllowUnverifiedEmail && p.RequireVerifiedEmailOrEmpty {
+		msgs = append(msgs, "invalid setting: oidc-require-verified-email-or-empty can't be used with insecure-oidc-allow-unverified-email")
+	}
+	p.RequireEmailVerified = o.Providers[0].OIDCConfig.RequireEmailVerified
+	if p.AllowUnverifiedEmail && p.RequireEmailVerified {
+		msgs = append(msgs, "invalid setting: oidc-require-email-verified can't be used with insecure-oidc-allow-unverified-email")
+	}
 	p.EmailClaim = o.Providers[0].OIDCConfig.EmailClaim
 	p.GroupsClaim = o.Providers[0].OIDCConfig.GroupsClaim
+	p.GroupsClaimRequired = o.Providers[0].OIDCConfig.GroupsClaimRequired
+	if expression := o.Providers[0].OIDCConfig.GroupsJMESPath; expression != "" {
+		p.GroupsClaim = providers.JMESPathClaimPrefix + strings.TrimPrefix(expression, providers.JMESPathClaimPrefix)
+	}
+	if err := providers.ValidateClaimExpression(p.EmailClaim); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-email-claim expression %q: %v", p.EmailClaim, err))
+	}
+	p.EmailClaims = o.Providers[0].OIDCConfig.EmailClaims
+	for _, claim := range p.EmailClaims {
+		if err := providers.ValidateClaimExpression(claim); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-email-claims expression %q: %v", claim, err))
+		}
+	}
+	p.EmailVerifiedClaim = o.Providers[0].OIDCConfig.EmailVerifiedClaim
+	if err := providers.ValidateClaimExpression(p.EmailVerifiedClaim); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-email-verified-claim expression %q: %v", p.EmailVerifiedClaim, err))
+	}
+	for _, claim := range providers.ParseGroupsClaimList(p.GroupsClaim) {
+		if err := providers.ValidateClaimExpression(claim); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-groups-claim expression %q: %v", claim, err))
+		}
+	}
+	p.GroupsClaims = o.Providers[0].OIDCConfig.GroupsClaims
+	for _, claim := range p.GroupsClaims {
+		if err := providers.ValidateClaimExpression(claim); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-groups-claims expression %q: %v", claim, err))
+		}
+	}
+	if len(p.GroupsClaims) > 0 && o.Providers[0].OIDCConfig.GroupsJMESPath != "" {
+		msgs = append(msgs, "invalid setting: oidc-groups-claims can't be used with oidc-groups-jmespath")
+	}
+	p.GroupsClaimPrefixes = o.Providers[0].OIDCConfig.GroupsClaimPrefixes
+	msgs = append(msgs, validateGroupsClaimPrefixes(p)...)
+	p.RolesClaim = o.Providers[0].OIDCConfig.RolesClaim
+	if err := providers.ValidateClaimExpression(p.RolesClaim); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-roles-claim expression %q: %v", p.RolesClaim, err))
+	}
+	p.ActiveOrgClaim = o.Providers[0].OIDCConfig.ActiveOrgClaim
+	if err := providers.ValidateClaimExpression(p.ActiveOrgClaim); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-active-org-claim expression %q: %v", p.ActiveOrgClaim, err))
+	}
+	p.CostCenterClaim = o.Providers[0].OIDCConfig.CostCenterClaim
+	if err := providers.ValidateClaimExpression(p.CostCenterClaim); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-cost-center-claim expression %q: %v", p.CostCenterClaim, err))
+	}
+	p.AccessTokenRoles = o.Providers[0].OIDCConfig.AccessTokenRoles
+	if p.AccessTokenRoles && p.RolesClaim == "" {
+		p.RolesClaim = providers.OIDCRolesClaim
+	}
+	p.AccessTokenSubjectClaim = o.Providers[0].OIDCConfig.AccessTokenSubjectClaim
+	if err := providers.ValidateClaimExpression(p.AccessTokenSubjectClaim); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-access-token-subject-claim expression %q: %v", p.AccessTokenSubjectClaim, err))
+	}
+	p.GroupsFromAccessToken = o.Providers[0].OIDCConfig.GroupsFromAccessToken
+	p.FlattenGroupsMap = o.Providers[0].OIDCConfig.GroupsFlattenMap
+	p.EmailFromSubject = o.Providers[0].OIDCConfig.EmailFromSubject
+	p.SkewTolerance = o.Providers[0].OIDCConfig.SkewTolerance.Duration()
+	p.IssuerURLNormalize = o.Providers[0].OIDCConfig.IssuerURLNormalize
+	p.AdditionalAudiences = o.Providers[0].OIDCConfig.AdditionalAudiences
+	p.RequireAudienceExact = o.Providers[0].OIDCConfig.RequireAudienceExact
+	p.DiscoveryExtraFields = o.Providers[0].OIDCConfig.DiscoveryExtraFields
+	if len(p.DiscoveryExtraFields) > 0 && len(o.GetOIDCDiscovery()) == 0 {
+		// eg. a verifier built from an explicit JWKS URL with skip-oidc-discovery
+		logger.Errorf("WARNING: oidc-discovery-extra-field is ignored without an OIDC discovery document")
+	}
+	if err := p.SetDiscoveryExtraFieldValues(o.GetOIDCDiscovery()); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-discovery-extra-field: %v", err))
+	}
+	p.MaxIDTokenBytes = o.Providers[0].OIDCConfig.MaxIDTokenBytes
+	if p.MaxIDTokenBytes < 0 {
+		msgs = append(msgs, "invalid setting: oidc-max-id-token-bytes must not be negative")
+	}
+	switch precedence := o.Providers[0].OIDCConfig.ClaimPrecedence; precedence {
+	case "", providers.ClaimPrecedenceIDTokenFirst, providers.ClaimPrecedenceUserinfoFirst:
+		p.ClaimPrecedence = precedence
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-claim-precedence %q must be %q or %q",
+			precedence, providers.ClaimPrecedenceIDTokenFirst, providers.ClaimPrecedenceUserinfoFirst))
+	}
+	switch conflict := o.Providers[0].OIDCConfig.ClaimTypeConflict; conflict {
+	case "", providers.ClaimTypeConflictUnion, providers.ClaimTypeConflictPrecedence:
+		p.ClaimTypeConflict = conflict
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-claim-type-conflict %q must be %q or %q",
+			conflict, providers.ClaimTypeConflictUnion, providers.ClaimTypeConflictPrecedence))
+	}
+	p.PreferNonEmptyClaims = o.Providers[0].OIDCConfig.PreferNonEmptyClaims
+	p.IssuerURL = o.Providers[0].OIDCConfig.IssuerURL
+	p.DiscoveryMaxRetries = o.Providers[0].OIDCConfig.DiscoveryMaxRetries
+	p.DiscoveryRetryInterval = o.Providers[0].OIDCConfig.DiscoveryRetryInterval.Duration()
+	if p.DiscoveryMaxRetries < 0 {
+		msgs = append(msgs, "invalid setting: oidc-discovery-max-retries must not be negative")
+	}
 	p.Verifier = o.GetOIDCVerifier()
 
 	// TODO (@NickMeves) - Remove This
@@ -224,20 +447,167 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 		p.EmailClaim = o.Providers[0].OIDCConfig.UserIDClaim
 	}
 
+	p.NormalizeUnicodeGroups = o.Providers[0].NormalizeUnicodeGroups
 	p.SetAllowedGroups(o.Providers[0].AllowedGroups)
+	switch mode := o.Providers[0].GroupMatchMode; mode {
+	case "", providers.GroupMatchModeExact:
+		p.GroupMatchMode = providers.GroupMatchModeExact
+	case providers.GroupMatchModeGlob:
+		p.GroupMatchMode = mode
+		for _, group := range o.Providers[0].AllowedGroups {
+			if _, err := path.Match(group, ""); err != nil {
+				msgs = append(msgs, fmt.Sprintf("invalid setting: allowed-group %q is not a valid glob: %v", group, err))
+			}
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid setting: group-match-mode %q must be %q or %q",
+			mode, providers.GroupMatchModeExact, providers.GroupMatchModeGlob))
+	}
+	if err := p.SetAllowedGroupsRegex(o.Providers[0].AllowedGroupsRegex); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: %v", err))
+	}
+	if o.Providers[0].AllowedGroupsURL != "" {
+		p.AllowedGroupsURL, msgs = parseURL(o.Providers[0].AllowedGroupsURL, "allowed-groups", msgs)
+	}
+	p.AllowedGroupsURLRefreshInterval = o.Providers[0].AllowedGroupsURLRefreshInterval.Duration()
+	if p.AllowedGroupsURLRefreshInterval < 0 {
+		msgs = append(msgs, "invalid setting: allowed-groups-url-refresh-interval must not be negative")
+	}
+	p.SetEmailDomains(o.EmailDomains)
+	p.SetAllowedSubjects(o.Providers[0].AllowedSubjects)
+	p.SetDeniedSubjects(o.Providers[0].DeniedSubjects)
+	p.SubjectPrefix = o.Providers[0].SubjectPrefix
+	if strings.Contains(p.SubjectPrefix, providers.SubjectPrefixSeparator) {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: subject-prefix %q must not contain %q", p.SubjectPrefix, providers.SubjectPrefixSeparator))
+	}
+	p.SubjectPrefixMigration = o.Providers[0].SubjectPrefixMigration
+	p.SetAllowedOrgs(o.Providers[0].AllowedOrgs)
+	if len(p.AllowedOrgs) > 0 && p.ActiveOrgClaim == "" {
+		msgs = append(msgs, "invalid setting: allowed-org requires an oidc-active-org-claim")
+	}
+	p.RequireGroupsSubsetOf = o.Providers[0].RequireGroupsSubsetOf
+	if fields := o.Providers[0].RequiredTokenResponseFields; len(fields) > 0 {
+		p.TokenResponseValidator = providers.RequireTokenResponseFields(fields...)
+	}
+	if resolverURL := o.Providers[0].GroupResolverURL; resolverURL != "" {
+		if !strings.Contains(resolverURL, providers.GroupResolverURLPlaceholder) {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: group-resolver-url must contain %s", providers.GroupResolverURLPlaceholder))
+		} else if _, err := url.Parse(strings.ReplaceAll(resolverURL, providers.GroupResolverURLPlaceholder, "id")); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: group-resolver-url: %v", err))
+		}
+		p.GroupResolver = providers.NewGroupResolver(p.URLGroupResolverFunc(resolverURL, o.Providers[0].GroupResolverNameField), 0, 0)
+	}
+	if o.Logging.ProviderJSON {
+		p.WithLogger(logger.NewJSONLogger(logger.StandardWriter()))
+	}
+	if o.Logging.AuditFilename != "" {
+		auditFile, err := os.OpenFile(o.Logging.AuditFilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("unable to open audit-logging-filename: %v", err))
+		} else {
+			p.AuditLogger = providers.NewStructuredAuditLogger(logger.NewJSONLogger(auditFile))
+		}
+	}
+	p.GroupChangeInvalidatesSession = o.Providers[0].GroupChangeInvalidatesSession
+	p.AutoLoginHint = o.Providers[0].AutoLoginHint
+	p.HSTSMaxAge = o.Providers[0].HSTSMaxAge.Duration()
+	p.HSTSIncludeSubdomains = o.Providers[0].HSTSIncludeSubdomains
+	if p.HSTSMaxAge < 0 {
+		msgs = append(msgs, "invalid setting: hsts-max-age must not be negative")
+	}
+	p.CookieRefreshOnActivity = o.Providers[0].CookieRefreshOnActivity
+	p.MaxSessionDuration = o.Providers[0].MaxSessionDuration.Duration()
+	if p.MaxSessionDuration < 0 {
+		msgs = append(msgs, "invalid setting: max-session-duration must not be negative")
+	}
+	if p.CookieRefreshOnActivity && p.MaxSessionDuration == 0 {
+		msgs = append(msgs, "missing setting: max-session-duration is required with cookie-refresh-on-activity")
+	}
+	p.ClaimMappings = o.Providers[0].ClaimMappings
+	p.TokenEndpointHeaders = o.Providers[0].TokenEndpointHeaders
+	msgs = append(msgs, validateTokenEndpointHeaders(p.TokenEndpointHeaders)...)
+	claimPlan, err := providers.CompileClaimPlan(convertClaimRules(o.Providers[0].ClaimRules))
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: claimRules: %v", err))
+	}
+	p.ClaimPlan = claimPlan
+	p.ProfileCache = providers.NewProfileCache(o.Providers[0].ProfileURLCacheTTL.Duration(), o.Providers[0].ProfileURLCacheSize)
+	p.ProfileURLMaxRetries = o.Providers[0].ProfileURLMaxRetries
+	p.ProfileURLRetryBackoff = o.Providers[0].ProfileURLRetryBackoff.Duration()
+	p.ProfileURLTimeout = o.Providers[0].ProfileURLTimeout.Duration()
+	p.ProfileURLUnwrapArray = o.Providers[0].ProfileURLUnwrapArray
+	switch scheme := o.Providers[0].ProfileURLAuthScheme; scheme {
+	case "", providers.ProfileURLAuthSchemeBearer, providers.ProfileURLAuthSchemeBasic:
+		p.ProfileURLAuthScheme = scheme
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid setting: profile-url-auth-scheme %q must be %q or %q",
+			scheme, providers.ProfileURLAuthSchemeBearer, providers.ProfileURLAuthSchemeBasic))
+	}
+	p.ClaimExtractionTimeout = o.Providers[0].ClaimExtractionTimeout.Duration()
+	profileFetchPredicate, err := providers.NewUserAgentProfileFetchPredicate(o.Providers[0].SkipProfileFetchUserAgents)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid setting: skip-profile-fetch-user-agent: %v", err))
+	}
+	p.ProfileFetchPredicate = profileFetchPredicate
+	p.ForwardAllClaims = o.Providers[0].ForwardAllClaims
+	p.SaveRawClaims = o.Providers[0].SaveRawClaims
+	p.ForwardExtraClaimsPrefix = o.Providers[0].ForwardExtraClaimsPrefix
+	if p.ForwardExtraClaimsPrefix == "" {
+		p.ForwardExtraClaimsPrefix = providers.DefaultForwardExtraClaimsPrefix
+	}
 
-	provider := providers.New(o.Providers[0].Type, p)
+	provider, err := providers.New(o.Providers[0].Type, p)
 	if provider == nil {
-		msgs = append(msgs, fmt.Sprintf("invalid setting: provider '%s' is not available", o.Providers[0].Type))
+		msgs = append(msgs, fmt.Sprintf("invalid setting: %v", err))
 		return msgs
 	}
+	if agg, ok := err.(k8serrors.Aggregate); ok {
+		msgs = appendMissing(msgs, agg.Errors())
+	}
 	o.SetProvider(provider)
 
+	if p.TokenIntrospectionEnabled {
+		switch provider.(type) {
+		case *providers.GitHubProvider, *providers.GitLabProvider, *providers.AzureProvider,
+			*providers.FacebookProvider, *providers.LinkedInProvider, *providers.DigitalOceanProvider:
+			msgs = append(msgs, fmt.Sprintf("invalid setting: token-introspection-enabled: the %s provider validates sessions with its own API", o.Providers[0].Type))
+		}
+	}
+
+	if p.GroupResolver != nil {
+		switch provider.(type) {
+		case *providers.OIDCProvider, *providers.ADFSProvider:
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid setting: group-resolver-url: the %s provider doesn't read groups from id_token claims", o.Providers[0].Type))
+		}
+	}
+
+	if p.SubjectPrefix != "" {
+		switch provider.(type) {
+		case *providers.OIDCProvider, *providers.ADFSProvider:
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid setting: subject-prefix: the %s provider doesn't set the user from id_token claims", o.Providers[0].Type))
+		}
+	}
+
+	if p.GroupsFromAccessToken {
+		switch provider.(type) {
+		case *providers.OIDCProvider, *providers.ADFSProvider, *providers.AzureProvider:
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid setting: oidc-groups-from-access-token: the %s provider issues opaque access tokens", o.Providers[0].Type))
+		}
+	}
+
 	switch p := o.GetProvider().(type) {
 	case *providers.AzureProvider:
 		p.Configure(o.Providers[0].AzureConfig.Tenant)
 	case *providers.ADFSProvider:
 		p.Configure(o.Providers[0].ADFSConfig.SkipScope)
+	case *providers.ALBProvider:
+		if o.Providers[0].ALBConfig.SignerARN == "" {
+			msgs = append(msgs, "missing setting: alb-signer-arn")
+		}
+		p.Configure(o.Providers[0].ALBConfig.Region, o.Providers[0].ALBConfig.SignerARN)
 	case *providers.GitHubProvider:
 		p.SetOrgTeam(o.Providers[0].GitHubConfig.Org, o.Providers[0].GitHubConfig.Team)
 		p.SetRepo(o.Providers[0].GitHubConfig.Repo, o.Providers[0].GitHubConfig.Token)
@@ -267,6 +637,9 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 		p.SetRepository(o.Providers[0].BitbucketConfig.Repository)
 	case *providers.OIDCProvider:
 		p.SkipNonce = o.Providers[0].OIDCConfig.InsecureSkipNonce
+		if o.Providers[0].ProfileURLTokenRefresh {
+			p.ProfileURLTokenRefresher = p.RefreshAccessToken
+		}
 		if p.Verifier == nil {
 			msgs = append(msgs, "oidc provider requires an oidc issuer URL")
 		}
@@ -288,7 +661,9 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 				msgs = append(msgs, "failed to initialize oidc provider for gitlab.com")
 			} else {
 				p.Verifier = provider.Verifier(&oidc.Config{
-					ClientID: o.Providers[0].ClientID,
+					ClientID:          o.Providers[0].ClientID,
+					SkipClientIDCheck: p.SkipClientIDCheck(),
+					SkipExpiryCheck:   providers.SkipVerifierExpiryCheck(o.Providers[0].OIDCConfig.SkewTolerance.Duration()),
 				})
 
 				p.LoginURL, msgs = parseURL(provider.Endpoint().AuthURL, "login", msgs)
@@ -329,6 +704,61 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 	return msgs
 }
 
+// convertClaimRules converts the claim rule options into the rules compiled
+// by the provider
+func convertClaimRules(rules []options.ClaimRule) []providers.ClaimRule {
+	converted := make([]providers.ClaimRule, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, providers.ClaimRule{
+			Claim:     rule.Claim,
+			Target:    rule.Target,
+			Type:      rule.Type,
+			Required:  rule.Required,
+			Transform: rule.Transform,
+			Source:    rule.Source,
+		})
+	}
+	return converted
+}
+
+// oidcDiscoveryDocument contains the fields of an OIDC discovery document
+// required to configure a provider without performing discovery.
+type oidcDiscoveryDocument struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+
+	raw json.RawMessage
+}
+
+// writeOIDCDiscoveryCache persists the discovery document of a successfully
+// discovered provider so that it can be used if discovery fails later.
+func writeOIDCDiscoveryCache(cacheFile string, provider *oidc.Provider) error {
+	var doc json.RawMessage
+	if err := provider.Claims(&doc); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cacheFile, doc, 0600)
+}
+
+// readOIDCDiscoveryCache loads a previously persisted discovery document
+func readOIDCDiscoveryCache(cacheFile string) (*oidcDiscoveryDocument, error) {
+	data, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &oidcDiscoveryDocument{raw: data}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("could not parse cached discovery document: %v", err)
+	}
+	if doc.Issuer == "" || doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, errors.New("cached discovery document is missing required endpoints")
+	}
+	return doc, nil
+}
+
 func parseSignatureKey(o *options.Options, msgs []string) []string {
 	if o.SignatureKey == "" {
 		return msgs
@@ -404,3 +834,91 @@ func parseURL(toParse string, urltype string, msgs []string) (*url.URL, []string
 	}
 	return parsed, msgs
 }
+
+// appendMissing appends the errors to the msgs, skipping any that have
+// already been reported by the options validation
+func appendMissing(msgs []string, errs []error) []string {
+	for _, err := range errs {
+		msg := err.Error()
+		found := false
+		for _, existing := range msgs {
+			if existing == msg {
+				found = true
+				break
+			}
+		}
+		if !found {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// validateGroupsClaimPrefixes checks that the groups claim prefixes are for
+// claims that groups are extracted from. Once any claim is prefixed, every
+// groups claim needs a prefix that doesn't start another one, otherwise a
+// group of one claim could pose as a prefixed group of another.
+func validateGroupsClaimPrefixes(p *providers.ProviderData) []string {
+	claims := p.GroupsClaims
+	if len(claims) == 0 {
+		claims = providers.ParseGroupsClaimList(p.GroupsClaim)
+	}
+
+	prefixed := make([]string, 0, len(p.GroupsClaimPrefixes))
+	for claim := range p.GroupsClaimPrefixes {
+		prefixed = append(prefixed, claim)
+	}
+	sort.Strings(prefixed)
+
+	msgs := []string{}
+	for _, claim := range prefixed {
+		found := false
+		for _, groupsClaim := range claims {
+			if claim == groupsClaim {
+				found = true
+				break
+			}
+		}
+		if !found {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: groups claim prefix for %q, which is not a groups claim", claim))
+		}
+	}
+	if len(prefixed) == 0 {
+		return msgs
+	}
+
+	for i, claim := range claims {
+		prefix := p.GroupsClaimPrefixes[claim]
+		if prefix == "" {
+			msgs = append(msgs, fmt.Sprintf("missing setting: groups claim prefix for %q, every groups claim needs a prefix once one is prefixed", claim))
+			continue
+		}
+		for _, other := range claims[i+1:] {
+			otherPrefix := p.GroupsClaimPrefixes[other]
+			if otherPrefix != "" && (strings.HasPrefix(prefix, otherPrefix) || strings.HasPrefix(otherPrefix, prefix)) {
+				msgs = append(msgs, fmt.Sprintf("invalid setting: groups claim prefixes %q for %q and %q for %q overlap", prefix, claim, otherPrefix, other))
+			}
+		}
+	}
+	return msgs
+}
+
+// validateTokenEndpointHeaders checks that the token endpoint headers are
+// valid HTTP header fields, in name order so the messages are stable
+func validateTokenEndpointHeaders(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := []string{}
+	for _, name := range names {
+		if !httpguts.ValidHeaderFieldName(name) {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: token endpoint header name %q is not a valid HTTP header name", name))
+		} else if !httpguts.ValidHeaderFieldValue(headers[name]) {
+			msgs = append(msgs, fmt.Sprintf("invalid setting: token endpoint header %q has an invalid value", name))
+		}
+	}
+	return msgs
+}
@@ -2,9 +2,14 @@ package validation
 
 import (
 	"crypto"
+	"crypto/tls"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -245,6 +250,21 @@ func TestSkipOIDCDiscovery(t *testing.T) {
 	assert.Equal(t, nil, Validate(o))
 }
 
+func TestSkipOIDCDiscoveryExtraFields(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].Type = "oidc"
+	o.Providers[0].OIDCConfig.IssuerURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/v2.0/"
+	o.Providers[0].OIDCConfig.SkipDiscovery = true
+	o.Providers[0].OIDCConfig.DiscoveryExtraFields = []string{"end_session_endpoint"}
+	o.Providers[0].LoginURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/oauth2/v2.0/authorize?p=b2c_1_sign_in"
+	o.Providers[0].RedeemURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/oauth2/v2.0/token?p=b2c_1_sign_in"
+	o.Providers[0].OIDCConfig.JwksURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/discovery/v2.0/keys"
+
+	assert.NoError(t, Validate(o))
+	assert.NotNil(t, o.GetProvider().Data().Verifier)
+	assert.Empty(t, o.GetProvider().Data().GetDiscoveryExtraFieldValues())
+}
+
 func TestGCPHealthcheck(t *testing.T) {
 	o := testOptions()
 	o.GCPHealthChecks = true
@@ -301,4 +321,516 @@ func TestProviderCAFilesError(t *testing.T) {
 	err = Validate(o)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unable to load provider CA file(s)")
+	assert.Equal(t, 1, strings.Count(err.Error(), "unable to load provider CA file(s)"))
+
+	// The provider's CA pool is loaded even without TLS verification
+	o.SSLInsecureSkipVerify = true
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to load provider CA file(s)")
+}
+
+func TestProviderTLSMinVersion(t *testing.T) {
+	o := testOptions()
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, uint16(tls.VersionTLS12), o.GetProvider().Data().GetTLSMinVersion())
+	transport := http.DefaultClient.Transport.(*http.Transport)
+	assert.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MinVersion)
+
+	o = testOptions()
+	o.Providers[0].TLSMinVersion = "TLS1.3"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, uint16(tls.VersionTLS13), o.GetProvider().Data().GetTLSMinVersion())
+	transport = http.DefaultClient.Transport.(*http.Transport)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+
+	o = testOptions()
+	o.Providers[0].TLSMinVersion = "SSLv3"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: provider-tls-min-version")
+}
+
+func TestProfileURLFailovers(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].ProfileURL = "https://userinfo-a.example.com/"
+	o.Providers[0].ProfileURLFailovers = []string{"https://userinfo-b.example.com/"}
+	assert.Equal(t, nil, Validate(o))
+
+	p := o.GetProvider().Data()
+	assert.Equal(t, "https://userinfo-a.example.com/", p.ProfileURL.String())
+	profileURLs := make([]string, 0)
+	for _, u := range p.GetProfileURLs() {
+		profileURLs = append(profileURLs, u.String())
+	}
+	assert.Equal(t, []string{"https://userinfo-a.example.com/", "https://userinfo-b.example.com/"}, profileURLs)
+
+	o = testOptions()
+	o.Providers[0].ProfileURLFailovers = []string{"https://userinfo-b.example.com/"}
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing setting: profile-url is required with profile-url-failover")
+}
+
+func TestOIDCGroupsJMESPath(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.GroupsJMESPath = "resource_access.*.roles[]"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, "jmespath:resource_access.*.roles[]", o.GetProvider().Data().GroupsClaim)
+
+	o = testOptions()
+	o.Providers[0].OIDCConfig.GroupsJMESPath = "jmespath:resource_access.*.roles[]"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, "jmespath:resource_access.*.roles[]", o.GetProvider().Data().GroupsClaim)
+}
+
+func TestOIDCGroupsJMESPathInvalid(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.GroupsJMESPath = "resource_access.*.roles["
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-groups-claim expression")
+}
+
+func TestOIDCClaimPrecedenceInvalid(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.ClaimPrecedence = "access_token_first"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-claim-precedence")
+}
+
+func TestOIDCMaxIDTokenBytesNegative(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.MaxIDTokenBytes = -1
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-max-id-token-bytes must not be negative")
+}
+
+func TestNonceLengthTooShort(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].NonceLength = 4
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: nonce-length must be at least 8 bytes")
+}
+
+func TestProviderDataValidated(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].RedeemURL = "oauth2.example.com/token"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: redeem-url "oauth2.example.com/token" must be an absolute URL`)
+}
+
+func TestPKCEMethodInvalid(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].PKCEEnabled = true
+	o.Providers[0].PKCEMethod = "S512"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: pkce-method "S512" must be "S256" or "plain"`)
+}
+
+func TestAllowedOrgsRequireActiveOrgClaim(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].AllowedOrgs = []string{"acme"}
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: allowed-org requires an oidc-active-org-claim")
+
+	o.Providers[0].OIDCConfig.ActiveOrgClaim = "org_id"
+	assert.NoError(t, Validate(o))
+}
+
+func TestRequireVerifiedEmailOrEmptyWithAllowUnverifiedEmail(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.RequireVerifiedEmailOrEmpty = true
+	assert.NoError(t, Validate(o))
+
+	o.Providers[0].OIDCConfig.InsecureAllowUnverifiedEmail = true
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-require-verified-email-or-empty can't be used with insecure-oidc-allow-unverified-email")
+}
+
+func TestRequireEmailVerifiedWithAllowUnverifiedEmail(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.RequireEmailVerified = true
+	assert.NoError(t, Validate(o))
+
+	o.Providers[0].OIDCConfig.InsecureAllowUnverifiedEmail = true
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-require-email-verified can't be used with insecure-oidc-allow-unverified-email")
+}
+
+func TestProviderTLSClientCertificate(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].TLSClientCertFile = "/path/to/tls.crt"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: provider-tls-client-cert-file and provider-tls-client-key-file must be set together")
+
+	o.Providers[0].TLSClientKeyFile = "/path/to/tls.key"
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: unable to load provider-tls-client-cert-file")
+}
+
+func TestTokenEndpointHeaders(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].TokenEndpointHeaders = map[string]string{"X-Tenant-ID": "tenant1"}
+	assert.NoError(t, Validate(o))
+
+	o.Providers[0].TokenEndpointHeaders = map[string]string{
+		"X-Tenant ID":      "tenant1",
+		"X-Client-Version": "7\r\n",
+	}
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: token endpoint header \"X-Client-Version\" has an invalid value")
+	assert.Contains(t, err.Error(), "invalid setting: token endpoint header name \"X-Tenant ID\" is not a valid HTTP header name")
+}
+
+func TestUseHostCookiePrefix(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].UseHostCookiePrefix = true
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: use-host-cookie-prefix requires HTTPS, set an https redirect-url or an https-address")
+
+	o = testOptions()
+	o.Providers[0].UseHostCookiePrefix = true
+	o.RawRedirectURL = "https://proxy.example.com/oauth2/callback"
+	o.Cookie.Domains = []string{"example.com"}
+	o.Cookie.Path = "/app"
+	o.Cookie.Secure = false
+	assert.NoError(t, Validate(o))
+	assert.True(t, o.GetProvider().Data().UseHostCookiePrefix)
+	assert.Equal(t, "__Host-_oauth2_proxy", o.Cookie.Name)
+	assert.Nil(t, o.Cookie.Domains)
+	assert.Equal(t, "/", o.Cookie.Path)
+	assert.True(t, o.Cookie.Secure)
+
+	o = testOptions()
+	o.Providers[0].UseHostCookiePrefix = true
+	o.Server.SecureBindAddress = ":443"
+	o.Cookie.Name = "__Host-session"
+	assert.NoError(t, Validate(o))
+	assert.Equal(t, "__Host-session", o.Cookie.Name)
+}
+
+func TestSkipNonceVerification(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.InsecureSkipNonce = true
+	assert.Equal(t, nil, Validate(o))
+	assert.True(t, o.GetProvider().Data().SkipNonceVerification)
+
+	o = testOptions()
+	o.Providers[0].OIDCConfig.InsecureSkipNonce = false
+	assert.Equal(t, nil, Validate(o))
+	assert.False(t, o.GetProvider().Data().SkipNonceVerification)
+}
+
+func TestSubjectPrefixInvalid(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].SubjectPrefix = "google|eu"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: subject-prefix "google|eu" must not contain "|"`)
+}
+
+func TestCookieRefreshOnActivityRequiresMaxSessionDuration(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].CookieRefreshOnActivity = true
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing setting: max-session-duration is required with cookie-refresh-on-activity")
+
+	o = testOptions()
+	o.Providers[0].CookieRefreshOnActivity = true
+	o.Providers[0].MaxSessionDuration = options.Duration(12 * time.Hour)
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, 12*time.Hour, o.GetProvider().Data().MaxSessionDuration)
+}
+
+func TestTokenIntrospectionUnsupportedProvider(t *testing.T) {
+	for _, providerType := range []string{"github", "gitlab", "azure", "facebook", "linkedin", "digitalocean"} {
+		o := testOptions()
+		o.Providers[0].Type = providerType
+		o.Providers[0].TokenIntrospectionEnabled = true
+		err := Validate(o)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), fmt.Sprintf("invalid setting: token-introspection-enabled: the %s provider validates sessions with its own API", providerType))
+	}
+
+	o := testOptions()
+	o.Providers[0].Type = "google"
+	o.Providers[0].TokenIntrospectionEnabled = true
+	assert.Equal(t, nil, Validate(o))
+	assert.True(t, o.GetProvider().Data().TokenIntrospectionEnabled)
+}
+
+func TestSubjectPrefixUnsupportedProvider(t *testing.T) {
+	for _, providerType := range []string{"github", "gitlab", "google", "nextcloud", "alb"} {
+		o := testOptions()
+		o.Providers[0].Type = providerType
+		o.Providers[0].SubjectPrefix = "corp"
+		err := Validate(o)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), fmt.Sprintf("invalid setting: subject-prefix: the %s provider doesn't set the user from id_token claims", providerType))
+	}
+
+	o := testOptions()
+	o.Providers[0].Type = "adfs"
+	o.Providers[0].SubjectPrefix = "corp"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, "corp", o.GetProvider().Data().SubjectPrefix)
+}
+
+func TestRequiredTokenResponseFields(t *testing.T) {
+	o := testOptions()
+	assert.Equal(t, nil, Validate(o))
+	assert.Nil(t, o.GetProvider().Data().TokenResponseValidator)
+
+	o = testOptions()
+	o.Providers[0].RequiredTokenResponseFields = []string{"access_token", "token_type"}
+	assert.Equal(t, nil, Validate(o))
+	validator := o.GetProvider().Data().TokenResponseValidator
+	assert.NotNil(t, validator)
+	assert.EqualError(t, validator(map[string]interface{}{"access_token": "a1234"}), "token response missing token_type")
+}
+
+func TestGroupResolverURL(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].GroupResolverURL = "https://graph.example.com/groups"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: group-resolver-url must contain {id}")
+
+	o = testOptions()
+	o.Providers[0].Type = "github"
+	o.Providers[0].GroupResolverURL = "https://graph.example.com/groups/{id}"
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: group-resolver-url: the github provider doesn't read groups from id_token claims")
+
+	o = testOptions()
+	o.Providers[0].Type = "adfs"
+	o.Providers[0].GroupResolverURL = "https://graph.example.com/groups/{id}"
+	assert.Equal(t, nil, Validate(o))
+	assert.NotNil(t, o.GetProvider().Data().GroupResolver)
+}
+
+func TestAuditLoggingFilename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := testOptions()
+	o.Logging.AuditFilename = filepath.Join(dir, "missing", "audit.log")
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to open audit-logging-filename")
+
+	o = testOptions()
+	o.Logging.AuditFilename = filepath.Join(dir, "audit.log")
+	o.Logging.ProviderJSON = true
+	assert.Equal(t, nil, Validate(o))
+	assert.NotNil(t, o.GetProvider().Data().AuditLogger)
+	_, err = os.Stat(o.Logging.AuditFilename)
+	assert.NoError(t, err)
+}
+
+func TestGroupMatchMode(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].GroupMatchMode = "fuzzy"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: group-match-mode "fuzzy" must be "exact" or "glob"`)
+
+	o = testOptions()
+	o.Providers[0].GroupMatchMode = "glob"
+	o.Providers[0].AllowedGroups = []string{"team:*:admin", "team:[eng"}
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: allowed-group "team:[eng" is not a valid glob`)
+	assert.NotContains(t, err.Error(), `"team:*:admin"`)
+}
+
+func TestAllowedGroupsRegexInvalid(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].AllowedGroupsRegex = []string{"CN=(engineering"}
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: invalid allowed group regex "CN=(engineering"`)
+}
+
+func TestOIDCDiscoveryCacheFile(t *testing.T) {
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q}`,
+			issuer, issuer+"/auth", issuer+"/token", issuer+"/keys")
+	}))
+	issuer = server.URL
+
+	dir, err := ioutil.TempDir("", "oidc-discovery-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "discovery.json")
+
+	newOptions := func() *options.Options {
+		o := testOptions()
+		o.Providers[0].Type = "oidc"
+		o.Providers[0].OIDCConfig.IssuerURL = issuer
+		o.Providers[0].OIDCConfig.DiscoveryCacheFile = cacheFile
+		return o
+	}
+
+	// Successful discovery persists the document
+	o := newOptions()
+	assert.Equal(t, nil, Validate(o))
+	cached, err := ioutil.ReadFile(cacheFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(cached), issuer+"/token")
+
+	// Failed discovery falls back to the cached document
+	server.Close()
+	o = newOptions()
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, issuer+"/auth", o.Providers[0].LoginURL)
+	assert.Equal(t, issuer+"/token", o.Providers[0].RedeemURL)
+	assert.NotNil(t, o.GetOIDCVerifier())
+
+	// Failed discovery without a usable cache returns the discovery error
+	assert.NoError(t, os.Remove(cacheFile))
+	o = newOptions()
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to fall back to OIDC discovery cache")
+}
+
+func TestOIDCDiscoveryExtraFields(t *testing.T) {
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q,`+
+			`"tenant_region_scope":"EU","access_token_ttl_max":3600}`,
+			issuer, issuer+"/auth", issuer+"/token", issuer+"/keys")
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	o := testOptions()
+	o.Providers[0].Type = "oidc"
+	o.Providers[0].OIDCConfig.IssuerURL = issuer
+	o.Providers[0].OIDCConfig.DiscoveryExtraFields = []string{"tenant_region_scope", "access_token_ttl_max", "missing"}
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, map[string]interface{}{
+		"tenant_region_scope":  "EU",
+		"access_token_ttl_max": float64(3600),
+	}, o.GetProvider().Data().GetDiscoveryExtraFieldValues())
+}
+
+func TestOIDCClaimJSONPathInvalid(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.EmailClaim = "jsonpath:$.emails[0"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-email-claim expression")
+}
+
+func TestOIDCIssuerURLNormalize(t *testing.T) {
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q}`,
+			issuer, issuer+"auth", issuer+"token", issuer+"keys")
+	}))
+	defer server.Close()
+	// The provider adds a trailing slash to its issuer
+	issuer = server.URL + "/"
+
+	newOptions := func(normalize bool) *options.Options {
+		o := testOptions()
+		o.Providers[0].Type = "oidc"
+		o.Providers[0].OIDCConfig.IssuerURL = server.URL
+		o.Providers[0].OIDCConfig.IssuerURLNormalize = normalize
+		return o
+	}
+
+	err := Validate(newOptions(false))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "issuer did not match")
+
+	o := newOptions(true)
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, issuer+"token", o.Providers[0].RedeemURL)
+	assert.True(t, o.GetProvider().Data().IssuerURLNormalize)
+
+	// Other issuer differences still fail
+	issuer = server.URL + "/tenant/"
+	err = Validate(newOptions(true))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "issuer did not match")
+}
+
+func TestGroupsFromAccessTokenOpaqueProvider(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].Type = "github"
+	o.Providers[0].OIDCConfig.GroupsFromAccessToken = true
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid setting: oidc-groups-from-access-token: the github provider issues opaque access tokens")
+
+	o = testOptions()
+	o.Providers[0].Type = "azure"
+	o.Providers[0].OIDCConfig.GroupsFromAccessToken = true
+	assert.Equal(t, nil, Validate(o))
+	assert.True(t, o.GetProvider().Data().GroupsFromAccessToken)
+}
+
+func TestALBSignerARNRequired(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].Type = "alb"
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing setting: alb-signer-arn")
+
+	o = testOptions()
+	o.Providers[0].Type = "alb"
+	o.Providers[0].ALBConfig.SignerARN = "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/test/abcdef"
+	assert.Equal(t, nil, Validate(o))
+}
+
+func TestGroupsClaimPrefixes(t *testing.T) {
+	o := testOptions()
+	o.Providers[0].OIDCConfig.GroupsClaims = []string{"groups", "roles"}
+	o.Providers[0].OIDCConfig.GroupsClaimPrefixes = map[string]string{"groups": "group:", "roles": "role:"}
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, map[string]string{"groups": "group:", "roles": "role:"}, o.GetProvider().Data().GroupsClaimPrefixes)
+
+	// An unprefixed "role:admin" group would pose as the admin role
+	o = testOptions()
+	o.Providers[0].OIDCConfig.GroupsClaims = []string{"groups", "roles"}
+	o.Providers[0].OIDCConfig.GroupsClaimPrefixes = map[string]string{"roles": "role:"}
+	err := Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `missing setting: groups claim prefix for "groups", every groups claim needs a prefix once one is prefixed`)
+
+	o = testOptions()
+	o.Providers[0].OIDCConfig.GroupsClaim = "groups,roles"
+	o.Providers[0].OIDCConfig.GroupsClaimPrefixes = map[string]string{"groups": "role:x:", "roles": "role:"}
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: groups claim prefixes "role:x:" for "groups" and "role:" for "roles" overlap`)
+
+	o = testOptions()
+	o.Providers[0].OIDCConfig.GroupsClaimPrefixes = map[string]string{"role": "role:"}
+	err = Validate(o)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid setting: groups claim prefix for "role", which is not a groups claim`)
 }
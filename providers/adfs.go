@@ -3,7 +3,6 @@ package providers
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/url"
 	"strings"
 
@@ -79,7 +78,7 @@ func (p *ADFSProvider) EnrichSession(ctx context.Context, s *sessions.SessionSta
 		return nil
 	}
 
-	idToken, err := p.Verifier.Verify(ctx, s.IDToken)
+	idToken, err := p.verifyToken(ctx, s.IDToken)
 	if err != nil {
 		return err
 	}
@@ -88,7 +87,7 @@ func (p *ADFSProvider) EnrichSession(ctx context.Context, s *sessions.SessionSta
 	c, err := p.getClaims(idToken)
 
 	if err != nil {
-		return fmt.Errorf("couldn't extract claims from id_token (%v)", err)
+		return newClaimError(ErrClaimExtraction, err, "couldn't extract claims from id_token (%v)", err)
 	}
 	s.Email = c.Email
 
@@ -127,6 +127,16 @@ var _ = Describe("ADFS Provider Tests", func() {
 		})
 	})
 
+	Context("without a verifier", func() {
+		It("should return an error", func() {
+			p.Verifier = nil
+			rawIDToken, _ := newSignedTestIDToken(defaultIDToken)
+			session := &sessions.SessionState{IDToken: rawIDToken}
+			err := p.EnrichSession(context.Background(), session)
+			Expect(err).To(Equal(ErrMissingOIDCVerifier))
+		})
+	})
+
 	Context("with valid token", func() {
 		It("should not throw an error", func() {
 			p.EmailClaim = "email"
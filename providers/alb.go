@@ -0,0 +1,230 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// ALBProvider represents an AWS Application Load Balancer that has already
+// authenticated the user with OIDC. The ALB passes the user's claims in a JWT
+// that it signs itself, along with the access token, as request headers.
+type ALBProvider struct {
+	*ProviderData
+
+	// Region is the AWS region of the ALB, used to locate its public keys
+	Region string
+	// SignerARN must match the signer of the ALB JWT, as the ALB public keys
+	// of a region are shared by every ALB in it
+	SignerARN string
+	// PublicKeyURL is the base URL the ALB public keys are fetched from,
+	// the key ID is appended to it
+	PublicKeyURL string
+
+	keysMutex   sync.RWMutex
+	keys        map[string]*ecdsa.PublicKey
+	missingKeys map[string]time.Time
+}
+
+var _ Provider = (*ALBProvider)(nil)
+
+const (
+	albProviderName = "AWS ALB"
+
+	// ALBDataHeader is the header the ALB passes the signed user claims JWT in
+	ALBDataHeader = "X-Amzn-Oidc-Data"
+	// ALBAccessTokenHeader is the header the ALB passes the access token in
+	ALBAccessTokenHeader = "X-Amzn-Oidc-Accesstoken"
+
+	albDefaultRegion         = "us-east-1"
+	albPublicKeyURLFormat    = "https://public-keys.auth.elb.%s.amazonaws.com/"
+	albSigningAlgorithm      = "ES256"
+	albSignerHeaderParameter = "signer"
+
+	// albMissingKeyTTL is how long a key ID that couldn't be fetched isn't
+	// fetched again, and albMaxMissingKeys how many such key IDs are kept.
+	// Key IDs come from the unverified JWT header, so this bounds the key
+	// fetches made for made-up key IDs.
+	albMissingKeyTTL  = 5 * time.Minute
+	albMaxMissingKeys = 100
+)
+
+// NewALBProvider initiates a new ALBProvider
+func NewALBProvider(p *ProviderData) *ALBProvider {
+	p.setProviderDefaults(providerDefaults{
+		name: albProviderName,
+	})
+
+	provider := &ALBProvider{
+		ProviderData: p,
+		keys:         make(map[string]*ecdsa.PublicKey),
+		missingKeys:  make(map[string]time.Time),
+	}
+	provider.Configure(albDefaultRegion, "")
+	return provider
+}
+
+// Configure sets the AWS region the ALB public keys are fetched from and the
+// ARN of the ALB expected to sign the JWTs
+func (p *ALBProvider) Configure(region, signerARN string) {
+	if region == "" {
+		region = albDefaultRegion
+	}
+	p.Region = region
+	p.SignerARN = signerARN
+	p.PublicKeyURL = fmt.Sprintf(albPublicKeyURLFormat, region)
+}
+
+// CreateSessionFromRequest creates a session from the headers the ALB adds
+// to authenticated requests
+func (p *ALBProvider) CreateSessionFromRequest(req *http.Request) (*sessions.SessionState, error) {
+	token := req.Header.Get(ALBDataHeader)
+	if token == "" {
+		return nil, nil
+	}
+
+	ss, err := p.CreateSessionFromToken(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	ss.AccessToken = req.Header.Get(ALBAccessTokenHeader)
+	return ss, nil
+}
+
+// CreateSessionFromToken converts an ALB signed JWT into a session. Its claims
+// go through the same checks as the claims of an id_token.
+func (p *ALBProvider) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
+	claims, err := p.verifyALBToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ALB token claims: %v", err)
+	}
+	oidcClaims, err := p.parseClaims(payload)
+	if err != nil {
+		return nil, newClaimError(ErrClaimExtraction, err, "couldn't extract claims from ALB token (%v)", err)
+	}
+	ss, err := p.buildSessionFromOIDCClaims(oidcClaims)
+	if err != nil {
+		return nil, err
+	}
+	if ss.Email == "" && !ss.EmailUnverified {
+		ss.Email = ss.User
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		ss.SetExpiresOn(time.Unix(int64(exp), 0))
+	}
+	ss.CreatedAtNow()
+
+	return ss, nil
+}
+
+// verifyALBToken verifies the signature of an ALB JWT using the ALB public
+// key for its key ID and returns its claims
+func (p *ALBProvider) verifyALBToken(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != albSigningAlgorithm {
+			return nil, fmt.Errorf("unexpected signing algorithm %q", t.Method.Alg())
+		}
+		if p.SignerARN == "" {
+			return nil, errors.New("no ALB signer ARN configured")
+		}
+		if t.Header[albSignerHeaderParameter] != p.SignerARN {
+			return nil, fmt.Errorf("unexpected signer %q", t.Header[albSignerHeaderParameter])
+		}
+		kid, ok := t.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("missing key ID")
+		}
+		return p.getPublicKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ALB token: %v", err)
+	}
+	return claims, nil
+}
+
+// getPublicKey returns the ALB public key with the given key ID, fetching it
+// from the regional public key endpoint on first use. Key IDs that couldn't
+// be fetched aren't fetched again for albMissingKeyTTL.
+func (p *ALBProvider) getPublicKey(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	p.keysMutex.RLock()
+	key, ok := p.keys[kid]
+	failedAt, missing := p.missingKeys[kid]
+	p.keysMutex.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if missing && time.Since(failedAt) < albMissingKeyTTL {
+		return nil, fmt.Errorf("ALB public key %q recently failed to fetch", kid)
+	}
+	if !p.canFetchUnknownKey() {
+		return nil, fmt.Errorf("too many unknown ALB public key IDs, not fetching %q", kid)
+	}
+
+	key, err := p.fetchPublicKey(ctx, kid)
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+	if err != nil {
+		p.missingKeys[kid] = time.Now()
+		return nil, err
+	}
+	delete(p.missingKeys, kid)
+	p.keys[kid] = key
+	return key, nil
+}
+
+// canFetchUnknownKey prunes the expired missingKeys and reports whether
+// there is room to record another one
+func (p *ALBProvider) canFetchUnknownKey() bool {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	if len(p.missingKeys) < albMaxMissingKeys {
+		return true
+	}
+	for kid, failedAt := range p.missingKeys {
+		if time.Since(failedAt) >= albMissingKeyTTL {
+			delete(p.missingKeys, kid)
+		}
+	}
+	return len(p.missingKeys) < albMaxMissingKeys
+}
+
+// fetchPublicKey fetches the ALB public key with the given key ID from the
+// regional public key endpoint
+func (p *ALBProvider) fetchPublicKey(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	keyURL := strings.TrimSuffix(p.PublicKeyURL, "/") + "/" + url.PathEscape(kid)
+	result := requests.New(keyURL).
+		WithContext(ctx).
+		WithClient(p.getHTTPClient()).
+		Do()
+	if result.Error() != nil {
+		return nil, result.Error()
+	}
+	if result.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching ALB public key %q", result.StatusCode(), kid)
+	}
+
+	key, err := jwt.ParseECPublicKeyFromPEM(result.Body())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ALB public key %q: %v", kid, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,295 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	albTestKeyID     = "alb-key-id"
+	albTestSignerARN = "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/test/abcdef"
+)
+
+type albKeyServer struct {
+	*httptest.Server
+	fetches int32
+}
+
+func newALBKeyServer(t *testing.T, key *ecdsa.PrivateKey) *albKeyServer {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	s := &albKeyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&s.fetches, 1)
+		if strings.TrimPrefix(req.URL.Path, "/") != albTestKeyID {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write(keyPEM)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestALBProvider(serverURL string) *ALBProvider {
+	p := NewALBProvider(&ProviderData{
+		EmailClaim:  "email",
+		GroupsClaim: "groups",
+	})
+	p.Configure("eu-west-1", albTestSignerARN)
+	p.PublicKeyURL = serverURL
+	return p
+}
+
+func signALBToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid, signer string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	token.Header["signer"] = signer
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestALBProviderDefaults(t *testing.T) {
+	g := NewWithT(t)
+	p := NewALBProvider(&ProviderData{})
+	g.Expect(p.Data().ProviderName).To(Equal("AWS ALB"))
+	g.Expect(p.Region).To(Equal("us-east-1"))
+	g.Expect(p.PublicKeyURL).To(Equal("https://public-keys.auth.elb.us-east-1.amazonaws.com/"))
+
+	p.Configure("eu-west-1", albTestSignerARN)
+	g.Expect(p.Region).To(Equal("eu-west-1"))
+	g.Expect(p.SignerARN).To(Equal(albTestSignerARN))
+	g.Expect(p.PublicKeyURL).To(Equal("https://public-keys.auth.elb.eu-west-1.amazonaws.com/"))
+}
+
+func TestALBProviderCreateSessionFromRequest(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiry := time.Now().Add(time.Minute).Truncate(time.Second)
+	claims := jwt.MapClaims{
+		"sub":                "123456789",
+		"email":              "janed@me.com",
+		"preferred_username": "Jane Dobbs",
+		"groups":             []string{"admin", "users"},
+		"exp":                expiry.Unix(),
+	}
+
+	testCases := map[string]struct {
+		token         string
+		expectedError string
+	}{
+		"valid token": {
+			token: signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, albTestSignerARN, claims),
+		},
+		"wrong signer": {
+			token:         signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, "arn:aws:other", claims),
+			expectedError: `failed to verify ALB token: unexpected signer "arn:aws:other"`,
+		},
+		"wrong signing key": {
+			token:         signALBToken(t, jwt.SigningMethodES256, otherKey, albTestKeyID, albTestSignerARN, claims),
+			expectedError: "failed to verify ALB token: crypto/ecdsa: verification error",
+		},
+		"wrong signing algorithm": {
+			token:         signALBToken(t, jwt.SigningMethodHS256, []byte("secret"), albTestKeyID, albTestSignerARN, claims),
+			expectedError: `failed to verify ALB token: unexpected signing algorithm "HS256"`,
+		},
+		"unknown key ID": {
+			token:         signALBToken(t, jwt.SigningMethodES256, key, "unknown", albTestSignerARN, claims),
+			expectedError: `failed to verify ALB token: unexpected status 404 fetching ALB public key "unknown"`,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			server := newALBKeyServer(t, key)
+			p := newTestALBProvider(server.URL)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(ALBDataHeader, tc.token)
+			req.Header.Set(ALBAccessTokenHeader, "access-token")
+
+			ss, err := p.CreateSessionFromRequest(req)
+			if tc.expectedError != "" {
+				g.Expect(err).To(MatchError(tc.expectedError))
+				g.Expect(ss).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ss.User).To(Equal("123456789"))
+			g.Expect(ss.Email).To(Equal("janed@me.com"))
+			g.Expect(ss.PreferredUsername).To(Equal("Jane Dobbs"))
+			g.Expect(ss.Groups).To(Equal([]string{"admin", "users"}))
+			g.Expect(ss.AccessToken).To(Equal("access-token"))
+			g.Expect(*ss.ExpiresOn).To(BeTemporally("==", expiry))
+		})
+	}
+
+	t.Run("without the data header", func(t *testing.T) {
+		g := NewWithT(t)
+		p := newTestALBProvider("http://127.0.0.1:0")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		ss, err := p.CreateSessionFromRequest(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ss).To(BeNil())
+	})
+
+	t.Run("caches public keys", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+		token := signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, albTestSignerARN, claims)
+
+		for i := 0; i < 3; i++ {
+			_, err := p.CreateSessionFromToken(context.Background(), token)
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+		g.Expect(atomic.LoadInt32(&server.fetches)).To(Equal(int32(1)))
+	})
+
+	t.Run("doesn't refetch unknown key IDs", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+		token := signALBToken(t, jwt.SigningMethodES256, key, "unknown", albTestSignerARN, claims)
+
+		for i := 0; i < 3; i++ {
+			_, err := p.CreateSessionFromToken(context.Background(), token)
+			g.Expect(err).To(HaveOccurred())
+		}
+		g.Expect(atomic.LoadInt32(&server.fetches)).To(Equal(int32(1)))
+	})
+
+	t.Run("limits the unknown key IDs fetched", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+
+		for i := 0; i < albMaxMissingKeys+10; i++ {
+			token := signALBToken(t, jwt.SigningMethodES256, key, fmt.Sprintf("unknown-%d", i), albTestSignerARN, claims)
+			_, err := p.CreateSessionFromToken(context.Background(), token)
+			g.Expect(err).To(HaveOccurred())
+		}
+		g.Expect(atomic.LoadInt32(&server.fetches)).To(Equal(int32(albMaxMissingKeys)))
+	})
+
+	t.Run("without a signer ARN", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+		p.SignerARN = ""
+		token := signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, "", claims)
+
+		_, err := p.CreateSessionFromToken(context.Background(), token)
+		g.Expect(err).To(MatchError("failed to verify ALB token: no ALB signer ARN configured"))
+		g.Expect(atomic.LoadInt32(&server.fetches)).To(Equal(int32(0)))
+	})
+}
+
+func TestALBProviderAppliesClaimChecks(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{
+		"sub":   "123456789",
+		"email": "janed@me.com",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+
+	t.Run("refuses a denied subject", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+		p.SetDeniedSubjects([]string{"123456789"})
+		token := signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, albTestSignerARN, claims)
+
+		ss, err := p.CreateSessionFromToken(context.Background(), token)
+		g.Expect(errors.Is(err, ErrSubjectNotAllowed)).To(BeTrue())
+		g.Expect(ss).To(BeNil())
+	})
+
+	t.Run("allows an allowed subject", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+		p.SetAllowedSubjects([]string{"123456789"})
+		token := signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, albTestSignerARN, claims)
+
+		ss, err := p.CreateSessionFromToken(context.Background(), token)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ss.User).To(Equal("123456789"))
+	})
+
+	t.Run("refuses an unverified email", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newALBKeyServer(t, key)
+		p := newTestALBProvider(server.URL)
+		unverified := jwt.MapClaims{"email_verified": false}
+		for claim, value := range claims {
+			unverified[claim] = value
+		}
+		token := signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, albTestSignerARN, unverified)
+
+		_, err := p.CreateSessionFromToken(context.Background(), token)
+		g.Expect(errors.Is(err, ErrEmailNotVerified)).To(BeTrue())
+	})
+}
+
+func TestALBProviderFetchesKeysWithProviderClient(t *testing.T) {
+	g := NewWithT(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write(keyPEM)
+	}))
+	defer server.Close()
+
+	p := newTestALBProvider(server.URL)
+	token := signALBToken(t, jwt.SigningMethodES256, key, albTestKeyID, albTestSignerARN, jwt.MapClaims{"sub": "123456789"})
+
+	// The test server's certificate is only trusted by the provider's CA pool
+	_, err = p.CreateSessionFromToken(context.Background(), token)
+	g.Expect(err).To(HaveOccurred())
+
+	p.missingKeys = make(map[string]time.Time)
+	p.CAPool = x509.NewCertPool()
+	p.CAPool.AddCert(server.Certificate())
+	ss, err := p.CreateSessionFromToken(context.Background(), token)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ss.User).To(Equal("123456789"))
+}
@@ -0,0 +1,110 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultAllowedGroupsURLRefreshInterval is how often the allowed groups are
+// fetched from the AllowedGroupsURL when no refresh interval is set
+const DefaultAllowedGroupsURLRefreshInterval = 5 * time.Minute
+
+// allowedGroupsURLFetches counts the fetches of the allowed groups URL by result
+var allowedGroupsURLFetches = registerAllowedGroupsURLFetchCounter(prometheus.DefaultRegisterer)
+
+// FetchAllowedGroups fetches the JSON array of group names from the
+// AllowedGroupsURL. They are allowed in addition to the static AllowedGroups.
+// When the fetch fails, the groups of the last successful fetch are kept.
+func (p *ProviderData) FetchAllowedGroups(ctx context.Context) error {
+	if p.AllowedGroupsURL == nil {
+		return errors.New("no allowed groups URL to fetch")
+	}
+
+	var groups []string
+	err := requests.New(p.AllowedGroupsURL.String()).
+		WithContext(ctx).
+		SetHeader(acceptHeader, acceptApplicationJSON).
+		Do().
+		UnmarshalInto(&groups)
+	if err != nil {
+		allowedGroupsURLFetches.WithLabelValues("error").Inc()
+		return fmt.Errorf("unable to fetch the allowed groups from %s: %v", p.AllowedGroupsURL, err)
+	}
+
+	allowed := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		allowed[p.normalizeGroup(group)] = struct{}{}
+	}
+	p.dynamicAllowedGroups.Store(allowed)
+	allowedGroupsURLFetches.WithLabelValues("success").Inc()
+	return nil
+}
+
+// WatchAllowedGroupsURL fetches the allowed groups from the AllowedGroupsURL
+// and refetches them every AllowedGroupsURLRefreshInterval until the context
+// is done. Only the first fetch must succeed.
+func (p *ProviderData) WatchAllowedGroupsURL(ctx context.Context) error {
+	if err := p.FetchAllowedGroups(ctx); err != nil {
+		return err
+	}
+
+	interval := p.AllowedGroupsURLRefreshInterval
+	if interval <= 0 {
+		interval = DefaultAllowedGroupsURLRefreshInterval
+	}
+	go p.watchAllowedGroupsURL(ctx, interval)
+	logger.Printf("refreshing the allowed groups from %s every %s", p.AllowedGroupsURL, interval)
+	return nil
+}
+
+func (p *ProviderData) watchAllowedGroupsURL(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Printf("Stopped refreshing the allowed groups from %s", p.AllowedGroupsURL)
+			return
+		case <-ticker.C:
+			if err := p.FetchAllowedGroups(ctx); err != nil {
+				logger.Errorf("Warning: %v, using the last fetched allowed groups", err)
+			}
+		}
+	}
+}
+
+// getDynamicAllowedGroups returns the allowed groups last fetched from the
+// AllowedGroupsURL
+func (p *ProviderData) getDynamicAllowedGroups() map[string]struct{} {
+	groups, _ := p.dynamicAllowedGroups.Load().(map[string]struct{})
+	return groups
+}
+
+// registerAllowedGroupsURLFetchCounter registers 'oauth2_proxy_allowed_groups_url_fetch_total'
+// This keeps a tally of allowed groups URL fetches bucketed by their result
+func registerAllowedGroupsURLFetchCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oauth2_proxy_allowed_groups_url_fetch_total",
+			Help: "Total number of allowed groups URL fetches by result.",
+		},
+		[]string{"result"},
+	)
+
+	if err := registerer.Register(counter); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counter = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			panic(err)
+		}
+	}
+
+	return counter
+}
@@ -0,0 +1,136 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testAllowedGroupsServer serves the groups it is given as a JSON array, or
+// a server error when it has none
+type testAllowedGroupsServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	groups string
+}
+
+func newTestAllowedGroupsServer(t *testing.T, groups string) *testAllowedGroupsServer {
+	s := &testAllowedGroupsServer{groups: groups}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.groups == "" {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(s.groups))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testAllowedGroupsServer) SetGroups(groups string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = groups
+}
+
+func newTestAllowedGroupsProvider(g *WithT, server *testAllowedGroupsServer) *ProviderData {
+	allowedGroupsURL, err := url.Parse(server.URL)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	p := &ProviderData{AllowedGroupsURL: allowedGroupsURL}
+	p.SetAllowedGroups([]string{"static"})
+	return p
+}
+
+func TestProviderData_FetchAllowedGroups(t *testing.T) {
+	t.Run("merges the fetched groups with the static groups", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newTestAllowedGroupsServer(t, `["dynamic1", "dynamic2"]`)
+		p := newTestAllowedGroupsProvider(g, server)
+
+		g.Expect(p.FetchAllowedGroups(context.Background())).To(Succeed())
+		g.Expect(p.IsGroupAllowed("static")).To(BeTrue())
+		g.Expect(p.IsGroupAllowed("dynamic1")).To(BeTrue())
+		g.Expect(p.IsGroupAllowed("dynamic2")).To(BeTrue())
+		g.Expect(p.IsGroupAllowed("other")).To(BeFalse())
+	})
+
+	t.Run("replaces the groups of the previous fetch", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newTestAllowedGroupsServer(t, `["dynamic1"]`)
+		p := newTestAllowedGroupsProvider(g, server)
+
+		g.Expect(p.FetchAllowedGroups(context.Background())).To(Succeed())
+		g.Expect(p.IsGroupAllowed("dynamic1")).To(BeTrue())
+
+		server.SetGroups(`["dynamic2"]`)
+		g.Expect(p.FetchAllowedGroups(context.Background())).To(Succeed())
+		g.Expect(p.IsGroupAllowed("dynamic1")).To(BeFalse())
+		g.Expect(p.IsGroupAllowed("dynamic2")).To(BeTrue())
+	})
+
+	t.Run("keeps the last fetched groups when a fetch fails", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newTestAllowedGroupsServer(t, `["dynamic1"]`)
+		p := newTestAllowedGroupsProvider(g, server)
+		errors := testutil.ToFloat64(allowedGroupsURLFetches.WithLabelValues("error"))
+
+		g.Expect(p.FetchAllowedGroups(context.Background())).To(Succeed())
+
+		server.SetGroups("")
+		g.Expect(p.FetchAllowedGroups(context.Background())).ToNot(Succeed())
+		server.SetGroups(`{"groups": ["dynamic2"]}`)
+		g.Expect(p.FetchAllowedGroups(context.Background())).ToNot(Succeed())
+
+		g.Expect(p.IsGroupAllowed("dynamic1")).To(BeTrue())
+		g.Expect(p.IsGroupAllowed("dynamic2")).To(BeFalse())
+		g.Expect(testutil.ToFloat64(allowedGroupsURLFetches.WithLabelValues("error"))).To(Equal(errors + 2))
+	})
+
+	t.Run("restricts groups while none have been fetched", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newTestAllowedGroupsServer(t, "")
+		p := newTestAllowedGroupsProvider(g, server)
+		p.SetAllowedGroups(nil)
+
+		g.Expect(p.FetchAllowedGroups(context.Background())).ToNot(Succeed())
+		g.Expect(p.IsGroupAllowed("other")).To(BeFalse())
+	})
+}
+
+func TestProviderData_WatchAllowedGroupsURL(t *testing.T) {
+	t.Run("refetches the groups every refresh interval", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newTestAllowedGroupsServer(t, `["dynamic1"]`)
+		p := newTestAllowedGroupsProvider(g, server)
+		p.AllowedGroupsURLRefreshInterval = 10 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		g.Expect(p.WatchAllowedGroupsURL(ctx)).To(Succeed())
+		g.Expect(p.IsGroupAllowed("dynamic1")).To(BeTrue())
+
+		server.SetGroups(`["dynamic2"]`)
+		g.Eventually(func() bool { return p.IsGroupAllowed("dynamic2") }).Should(BeTrue())
+		g.Expect(p.IsGroupAllowed("dynamic1")).To(BeFalse())
+	})
+
+	t.Run("fails when the first fetch fails", func(t *testing.T) {
+		g := NewWithT(t)
+		server := newTestAllowedGroupsServer(t, "")
+		p := newTestAllowedGroupsProvider(g, server)
+
+		g.Expect(p.WatchAllowedGroupsURL(context.Background())).ToNot(Succeed())
+	})
+}
@@ -0,0 +1,100 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	// AuthDecisionAllow is the Decision of an allowed login or request
+	AuthDecisionAllow = "allow"
+	// AuthDecisionDeny is the Decision of a denied login or request
+	AuthDecisionDeny = "deny"
+)
+
+// AuthDecision is an authentication or authorization decision made by a
+// provider, as recorded by an AuditLogger
+type AuthDecision struct {
+	Timestamp time.Time
+	Email     string
+	SourceIP  string
+	// Decision is either `allow` or `deny`
+	Decision string
+	Reason   string
+	// Groups are the session groups the decision was based on
+	Groups   []string
+	Provider string
+}
+
+// AuditLogger records the authentication and authorization decisions of a
+// provider, eg. for compliance focused deployments that need an audit trail
+// of every login and authorization check. Implementations must be safe for
+// concurrent use.
+type AuditLogger interface {
+	LogAuthDecision(ctx context.Context, decision AuthDecision)
+}
+
+// NewStructuredAuditLogger creates an AuditLogger that writes each decision
+// to the StructuredLogger, eg. a logger.JSONLogger writing to an append only
+// audit log
+func NewStructuredAuditLogger(l logger.StructuredLogger) AuditLogger {
+	return structuredAuditLogger{logger: l}
+}
+
+type structuredAuditLogger struct {
+	logger logger.StructuredLogger
+}
+
+func (l structuredAuditLogger) LogAuthDecision(_ context.Context, decision AuthDecision) {
+	l.logger.Infow("auth decision",
+		"decision_time", decision.Timestamp.UTC().Format(time.RFC3339Nano),
+		"decision", decision.Decision,
+		"reason", decision.Reason,
+		"email", decision.Email,
+		"source_ip", decision.SourceIP,
+		"groups", decision.Groups,
+		"provider", decision.Provider,
+	)
+}
+
+type sourceIPContextKey struct{}
+
+// ContextWithSourceIP stores the IP address of the client being served in
+// the context, so that it can be included in audited decisions
+func ContextWithSourceIP(ctx context.Context, sourceIP string) context.Context {
+	return context.WithValue(ctx, sourceIPContextKey{}, sourceIP)
+}
+
+// AuditDenied records that the session was denied for a reason decided
+// outside of the provider, eg. by the email validator. Sessions are only
+// allowed by Authorize, once everything else has accepted them.
+func (p *ProviderData) AuditDenied(ctx context.Context, s *sessions.SessionState, reason string) {
+	p.logAuthDecision(ctx, s, false, reason)
+}
+
+// logAuthDecision records the decision about the session with the
+// AuditLogger, if one is configured
+func (p *ProviderData) logAuthDecision(ctx context.Context, s *sessions.SessionState, allowed bool, reason string) {
+	if p.AuditLogger == nil {
+		return
+	}
+
+	decision := AuthDecision{
+		Timestamp: time.Now(),
+		Decision:  AuthDecisionDeny,
+		Reason:    reason,
+		Provider:  p.ProviderName,
+	}
+	if allowed {
+		decision.Decision = AuthDecisionAllow
+	}
+	if s != nil {
+		decision.Email = s.Email
+		decision.Groups = s.Groups
+	}
+	decision.SourceIP, _ = ctx.Value(sourceIPContextKey{}).(string)
+	p.AuditLogger.LogAuthDecision(ctx, decision)
+}
@@ -0,0 +1,143 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	. "github.com/onsi/gomega"
+)
+
+type recordingAuditLogger struct {
+	mu        sync.Mutex
+	decisions []AuthDecision
+}
+
+func (l *recordingAuditLogger) LogAuthDecision(_ context.Context, decision AuthDecision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decisions = append(l.decisions, decision)
+}
+
+func TestProviderData_AuthorizeAudit(t *testing.T) {
+	testCases := map[string]struct {
+		AllowedGroups    []string
+		Groups           []string
+		ExpectedDecision string
+		ExpectedReason   string
+	}{
+		"No Allowed Groups": {
+			Groups:           []string{"eng"},
+			ExpectedDecision: AuthDecisionAllow,
+			ExpectedReason:   "no allowed groups are configured",
+		},
+		"Allowed Group": {
+			AllowedGroups:    []string{"ops", "eng"},
+			Groups:           []string{"sales", "eng"},
+			ExpectedDecision: AuthDecisionAllow,
+			ExpectedReason:   `group "eng" is allowed`,
+		},
+		"No Allowed Group": {
+			AllowedGroups:    []string{"ops"},
+			Groups:           []string{"sales", "eng"},
+			ExpectedDecision: AuthDecisionDeny,
+			ExpectedReason:   "no group is allowed",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			audit := &recordingAuditLogger{}
+			p := &ProviderData{ProviderName: "OpenID Connect", AuditLogger: audit}
+			p.SetAllowedGroups(tc.AllowedGroups)
+
+			session := &sessions.SessionState{Email: "janed@me.com", Groups: tc.Groups}
+			ctx := ContextWithSourceIP(context.Background(), "10.0.0.1")
+			authorized, err := p.Authorize(ctx, session)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(authorized).To(Equal(tc.ExpectedDecision == AuthDecisionAllow))
+
+			g.Expect(audit.decisions).To(HaveLen(1))
+			decision := audit.decisions[0]
+			g.Expect(decision.Timestamp).To(BeTemporally("~", time.Now(), time.Second))
+			g.Expect(decision).To(Equal(AuthDecision{
+				Timestamp: decision.Timestamp,
+				Email:     "janed@me.com",
+				SourceIP:  "10.0.0.1",
+				Decision:  tc.ExpectedDecision,
+				Reason:    tc.ExpectedReason,
+				Groups:    tc.Groups,
+				Provider:  "OpenID Connect",
+			}))
+		})
+	}
+}
+
+func TestOIDCProvider_RedeemAudit(t *testing.T) {
+	g := NewWithT(t)
+
+	idToken, err := newSignedTestIDToken(defaultIDToken)
+	g.Expect(err).ToNot(HaveOccurred())
+	body, err := json.Marshal(redeemTokenResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   10,
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server, provider := newTestOIDCSetup(body)
+	defer server.Close()
+	audit := &recordingAuditLogger{}
+	provider.AuditLogger = audit
+
+	// Sessions are only allowed once they are authorized
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(audit.decisions).To(BeEmpty())
+	_, err = provider.Authorize(context.Background(), session)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(audit.decisions).To(HaveLen(1))
+	g.Expect(audit.decisions[0].Decision).To(Equal(AuthDecisionAllow))
+	g.Expect(audit.decisions[0].Email).To(Equal(defaultIDToken.Email))
+
+	provider.SetDeniedSubjects([]string{defaultIDToken.Subject})
+	_, err = provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(audit.decisions).To(HaveLen(2))
+	g.Expect(audit.decisions[1].Decision).To(Equal(AuthDecisionDeny))
+	g.Expect(audit.decisions[1].Reason).To(Equal(err.Error()))
+}
+
+func TestStructuredAuditLogger(t *testing.T) {
+	g := NewWithT(t)
+
+	out := new(bytes.Buffer)
+	audit := NewStructuredAuditLogger(logger.NewJSONLogger(out))
+	audit.LogAuthDecision(context.Background(), AuthDecision{
+		Timestamp: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Email:     "janed@me.com",
+		SourceIP:  "10.0.0.1",
+		Decision:  AuthDecisionDeny,
+		Reason:    "no group is allowed",
+		Groups:    []string{"sales"},
+		Provider:  "OpenID Connect",
+	})
+
+	var entry map[string]interface{}
+	g.Expect(json.Unmarshal(out.Bytes(), &entry)).To(Succeed())
+	g.Expect(entry).To(HaveKeyWithValue("msg", "auth decision"))
+	g.Expect(entry).To(HaveKeyWithValue("decision_time", "2021-03-04T05:06:07Z"))
+	g.Expect(entry).To(HaveKeyWithValue("decision", "deny"))
+	g.Expect(entry).To(HaveKeyWithValue("reason", "no group is allowed"))
+	g.Expect(entry).To(HaveKeyWithValue("email", "janed@me.com"))
+	g.Expect(entry).To(HaveKeyWithValue("source_ip", "10.0.0.1"))
+	g.Expect(entry).To(HaveKeyWithValue("groups", []interface{}{"sales"}))
+	g.Expect(entry).To(HaveKeyWithValue("provider", "OpenID Connect"))
+}
@@ -122,6 +122,7 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (*
 	if err != nil {
 		return nil, err
 	}
+	addCodeVerifier(ctx, params)
 
 	// blindly try json and x-www-form-urlencoded
 	var jsonResponse struct {
@@ -131,14 +132,17 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (*
 		IDToken      string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenHTTPClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&jsonResponse)
-	if err != nil {
+		Do()
+	if err := result.UnmarshalInto(&jsonResponse); err != nil {
+		return nil, err
+	}
+	if err := p.validateTokenResponseBody(result.Body()); err != nil {
 		return nil, err
 	}
 
@@ -171,6 +175,10 @@ func (p *AzureProvider) Redeem(ctx context.Context, redirectURL, code string) (*
 		}
 	}
 
+	if p.GroupsFromAccessToken {
+		session.Groups = p.mergeAccessTokenGroups(nil, session.AccessToken)
+	}
+
 	return session, nil
 }
 
@@ -218,8 +226,8 @@ func (p *AzureProvider) prepareRedeem(redirectURL, code string) (url.Values, err
 func (p *AzureProvider) verifyTokenAndExtractEmail(ctx context.Context, token string) (string, error) {
 	email := ""
 
-	if token != "" && p.Verifier != nil {
-		token, err := p.Verifier.Verify(ctx, token)
+	if token != "" {
+		token, err := p.verifyToken(ctx, token)
 		// due to issues mentioned above, id_token may not be signed by AAD
 		if err == nil {
 			claims, err := p.getClaims(token)
@@ -228,7 +236,7 @@ func (p *AzureProvider) verifyTokenAndExtractEmail(ctx context.Context, token st
 			} else {
 				logger.Printf("unable to get claims from token: %v", err)
 			}
-		} else {
+		} else if !errors.Is(err, ErrMissingOIDCVerifier) {
 			logger.Printf("unable to verify token: %v", err)
 		}
 	}
@@ -269,14 +277,17 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 		IDToken      string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenHTTPClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&jsonResponse)
-	if err != nil {
+		Do()
+	if err := result.UnmarshalInto(&jsonResponse); err != nil {
+		return err
+	}
+	if err := p.validateTokenResponseBody(result.Body()); err != nil {
 		return err
 	}
 
@@ -308,6 +319,10 @@ func (p *AzureProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sess
 		}
 	}
 
+	if p.GroupsFromAccessToken {
+		s.Groups = p.mergeAccessTokenGroups(nil, s.AccessToken)
+	}
+
 	return nil
 }
 
@@ -347,6 +362,7 @@ func (p *AzureProvider) getEmailFromProfileAPI(ctx context.Context, accessToken
 
 	json, err := requests.New(p.ProfileURL.String()).
 		WithContext(ctx).
+		WithClient(p.getHTTPClient()).
 		WithHeaders(makeAzureHeader(accessToken)).
 		Do().
 		UnmarshalJSON()
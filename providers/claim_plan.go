@@ -0,0 +1,270 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	// ClaimRuleTypeString extracts a claim as a single string
+	ClaimRuleTypeString = "string"
+	// ClaimRuleTypeList extracts a claim as a list of strings, a single
+	// value is treated as a list of one
+	ClaimRuleTypeList = "list"
+
+	// ClaimRuleTransformLowercase lowercases the extracted values
+	ClaimRuleTransformLowercase = "lowercase"
+	// ClaimRuleTransformUppercase uppercases the extracted values
+	ClaimRuleTransformUppercase = "uppercase"
+	// ClaimRuleTransformTrim trims surrounding whitespace from the extracted
+	// values
+	ClaimRuleTransformTrim = "trim"
+
+	// ClaimRuleSourceToken extracts a claim from the id_token only, even when
+	// the ClaimPrecedence is `userinfo_first`
+	ClaimRuleSourceToken = "token"
+	// ClaimRuleSourceProfile extracts a claim from the profile URL only, even
+	// when the ClaimPrecedence is `id_token_first`
+	ClaimRuleSourceProfile = "profile"
+)
+
+// ClaimRule declares how a single claim is extracted into the session
+type ClaimRule struct {
+	// Claim is the source claim, with the same syntax as the GroupsClaim
+	Claim string
+	// Target is the session field set from the claim: `user`, `email`,
+	// `preferred_username`, `groups` or `roles`. Any other name is stored in
+	// the session's Extra fields.
+	Target string
+	// Type is either `string` or `list`, it defaults to `list` for the
+	// groups and roles targets and `string` otherwise
+	Type string
+	// Required fails the session creation when the claim is missing or empty
+	Required bool
+	// Transform is applied to every extracted value, one of `lowercase`,
+	// `uppercase` or `trim`
+	Transform string
+	// Source overrides the ClaimPrecedence for the claim, either `token` or
+	// `profile`. By default the claim is extracted from the id_token, and
+	// from the profile URL too when the ClaimPrecedence is `userinfo_first`.
+	Source string
+}
+
+// ClaimPlan is a compiled list of ClaimRules. A nil ClaimPlan is valid and
+// does nothing.
+type ClaimPlan struct {
+	steps []claimPlanStep
+}
+
+type claimPlanStep struct {
+	claim     string
+	target    string
+	source    string
+	required  bool
+	list      bool
+	transform func(string) string
+	apply     func(ss *sessions.SessionState, values []string)
+}
+
+// claimRuleTransforms are the transforms a ClaimRule may use
+var claimRuleTransforms = map[string]func(string) string{
+	"":                          func(s string) string { return s },
+	ClaimRuleTransformLowercase: strings.ToLower,
+	ClaimRuleTransformUppercase: strings.ToUpper,
+	ClaimRuleTransformTrim:      strings.TrimSpace,
+}
+
+// CompileClaimPlan validates the rules and compiles them into a ClaimPlan.
+// It returns nil if there are no rules.
+func CompileClaimPlan(rules []ClaimRule) (*ClaimPlan, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	plan := &ClaimPlan{steps: make([]claimPlanStep, 0, len(rules))}
+	for i, rule := range rules {
+		step, err := compileClaimRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("claim rule %d: %v", i, err)
+		}
+		plan.steps = append(plan.steps, step)
+	}
+	return plan, nil
+}
+
+func compileClaimRule(rule ClaimRule) (claimPlanStep, error) {
+	step := claimPlanStep{
+		claim:    rule.Claim,
+		target:   rule.Target,
+		source:   rule.Source,
+		required: rule.Required,
+	}
+
+	if rule.Claim == "" {
+		return step, errors.New("missing claim")
+	}
+	if err := ValidateClaimExpression(rule.Claim); err != nil {
+		return step, fmt.Errorf("invalid claim %q: %v", rule.Claim, err)
+	}
+
+	transform, ok := claimRuleTransforms[rule.Transform]
+	if !ok {
+		return step, fmt.Errorf("unknown transform %q", rule.Transform)
+	}
+	step.transform = transform
+
+	switch rule.Source {
+	case "", ClaimRuleSourceToken:
+	case ClaimRuleSourceProfile:
+		// Profile URL failures don't fail logins, so required claims must
+		// come from the id_token
+		if rule.Required {
+			return step, errors.New("a required claim can't have the profile source")
+		}
+	default:
+		return step, fmt.Errorf("unknown source %q", rule.Source)
+	}
+
+	switch rule.Type {
+	case "":
+		step.list = rule.Target == "groups" || rule.Target == "roles"
+	case ClaimRuleTypeString:
+	case ClaimRuleTypeList:
+		step.list = true
+	default:
+		return step, fmt.Errorf("unknown type %q", rule.Type)
+	}
+
+	switch rule.Target {
+	case "":
+		return step, errors.New("missing target")
+	case "user":
+		step.apply = func(ss *sessions.SessionState, values []string) { ss.User = values[0] }
+	case "email":
+		step.apply = func(ss *sessions.SessionState, values []string) { ss.Email = values[0] }
+	case "preferred_username":
+		step.apply = func(ss *sessions.SessionState, values []string) { ss.PreferredUsername = values[0] }
+	case "groups":
+		step.apply = func(ss *sessions.SessionState, values []string) { ss.Groups = values }
+	case "roles":
+		step.apply = func(ss *sessions.SessionState, values []string) { ss.Roles = values }
+	default:
+		if _, ok := standardSessionClaims[rule.Target]; ok {
+			return step, fmt.Errorf("target %q is not a claim field of the session", rule.Target)
+		}
+		step.apply = applyExtraClaim(rule.Target, step.list)
+	}
+
+	if step.list && (rule.Target == "user" || rule.Target == "email" || rule.Target == "preferred_username") {
+		return step, fmt.Errorf("target %q can't hold a list", rule.Target)
+	}
+	return step, nil
+}
+
+// applyExtraClaim stores the value in the session's Extra fields, lists are
+// stored as JSON like non-string ClaimMappings
+func applyExtraClaim(key string, list bool) func(*sessions.SessionState, []string) {
+	return func(ss *sessions.SessionState, values []string) {
+		value := values[0]
+		if list {
+			encoded, _ := json.Marshal(values)
+			value = string(encoded)
+		}
+		if ss.Extra == nil {
+			ss.Extra = make(map[string]string)
+		}
+		ss.Extra[key] = value
+	}
+}
+
+// Apply extracts the id_token claims into the session in the order of the
+// rules, skipping the rules with the profile source.
+// It returns an error if a required claim is missing or empty.
+func (c *ClaimPlan) Apply(ss *sessions.SessionState, claims map[string]interface{}) error {
+	if c == nil {
+		return nil
+	}
+
+	for _, step := range c.steps {
+		if step.source == ClaimRuleSourceProfile {
+			continue
+		}
+		values := step.extract(claims)
+		if len(values) == 0 {
+			if step.required {
+				return fmt.Errorf("required claim %q is missing", step.claim)
+			}
+			continue
+		}
+		step.apply(ss, values)
+	}
+	return nil
+}
+
+// ApplyProfile extracts the profile URL claims into the session in the order
+// of the rules with the profile source, and of the rules without a source
+// when userinfoFirst is set. Claims that are missing leave the session as it
+// is.
+func (c *ClaimPlan) ApplyProfile(ss *sessions.SessionState, claims map[string]interface{}, userinfoFirst bool) {
+	if c == nil {
+		return
+	}
+
+	for _, step := range c.steps {
+		if step.source != ClaimRuleSourceProfile && (step.source != "" || !userinfoFirst) {
+			continue
+		}
+		if values := step.extract(claims); len(values) > 0 {
+			step.apply(ss, values)
+		}
+	}
+}
+
+// hasProfileSource is true when a rule has the profile source, so the
+// profile URL must be requested
+func (c *ClaimPlan) hasProfileSource() bool {
+	if c == nil {
+		return false
+	}
+
+	for _, step := range c.steps {
+		if step.source == ClaimRuleSourceProfile {
+			return true
+		}
+	}
+	return false
+}
+
+// extract returns the transformed values of the claim, empty values are
+// dropped
+func (s claimPlanStep) extract(claims map[string]interface{}) []string {
+	rawClaim, ok := getClaim(claims, s.claim)
+	if !ok || rawClaim == nil {
+		return nil
+	}
+
+	rawValues := []interface{}{rawClaim}
+	if list, ok := rawClaim.([]interface{}); ok && s.list {
+		rawValues = list
+	}
+
+	values := make([]string, 0, len(rawValues))
+	for _, rawValue := range rawValues {
+		value, err := formatGroup(rawValue)
+		if err != nil {
+			logger.Errorf("Warning: unable to format claim %q of type %s with error %s",
+				s.claim, reflect.TypeOf(rawValue), err)
+			continue
+		}
+		if value = s.transform(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,213 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/gomega"
+)
+
+func TestCompileClaimPlan(t *testing.T) {
+	testCases := map[string]struct {
+		Rules         []ClaimRule
+		ExpectedError string
+	}{
+		"No Rules": {
+			Rules: nil,
+		},
+		"Valid Rules": {
+			Rules: []ClaimRule{
+				{Claim: "sub", Target: "user", Required: true},
+				{Claim: "resource_access.my-client.roles", Target: "roles", Transform: ClaimRuleTransformTrim},
+				{Claim: "jmespath:addresses[0].country", Target: "country", Type: ClaimRuleTypeString},
+				{Claim: "entitlements", Target: "entitlements", Type: ClaimRuleTypeList},
+				{Claim: "groups", Target: "groups", Source: ClaimRuleSourceProfile},
+				{Claim: "roles", Target: "roles", Source: ClaimRuleSourceToken, Required: true},
+			},
+		},
+		"Missing Claim": {
+			Rules:         []ClaimRule{{Target: "user"}},
+			ExpectedError: "claim rule 0: missing claim",
+		},
+		"Invalid Claim Expression": {
+			Rules: []ClaimRule{
+				{Claim: "sub", Target: "user"},
+				{Claim: "jmespath:groups[", Target: "groups"},
+			},
+			ExpectedError: `claim rule 1: invalid claim "jmespath:groups["`,
+		},
+		"Missing Target": {
+			Rules:         []ClaimRule{{Claim: "sub"}},
+			ExpectedError: "claim rule 0: missing target",
+		},
+		"Unsupported Session Field": {
+			Rules:         []ClaimRule{{Claim: "sub", Target: "access_token"}},
+			ExpectedError: `claim rule 0: target "access_token" is not a claim field of the session`,
+		},
+		"Unknown Type": {
+			Rules:         []ClaimRule{{Claim: "sub", Target: "user", Type: "int"}},
+			ExpectedError: `claim rule 0: unknown type "int"`,
+		},
+		"List Into String Field": {
+			Rules:         []ClaimRule{{Claim: "emails", Target: "email", Type: ClaimRuleTypeList}},
+			ExpectedError: `claim rule 0: target "email" can't hold a list`,
+		},
+		"Unknown Transform": {
+			Rules:         []ClaimRule{{Claim: "sub", Target: "user", Transform: "reverse"}},
+			ExpectedError: `claim rule 0: unknown transform "reverse"`,
+		},
+		"Unknown Source": {
+			Rules:         []ClaimRule{{Claim: "sub", Target: "user", Source: "access_token"}},
+			ExpectedError: `claim rule 0: unknown source "access_token"`,
+		},
+		"Required Claim From Profile": {
+			Rules:         []ClaimRule{{Claim: "sub", Target: "user", Source: ClaimRuleSourceProfile, Required: true}},
+			ExpectedError: "claim rule 0: a required claim can't have the profile source",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			plan, err := CompileClaimPlan(tc.Rules)
+			if tc.ExpectedError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.ExpectedError)))
+				g.Expect(plan).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(plan == nil).To(Equal(len(tc.Rules) == 0))
+		})
+	}
+}
+
+func TestClaimPlan_Apply(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":                "123456789",
+		"email":              " JaneD@Me.com ",
+		"preferred_username": "Jane Dobbs",
+		"groups":             []interface{}{"Admins", "Users"},
+		"department":         "Engineering",
+		"entitlements":       []interface{}{"read", "write"},
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"offline_access"},
+		},
+	}
+
+	plan, err := CompileClaimPlan([]ClaimRule{
+		{Claim: "email", Target: "email", Required: true, Transform: ClaimRuleTransformTrim},
+		{Claim: "email", Target: "user", Transform: ClaimRuleTransformLowercase},
+		{Claim: "groups", Target: "groups", Transform: ClaimRuleTransformLowercase},
+		{Claim: "realm_access.roles", Target: "roles"},
+		{Claim: "department", Target: "department", Transform: ClaimRuleTransformUppercase},
+		{Claim: "entitlements", Target: "entitlements", Type: ClaimRuleTypeList},
+		{Claim: "cost_center", Target: "cost_center"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("applies every rule", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ss := &sessions.SessionState{User: "original", PreferredUsername: "Jane Dobbs"}
+		g.Expect(plan.Apply(ss, claims)).To(Succeed())
+		g.Expect(ss).To(Equal(&sessions.SessionState{
+			User:              " janed@me.com ",
+			Email:             "JaneD@Me.com",
+			Groups:            []string{"admins", "users"},
+			Roles:             []string{"offline_access"},
+			PreferredUsername: "Jane Dobbs",
+			Extra: map[string]string{
+				"department":   "ENGINEERING",
+				"entitlements": `["read","write"]`,
+			},
+		}))
+	})
+
+	t.Run("fails on a missing required claim", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ss := &sessions.SessionState{}
+		err := plan.Apply(ss, map[string]interface{}{"email": "   "})
+		g.Expect(err).To(MatchError(`required claim "email" is missing`))
+	})
+
+	t.Run("does nothing without a plan", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var nilPlan *ClaimPlan
+		ss := &sessions.SessionState{User: "original"}
+		g.Expect(nilPlan.Apply(ss, claims)).To(Succeed())
+		nilPlan.ApplyProfile(ss, claims, true)
+		g.Expect(ss.User).To(Equal("original"))
+		g.Expect(nilPlan.hasProfileSource()).To(BeFalse())
+	})
+}
+
+func TestClaimPlan_ApplyProfile(t *testing.T) {
+	tokenClaims := map[string]interface{}{
+		"groups":     []interface{}{"token:a"},
+		"roles":      []interface{}{"token:b"},
+		"department": "Engineering",
+	}
+	profileClaims := map[string]interface{}{
+		"groups":     []interface{}{"profile:a"},
+		"roles":      []interface{}{"profile:b"},
+		"department": "Sales",
+	}
+
+	plan, err := CompileClaimPlan([]ClaimRule{
+		{Claim: "groups", Target: "groups", Source: ClaimRuleSourceProfile},
+		{Claim: "roles", Target: "roles", Source: ClaimRuleSourceToken},
+		{Claim: "department", Target: "department"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		UserinfoFirst   bool
+		ProfileClaims   map[string]interface{}
+		ExpectedSession *sessions.SessionState
+	}{
+		"ID Token First": {
+			ProfileClaims: profileClaims,
+			ExpectedSession: &sessions.SessionState{
+				Groups: []string{"profile:a"},
+				Roles:  []string{"token:b"},
+				Extra:  map[string]string{"department": "Engineering"},
+			},
+		},
+		"Userinfo First": {
+			UserinfoFirst: true,
+			ProfileClaims: profileClaims,
+			ExpectedSession: &sessions.SessionState{
+				Groups: []string{"profile:a"},
+				Roles:  []string{"token:b"},
+				Extra:  map[string]string{"department": "Sales"},
+			},
+		},
+		"Missing Profile Claims": {
+			UserinfoFirst: true,
+			ProfileClaims: map[string]interface{}{},
+			ExpectedSession: &sessions.SessionState{
+				Roles: []string{"token:b"},
+				Extra: map[string]string{"department": "Engineering"},
+			},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ss := &sessions.SessionState{}
+			g.Expect(plan.Apply(ss, tokenClaims)).To(Succeed())
+			plan.ApplyProfile(ss, tc.ProfileClaims, tc.UserinfoFirst)
+			g.Expect(ss).To(Equal(tc.ExpectedSession))
+		})
+	}
+
+	g := NewWithT(t)
+	g.Expect(plan.hasProfileSource()).To(BeTrue())
+}
@@ -0,0 +1,62 @@
+package providers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// clientCertificate loads a TLS client certificate and key from files,
+// reloading them when either file changes. Connections that are already
+// established keep the certificate they were made with.
+type clientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newClientCertificate(certFile, keyFile string) *clientCertificate {
+	return &clientCertificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+}
+
+// GetClientCertificate returns the current certificate, for use as the
+// tls.Config GetClientCertificate callback. When a changed certificate
+// can't be loaded, eg. while only one of the files has been replaced, the
+// last good certificate keeps being used.
+func (c *clientCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certInfo, certErr := os.Stat(c.certFile)
+	keyInfo, keyErr := os.Stat(c.keyFile)
+	if certErr == nil && keyErr == nil &&
+		c.cert != nil && certInfo.ModTime().Equal(c.certModTime) && keyInfo.ModTime().Equal(c.keyModTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			logger.Errorf("Warning: unable to reload the provider TLS client certificate, using the previous one: %v", err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("unable to load the provider TLS client certificate: %v", err)
+	}
+	c.cert = &cert
+	if certErr == nil && keyErr == nil {
+		c.certModTime = certInfo.ModTime()
+		c.keyModTime = keyInfo.ModTime()
+	}
+	return c.cert, nil
+}
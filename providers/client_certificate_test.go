@@ -0,0 +1,159 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+type testClientCertificate struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	Cert    *x509.Certificate
+}
+
+// newTestClientCertificate creates a self-signed client certificate
+func newTestClientCertificate(g *WithT, commonName string) testClientCertificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return testClientCertificate{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		Cert:    cert,
+	}
+}
+
+// writeTestFile writes the file with a modification time later than any
+// previous write, so that changes are detected on filesystems with a coarse
+// modification time
+func writeTestFile(g *WithT, path string, content []byte, modTime time.Time) {
+	g.Expect(ioutil.WriteFile(path, content, 0600)).To(Succeed())
+	g.Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+}
+
+func TestProviderData_TLSClientCertificate(t *testing.T) {
+	g := NewWithT(t)
+
+	certA := newTestClientCertificate(g, "client-a")
+	certB := newTestClientCertificate(g, "client-b")
+	untrusted := newTestClientCertificate(g, "untrusted")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certA.Cert)
+	clientCAs.AddCert(certB.Cert)
+
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		presented = req.TLS.PeerCertificates[0].Subject.CommonName
+		// Each request makes a new connection, presenting the current
+		// client certificate
+		rw.Header().Set("Connection", "close")
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token": "a1234", "token_type": "Bearer"}`))
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAPool := x509.NewCertPool()
+	serverCAPool.AddCert(server.Certificate())
+	redeemURL, err := url.Parse(server.URL + "/token")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	redeem := func(p *ProviderData) error {
+		_, err := p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+		return err
+	}
+
+	t.Run("Without A Client Certificate", func(t *testing.T) {
+		g := NewWithT(t)
+
+		p := &ProviderData{RedeemURL: redeemURL, CAPool: serverCAPool}
+		g.Expect(redeem(p)).ToNot(Succeed())
+	})
+
+	t.Run("With A Preloaded Client Certificate", func(t *testing.T) {
+		g := NewWithT(t)
+
+		cert, err := tls.X509KeyPair(certA.CertPEM, certA.KeyPEM)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		p := &ProviderData{RedeemURL: redeemURL, CAPool: serverCAPool, TLSClientCertificate: &cert}
+		g.Expect(redeem(p)).To(Succeed())
+		g.Expect(presented).To(Equal("client-a"))
+	})
+
+	t.Run("With Client Certificate Files", func(t *testing.T) {
+		g := NewWithT(t)
+
+		dir, err := ioutil.TempDir("", "client-certificate")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		certFile := filepath.Join(dir, "tls.crt")
+		keyFile := filepath.Join(dir, "tls.key")
+		modTime := time.Now()
+		writeTestFile(g, certFile, certA.CertPEM, modTime)
+		writeTestFile(g, keyFile, certA.KeyPEM, modTime)
+
+		p := &ProviderData{
+			RedeemURL:         redeemURL,
+			CAPool:            serverCAPool,
+			TLSClientCertFile: certFile,
+			TLSClientKeyFile:  keyFile,
+		}
+		g.Expect(redeem(p)).To(Succeed())
+		g.Expect(presented).To(Equal("client-a"))
+
+		// The rotated certificate is used once both files are replaced
+		modTime = modTime.Add(time.Minute)
+		writeTestFile(g, certFile, certB.CertPEM, modTime)
+		writeTestFile(g, keyFile, certB.KeyPEM, modTime)
+		g.Expect(redeem(p)).To(Succeed())
+		g.Expect(presented).To(Equal("client-b"))
+
+		// A certificate that doesn't match the key keeps the previous one
+		modTime = modTime.Add(time.Minute)
+		writeTestFile(g, certFile, untrusted.CertPEM, modTime)
+		g.Expect(redeem(p)).To(Succeed())
+		g.Expect(presented).To(Equal("client-b"))
+
+		// A certificate the server doesn't trust is presented once loaded
+		writeTestFile(g, keyFile, untrusted.KeyPEM, modTime)
+		g.Expect(redeem(p)).ToNot(Succeed())
+	})
+}
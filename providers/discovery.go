@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// DefaultDiscoveryRetryInterval is the wait before the first OIDC discovery
+// retry
+const DefaultDiscoveryRetryInterval = time.Second
+
+const (
+	// verifierDiscoveryTimeout bounds the lazy discovery of a Verifier,
+	// retries included, see getVerifier
+	verifierDiscoveryTimeout = 30 * time.Second
+	// verifierDiscoveryFailureTTL is how long a failed lazy discovery is
+	// returned to callers before it is attempted again
+	verifierDiscoveryFailureTTL = 30 * time.Second
+)
+
+// DiscoverOIDCProvider performs OIDC discovery of the issuer, retrying
+// failures up to maxRetries times so that a network blip at startup doesn't
+// stop the proxy. The wait before each retry starts at the interval (0 uses
+// the DefaultDiscoveryRetryInterval) and doubles for each later retry.
+//
+// The returned provider's remote key set keeps using the context to refresh
+// keys, so it must outlive any single request.
+func DiscoverOIDCProvider(ctx context.Context, issuerURL string, maxRetries int, interval time.Duration) (*oidc.Provider, error) {
+	if interval <= 0 {
+		interval = DefaultDiscoveryRetryInterval
+	}
+
+	for retry := 0; ; retry++ {
+		provider, err := oidc.NewProvider(ctx, issuerURL)
+		if err == nil || retry >= maxRetries {
+			return provider, err
+		}
+
+		logger.Errorf("OIDC discovery of %s failed, retrying in %s: %v", issuerURL, interval, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestDiscoverOIDCProvider(t *testing.T) {
+	testCases := map[string]struct {
+		Failures         int32
+		MaxRetries       int
+		ExpectedError    bool
+		ExpectedRequests int32
+	}{
+		"Succeeds Without Retries": {
+			Failures:         0,
+			MaxRetries:       0,
+			ExpectedRequests: 1,
+		},
+		"Fails Without Retries": {
+			Failures:         1,
+			MaxRetries:       0,
+			ExpectedError:    true,
+			ExpectedRequests: 1,
+		},
+		"Succeeds After Retries": {
+			Failures:         2,
+			MaxRetries:       3,
+			ExpectedRequests: 3,
+		},
+		"Fails Once The Retries Run Out": {
+			Failures:         5,
+			MaxRetries:       2,
+			ExpectedError:    true,
+			ExpectedRequests: 3,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var requests int32
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tc.Failures {
+					rw.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				rw.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+					"issuer":                 server.URL,
+					"authorization_endpoint": server.URL + "/authorize",
+					"token_endpoint":         server.URL + "/token",
+					"jwks_uri":               server.URL + "/jwks",
+				})
+			}))
+			defer server.Close()
+
+			provider, err := DiscoverOIDCProvider(context.Background(), server.URL, tc.MaxRetries, time.Millisecond)
+			if tc.ExpectedError {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(provider.Endpoint().TokenURL).To(Equal(server.URL + "/token"))
+			}
+			g.Expect(atomic.LoadInt32(&requests)).To(Equal(tc.ExpectedRequests))
+		})
+	}
+
+	t.Run("Stops Retrying When The Context Is Done", func(t *testing.T) {
+		g := NewWithT(t)
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := DiscoverOIDCProvider(ctx, server.URL, 10, time.Second)
+		g.Expect(err).To(MatchError(context.DeadlineExceeded))
+		g.Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+}
@@ -381,11 +381,7 @@ func (p *GitHubProvider) isCollaborator(ctx context.Context, username, accessTok
 // getEmail updates the SessionState Email
 func (p *GitHubProvider) getEmail(ctx context.Context, s *sessions.SessionState) error {
 
-	var emails []struct {
-		Email    string `json:"email"`
-		Primary  bool   `json:"primary"`
-		Verified bool   `json:"verified"`
-	}
+	var emails []gitHubEmail
 
 	// If usernames are set, check that first
 	verifiedUser := false
@@ -433,16 +429,49 @@ func (p *GitHubProvider) getEmail(ctx context.Context, s *sessions.SessionState)
 		return err
 	}
 
+	if email := p.selectEmail(emails); email != "" {
+		s.Email = email
+	}
+
+	return nil
+}
+
+// gitHubEmail is a single entry from the GitHub `/user/emails` endpoint
+type gitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// selectEmail picks the most suitable email from a user's emails.
+// Verified emails are always preferred, with the primary email taking
+// precedence. Unverified emails are only considered when
+// `AllowUnverifiedEmail` is set.
+func (p *GitHubProvider) selectEmail(emails []gitHubEmail) string {
+	var verified, unverifiedPrimary, unverified string
 	for _, email := range emails {
-		if email.Verified {
-			if email.Primary {
-				s.Email = email.Email
-				return nil
-			}
+		switch {
+		case email.Verified && email.Primary:
+			return email.Email
+		case email.Verified && verified == "":
+			verified = email.Email
+		case !email.Verified && email.Primary:
+			unverifiedPrimary = email.Email
+		case !email.Verified && unverified == "":
+			unverified = email.Email
 		}
 	}
 
-	return nil
+	if verified != "" {
+		return verified
+	}
+	if !p.AllowUnverifiedEmail {
+		return ""
+	}
+	if unverifiedPrimary != "" {
+		return unverifiedPrimary
+	}
+	return unverified
 }
 
 // getUser updates the SessionState User
@@ -137,6 +137,43 @@ func TestGitHubProvider_getEmailNotVerified(t *testing.T) {
 	assert.Empty(t, session.Email)
 }
 
+func TestGitHubProvider_getEmailPrefersVerified(t *testing.T) {
+	b := testGitHubBackend(map[string][]string{
+		"/user/emails": {`[ {"email": "unverified@gsa.gov", "verified": false, "primary": true}, {"email": "michael.bland@gsa.gov", "verified": true, "primary": false} ]`},
+	})
+	defer b.Close()
+
+	bURL, _ := url.Parse(b.URL)
+	p := testGitHubProvider(bURL.Host)
+
+	session := CreateAuthorizedSession()
+	err := p.getEmail(context.Background(), session)
+	assert.NoError(t, err)
+	assert.Equal(t, "michael.bland@gsa.gov", session.Email)
+
+	p.AllowUnverifiedEmail = true
+	session = CreateAuthorizedSession()
+	err = p.getEmail(context.Background(), session)
+	assert.NoError(t, err)
+	assert.Equal(t, "michael.bland@gsa.gov", session.Email)
+}
+
+func TestGitHubProvider_getEmailNotVerifiedAllowed(t *testing.T) {
+	b := testGitHubBackend(map[string][]string{
+		"/user/emails": {`[ {"email": "secondary@gsa.gov", "verified": false, "primary": false}, {"email": "michael.bland@gsa.gov", "verified": false, "primary": true} ]`},
+	})
+	defer b.Close()
+
+	bURL, _ := url.Parse(b.URL)
+	p := testGitHubProvider(bURL.Host)
+	p.AllowUnverifiedEmail = true
+
+	session := CreateAuthorizedSession()
+	err := p.getEmail(context.Background(), session)
+	assert.NoError(t, err)
+	assert.Equal(t, "michael.bland@gsa.gov", session.Email)
+}
+
 func TestGitHubProvider_getEmailWithOrg(t *testing.T) {
 	b := testGitHubBackend(map[string][]string{
 		"/user/emails": {`[ {"email": "michael.bland@gsa.gov", "verified": true, "primary": true} ]`},
@@ -97,10 +97,16 @@ func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code string) (
 		},
 		RedirectURL: redirectURL,
 	}
-	token, err := c.Exchange(ctx, code)
+	token, err := c.Exchange(p.contextWithHTTPClient(ctx), code, codeVerifierOptions(ctx)...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange: %v", err)
 	}
+	if err := p.validateOAuth2Token(token); err != nil {
+		return nil, fmt.Errorf("token exchange: %v", err)
+	}
+	if err := verifyIDTokenHash(getIDToken(token), "c_hash", code); err != nil {
+		return nil, fmt.Errorf("could not verify id_token: %v", err)
+	}
 	s, err = p.createSession(ctx, token)
 	if err != nil {
 		return nil, fmt.Errorf("unable to update session: %v", err)
@@ -155,10 +161,13 @@ func (p *GitLabProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 		RefreshToken: s.RefreshToken,
 		Expiry:       time.Now().Add(-time.Hour),
 	}
-	token, err := c.TokenSource(ctx, t).Token()
+	token, err := c.TokenSource(p.contextWithHTTPClient(ctx), t).Token()
 	if err != nil {
 		return fmt.Errorf("failed to get token: %v", err)
 	}
+	if err := p.validateOAuth2Token(token); err != nil {
+		return fmt.Errorf("failed to get token: %v", err)
+	}
 	newSession, err := p.createSession(ctx, token)
 	if err != nil {
 		return fmt.Errorf("unable to update session: %v", err)
@@ -273,7 +282,10 @@ func (p *GitLabProvider) createSession(ctx context.Context, token *oauth2.Token)
 
 // ValidateSession checks that the session's IDToken is still valid
 func (p *GitLabProvider) ValidateSession(ctx context.Context, s *sessions.SessionState) bool {
-	_, err := p.Verifier.Verify(ctx, s.IDToken)
+	_, err := p.verifyToken(ctx, s.IDToken)
+	if err == ErrMissingOIDCVerifier {
+		logger.Errorf("id_token verification failed: %v", err)
+	}
 	return err == nil
 }
 
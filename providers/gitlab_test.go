@@ -173,6 +173,15 @@ var _ = Describe("Gitlab Provider Tests", func() {
 		})
 	})
 
+	Context("without a verifier", func() {
+		It("should not validate the session", func() {
+			p.Verifier = nil
+			rawIDToken, _ := newSignedTestIDToken(defaultIDToken)
+			session := &sessions.SessionState{IDToken: rawIDToken}
+			Expect(p.ValidateSession(context.Background(), session)).To(BeFalse())
+		})
+	})
+
 	Context("when filtering on email", func() {
 		type emailsTableInput struct {
 			expectedError        error
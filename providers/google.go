@@ -139,6 +139,7 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 	params.Add("client_secret", clientSecret)
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	addCodeVerifier(ctx, params)
 
 	var jsonResponse struct {
 		AccessToken  string `json:"access_token"`
@@ -147,14 +148,17 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 		IDToken      string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenHTTPClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&jsonResponse)
-	if err != nil {
+		Do()
+	if err := result.UnmarshalInto(&jsonResponse); err != nil {
+		return nil, err
+	}
+	if err := p.validateTokenResponseBody(result.Body()); err != nil {
 		return nil, err
 	}
 
@@ -265,6 +269,16 @@ func userInGroup(service *admin.Service, group string, email string) bool {
 	return false
 }
 
+// ValidateSession validates the AccessToken. Token introspection is done
+// here rather than by the ProviderData, so that a challenged token is
+// refreshed with the Google RefreshSession.
+func (p *GoogleProvider) ValidateSession(ctx context.Context, s *sessions.SessionState) bool {
+	if p.TokenIntrospectionEnabled {
+		return introspectSession(ctx, p, s)
+	}
+	return p.ProviderData.ValidateSession(ctx, s)
+}
+
 // RefreshSession uses the RefreshToken to fetch new Access and ID Tokens
 func (p *GoogleProvider) RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error) {
 	if s == nil || s.RefreshToken == "" {
@@ -306,14 +320,17 @@ func (p *GoogleProvider) redeemRefreshToken(ctx context.Context, s *sessions.Ses
 		IDToken     string `json:"id_token"`
 	}
 
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenHTTPClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&data)
-	if err != nil {
+		Do()
+	if err := result.UnmarshalInto(&data); err != nil {
+		return err
+	}
+	if err := p.validateTokenResponseBody(result.Body()); err != nil {
 		return err
 	}
 
@@ -0,0 +1,211 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+const (
+	// DefaultGroupResolverConcurrency is the number of group IDs a
+	// GroupResolver looks up at once
+	DefaultGroupResolverConcurrency = 4
+	// DefaultGroupNameCacheTTL is how long resolved group names are cached for
+	DefaultGroupNameCacheTTL = 10 * time.Minute
+
+	// GroupResolverURLPlaceholder is replaced by the group ID in the URL of
+	// a URLGroupResolverFunc
+	GroupResolverURLPlaceholder = "{id}"
+	// DefaultGroupResolverNameField is the JSON field holding the group name
+	// in the responses of a URLGroupResolverFunc
+	DefaultGroupResolverNameField = "name"
+)
+
+// GroupResolverFunc translates a group ID into the group name, eg. with an
+// API call to the identity provider
+type GroupResolverFunc func(ctx context.Context, groupID string) (string, error)
+
+// GroupResolver translates the group IDs listed in tokens into group names,
+// for providers that only list group IDs in the groups claim. The names are
+// cached, so each group ID is only looked up once per TTL.
+// A nil GroupResolver leaves the groups unchanged. It is safe for concurrent
+// use.
+type GroupResolver struct {
+	resolve     GroupResolverFunc
+	concurrency int
+	ttl         time.Duration
+
+	mu    sync.Mutex
+	names map[string]groupNameCacheEntry
+
+	clock clock.Clock
+}
+
+type groupNameCacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// NewGroupResolver creates a GroupResolver that looks up at most concurrency
+// group IDs at once with the resolve function and caches the names for the
+// ttl. A nil resolve function returns nil, which passes groups through.
+// A concurrency or ttl of zero or less uses the defaults.
+func NewGroupResolver(resolve GroupResolverFunc, concurrency int, ttl time.Duration) *GroupResolver {
+	if resolve == nil {
+		return nil
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultGroupResolverConcurrency
+	}
+	if ttl <= 0 {
+		ttl = DefaultGroupNameCacheTTL
+	}
+	return &GroupResolver{
+		resolve:     resolve,
+		concurrency: concurrency,
+		ttl:         ttl,
+		names:       make(map[string]groupNameCacheEntry),
+	}
+}
+
+// Resolve returns the names of the group IDs in the same order. Group IDs
+// that aren't cached are looked up concurrently, and an error is returned if
+// any of them can't be resolved.
+func (r *GroupResolver) Resolve(ctx context.Context, groupIDs []string) ([]string, error) {
+	if r == nil || len(groupIDs) == 0 {
+		return groupIDs, nil
+	}
+
+	names := make(map[string]string, len(groupIDs))
+	var missing []string
+	for _, groupID := range groupIDs {
+		if _, seen := names[groupID]; seen {
+			continue
+		}
+		name, ok := r.get(groupID)
+		if !ok {
+			missing = append(missing, groupID)
+		}
+		names[groupID] = name
+	}
+
+	if len(missing) > 0 {
+		resolved, err := r.lookup(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for groupID, name := range resolved {
+			names[groupID] = name
+		}
+	}
+
+	groups := make([]string, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		groups = append(groups, names[groupID])
+	}
+	return groups, nil
+}
+
+// lookup resolves the group IDs with at most r.concurrency calls in flight,
+// caching each name as it is resolved. The remaining lookups are cancelled
+// after the first error.
+func (r *GroupResolver) lookup(ctx context.Context, groupIDs []string) (map[string]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		resolved = make(map[string]string, len(groupIDs))
+		firstErr error
+	)
+	sem := make(chan struct{}, r.concurrency)
+	for _, groupID := range groupIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(groupID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			name, err := r.resolve(ctx, groupID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("unable to resolve group %q: %v", groupID, err)
+					cancel()
+				}
+				return
+			}
+			resolved[groupID] = name
+			r.set(groupID, name)
+		}(groupID)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return resolved, nil
+}
+
+// URLGroupResolverFunc creates a GroupResolverFunc that requests the URL
+// with the GroupResolverURLPlaceholder replaced by the escaped group ID, with
+// the provider HTTP client, and reads the group name from the nameField of the
+// JSON response.
+func (p *ProviderData) URLGroupResolverFunc(urlTemplate, nameField string) GroupResolverFunc {
+	if nameField == "" {
+		nameField = DefaultGroupResolverNameField
+	}
+	return func(ctx context.Context, groupID string) (string, error) {
+		groupURL := strings.ReplaceAll(urlTemplate, GroupResolverURLPlaceholder, url.PathEscape(groupID))
+		json, err := requests.New(groupURL).
+			WithContext(ctx).
+			WithClient(p.getHTTPClient()).
+			Do().
+			UnmarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		name, err := json.Get(nameField).String()
+		if err != nil || name == "" {
+			return "", fmt.Errorf("response has no %s", nameField)
+		}
+		return name, nil
+	}
+}
+
+// get returns the cached name of the group ID if it hasn't expired
+func (r *GroupResolver) get(groupID string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.names[groupID]
+	if !ok {
+		return "", false
+	}
+	if r.clock.Now().After(entry.expires) {
+		delete(r.names, groupID)
+		return "", false
+	}
+	return entry.name, true
+}
+
+// set caches the name of the group ID for the TTL
+func (r *GroupResolver) set(groupID, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.names[groupID] = groupNameCacheEntry{
+		name:    name,
+		expires: r.clock.Now().Add(r.ttl),
+	}
+}
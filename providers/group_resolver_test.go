@@ -0,0 +1,139 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestGroupResolver(t *testing.T) {
+	t.Run("passes groups through when nil", func(t *testing.T) {
+		g := NewWithT(t)
+		resolver := NewGroupResolver(nil, 0, 0)
+		g.Expect(resolver).To(BeNil())
+
+		groups, err := resolver.Resolve(context.Background(), []string{"a1", "b2"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(groups).To(Equal([]string{"a1", "b2"}))
+	})
+
+	t.Run("resolves group IDs concurrently in order", func(t *testing.T) {
+		g := NewWithT(t)
+		const concurrency = 3
+
+		var calls, inFlight, maxInFlight int32
+		// Hold the first lookups until the concurrency limit is reached, so
+		// that they must run at the same time
+		var started sync.WaitGroup
+		started.Add(concurrency)
+		resolver := NewGroupResolver(func(ctx context.Context, groupID string) (string, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			if atomic.AddInt32(&calls, 1) <= concurrency {
+				started.Done()
+				started.Wait()
+			}
+			return "name-" + groupID, nil
+		}, concurrency, time.Minute)
+
+		groupIDs := make([]string, 0, 10)
+		expected := make([]string, 0, 10)
+		for i := 0; i < 10; i++ {
+			groupIDs = append(groupIDs, fmt.Sprintf("id%d", i))
+			expected = append(expected, fmt.Sprintf("name-id%d", i))
+		}
+
+		groups, err := resolver.Resolve(context.Background(), groupIDs)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(groups).To(Equal(expected))
+		g.Expect(atomic.LoadInt32(&maxInFlight)).To(BeEquivalentTo(concurrency))
+	})
+
+	t.Run("caches names until the TTL expires", func(t *testing.T) {
+		g := NewWithT(t)
+		var calls int32
+		resolver := NewGroupResolver(func(ctx context.Context, groupID string) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			return "name-" + groupID, nil
+		}, 2, time.Minute)
+		resolver.clock.Set(time.Now())
+
+		groups, err := resolver.Resolve(context.Background(), []string{"a1", "b2", "a1"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(groups).To(Equal([]string{"name-a1", "name-b2", "name-a1"}))
+		g.Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(2))
+
+		groups, err = resolver.Resolve(context.Background(), []string{"b2", "c3"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(groups).To(Equal([]string{"name-b2", "name-c3"}))
+		g.Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(3))
+
+		g.Expect(resolver.clock.Add(2 * time.Minute)).To(Succeed())
+		_, err = resolver.Resolve(context.Background(), []string{"a1"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(atomic.LoadInt32(&calls)).To(BeEquivalentTo(4))
+	})
+
+	t.Run("errors when a group can't be resolved", func(t *testing.T) {
+		g := NewWithT(t)
+		resolver := NewGroupResolver(func(ctx context.Context, groupID string) (string, error) {
+			if groupID == "b2" {
+				return "", errors.New("not found")
+			}
+			return "name-" + groupID, nil
+		}, 2, time.Minute)
+
+		_, err := resolver.Resolve(context.Background(), []string{"a1", "b2"})
+		g.Expect(err).To(MatchError(`unable to resolve group "b2": not found`))
+
+		// Groups resolved before the error are still cached
+		name, ok := resolver.get("a1")
+		g.Expect(ok).To(BeTrue())
+		g.Expect(name).To(Equal("name-a1"))
+	})
+}
+
+func TestProviderData_URLGroupResolverFunc(t *testing.T) {
+	g := NewWithT(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.EscapedPath() {
+		case "/groups/a1%2Fb2":
+			_, _ = rw.Write([]byte(`{"displayName": "admins"}`))
+		case "/groups/c3":
+			_, _ = rw.Write([]byte(`{"id": "c3"}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := &ProviderData{}
+	resolve := p.URLGroupResolverFunc(server.URL+"/groups/"+GroupResolverURLPlaceholder, "displayName")
+
+	// Group IDs are escaped into the URL
+	name, err := resolve(context.Background(), "a1/b2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(name).To(Equal("admins"))
+
+	_, err = resolve(context.Background(), "c3")
+	g.Expect(err).To(MatchError("response has no displayName"))
+
+	_, err = resolve(context.Background(), "d4")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,96 @@
+package providers
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"golang.org/x/oauth2"
+)
+
+// getHTTPClient returns the client for requests to the provider's redeem,
+// profile, validation and introspection endpoints. When none of a CAPool,
+// InsecureSkipTLSVerify or a TLS client certificate is set, the
+// http.DefaultClient is used as before.
+func (p *ProviderData) getHTTPClient() *http.Client {
+	hasClientCertificate := (p.TLSClientCertFile != "" && p.TLSClientKeyFile != "") || p.TLSClientCertificate != nil
+	if p.CAPool == nil && !p.InsecureSkipTLSVerify && !hasClientCertificate {
+		return http.DefaultClient
+	}
+
+	p.httpClientMutex.Lock()
+	defer p.httpClientMutex.Unlock()
+
+	if p.httpClient == nil {
+		if p.InsecureSkipTLSVerify {
+			logger.Errorf("Warning: TLS certificates of the %s provider are not verified, this must only be used for testing", p.ProviderName)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// InsecureSkipVerify is a configurable option we allow for testing
+		/* #nosec G402 */
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:         p.GetTLSMinVersion(),
+			RootCAs:            p.CAPool,
+			InsecureSkipVerify: p.InsecureSkipTLSVerify,
+		}
+		switch {
+		case p.TLSClientCertFile != "" && p.TLSClientKeyFile != "":
+			transport.TLSClientConfig.GetClientCertificate = newClientCertificate(p.TLSClientCertFile, p.TLSClientKeyFile).GetClientCertificate
+		case p.TLSClientCertificate != nil:
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*p.TLSClientCertificate}
+		}
+		p.httpClient = &http.Client{Transport: transport}
+	}
+	return p.httpClient
+}
+
+// getTokenHTTPClient returns the client for token endpoint requests, which
+// sends the TokenEndpointHeaders with each request
+func (p *ProviderData) getTokenHTTPClient() *http.Client {
+	client := p.getHTTPClient()
+	if len(p.TokenEndpointHeaders) == 0 {
+		return client
+	}
+
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport: &headerTransport{headers: p.TokenEndpointHeaders, base: transport},
+		Timeout:   client.Timeout,
+	}
+}
+
+// contextWithHTTPClient makes the oauth2 library use the provider's HTTP
+// client for token requests made with the context
+func (p *ProviderData) contextWithHTTPClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, p.getTokenHTTPClient())
+}
+
+// headerTransport sets the headers on each request before it is sent by the
+// base transport. Headers the request already has, such as the Authorization
+// header of the client authentication, take precedence so that a single
+// value is sent.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	for key, value := range t.headers {
+		if existing := req.Header.Get(key); existing != "" {
+			if existing != value {
+				logger.Errorf("Warning: not overriding the %s header of the request to %s with the configured value", key, req.URL.Host)
+			}
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(req)
+}
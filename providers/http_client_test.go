@@ -0,0 +1,293 @@
+package providers
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderData_HTTPClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/token":
+			_, _ = rw.Write([]byte(`{"access_token": "a1234", "token_type": "Bearer"}`))
+		case "/userinfo":
+			_, _ = rw.Write([]byte(`{"email": "janed@me.com"}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// The server's self-signed certificate is its own CA
+	serverCAPool := x509.NewCertPool()
+	serverCAPool.AddCert(server.Certificate())
+
+	testCases := map[string]struct {
+		CAPool                *x509.CertPool
+		InsecureSkipTLSVerify bool
+		ExpectedError         bool
+	}{
+		"System Pool": {
+			ExpectedError: true,
+		},
+		"CA Pool": {
+			CAPool: serverCAPool,
+		},
+		"Other CA Pool": {
+			CAPool:        x509.NewCertPool(),
+			ExpectedError: true,
+		},
+		"Insecure Skip TLS Verify": {
+			InsecureSkipTLSVerify: true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			redeemURL, err := url.Parse(server.URL + "/token")
+			g.Expect(err).ToNot(HaveOccurred())
+			profileURL, err := url.Parse(server.URL + "/userinfo")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			p := NewOIDCProvider(&ProviderData{
+				ClientID:              "client-id",
+				ClientSecret:          "client-secret",
+				RedeemURL:             redeemURL,
+				ProfileURL:            profileURL,
+				ProfileURLMaxRetries:  -1,
+				CAPool:                tc.CAPool,
+				InsecureSkipTLSVerify: tc.InsecureSkipTLSVerify,
+			})
+
+			session, err := p.ProviderData.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+			if tc.ExpectedError {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(session.AccessToken).To(Equal("a1234"))
+			}
+
+			profile, err := p.getProfile(context.Background(), "a1234")
+			if tc.ExpectedError {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(profile).To(HaveKeyWithValue("email", "janed@me.com"))
+			}
+
+			_, err = p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+			if tc.ExpectedError {
+				g.Expect(err).To(MatchError(ContainSubstring("certificate")))
+			} else {
+				// The redeemed token is valid, but has no id_token
+				g.Expect(err).To(MatchError(ContainSubstring("id_token")))
+			}
+		})
+	}
+}
+
+func TestProviderData_getHTTPClientDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	p := &ProviderData{}
+	g.Expect(p.getHTTPClient()).To(BeIdenticalTo(http.DefaultClient))
+
+	p = &ProviderData{CAPool: x509.NewCertPool()}
+	client := p.getHTTPClient()
+	g.Expect(client).ToNot(BeIdenticalTo(http.DefaultClient))
+	g.Expect(p.getHTTPClient()).To(BeIdenticalTo(client))
+}
+
+func TestProviderData_TokenEndpointHeaders(t *testing.T) {
+	g := NewWithT(t)
+
+	requestHeaders := map[string]http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requestHeaders[req.URL.Path] = req.Header
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/token":
+			_, _ = rw.Write([]byte(`{"access_token": "a1234", "token_type": "Bearer"}`))
+		case "/userinfo":
+			_, _ = rw.Write([]byte(`{"email": "janed@me.com"}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	redeemURL, err := url.Parse(server.URL + "/token")
+	g.Expect(err).ToNot(HaveOccurred())
+	profileURL, err := url.Parse(server.URL + "/userinfo")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	p := NewOIDCProvider(&ProviderData{
+		ClientID:             "client-id",
+		ClientSecret:         "client-secret",
+		RedeemURL:            redeemURL,
+		ProfileURL:           profileURL,
+		ProfileURLMaxRetries: -1,
+		TokenEndpointHeaders: map[string]string{
+			"X-Tenant-ID":      "tenant1",
+			"X-Client-Version": "7",
+		},
+	})
+
+	_, err = p.ProviderData.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requestHeaders["/token"].Get("X-Tenant-ID")).To(Equal("tenant1"))
+	g.Expect(requestHeaders["/token"].Get("X-Client-Version")).To(Equal("7"))
+
+	// Token requests made by the oauth2 library
+	delete(requestHeaders, "/token")
+	_, err = p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+	g.Expect(err).To(MatchError(ContainSubstring("id_token")))
+	g.Expect(requestHeaders["/token"].Get("X-Tenant-ID")).To(Equal("tenant1"))
+	g.Expect(requestHeaders["/token"].Get("X-Client-Version")).To(Equal("7"))
+
+	// Other provider requests don't send the headers
+	_, err = p.getProfile(context.Background(), "a1234")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requestHeaders["/userinfo"]).ToNot(HaveKey("X-Tenant-Id"))
+}
+
+func TestProviderTokenRequestsUseTokenHTTPClient(t *testing.T) {
+	testCases := map[string]func(redeemURL *url.URL, headers map[string]string) error{
+		"Azure Redeem": func(redeemURL *url.URL, headers map[string]string) error {
+			p := NewAzureProvider(&ProviderData{})
+			p.RedeemURL, p.TokenEndpointHeaders = redeemURL, headers
+			_, err := p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+			return err
+		},
+		"Azure RefreshSession": func(redeemURL *url.URL, headers map[string]string) error {
+			p := NewAzureProvider(&ProviderData{})
+			p.RedeemURL, p.TokenEndpointHeaders = redeemURL, headers
+			_, err := p.RefreshSession(context.Background(), &sessions.SessionState{RefreshToken: "r1234"})
+			return err
+		},
+		"Google Redeem": func(redeemURL *url.URL, headers map[string]string) error {
+			p := newGoogleProvider()
+			p.RedeemURL, p.TokenEndpointHeaders = redeemURL, headers
+			_, err := p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+			return err
+		},
+		"Google RefreshSession": func(redeemURL *url.URL, headers map[string]string) error {
+			p := newGoogleProvider()
+			p.RedeemURL, p.TokenEndpointHeaders = redeemURL, headers
+			_, err := p.RefreshSession(context.Background(), &sessions.SessionState{RefreshToken: "r1234"})
+			return err
+		},
+		"Login.gov Redeem": func(redeemURL *url.URL, headers map[string]string) error {
+			p, _, err := newLoginGovProvider()
+			if err != nil {
+				return err
+			}
+			p.RedeemURL, p.TokenEndpointHeaders = redeemURL, headers
+			_, err = p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+			return err
+		},
+	}
+
+	for testName, tokenRequest := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var requestHeaders http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				requestHeaders = req.Header
+				rw.WriteHeader(http.StatusBadRequest)
+			}))
+			defer server.Close()
+
+			redeemURL, err := url.Parse(server.URL + "/token")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			// Only the headers matter, the token response is an error
+			_ = tokenRequest(redeemURL, map[string]string{"X-Tenant-ID": "tenant1"})
+			g.Expect(requestHeaders).ToNot(BeNil())
+			g.Expect(requestHeaders.Get("X-Tenant-ID")).To(Equal("tenant1"))
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHeaderTransport(t *testing.T) {
+	testCases := map[string]struct {
+		RequestHeaders  http.Header
+		Headers         map[string]string
+		ExpectedHeaders http.Header
+	}{
+		"Adds Headers": {
+			RequestHeaders: http.Header{},
+			Headers:        map[string]string{"X-Tenant-ID": "tenant1"},
+			ExpectedHeaders: http.Header{
+				"X-Tenant-Id": []string{"tenant1"},
+			},
+		},
+		"Authorization Of The Request Wins": {
+			RequestHeaders: http.Header{
+				"Authorization": []string{"Basic Y2xpZW50LWlkOmNsaWVudC1zZWNyZXQ="},
+			},
+			Headers: map[string]string{
+				"Authorization": "Bearer configured",
+				"X-Tenant-ID":   "tenant1",
+			},
+			ExpectedHeaders: http.Header{
+				"Authorization": []string{"Basic Y2xpZW50LWlkOmNsaWVudC1zZWNyZXQ="},
+				"X-Tenant-Id":   []string{"tenant1"},
+			},
+		},
+		"Same Authorization": {
+			RequestHeaders: http.Header{
+				"Authorization": []string{"Bearer configured"},
+			},
+			Headers: map[string]string{"Authorization": "Bearer configured"},
+			ExpectedHeaders: http.Header{
+				"Authorization": []string{"Bearer configured"},
+			},
+		},
+		"Authorization Without One On The Request": {
+			RequestHeaders: http.Header{},
+			Headers:        map[string]string{"Authorization": "Bearer configured"},
+			ExpectedHeaders: http.Header{
+				"Authorization": []string{"Bearer configured"},
+			},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var sent http.Header
+			transport := &headerTransport{
+				headers: tc.Headers,
+				base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					sent = req.Header
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+				}),
+			}
+
+			req := httptest.NewRequest("POST", "https://idp.example.com/token", nil)
+			req.Header = tc.RequestHeaders.Clone()
+			_, err := transport.RoundTrip(req)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(sent).To(Equal(tc.ExpectedHeaders))
+			// The original request isn't modified
+			g.Expect(req.Header).To(Equal(tc.RequestHeaders))
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// verifyIDTokenHash checks a hash claim of the id_token, `at_hash` or
+// `c_hash`, against the access token or authorization code it was issued
+// with. The claim must be the base64url encoded left half of the value's hash,
+// using the hash function of the id_token's `alg` header. It does nothing
+// when the id_token doesn't have the claim.
+//
+// The id_token isn't verified, so this must be used together with
+// verifyIDToken.
+func verifyIDTokenHash(rawIDToken, claim, value string) error {
+	if rawIDToken == "" {
+		return nil
+	}
+
+	claims, err := parseIDTokenClaims(rawIDToken)
+	if err != nil {
+		return err
+	}
+	expected, ok := claims[claim]
+	if !ok || expected == nil {
+		return nil
+	}
+
+	alg, err := parseIDTokenAlgorithm(rawIDToken)
+	if err != nil {
+		return err
+	}
+	var h hash.Hash
+	switch alg {
+	case "RS256", "ES256", "PS256", "HS256":
+		h = sha256.New()
+	case "RS384", "ES384", "PS384", "HS384":
+		h = sha512.New384()
+	case "RS512", "ES512", "PS512", "HS512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("%w: unable to verify %s of an id_token signed with %q", ErrIDTokenHashMismatch, claim, alg)
+	}
+
+	_, _ = h.Write([]byte(value))
+	sum := h.Sum(nil)
+	if expected != base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]) {
+		return fmt.Errorf("%w: %s", ErrIDTokenHashMismatch, claim)
+	}
+	return nil
+}
+
+// parseIDTokenAlgorithm decodes the `alg` header of an id_token without
+// verifying it
+func parseIDTokenAlgorithm(rawIDToken string) (string, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed id_token: expected 3 parts, got %d", len(parts))
+	}
+	header, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return "", fmt.Errorf("malformed id_token header: %v", err)
+	}
+
+	var h struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return "", fmt.Errorf("failed to parse id_token header: %v", err)
+	}
+	return h.Algorithm, nil
+}
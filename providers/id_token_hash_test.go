@@ -0,0 +1,121 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// tokenHash computes an at_hash or c_hash of the value
+func tokenHash(h hash.Hash, value string) string {
+	_, _ = h.Write([]byte(value))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// newUnsignedTestJWT hand-crafts a JWT with the header alg and claims, and a
+// placeholder signature
+func newUnsignedTestJWT(alg string, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s.signature",
+		base64.RawURLEncoding.EncodeToString(header),
+		base64.RawURLEncoding.EncodeToString(payload)), nil
+}
+
+func TestVerifyIDTokenHash(t *testing.T) {
+	const code = "code1234"
+
+	testCases := map[string]struct {
+		Algorithm     string
+		Claims        map[string]interface{}
+		Claim         string
+		Value         string
+		ExpectedError error
+	}{
+		"Matching RS256 Access Token Hash": {
+			Algorithm: "RS256",
+			Claims:    map[string]interface{}{"at_hash": tokenHash(sha256.New(), accessToken)},
+			Claim:     "at_hash",
+			Value:     accessToken,
+		},
+		"Matching ES384 Access Token Hash": {
+			Algorithm: "ES384",
+			Claims:    map[string]interface{}{"at_hash": tokenHash(sha512.New384(), accessToken)},
+			Claim:     "at_hash",
+			Value:     accessToken,
+		},
+		"Matching PS512 Code Hash": {
+			Algorithm: "PS512",
+			Claims:    map[string]interface{}{"c_hash": tokenHash(sha512.New(), code)},
+			Claim:     "c_hash",
+			Value:     code,
+		},
+		"Mismatched Access Token Hash": {
+			Algorithm:     "RS256",
+			Claims:        map[string]interface{}{"at_hash": tokenHash(sha256.New(), "other-access-token")},
+			Claim:         "at_hash",
+			Value:         accessToken,
+			ExpectedError: fmt.Errorf("%w: at_hash", ErrIDTokenHashMismatch),
+		},
+		"Hash Of The Wrong Algorithm": {
+			Algorithm:     "RS512",
+			Claims:        map[string]interface{}{"c_hash": tokenHash(sha256.New(), code)},
+			Claim:         "c_hash",
+			Value:         code,
+			ExpectedError: fmt.Errorf("%w: c_hash", ErrIDTokenHashMismatch),
+		},
+		"Non-String Hash": {
+			Algorithm:     "RS256",
+			Claims:        map[string]interface{}{"at_hash": 1234},
+			Claim:         "at_hash",
+			Value:         accessToken,
+			ExpectedError: fmt.Errorf("%w: at_hash", ErrIDTokenHashMismatch),
+		},
+		"Unsupported Algorithm": {
+			Algorithm:     "none",
+			Claims:        map[string]interface{}{"at_hash": tokenHash(sha256.New(), accessToken)},
+			Claim:         "at_hash",
+			Value:         accessToken,
+			ExpectedError: fmt.Errorf("%w: unable to verify at_hash of an id_token signed with \"none\"", ErrIDTokenHashMismatch),
+		},
+		"Missing Hash Claim": {
+			Algorithm: "RS256",
+			Claims:    map[string]interface{}{"sub": "123456789"},
+			Claim:     "c_hash",
+			Value:     code,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			rawIDToken, err := newUnsignedTestJWT(tc.Algorithm, tc.Claims)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			err = verifyIDTokenHash(rawIDToken, tc.Claim, tc.Value)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(MatchError(tc.ExpectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenHashMissingIDToken(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(verifyIDTokenHash("", "c_hash", "code1234")).To(Succeed())
+}
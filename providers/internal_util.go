@@ -58,6 +58,7 @@ func validateToken(ctx context.Context, p Provider, accessToken string, header h
 
 	result := requests.New(endpoint).
 		WithContext(ctx).
+		WithClient(p.Data().getHTTPClient()).
 		WithHeaders(header).
 		Do()
 	if result.Error() != nil {
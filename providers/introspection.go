@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// tokenIntrospection is the RFC 7662 token introspection response
+type tokenIntrospection struct {
+	Active   bool   `json:"active"`
+	Username string `json:"username"`
+	Scope    string `json:"scope"`
+}
+
+// introspectSession validates the session's access token with RFC 7662 token
+// introspection at the ValidateURL, for providers issuing opaque access
+// tokens. The introspected `username` sets the session's PreferredUsername
+// if it is unset, and the `scope` is stored in the session's Extra.
+//
+// If the introspection endpoint rejects the token with a 401 and a
+// WWW-Authenticate challenge, the session is refreshed with the provider and
+// introspected once more.
+func introspectSession(ctx context.Context, p Provider, s *sessions.SessionState) bool {
+	if s.AccessToken == "" || p.Data().ValidateURL == nil || p.Data().ValidateURL.String() == "" {
+		return false
+	}
+
+	result, err := p.Data().introspectToken(ctx, s.AccessToken)
+	if err == nil && result.StatusCode() == http.StatusUnauthorized && result.Headers().Get("WWW-Authenticate") != "" {
+		refreshed, refreshErr := p.RefreshSession(ctx, s)
+		if refreshErr != nil || !refreshed {
+			logger.Errorf("token introspection was challenged and the session couldn't be refreshed: %v", refreshErr)
+			return false
+		}
+		result, err = p.Data().introspectToken(ctx, s.AccessToken)
+	}
+	if err != nil {
+		logger.Errorf("token introspection request failed: %v", err)
+		return false
+	}
+	if result.StatusCode() != http.StatusOK {
+		logger.Errorf("token introspection request failed: status %d - %s", result.StatusCode(), result.Body())
+		return false
+	}
+
+	var introspection tokenIntrospection
+	if err := result.UnmarshalInto(&introspection); err != nil {
+		logger.Errorf("unable to parse token introspection response: %v", err)
+		return false
+	}
+	if !introspection.Active {
+		return false
+	}
+
+	if introspection.Username != "" && s.PreferredUsername == "" {
+		s.PreferredUsername = introspection.Username
+	}
+	if introspection.Scope != "" {
+		if s.Extra == nil {
+			s.Extra = map[string]string{}
+		}
+		s.Extra["scope"] = introspection.Scope
+	}
+	return true
+}
+
+// introspectToken POSTs the access token to the ValidateURL as an RFC 7662
+// token introspection request, authenticated with the client credentials
+func (p *ProviderData) introspectToken(ctx context.Context, accessToken string) (requests.Result, error) {
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Add("token", accessToken)
+	params.Add("token_type_hint", "access_token")
+
+	result := requests.New(p.ValidateURL.String()).
+		WithContext(ctx).
+		WithClient(p.getHTTPClient()).
+		WithMethod("POST").
+		WithBody(bytes.NewBufferString(params.Encode())).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SetHeader("Accept", "application/json").
+		SetHeader("Authorization", clientSecretBasicAuth(p.ClientID, clientSecret)).
+		Do()
+	if result.Error() != nil {
+		return nil, result.Error()
+	}
+	return result, nil
+}
+
+// clientSecretBasicAuth is the Authorization header value for the client
+// credentials. RFC 6749 section 2.3.1 form encodes the credentials before
+// they are base64 encoded.
+func clientSecretBasicAuth(clientID, clientSecret string) string {
+	credentials := url.QueryEscape(clientID) + ":" + url.QueryEscape(clientSecret)
+	return fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(credentials)))
+}
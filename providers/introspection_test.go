@@ -0,0 +1,204 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	. "github.com/onsi/gomega"
+)
+
+// refreshingTestProvider refreshes sessions with the next access token
+type refreshingTestProvider struct {
+	*ProviderData
+	refreshedToken string
+}
+
+func (p *refreshingTestProvider) RefreshSession(_ context.Context, s *sessions.SessionState) (bool, error) {
+	s.AccessToken = p.refreshedToken
+	return true, nil
+}
+
+func newIntrospectionServer(activeToken string, challengedToken string, requests *[]url.Values) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		// The client credentials are form encoded before they are base64
+		// encoded
+		clientID, clientSecret, ok := req.BasicAuth()
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+		if !ok || clientID != "client-id" || clientSecret != "client secret" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*requests = append(*requests, req.PostForm)
+
+		token := req.PostForm.Get("token")
+		if token == challengedToken {
+			rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		if token != activeToken {
+			_ = json.NewEncoder(rw).Encode(map[string]interface{}{"active": false})
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+			"active":   true,
+			"username": "janed",
+			"scope":    "openid profile",
+		})
+	}))
+}
+
+func TestProviderData_ValidateSessionIntrospection(t *testing.T) {
+	testCases := map[string]struct {
+		AccessToken       string
+		ExpectedValid     bool
+		ExpectedUsername  string
+		ExpectedScope     string
+		ExpectedRequested []string
+	}{
+		"Active Token": {
+			AccessToken:       "active",
+			ExpectedValid:     true,
+			ExpectedUsername:  "janed",
+			ExpectedScope:     "openid profile",
+			ExpectedRequested: []string{"active"},
+		},
+		"Inactive Token": {
+			AccessToken:       "revoked",
+			ExpectedValid:     false,
+			ExpectedRequested: []string{"revoked"},
+		},
+		"Challenged Token Is Refreshed": {
+			AccessToken:       "expired",
+			ExpectedValid:     true,
+			ExpectedUsername:  "janed",
+			ExpectedScope:     "openid profile",
+			ExpectedRequested: []string{"expired", "active"},
+		},
+		"Missing Token": {
+			AccessToken:   "",
+			ExpectedValid: false,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var requests []url.Values
+			server := newIntrospectionServer("active", "expired", &requests)
+			defer server.Close()
+			validateURL, err := url.Parse(server.URL)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			p := &refreshingTestProvider{
+				ProviderData: &ProviderData{
+					ClientID:                  "client-id",
+					ClientSecret:              "client secret",
+					ValidateURL:               validateURL,
+					TokenIntrospectionEnabled: true,
+				},
+				refreshedToken: "active",
+			}
+
+			session := &sessions.SessionState{AccessToken: tc.AccessToken}
+			g.Expect(introspectSession(context.Background(), p, session)).To(Equal(tc.ExpectedValid))
+			g.Expect(session.PreferredUsername).To(Equal(tc.ExpectedUsername))
+			g.Expect(session.Extra["scope"]).To(Equal(tc.ExpectedScope))
+
+			g.Expect(requests).To(HaveLen(len(tc.ExpectedRequested)))
+			for i, token := range tc.ExpectedRequested {
+				g.Expect(requests[i]).To(Equal(url.Values{
+					"token":           []string{token},
+					"token_type_hint": []string{"access_token"},
+				}))
+			}
+		})
+	}
+
+	t.Run("ValidateSession Introspects When Enabled", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var requests []url.Values
+		server := newIntrospectionServer("active", "", &requests)
+		defer server.Close()
+		validateURL, err := url.Parse(server.URL)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		p := &ProviderData{
+			ClientID:                  "client-id",
+			ClientSecret:              "client secret",
+			ValidateURL:               validateURL,
+			TokenIntrospectionEnabled: true,
+		}
+		g.Expect(p.ValidateSession(context.Background(), &sessions.SessionState{AccessToken: "active"})).To(BeTrue())
+		g.Expect(p.ValidateSession(context.Background(), &sessions.SessionState{AccessToken: "revoked"})).To(BeFalse())
+		g.Expect(requests).To(HaveLen(2))
+	})
+
+	t.Run("ValidateSession Refreshes A Challenged Token With The Provider", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var requests []url.Values
+		server := newIntrospectionServer("active", "expired", &requests)
+		defer server.Close()
+		validateURL, err := url.Parse(server.URL)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+				"access_token": "active",
+				"expires_in":   3600,
+			})
+		}))
+		defer tokenServer.Close()
+		redeemURL, err := url.Parse(tokenServer.URL)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		p := NewGoogleProvider(&ProviderData{
+			ClientID:                  "client-id",
+			ClientSecret:              "client secret",
+			RedeemURL:                 redeemURL,
+			ValidateURL:               validateURL,
+			TokenIntrospectionEnabled: true,
+		})
+		session := &sessions.SessionState{AccessToken: "expired", RefreshToken: "refresh"}
+		g.Expect(p.ValidateSession(context.Background(), session)).To(BeTrue())
+		g.Expect(session.AccessToken).To(Equal("active"))
+		g.Expect(requests).To(HaveLen(2))
+	})
+
+	t.Run("Challenged Token Without A Refresh Is Invalid", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var requests []url.Values
+		server := newIntrospectionServer("active", "expired", &requests)
+		defer server.Close()
+		validateURL, err := url.Parse(server.URL)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		p := &ProviderData{
+			ClientID:                  "client-id",
+			ClientSecret:              "client secret",
+			ValidateURL:               validateURL,
+			TokenIntrospectionEnabled: true,
+		}
+		g.Expect(p.ValidateSession(context.Background(), &sessions.SessionState{AccessToken: "expired"})).To(BeFalse())
+		g.Expect(requests).To(HaveLen(1))
+	})
+}
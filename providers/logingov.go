@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"fmt"
 	"math/big"
+	"net/http"
 	"net/url"
 	"time"
 
@@ -110,7 +111,10 @@ type loginGovCustomClaims struct {
 func checkNonce(idToken string, p *LoginGovProvider) (err error) {
 	token, err := jwt.ParseWithClaims(idToken, &loginGovCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		var pubkeys jose.JSONWebKeySet
-		rerr := requests.New(p.PubJWKURL.String()).Do().UnmarshalInto(&pubkeys)
+		rerr := requests.New(p.PubJWKURL.String()).
+			WithClient(p.getHTTPClient()).
+			Do().
+			UnmarshalInto(&pubkeys)
 		if rerr != nil {
 			return nil, rerr
 		}
@@ -128,7 +132,7 @@ func checkNonce(idToken string, p *LoginGovProvider) (err error) {
 	return
 }
 
-func emailFromUserInfo(ctx context.Context, accessToken string, userInfoEndpoint string) (string, error) {
+func emailFromUserInfo(ctx context.Context, client *http.Client, accessToken string, userInfoEndpoint string) (string, error) {
 	// parse the user attributes from the data we got and make sure that
 	// the email address has been validated.
 	var emailData struct {
@@ -139,6 +143,7 @@ func emailFromUserInfo(ctx context.Context, accessToken string, userInfoEndpoint
 	// query the user info endpoint for user attributes
 	err := requests.New(userInfoEndpoint).
 		WithContext(ctx).
+		WithClient(client).
 		SetHeader("Authorization", "Bearer "+accessToken).
 		Do().
 		UnmarshalInto(&emailData)
@@ -182,6 +187,7 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, _, code string) (*session
 	params.Add("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	addCodeVerifier(ctx, params)
 
 	// Get the token from the body that we got from the token endpoint.
 	var jsonResponse struct {
@@ -190,14 +196,17 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, _, code string) (*session
 		TokenType   string `json:"token_type"`
 		ExpiresIn   int64  `json:"expires_in"`
 	}
-	err = requests.New(p.RedeemURL.String()).
+	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenHTTPClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Do().
-		UnmarshalInto(&jsonResponse)
-	if err != nil {
+		Do()
+	if err := result.UnmarshalInto(&jsonResponse); err != nil {
+		return nil, err
+	}
+	if err := p.validateTokenResponseBody(result.Body()); err != nil {
 		return nil, err
 	}
 
@@ -209,7 +218,7 @@ func (p *LoginGovProvider) Redeem(ctx context.Context, _, code string) (*session
 
 	// Get the email address
 	var email string
-	email, err = emailFromUserInfo(ctx, jsonResponse.AccessToken, p.ProfileURL.String())
+	email, err = emailFromUserInfo(ctx, p.getHTTPClient(), jsonResponse.AccessToken, p.ProfileURL.String())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,54 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BackchannelLogoutEvent is the member of a logout_token's `events` claim
+// that identifies it as a back-channel logout token
+const BackchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// ErrInvalidLogoutToken is wrapped by the errors of ValidateLogoutToken for
+// logout tokens that don't follow the back-channel logout rules
+var ErrInvalidLogoutToken = errors.New("invalid logout_token")
+
+// logoutTokenClaims are the claims of a back-channel logout token
+type logoutTokenClaims struct {
+	Subject   string                 `json:"sub"`
+	SessionID string                 `json:"sid"`
+	Events    map[string]interface{} `json:"events"`
+	Nonce     *string                `json:"nonce"`
+}
+
+// ValidateLogoutToken validates a back-channel logout token. It is verified
+// like an id_token by the Verifier, must have the back-channel logout event
+// in its `events` claim, a `sub` or `sid` claim, and must not have a `nonce`
+// claim, so that id_tokens can't be used as logout tokens. It returns the
+// subject and session ID of the sessions to log out, either may be empty.
+func (p *ProviderData) ValidateLogoutToken(ctx context.Context, rawJWT string) (sub, sid string, err error) {
+	token, err := p.verifyToken(ctx, rawJWT)
+	if err != nil {
+		return "", "", fmt.Errorf("could not verify logout_token: %v", err)
+	}
+
+	var claims logoutTokenClaims
+	if err := token.Claims(&claims); err != nil {
+		return "", "", fmt.Errorf("%w: failed to parse claims: %v", ErrInvalidLogoutToken, err)
+	}
+
+	if event, ok := claims.Events[BackchannelLogoutEvent]; !ok {
+		return "", "", fmt.Errorf("%w: events claim has no %s event", ErrInvalidLogoutToken, BackchannelLogoutEvent)
+	} else if _, ok := event.(map[string]interface{}); !ok {
+		return "", "", fmt.Errorf("%w: %s event isn't a JSON object", ErrInvalidLogoutToken, BackchannelLogoutEvent)
+	}
+	if claims.Nonce != nil {
+		return "", "", fmt.Errorf("%w: must not have a nonce claim", ErrInvalidLogoutToken)
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return "", "", fmt.Errorf("%w: has neither a sub nor a sid claim", ErrInvalidLogoutToken)
+	}
+
+	return claims.Subject, claims.SessionID, nil
+}
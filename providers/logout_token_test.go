@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/gomega"
+)
+
+func newSignedTestLogoutToken(claims jwt.MapClaims) (string, error) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+}
+
+func TestProviderData_ValidateLogoutToken(t *testing.T) {
+	logoutEvent := map[string]interface{}{BackchannelLogoutEvent: map[string]interface{}{}}
+
+	testCases := map[string]struct {
+		Claims        jwt.MapClaims
+		ExpectedSub   string
+		ExpectedSID   string
+		ExpectedError string
+	}{
+		"Valid With Sub And Sid": {
+			Claims: jwt.MapClaims{
+				"sub":    "123456789",
+				"sid":    "session-1",
+				"events": logoutEvent,
+			},
+			ExpectedSub: "123456789",
+			ExpectedSID: "session-1",
+		},
+		"Valid With Sid Only": {
+			Claims: jwt.MapClaims{
+				"sid":    "session-1",
+				"events": logoutEvent,
+			},
+			ExpectedSID: "session-1",
+		},
+		"Missing Events": {
+			Claims: jwt.MapClaims{
+				"sub": "123456789",
+			},
+			ExpectedError: "invalid logout_token: events claim has no " + BackchannelLogoutEvent + " event",
+		},
+		"Other Event": {
+			Claims: jwt.MapClaims{
+				"sub":    "123456789",
+				"events": map[string]interface{}{"http://schemas.openid.net/event/other": map[string]interface{}{}},
+			},
+			ExpectedError: "invalid logout_token: events claim has no " + BackchannelLogoutEvent + " event",
+		},
+		"Event Is Not An Object": {
+			Claims: jwt.MapClaims{
+				"sub":    "123456789",
+				"events": map[string]interface{}{BackchannelLogoutEvent: "logout"},
+			},
+			ExpectedError: "invalid logout_token: " + BackchannelLogoutEvent + " event isn't a JSON object",
+		},
+		"Nonce": {
+			Claims: jwt.MapClaims{
+				"sub":    "123456789",
+				"events": logoutEvent,
+				"nonce":  "abcdef",
+			},
+			ExpectedError: "invalid logout_token: must not have a nonce claim",
+		},
+		"Neither Sub Nor Sid": {
+			Claims: jwt.MapClaims{
+				"events": logoutEvent,
+			},
+			ExpectedError: "invalid logout_token: has neither a sub nor a sid claim",
+		},
+		"Wrong Audience": {
+			Claims: jwt.MapClaims{
+				"aud":    "https://other.myapp.com",
+				"sub":    "123456789",
+				"events": logoutEvent,
+			},
+			ExpectedError: "could not verify logout_token",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			claims := jwt.MapClaims{
+				"iss": oidcIssuer,
+				"aud": oidcClientID,
+				"iat": time.Now().Unix(),
+				"exp": time.Now().Add(5 * time.Minute).Unix(),
+				"jti": "logout-token-id",
+			}
+			for claim, value := range tc.Claims {
+				claims[claim] = value
+			}
+			rawLogoutToken, err := newSignedTestLogoutToken(claims)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			provider := &ProviderData{
+				Verifier: oidc.NewVerifier(
+					oidcIssuer,
+					mockJWKS{},
+					&oidc.Config{ClientID: oidcClientID},
+				),
+			}
+
+			sub, sid, err := provider.ValidateLogoutToken(context.Background(), rawLogoutToken)
+			if tc.ExpectedError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.ExpectedError)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(sub).To(Equal(tc.ExpectedSub))
+			g.Expect(sid).To(Equal(tc.ExpectedSID))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultOAuthStateMaxAge is how long a login has to complete before its
+// OAuth state token expires
+const DefaultOAuthStateMaxAge = 15 * time.Minute
+
+var (
+	// ErrStateInvalid is returned when an OAuth state token is malformed, has
+	// a bad signature or doesn't match the expected CSRF token. This suggests
+	// a forged or replayed callback.
+	ErrStateInvalid = errors.New("invalid OAuth state")
+	// ErrStateExpired is returned when a valid OAuth state token is older
+	// than the OAuthStateMaxAge, eg. the user took too long to log in
+	ErrStateExpired = errors.New("OAuth state has expired")
+)
+
+// NewStateToken returns a signed OAuth state token for the CSRF token,
+// recording when it was issued
+func (p *ProviderData) NewStateToken(csrf string) string {
+	issued := strconv.FormatInt(time.Now().Unix(), 10)
+	return strings.Join([]string{csrf, issued, p.signStateToken(csrf, issued)}, ".")
+}
+
+// ValidateStateToken checks that the OAuth state token was signed by
+// NewStateToken for the expected CSRF token and hasn't expired.
+// It returns ErrStateInvalid or ErrStateExpired on failure.
+func (p *ProviderData) ValidateStateToken(state, expectedCSRF string) error {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 {
+		return ErrStateInvalid
+	}
+	csrf, issued, signature := parts[0], parts[1], parts[2]
+
+	if !hmac.Equal([]byte(signature), []byte(p.signStateToken(csrf, issued))) {
+		return ErrStateInvalid
+	}
+	if expectedCSRF == "" || !hmac.Equal([]byte(csrf), []byte(expectedCSRF)) {
+		return ErrStateInvalid
+	}
+
+	issuedUnix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return ErrStateInvalid
+	}
+	if time.Since(time.Unix(issuedUnix, 0)) > p.getOAuthStateMaxAge() {
+		return ErrStateExpired
+	}
+	return nil
+}
+
+// signStateToken signs the CSRF token and issue time of an OAuth state token
+// with the OAuthStateSecret
+func (p *ProviderData) signStateToken(csrf, issued string) string {
+	h := hmac.New(sha256.New, []byte(p.OAuthStateSecret))
+	fmt.Fprintf(h, "%s.%s", csrf, issued)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (p *ProviderData) getOAuthStateMaxAge() time.Duration {
+	if p.OAuthStateMaxAge <= 0 {
+		return DefaultOAuthStateMaxAge
+	}
+	return p.OAuthStateMaxAge
+}
@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderData_ValidateStateToken(t *testing.T) {
+	const csrf = "e1pyvjkIvaN6Ac8eN0LsfIpOgQ3OJbPNHx8TjJm1JnU"
+
+	p := &ProviderData{OAuthStateSecret: "secret"}
+	// signedAt builds a state token as if it were issued at the given time
+	signedAt := func(issued time.Time) string {
+		unix := strconv.FormatInt(issued.Unix(), 10)
+		return strings.Join([]string{csrf, unix, p.signStateToken(csrf, unix)}, ".")
+	}
+	// tampered moves the issue time of an old token forward without re-signing
+	tampered := strings.Split(signedAt(time.Now().Add(-time.Hour)), ".")
+	tampered[1] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	testCases := map[string]struct {
+		State         string
+		ExpectedCSRF  string
+		MaxAge        time.Duration
+		ExpectedError error
+	}{
+		"Valid": {
+			State:         p.NewStateToken(csrf),
+			ExpectedCSRF:  csrf,
+			ExpectedError: nil,
+		},
+		"Wrong CSRF": {
+			State:         p.NewStateToken(csrf),
+			ExpectedCSRF:  "other-csrf",
+			ExpectedError: ErrStateInvalid,
+		},
+		"Empty Expected CSRF": {
+			State:         p.NewStateToken(""),
+			ExpectedCSRF:  "",
+			ExpectedError: ErrStateInvalid,
+		},
+		"Malformed": {
+			State:         csrf,
+			ExpectedCSRF:  csrf,
+			ExpectedError: ErrStateInvalid,
+		},
+		"Signed With Another Secret": {
+			State:         (&ProviderData{OAuthStateSecret: "other"}).NewStateToken(csrf),
+			ExpectedCSRF:  csrf,
+			ExpectedError: ErrStateInvalid,
+		},
+		"Tampered Issue Time": {
+			State:         strings.Join(tampered, "."),
+			ExpectedCSRF:  csrf,
+			ExpectedError: ErrStateInvalid,
+		},
+		"Expired With Default Max Age": {
+			State:         signedAt(time.Now().Add(-DefaultOAuthStateMaxAge - time.Minute)),
+			ExpectedCSRF:  csrf,
+			ExpectedError: ErrStateExpired,
+		},
+		"Within Custom Max Age": {
+			State:         signedAt(time.Now().Add(-30 * time.Minute)),
+			ExpectedCSRF:  csrf,
+			MaxAge:        time.Hour,
+			ExpectedError: nil,
+		},
+		"Expired With Custom Max Age": {
+			State:         signedAt(time.Now().Add(-2 * time.Minute)),
+			ExpectedCSRF:  csrf,
+			MaxAge:        time.Minute,
+			ExpectedError: ErrStateExpired,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				OAuthStateSecret: p.OAuthStateSecret,
+				OAuthStateMaxAge: tc.MaxAge,
+			}
+			err := provider.ValidateStateToken(tc.State, tc.ExpectedCSRF)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(Equal(tc.ExpectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
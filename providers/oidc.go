@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"reflect"
 	"time"
@@ -57,10 +58,16 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (*s
 		},
 		RedirectURL: redirectURL,
 	}
-	token, err := c.Exchange(ctx, code)
+	token, err := c.Exchange(p.contextWithHTTPClient(ctx), code, codeVerifierOptions(ctx)...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange failed: %v", err)
 	}
+	if err := p.validateOAuth2Token(token); err != nil {
+		return nil, fmt.Errorf("token exchange failed: %v", err)
+	}
+	if err := verifyIDTokenHash(getIDToken(token), "c_hash", code); err != nil {
+		return nil, fmt.Errorf("could not verify id_token: %v", err)
+	}
 
 	return p.createSession(ctx, token, false)
 }
@@ -68,64 +75,299 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (*s
 // EnrichSession is called after Redeem to allow providers to enrich session fields
 // such as User, Email, Groups with provider specific API calls.
 func (p *OIDCProvider) EnrichSession(ctx context.Context, s *sessions.SessionState) error {
-	if p.ProfileURL.String() == "" {
-		if s.Email == "" {
+	// An unverified email cleared with RequireVerifiedEmailOrEmpty is
+	// neither mandatory nor filled in from the profile URL
+	missingEmail := s.Email == "" && !s.EmailUnverified
+	if len(p.GetProfileURLs()) == 0 {
+		if missingEmail {
 			return errors.New("id_token did not contain an email and profileURL is not defined")
 		}
+		p.warnMissingGroups(s)
 		return nil
 	}
 
-	// Try to get missing emails or groups from a profileURL
-	if s.Email == "" || s.Groups == nil {
-		err := p.enrichFromProfileURL(ctx, s)
-		if err != nil {
-			logger.Errorf("Warning: Profile URL request failed: %v", err)
+	// Try to get missing emails or groups from a profileURL, or always
+	// consult it when its claims take precedence over the id_token. Empty
+	// groups are missing too when PreferNonEmptyClaims is set.
+	userinfoFirst := p.ClaimPrecedence == ClaimPrecedenceUserinfoFirst
+	missingGroups := s.Groups == nil || (p.PreferNonEmptyClaims && isEmptyGroups(s.Groups))
+	if userinfoFirst || missingEmail || missingGroups || p.ClaimPlan.hasProfileSource() {
+		// The predicate may only skip fetches that the authorization doesn't depend on
+		required := missingEmail || (missingGroups && p.groupsAuthorizeLogins()) || p.ClaimPlan.hasProfileSource()
+		fetched := false
+		if required || p.shouldFetchProfile(ctx) {
+			err := p.enrichFromProfileURL(ctx, s, userinfoFirst)
+			if err != nil {
+				logger.Errorf("Warning: Profile URL request failed: %v", err)
+			}
+			fetched = err == nil
 		}
+		// Missing groups that couldn't be fetched aren't known to be empty
+		s.GroupsIncomplete = missingGroups && !fetched
 	}
 
 	// If a mandatory email wasn't set, error at this point.
-	if s.Email == "" {
+	if s.Email == "" && !s.EmailUnverified {
 		return errors.New("neither the id_token nor the profileURL set an email")
 	}
+	if p.GroupsClaimRequired && s.Groups == nil {
+		return newClaimError(ErrMissingGroupsClaim, nil, "neither the id_token nor the profileURL had a groups claim")
+	}
+	p.warnMissingGroups(s)
 	return nil
 }
 
 // enrichFromProfileURL enriches a session's Email & Groups via the JSON response of
-// an OIDC profile URL
-func (p *OIDCProvider) enrichFromProfileURL(ctx context.Context, s *sessions.SessionState) error {
-	respJSON, err := requests.New(p.ProfileURL.String()).
-		WithContext(ctx).
-		WithHeaders(makeOIDCHeader(s.AccessToken)).
-		Do().
-		UnmarshalJSON()
+// an OIDC profile URL. When override is set, values from the profile URL replace
+// any already extracted from the id_token. The claim rules are applied last.
+func (p *OIDCProvider) enrichFromProfileURL(ctx context.Context, s *sessions.SessionState, override bool) error {
+	profile, err := p.getSessionProfile(ctx, s)
 	if err != nil {
 		return err
 	}
+	if err := p.mergeRawClaims(s, profile); err != nil {
+		return err
+	}
 
-	email, err := respJSON.Get(p.EmailClaim).String()
-	if err == nil && s.Email == "" {
+	if email, _ := p.extractEmail(profile); email != "" && !s.EmailUnverified && (override || s.Email == "") {
 		s.Email = email
 	}
 
-	if len(s.Groups) > 0 {
-		return nil
+	if len(s.Groups) == 0 || override || (p.PreferNonEmptyClaims && isEmptyGroups(s.Groups)) {
+		if groups := p.extractGroups(profile); len(groups) > 0 {
+			if groups, err = p.GroupResolver.Resolve(ctx, groups); err != nil {
+				return err
+			}
+			s.Groups = groups
+		}
 	}
-	for _, group := range coerceArray(respJSON, p.GroupsClaim) {
-		formatted, err := formatGroup(group)
-		if err != nil {
-			logger.Errorf("Warning: unable to format group of type %s with error %s",
-				reflect.TypeOf(group), err)
-			continue
+
+	groups, user := s.Groups, s.User
+	p.ClaimPlan.ApplyProfile(s, profile, override)
+	if s.User != user {
+		s.User = p.prefixSubject(s.User)
+	}
+	if !reflect.DeepEqual(groups, s.Groups) {
+		if s.Groups, err = p.GroupResolver.Resolve(ctx, s.Groups); err != nil {
+			return err
 		}
-		s.Groups = append(s.Groups, formatted)
 	}
 
 	return nil
 }
 
+// isEmptyGroups is true when the groups came from an empty groups claim, an
+// empty array or an empty string
+func isEmptyGroups(groups []string) bool {
+	return len(groups) == 0 || (len(groups) == 1 && groups[0] == "")
+}
+
+// requestProfiles requests each of the profile URLs in priority order until
+// one responds without a network error or server error
+func (p *OIDCProvider) requestProfiles(ctx context.Context, accessToken string) (requests.Result, error) {
+	profileURLs := p.GetProfileURLs()
+	if len(profileURLs) == 0 {
+		return nil, errors.New("no profile URL is configured")
+	}
+
+	var result requests.Result
+	for i, profileURL := range profileURLs {
+		var err error
+		result, err = p.requestProfile(ctx, profileURL, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		if !isProfileFailover(result) || i == len(profileURLs)-1 {
+			break
+		}
+		logger.Errorf("Warning: Profile URL %s failed, trying the next profile URL", profileURL)
+	}
+	return result, nil
+}
+
+// isProfileFailover is true when a profile URL failed in a way that another
+// replica may not, a network error or server error
+func isProfileFailover(result requests.Result) bool {
+	return result.Error() != nil || result.StatusCode() >= http.StatusInternalServerError
+}
+
+// requestProfile requests the profile URL, retrying connection errors and
+// responses that are rate limited or server errors with an exponential
+// backoff. Requests abandoned after the ProfileURLTimeout aren't retried.
+func (p *OIDCProvider) requestProfile(ctx context.Context, profileURL *url.URL, accessToken string) (requests.Result, error) {
+	maxRetries := p.ProfileURLMaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultProfileURLMaxRetries
+	}
+	backoff := p.ProfileURLRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultProfileURLRetryBackoff
+	}
+	timeout := p.ProfileURLTimeout
+	if timeout <= 0 {
+		timeout = DefaultProfileURLTimeout
+	}
+	header, err := p.getAuthorizationHeader(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	for retry := 0; ; retry++ {
+		requestCtx, cancel := context.WithTimeout(ctx, timeout)
+		result := requests.New(profileURL.String()).
+			WithContext(requestCtx).
+			WithClient(p.getHTTPClient()).
+			WithHeaders(header).
+			Do()
+		timedOut := requestCtx.Err() != nil
+		cancel()
+		if retry >= maxRetries || timedOut || !isRetryableProfileResult(result) {
+			return result, nil
+		}
+
+		if result.Error() != nil {
+			logger.Printf("Profile URL request failed, retrying in %s: %v", backoff, result.Error())
+		} else {
+			logger.Printf("Profile URL request returned status %d, retrying in %s", result.StatusCode(), backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryableProfileResult is true when the profile URL request failed with a
+// connection error or a status that may succeed if requested again
+func isRetryableProfileResult(result requests.Result) bool {
+	if result.Error() != nil {
+		return true
+	}
+	status := result.StatusCode()
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// getProfile fetches the JSON document from the profile URL, reusing a cached
+// copy for the same access token if the ProfileCache is enabled. All the
+// profile URL requests, retries included, must finish within the
+// ClaimExtractionTimeout when it is set.
+func (p *OIDCProvider) getProfile(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	if profile, ok := p.ProfileCache.Get(accessToken); ok {
+		return profile, nil
+	}
+
+	extractionCtx := ctx
+	if p.ClaimExtractionTimeout > 0 {
+		var cancel context.CancelFunc
+		extractionCtx, cancel = context.WithTimeout(ctx, p.ClaimExtractionTimeout)
+		defer cancel()
+	}
+
+	result, err := p.requestProfiles(extractionCtx, accessToken)
+	// Only report the extraction deadline when it fired before the deadline
+	// of the request being served
+	if extractionCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w after %s", ErrClaimExtractionTimeout, p.ClaimExtractionTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Error() == nil && result.StatusCode() == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: %s", ErrProfileURLUnauthorized, result.Body())
+	}
+	respJSON, err := result.UnmarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	profile, err := p.profileFromJSON(respJSON.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	p.ProfileCache.Set(accessToken, profile)
+	return profile, nil
+}
+
+// getSessionProfile fetches the profile for the session's access token. When
+// the profile URL responds 401 Unauthorized and a ProfileURLTokenRefresher is
+// set, the session's access token is refreshed and the profile URL requested
+// once more.
+func (p *OIDCProvider) getSessionProfile(ctx context.Context, s *sessions.SessionState) (map[string]interface{}, error) {
+	profile, err := p.getProfile(ctx, s.AccessToken)
+	if p.ProfileURLTokenRefresher == nil || !errors.Is(err, ErrProfileURLUnauthorized) {
+		return profile, err
+	}
+
+	logger.Printf("Profile URL request was unauthorized, refreshing the access token and retrying")
+	if err := p.ProfileURLTokenRefresher(ctx, s); err != nil {
+		return nil, fmt.Errorf("unable to refresh the access token for the profile URL: %w", err)
+	}
+	return p.getProfile(ctx, s.AccessToken)
+}
+
+// RefreshAccessToken refreshes the session's tokens with its RefreshToken.
+// It is used as the ProfileURLTokenRefresher with ProfileURLTokenRefresh.
+func (p *OIDCProvider) RefreshAccessToken(ctx context.Context, s *sessions.SessionState) error {
+	if s.RefreshToken == "" {
+		return errors.New("session has no refresh token")
+	}
+	return p.redeemRefreshToken(ctx, s)
+}
+
+// profileFromJSON returns the profile from the decoded profile URL response,
+// which must be a JSON object. An array of a single object is also accepted
+// when ProfileURLUnwrapArray is set.
+func (p *OIDCProvider) profileFromJSON(document interface{}) (map[string]interface{}, error) {
+	switch v := document.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case []interface{}:
+		if p.ProfileURLUnwrapArray && len(v) == 1 {
+			if profile, ok := v[0].(map[string]interface{}); ok {
+				return profile, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("profile URL response is a JSON %s, not an object", jsonTypeName(document))
+}
+
+// GetAllClaims returns every claim for the session in a single map: the
+// claims from the session's id_token overlaid on those from the profile URL.
+// The id_token claims win conflicts, see mergeClaims for claims with
+// conflicting types. The profile URL is only requested when
+// one is configured, and the ProfileCache is used if it is enabled.
+// The returned map is a copy that callers are free to modify.
+func (p *OIDCProvider) GetAllClaims(ctx context.Context, s *sessions.SessionState) (map[string]interface{}, error) {
+	tokenClaims := map[string]interface{}{}
+	if s.IDToken != "" {
+		var err error
+		tokenClaims, err = parseIDTokenClaims(s.IDToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	profileClaims := map[string]interface{}{}
+	if len(p.GetProfileURLs()) > 0 && s.AccessToken != "" {
+		var err error
+		profileClaims, err = p.getSessionProfile(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p.mergeClaims(profileClaims, tokenClaims), nil
+}
+
 // ValidateSession checks that the session's IDToken is still valid
 func (p *OIDCProvider) ValidateSession(ctx context.Context, s *sessions.SessionState) bool {
-	idToken, err := p.Verifier.Verify(ctx, s.IDToken)
+	if p.TokenIntrospectionEnabled && !introspectSession(ctx, p, s) {
+		return false
+	}
+
+	idToken, err := p.verifyToken(ctx, s.IDToken)
 	if err != nil {
 		logger.Errorf("id_token verification failed: %v", err)
 		return false
@@ -151,7 +393,7 @@ func (p *OIDCProvider) RefreshSession(ctx context.Context, s *sessions.SessionSt
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	return true, nil
@@ -176,10 +418,13 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 		RefreshToken: s.RefreshToken,
 		Expiry:       time.Now().Add(-time.Hour),
 	}
-	token, err := c.TokenSource(ctx, t).Token()
+	token, err := c.TokenSource(p.contextWithHTTPClient(ctx), t).Token()
 	if err != nil {
 		return fmt.Errorf("failed to get token: %v", err)
 	}
+	if err := p.validateOAuth2Token(token); err != nil {
+		return fmt.Errorf("failed to get token: %v", err)
+	}
 
 	newSession, err := p.createSession(ctx, token, true)
 	if err != nil {
@@ -190,11 +435,34 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 	// session will not contain an id token.
 	// If it doesn't it's probably better to retain the old one
 	if newSession.IDToken != "" {
+		// The stored session was enriched at login, so the refreshed one is
+		// enriched the same way before their groups are compared. An email
+		// the refreshed id_token lacks is kept, as it may not come from the
+		// id_token (eg. the ADFS `upn`).
+		if newSession.Email == "" && !newSession.EmailUnverified {
+			newSession.Email = s.Email
+		}
+		if err := p.EnrichSession(ctx, newSession); err != nil {
+			return fmt.Errorf("unable to enrich refreshed session: %v", err)
+		}
+		// Groups that couldn't be fetched aren't known to have changed, the
+		// stored groups are kept
+		if !newSession.GroupsIncomplete {
+			if err := p.checkGroupsChanged(s.Groups, newSession.Groups); err != nil {
+				return err
+			}
+			s.Groups = newSession.Groups
+		}
 		s.IDToken = newSession.IDToken
 		s.Email = newSession.Email
 		s.User = newSession.User
-		s.Groups = newSession.Groups
+		s.Roles = newSession.Roles
 		s.PreferredUsername = newSession.PreferredUsername
+		s.Extra = newSession.Extra
+		s.RawClaims = newSession.RawClaims
+		s.AuthTime = newSession.AuthTime
+		s.ActiveOrg = newSession.ActiveOrg
+		s.CostCenter = newSession.CostCenter
 	}
 
 	s.AccessToken = newSession.AccessToken
@@ -207,19 +475,25 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 
 // CreateSessionFromToken converts Bearer IDTokens into sessions
 func (p *OIDCProvider) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
-	idToken, err := p.Verifier.Verify(ctx, token)
+	idToken, err := p.verifyToken(ctx, token)
 	if err != nil {
+		p.logAuthDecision(ctx, nil, false, fmt.Sprintf("could not verify bearer token: %v", err))
 		return nil, err
 	}
 
 	ss, err := p.buildSessionFromClaims(idToken)
 	if err != nil {
+		p.logAuthDecision(ctx, nil, false, err.Error())
+		return nil, err
+	}
+	if ss.Groups, err = p.GroupResolver.Resolve(ctx, ss.Groups); err != nil {
+		p.logAuthDecision(ctx, ss, false, err.Error())
 		return nil, err
 	}
 
 	// Allow empty Email in Bearer case since we can't hit the ProfileURL
 	if ss.Email == "" {
-		ss.Email = ss.User
+		ss.Email = p.unprefixSubject(ss.User)
 	}
 
 	ss.AccessToken = token
@@ -244,18 +518,29 @@ func (p *OIDCProvider) createSession(ctx context.Context, token *oauth2.Token, r
 				return nil, errors.New("token response did not contain an id_token")
 			}
 		default:
-			return nil, fmt.Errorf("could not verify id_token: %v", err)
+			err = fmt.Errorf("could not verify id_token: %v", err)
+			p.logAuthDecision(ctx, nil, false, err.Error())
+			return nil, err
 		}
 	}
 
 	ss, err := p.buildSessionFromClaims(idToken)
 	if err != nil {
+		p.logAuthDecision(ctx, nil, false, err.Error())
+		return nil, err
+	}
+	if p.GroupsFromAccessToken {
+		ss.Groups = p.mergeAccessTokenGroups(ss.Groups, token.AccessToken)
+	}
+	if ss.Groups, err = p.GroupResolver.Resolve(ctx, ss.Groups); err != nil {
+		p.logAuthDecision(ctx, ss, false, err.Error())
 		return nil, err
 	}
 
 	ss.AccessToken = token.AccessToken
 	ss.RefreshToken = token.RefreshToken
 	ss.IDToken = getIDToken(token)
+	p.applyAccessTokenClaims(ctx, ss)
 
 	ss.CreatedAtNow()
 	ss.SetExpiresOn(token.Expiry)
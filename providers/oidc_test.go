@@ -2,6 +2,9 @@ package providers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -9,12 +12,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/coreos/go-oidc"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
 )
 
 type redeemTokenResponse struct {
@@ -118,6 +125,284 @@ func TestOIDCProviderRedeem(t *testing.T) {
 	assert.Equal(t, "123456789", session.User)
 }
 
+func TestOIDCProvider_explicitJWKSVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	sign := func(claims idTokenClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+	signedIDToken := sign(defaultIDToken)
+	accessTokenClaims := defaultIDToken
+	accessTokenClaims.Roles = []string{"app:admin"}
+	signedAccessToken := sign(accessTokenClaims)
+
+	// The IdP only serves the token endpoint and its JWKS, there is no
+	// discovery document or profile URL
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/jwks":
+			_ = json.NewEncoder(rw).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+				{Key: &key.PublicKey, KeyID: "test-key", Algorithm: "RS256", Use: "sig"},
+			}})
+		case "/token":
+			_ = json.NewEncoder(rw).Encode(redeemTokenResponse{
+				AccessToken:  signedAccessToken,
+				RefreshToken: refreshToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				IDToken:      signedIDToken,
+			})
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	provider := NewOIDCProvider(&ProviderData{
+		ClientID:             oidcClientID,
+		ClientSecret:         oidcSecret,
+		LoginURL:             serverURL.ResolveReference(&url.URL{Path: "/authorize"}),
+		RedeemURL:            serverURL.ResolveReference(&url.URL{Path: "/token"}),
+		ProfileURL:           &url.URL{},
+		ValidateURL:          &url.URL{},
+		EmailClaim:           OIDCEmailClaim,
+		GroupsClaim:          OIDCGroupsClaim,
+		RolesClaim:           OIDCRolesClaim,
+		AccessTokenRoles:     true,
+		DiscoveryExtraFields: []string{"end_session_endpoint"},
+		Verifier: oidc.NewVerifier(
+			oidcIssuer,
+			oidc.NewRemoteKeySet(context.Background(), serverURL.ResolveReference(&url.URL{Path: "/jwks"}).String()),
+			&oidc.Config{ClientID: oidcClientID},
+		),
+	})
+	assert.NoError(t, provider.SetDiscoveryExtraFieldValues(nil))
+	assert.Empty(t, provider.GetDiscoveryExtraFieldValues())
+
+	session, err := provider.Redeem(context.Background(), "https://proxy.example.com/oauth2/callback", "code1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789", session.User)
+	assert.Equal(t, defaultIDToken.Email, session.Email)
+	assert.Equal(t, []string{"test:a", "test:b"}, session.Groups)
+	assert.Equal(t, []string{"test:c", "test:d", "app:admin"}, session.Roles)
+
+	assert.NoError(t, provider.EnrichSession(context.Background(), session))
+	assert.True(t, provider.ValidateSession(context.Background(), session))
+
+	claims, err := provider.GetAllClaims(context.Background(), session)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultIDToken.Email, claims["email"])
+
+	refreshed, err := provider.RefreshSession(context.Background(), session)
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+	assert.Equal(t, signedIDToken, session.IDToken)
+
+	bearerSession, err := provider.CreateSessionFromToken(context.Background(), signedIDToken)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultIDToken.Email, bearerSession.Email)
+
+	otherAudience := defaultIDToken
+	otherAudience.Audience = "https://other.myapp.com"
+	session.IDToken = sign(otherAudience)
+	assert.False(t, provider.ValidateSession(context.Background(), session))
+}
+
+func TestOIDCProviderRedeem_codeHash(t *testing.T) {
+	cHashIDToken := defaultIDToken
+	cHashIDToken.AtHash = tokenHash(sha256.New(), accessToken)
+	cHashIDToken.CHash = tokenHash(sha256.New(), "code1234")
+	idToken, _ := newSignedTestIDToken(cHashIDToken)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   10,
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+	})
+
+	server, provider := newTestOIDCSetup(body)
+	defer server.Close()
+
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	assert.NoError(t, err)
+	assert.Equal(t, idToken, session.IDToken)
+
+	_, err = provider.Redeem(context.Background(), provider.RedeemURL.String(), "code5678")
+	assert.EqualError(t, err, "could not verify id_token: id_token hash mismatch: c_hash")
+}
+
+func TestOIDCProviderRedeem_accessTokenRoles(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	accessTokenClaims := defaultIDToken
+	accessTokenClaims.Roles = []string{"test:d", "app:admin"}
+	jwtAccessToken, _ := newSignedTestIDToken(accessTokenClaims)
+
+	testCases := map[string]struct {
+		accessToken      string
+		rolesClaim       string
+		accessTokenRoles bool
+		expectedRoles    []string
+	}{
+		"Roles not extracted without a roles claim": {
+			accessToken:   jwtAccessToken,
+			expectedRoles: nil,
+		},
+		"Roles from the id_token only": {
+			accessToken:   jwtAccessToken,
+			rolesClaim:    "roles",
+			expectedRoles: []string{"test:c", "test:d"},
+		},
+		"Roles merged from the id_token and access token": {
+			accessToken:      jwtAccessToken,
+			rolesClaim:       "roles",
+			accessTokenRoles: true,
+			expectedRoles:    []string{"test:c", "test:d", "app:admin"},
+		},
+		"Opaque access token is skipped": {
+			accessToken:      accessToken,
+			rolesClaim:       "roles",
+			accessTokenRoles: true,
+			expectedRoles:    []string{"test:c", "test:d"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			body, _ := json.Marshal(redeemTokenResponse{
+				AccessToken:  tc.accessToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+				IDToken:      idToken,
+			})
+
+			server, provider := newTestOIDCSetup(body)
+			defer server.Close()
+			provider.RolesClaim = tc.rolesClaim
+			provider.AccessTokenRoles = tc.accessTokenRoles
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedRoles, session.Roles)
+			assert.Equal(t, tc.accessToken, session.AccessToken)
+		})
+	}
+}
+
+func TestOIDCProviderRedeem_accessTokenSubjectClaim(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	accessTokenClaims := defaultIDToken
+	accessTokenClaims.Subject = "service-account-client"
+	jwtAccessToken, _ := newSignedTestIDToken(accessTokenClaims)
+
+	testCases := map[string]struct {
+		accessToken             string
+		accessTokenSubjectClaim string
+		expectedUser            string
+	}{
+		"User from the id_token by default": {
+			accessToken:  jwtAccessToken,
+			expectedUser: "123456789",
+		},
+		"User from the access token subject": {
+			accessToken:             jwtAccessToken,
+			accessTokenSubjectClaim: "sub",
+			expectedUser:            "service-account-client",
+		},
+		"User from another access token claim": {
+			accessToken:             jwtAccessToken,
+			accessTokenSubjectClaim: "preferred_username",
+			expectedUser:            "Jane Dobbs",
+		},
+		"Missing access token claim keeps the id_token user": {
+			accessToken:             jwtAccessToken,
+			accessTokenSubjectClaim: "client_id",
+			expectedUser:            "123456789",
+		},
+		"Opaque access token is skipped": {
+			accessToken:             accessToken,
+			accessTokenSubjectClaim: "sub",
+			expectedUser:            "123456789",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			body, _ := json.Marshal(redeemTokenResponse{
+				AccessToken:  tc.accessToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+				IDToken:      idToken,
+			})
+
+			server, provider := newTestOIDCSetup(body)
+			defer server.Close()
+			provider.AccessTokenSubjectClaim = tc.accessTokenSubjectClaim
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUser, session.User)
+			assert.Equal(t, "janed@me.com", session.Email)
+		})
+	}
+}
+
+func TestOIDCProviderRedeem_groupsFromAccessToken(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	accessTokenClaims := defaultIDToken
+	accessTokenClaims.Groups = []string{"test:b", "azure:admins"}
+	// The access token is meant for the resource server, so it is signed
+	// with a key the provider can't verify
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwtAccessToken, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, accessTokenClaims).SignedString(otherKey)
+
+	testCases := map[string]struct {
+		accessToken           string
+		groupsFromAccessToken bool
+		expectedGroups        []string
+	}{
+		"Groups from the id_token by default": {
+			accessToken:    jwtAccessToken,
+			expectedGroups: []string{"test:a", "test:b"},
+		},
+		"Groups merged from the id_token and access token": {
+			accessToken:           jwtAccessToken,
+			groupsFromAccessToken: true,
+			expectedGroups:        []string{"test:a", "test:b", "azure:admins"},
+		},
+		"Opaque access token is skipped": {
+			accessToken:           accessToken,
+			groupsFromAccessToken: true,
+			expectedGroups:        []string{"test:a", "test:b"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			body, _ := json.Marshal(redeemTokenResponse{
+				AccessToken:  tc.accessToken,
+				ExpiresIn:    10,
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+				IDToken:      idToken,
+			})
+
+			server, provider := newTestOIDCSetup(body)
+			defer server.Close()
+			provider.GroupsFromAccessToken = tc.groupsFromAccessToken
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedGroups, session.Groups)
+		})
+	}
+}
+
 func TestOIDCProviderRedeem_custom_userid(t *testing.T) {
 	idToken, _ := newSignedTestIDToken(defaultIDToken)
 	body, _ := json.Marshal(redeemTokenResponse{
@@ -142,10 +427,120 @@ func TestOIDCProvider_EnrichSession(t *testing.T) {
 		ExistingSession *sessions.SessionState
 		EmailClaim      string
 		GroupsClaim     string
+		ClaimPrecedence string
+		PreferNonEmpty  bool
+		GroupsRequired  bool
 		ProfileJSON     map[string]interface{}
 		ExpectedError   error
 		ExpectedSession *sessions.SessionState
 	}{
+		"Required Groups From Profile URL": {
+			ExistingSession: &sessions.SessionState{
+				User:        "missing.groups",
+				Email:       "already@populated.com",
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			GroupsRequired: true,
+			ProfileJSON: map[string]interface{}{
+				"groups": []string{"new", "thing"},
+			},
+			ExpectedSession: &sessions.SessionState{
+				User:        "missing.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{"new", "thing"},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+		},
+		"Required Groups Missing From Profile URL": {
+			ExistingSession: &sessions.SessionState{
+				User:        "missing.groups",
+				Email:       "already@populated.com",
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			GroupsRequired: true,
+			ProfileJSON: map[string]interface{}{
+				"email": "new@thing.com",
+			},
+			ExpectedError: newClaimError(ErrMissingGroupsClaim, nil, "neither the id_token nor the profileURL had a groups claim"),
+			ExpectedSession: &sessions.SessionState{
+				User:        "missing.groups",
+				Email:       "already@populated.com",
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+		},
+		"Empty Groups Array": {
+			ExistingSession: &sessions.SessionState{
+				User:        "empty.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			ProfileJSON: map[string]interface{}{
+				"groups": []string{"new", "thing"},
+			},
+			ExpectedSession: &sessions.SessionState{
+				User:        "empty.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+		},
+		"Empty Groups Array Prefers Non Empty": {
+			ExistingSession: &sessions.SessionState{
+				User:        "empty.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			PreferNonEmpty: true,
+			ProfileJSON: map[string]interface{}{
+				"groups": []string{"new", "thing"},
+			},
+			ExpectedSession: &sessions.SessionState{
+				User:        "empty.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{"new", "thing"},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+		},
+		"Empty Groups String Prefers Non Empty": {
+			ExistingSession: &sessions.SessionState{
+				User:        "empty.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{""},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			PreferNonEmpty: true,
+			ProfileJSON: map[string]interface{}{
+				"groups": "new",
+			},
+			ExpectedSession: &sessions.SessionState{
+				User:        "empty.groups",
+				Email:       "already@populated.com",
+				Groups:      []string{"new"},
+				IDToken:     idToken,
+				AccessToken: accessToken,
+			},
+		},
 		"Already Populated": {
 			ExistingSession: &sessions.SessionState{
 				User:         "already",
@@ -443,6 +838,55 @@ func TestOIDCProvider_EnrichSession(t *testing.T) {
 				RefreshToken: refreshToken,
 			},
 		},
+		"Already Populated with Userinfo First": {
+			ExistingSession: &sessions.SessionState{
+				User:         "already",
+				Email:        "already@populated.com",
+				Groups:       []string{"already", "populated"},
+				IDToken:      idToken,
+				AccessToken:  accessToken,
+				RefreshToken: refreshToken,
+			},
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ClaimPrecedence: ClaimPrecedenceUserinfoFirst,
+			ProfileJSON: map[string]interface{}{
+				"email":  "new@thing.com",
+				"groups": []string{"new", "thing"},
+			},
+			ExpectedError: nil,
+			ExpectedSession: &sessions.SessionState{
+				User:         "already",
+				Email:        "new@thing.com",
+				Groups:       []string{"new", "thing"},
+				IDToken:      idToken,
+				AccessToken:  accessToken,
+				RefreshToken: refreshToken,
+			},
+		},
+		"Userinfo First with Missing Profile Claims": {
+			ExistingSession: &sessions.SessionState{
+				User:         "already",
+				Email:        "already@populated.com",
+				Groups:       []string{"already", "populated"},
+				IDToken:      idToken,
+				AccessToken:  accessToken,
+				RefreshToken: refreshToken,
+			},
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ClaimPrecedence: ClaimPrecedenceUserinfoFirst,
+			ProfileJSON:     map[string]interface{}{},
+			ExpectedError:   nil,
+			ExpectedSession: &sessions.SessionState{
+				User:         "already",
+				Email:        "already@populated.com",
+				Groups:       []string{"already", "populated"},
+				IDToken:      idToken,
+				AccessToken:  accessToken,
+				RefreshToken: refreshToken,
+			},
+		},
 	}
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
@@ -455,6 +899,9 @@ func TestOIDCProvider_EnrichSession(t *testing.T) {
 
 			provider.EmailClaim = tc.EmailClaim
 			provider.GroupsClaim = tc.GroupsClaim
+			provider.ClaimPrecedence = tc.ClaimPrecedence
+			provider.PreferNonEmptyClaims = tc.PreferNonEmpty
+			provider.GroupsClaimRequired = tc.GroupsRequired
 			defer server.Close()
 
 			err = provider.EnrichSession(context.Background(), tc.ExistingSession)
@@ -464,6 +911,786 @@ func TestOIDCProvider_EnrichSession(t *testing.T) {
 	}
 }
 
+func TestOIDCProvider_EnrichSessionClaimRuleSource(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	redeemBody, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+	profileBody, _ := json.Marshal(map[string]interface{}{
+		"phone_number": "+4712345678",
+		"login":        "janed",
+		"groups":       []string{"profile:a"},
+		"roles":        []string{"profile:c"},
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("content-type", "application/json")
+		if req.URL.Path == "/profile" {
+			_, _ = rw.Write(profileBody)
+			return
+		}
+		_, _ = rw.Write(redeemBody)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	// Groups and the user always come from the profile URL and roles from
+	// the id_token, the phone number follows the ClaimPrecedence
+	plan, err := CompileClaimPlan([]ClaimRule{
+		{Claim: "groups", Target: "groups", Source: ClaimRuleSourceProfile},
+		{Claim: "login", Target: "user", Source: ClaimRuleSourceProfile},
+		{Claim: "roles", Target: "roles", Source: ClaimRuleSourceToken},
+		{Claim: "phone_number", Target: "phone"},
+	})
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		ClaimPrecedence string
+		ExpectedPhone   string
+	}{
+		"ID Token First": {
+			ClaimPrecedence: ClaimPrecedenceIDTokenFirst,
+			ExpectedPhone:   "+4798765432",
+		},
+		"Userinfo First": {
+			ClaimPrecedence: ClaimPrecedenceUserinfoFirst,
+			ExpectedPhone:   "+4712345678",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			provider := newOIDCProvider(serverURL)
+			provider.ClaimPrecedence = tc.ClaimPrecedence
+			provider.ClaimPlan = plan
+			provider.SubjectPrefix = "corp"
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"test:a", "test:b"}, session.Groups)
+			assert.Equal(t, "corp|123456789", session.User)
+
+			assert.NoError(t, provider.EnrichSession(context.Background(), session))
+			assert.Equal(t, []string{"profile:a"}, session.Groups)
+			assert.Equal(t, []string{"test:c", "test:d"}, session.Roles)
+			assert.Equal(t, tc.ExpectedPhone, session.Extra["phone"])
+			assert.Equal(t, "corp|janed", session.User)
+		})
+	}
+}
+
+func TestOIDCProvider_EnrichSessionUnverifiedEmailCleared(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(unverifiedIDToken)
+	redeemBody, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+	profileBody, _ := json.Marshal(map[string]interface{}{
+		"email": "profile@email.com",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("content-type", "application/json")
+		if req.URL.Path == "/profile" {
+			_, _ = rw.Write(profileBody)
+			return
+		}
+		_, _ = rw.Write(redeemBody)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	testCases := map[string]struct {
+		ProfileURL      bool
+		ClaimPrecedence string
+	}{
+		"Without A Profile URL": {},
+		"With A Profile URL": {
+			ProfileURL: true,
+		},
+		"With Userinfo First": {
+			ProfileURL:      true,
+			ClaimPrecedence: ClaimPrecedenceUserinfoFirst,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			provider := newOIDCProvider(serverURL)
+			provider.RequireVerifiedEmailOrEmpty = true
+			provider.ClaimPrecedence = tc.ClaimPrecedence
+			if !tc.ProfileURL {
+				provider.ProfileURL = &url.URL{}
+			}
+
+			session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+			assert.NoError(t, err)
+			assert.Equal(t, "", session.Email)
+
+			// The cleared email is neither required nor filled in from the profile
+			assert.NoError(t, provider.EnrichSession(context.Background(), session))
+			assert.Equal(t, "", session.Email)
+			assert.Equal(t, "123456789", session.User)
+		})
+	}
+}
+
+func TestOIDCProvider_EnrichSessionWithProfileCache(t *testing.T) {
+	var profileRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&profileRequests, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"email": "new@thing.com", "groups": ["new", "thing"]}`))
+	}))
+	defer server.Close()
+
+	provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+	profileURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	provider.ProfileURL = profileURL
+	provider.ProfileCache = NewProfileCache(time.Minute, 10)
+
+	for _, token := range []string{accessToken, accessToken, "another-access-token"} {
+		session := &sessions.SessionState{AccessToken: token}
+		err = provider.EnrichSession(context.Background(), session)
+		assert.NoError(t, err)
+		assert.Equal(t, "new@thing.com", session.Email)
+		assert.Equal(t, []string{"new", "thing"}, session.Groups)
+	}
+
+	// The second request for the same access token was served from the cache
+	assert.Equal(t, int32(2), atomic.LoadInt32(&profileRequests))
+}
+
+func TestOIDCProvider_EnrichSessionWithProfileFetchPredicate(t *testing.T) {
+	var profileRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&profileRequests, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"email": "new@thing.com", "groups": ["new", "thing"]}`))
+	}))
+	defer server.Close()
+
+	provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+	profileURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	provider.ProfileURL = profileURL
+	provider.ProfileFetchPredicate, err = NewUserAgentProfileFetchPredicate([]string{"^curl/"})
+	assert.NoError(t, err)
+
+	// API clients skip the profile fetch, keeping the id_token claims
+	apiReq := httptest.NewRequest(http.MethodGet, "/oauth2/callback", nil)
+	apiReq.Header.Set("User-Agent", "curl/7.68.0")
+	session := &sessions.SessionState{AccessToken: accessToken, Email: "janed@me.com"}
+	err = provider.EnrichSession(ContextWithRequest(context.Background(), apiReq), session)
+	assert.NoError(t, err)
+	assert.Equal(t, "janed@me.com", session.Email)
+	assert.Nil(t, session.Groups)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&profileRequests))
+
+	// Browsers fetch the profile
+	browserReq := httptest.NewRequest(http.MethodGet, "/oauth2/callback", nil)
+	browserReq.Header.Set("User-Agent", "Mozilla/5.0")
+	session = &sessions.SessionState{AccessToken: accessToken, Email: "janed@me.com"}
+	err = provider.EnrichSession(ContextWithRequest(context.Background(), browserReq), session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new", "thing"}, session.Groups)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&profileRequests))
+
+	// API clients still fetch the profile when the email is missing
+	session = &sessions.SessionState{AccessToken: accessToken}
+	err = provider.EnrichSession(ContextWithRequest(context.Background(), apiReq), session)
+	assert.NoError(t, err)
+	assert.Equal(t, "new@thing.com", session.Email)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&profileRequests))
+
+	// Or when the groups authorize the login
+	provider.AllowedGroups = map[string]struct{}{"new": {}}
+	session = &sessions.SessionState{AccessToken: accessToken, Email: "janed@me.com"}
+	err = provider.EnrichSession(ContextWithRequest(context.Background(), apiReq), session)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new", "thing"}, session.Groups)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&profileRequests))
+}
+
+func TestOIDCProvider_RequireGroupsSubsetOfFailsClosed(t *testing.T) {
+	testCases := map[string]struct {
+		profileStatus   int
+		userAgent       string
+		expectedAllowed bool
+	}{
+		"Profile fetch succeeds": {
+			profileStatus:   http.StatusOK,
+			userAgent:       "Mozilla/5.0",
+			expectedAllowed: true,
+		},
+		"Profile fetch fails": {
+			profileStatus:   http.StatusInternalServerError,
+			userAgent:       "Mozilla/5.0",
+			expectedAllowed: false,
+		},
+		"Profile fetch not skipped for API clients": {
+			profileStatus:   http.StatusOK,
+			userAgent:       "curl/7.68.0",
+			expectedAllowed: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(tc.profileStatus)
+				rw.Write([]byte(`{"email": "janed@me.com", "groups": ["admins"]}`))
+			}))
+			defer server.Close()
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			profileURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			provider.ProfileURL = profileURL
+			provider.ProfileURLMaxRetries = -1
+			provider.RequireGroupsSubsetOf = []string{"admins"}
+			provider.ProfileFetchPredicate, err = NewUserAgentProfileFetchPredicate([]string{"^curl/"})
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			ctx := ContextWithRequest(context.Background(), req)
+
+			// The id_token carries no groups, so they must come from the profile
+			session := &sessions.SessionState{AccessToken: accessToken, Email: "janed@me.com"}
+			assert.NoError(t, provider.EnrichSession(ctx, session))
+
+			allowed, err := provider.Authorize(ctx, session)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedAllowed, allowed)
+		})
+	}
+}
+
+func TestOIDCProvider_EnrichSessionRetriesProfileURL(t *testing.T) {
+	testCases := map[string]struct {
+		FailureStatus    int
+		Failures         int32
+		MaxRetries       int
+		ExpectedRequests int32
+		ExpectedError    bool
+	}{
+		"Retries Server Errors": {
+			FailureStatus:    http.StatusServiceUnavailable,
+			Failures:         2,
+			ExpectedRequests: 3,
+		},
+		"Retries Connection Errors": {
+			FailureStatus:    0,
+			Failures:         2,
+			ExpectedRequests: 3,
+		},
+		"Retries Rate Limiting": {
+			FailureStatus:    http.StatusTooManyRequests,
+			Failures:         1,
+			ExpectedRequests: 2,
+		},
+		"Gives Up After Max Retries": {
+			FailureStatus:    http.StatusInternalServerError,
+			Failures:         10,
+			MaxRetries:       2,
+			ExpectedRequests: 3,
+			ExpectedError:    true,
+		},
+		"Does Not Retry Unauthorized": {
+			FailureStatus:    http.StatusUnauthorized,
+			Failures:         1,
+			ExpectedRequests: 1,
+			ExpectedError:    true,
+		},
+		"Does Not Retry Forbidden": {
+			FailureStatus:    http.StatusForbidden,
+			Failures:         1,
+			ExpectedRequests: 1,
+			ExpectedError:    true,
+		},
+		"Retries Disabled": {
+			FailureStatus:    http.StatusBadGateway,
+			Failures:         1,
+			MaxRetries:       -1,
+			ExpectedRequests: 1,
+			ExpectedError:    true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			var profileRequests int32
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				if atomic.AddInt32(&profileRequests, 1) <= tc.Failures {
+					if tc.FailureStatus == 0 {
+						// Drop the connection without a response
+						conn, _, _ := rw.(http.Hijacker).Hijack()
+						conn.Close()
+						return
+					}
+					rw.WriteHeader(tc.FailureStatus)
+					return
+				}
+				rw.Header().Set("Content-Type", "application/json")
+				rw.Write([]byte(`{"email": "new@thing.com", "groups": ["new", "thing"]}`))
+			}))
+			defer server.Close()
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			profileURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			provider.ProfileURL = profileURL
+			provider.ProfileURLMaxRetries = tc.MaxRetries
+			provider.ProfileURLRetryBackoff = time.Millisecond
+
+			session := &sessions.SessionState{AccessToken: accessToken}
+			err = provider.EnrichSession(context.Background(), session)
+			if tc.ExpectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new@thing.com", session.Email)
+			}
+			assert.Equal(t, tc.ExpectedRequests, atomic.LoadInt32(&profileRequests))
+		})
+	}
+}
+
+func TestOIDCProvider_EnrichSessionProfileURLTimeout(t *testing.T) {
+	testCases := map[string]struct {
+		Delay          time.Duration
+		Timeout        time.Duration
+		RequestTimeout time.Duration
+		ExpectedError  bool
+	}{
+		"Responds Within The Timeout": {
+			Delay:   10 * time.Millisecond,
+			Timeout: time.Second,
+		},
+		"Abandons A Slow Response": {
+			Delay:         time.Second,
+			Timeout:       50 * time.Millisecond,
+			ExpectedError: true,
+		},
+		"Earlier Request Deadline Takes Precedence": {
+			Delay:          time.Second,
+			Timeout:        time.Second,
+			RequestTimeout: 50 * time.Millisecond,
+			ExpectedError:  true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			done := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				select {
+				case <-time.After(tc.Delay):
+				case <-done:
+					return
+				}
+				rw.Header().Set("Content-Type", "application/json")
+				rw.Write([]byte(`{"email": "new@thing.com"}`))
+			}))
+			defer server.Close()
+			defer close(done)
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			profileURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			provider.ProfileURL = profileURL
+			provider.ProfileURLTimeout = tc.Timeout
+
+			ctx := context.Background()
+			if tc.RequestTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.RequestTimeout)
+				defer cancel()
+			}
+
+			session := &sessions.SessionState{AccessToken: accessToken}
+			start := time.Now()
+			err = provider.EnrichSession(ctx, session)
+			if tc.ExpectedError {
+				assert.Error(t, err)
+				assert.Less(t, int64(time.Since(start)), int64(tc.Delay))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new@thing.com", session.Email)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_getProfileClaimExtractionTimeout(t *testing.T) {
+	testCases := map[string]struct {
+		Delay             time.Duration
+		Status            int
+		ExtractionTimeout time.Duration
+		RequestTimeout    time.Duration
+		ExpectedError     error
+	}{
+		"Responds Within The Timeout": {
+			Delay:             10 * time.Millisecond,
+			Status:            http.StatusOK,
+			ExtractionTimeout: time.Second,
+		},
+		"Abandons A Slow Response Within The Request Timeout": {
+			Delay:             time.Second,
+			Status:            http.StatusOK,
+			ExtractionTimeout: 50 * time.Millisecond,
+			ExpectedError:     ErrClaimExtractionTimeout,
+		},
+		"Abandons Retries Within The Request Timeout": {
+			Status:            http.StatusServiceUnavailable,
+			ExtractionTimeout: 50 * time.Millisecond,
+			ExpectedError:     ErrClaimExtractionTimeout,
+		},
+		"Request Timeout Fires First": {
+			Delay:             time.Second,
+			Status:            http.StatusOK,
+			ExtractionTimeout: time.Second,
+			RequestTimeout:    50 * time.Millisecond,
+			ExpectedError:     context.DeadlineExceeded,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			done := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				select {
+				case <-time.After(tc.Delay):
+				case <-done:
+					return
+				}
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(tc.Status)
+				rw.Write([]byte(`{"email": "new@thing.com"}`))
+			}))
+			defer server.Close()
+			defer close(done)
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			profileURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			provider.ProfileURL = profileURL
+			provider.ProfileURLRetryBackoff = time.Second
+			provider.ClaimExtractionTimeout = tc.ExtractionTimeout
+
+			ctx := context.Background()
+			if tc.RequestTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.RequestTimeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			profile, err := provider.getProfile(ctx, accessToken)
+			if tc.ExpectedError != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tc.ExpectedError == ErrClaimExtractionTimeout, errors.Is(err, ErrClaimExtractionTimeout))
+				assert.Less(t, int64(time.Since(start)), int64(time.Second))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new@thing.com", profile["email"])
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_getProfileNonObjectJSON(t *testing.T) {
+	testCases := map[string]struct {
+		Response      string
+		UnwrapArray   bool
+		ExpectedError string
+	}{
+		"Object": {
+			Response: `{"email": "new@thing.com"}`,
+		},
+		"Bare String": {
+			Response:      `"new@thing.com"`,
+			ExpectedError: "profile URL response is a JSON string, not an object",
+		},
+		"Bare Number": {
+			Response:      `42`,
+			ExpectedError: "profile URL response is a JSON number, not an object",
+		},
+		"Array": {
+			Response:      `[{"email": "new@thing.com"}]`,
+			ExpectedError: "profile URL response is a JSON array, not an object",
+		},
+		"Array Of One Object Unwrapped": {
+			Response:    `[{"email": "new@thing.com"}]`,
+			UnwrapArray: true,
+		},
+		"Array Of Many Objects Not Unwrapped": {
+			Response:      `[{"email": "new@thing.com"}, {"email": "other@thing.com"}]`,
+			UnwrapArray:   true,
+			ExpectedError: "profile URL response is a JSON array, not an object",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.Write([]byte(tc.Response))
+			}))
+			defer server.Close()
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			profileURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			provider.ProfileURL = profileURL
+			provider.ProfileURLUnwrapArray = tc.UnwrapArray
+
+			profile, err := provider.getProfile(context.Background(), accessToken)
+			if tc.ExpectedError != "" {
+				assert.EqualError(t, err, tc.ExpectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new@thing.com", profile["email"])
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_EnrichSessionProfileURLFailover(t *testing.T) {
+	newProfileServer := func(status int, requests *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			atomic.AddInt32(requests, 1)
+			if status != http.StatusOK {
+				rw.WriteHeader(status)
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Write([]byte(`{"email": "new@thing.com", "groups": ["new", "thing"]}`))
+		}))
+	}
+	parseURL := func(rawURL string) *url.URL {
+		u, err := url.Parse(rawURL)
+		assert.NoError(t, err)
+		return u
+	}
+
+	var primaryRequests, unauthorizedRequests, replicaRequests int32
+	primary := newProfileServer(http.StatusServiceUnavailable, &primaryRequests)
+	defer primary.Close()
+	unauthorized := newProfileServer(http.StatusUnauthorized, &unauthorizedRequests)
+	defer unauthorized.Close()
+	replica := newProfileServer(http.StatusOK, &replicaRequests)
+	defer replica.Close()
+	// Nothing listens on a closed server, giving a network error
+	closed := newProfileServer(http.StatusOK, new(int32))
+	closed.Close()
+
+	testCases := map[string]struct {
+		ProfileURLs   []*url.URL
+		ExpectedError bool
+	}{
+		"Fails Over On Server Error": {
+			ProfileURLs: []*url.URL{parseURL(primary.URL), parseURL(replica.URL)},
+		},
+		"Fails Over On Network Error": {
+			ProfileURLs: []*url.URL{parseURL(closed.URL), parseURL(replica.URL)},
+		},
+		"Does Not Fail Over On Client Error": {
+			ProfileURLs:   []*url.URL{parseURL(unauthorized.URL), parseURL(replica.URL)},
+			ExpectedError: true,
+		},
+		"Fails When All Profile URLs Fail": {
+			ProfileURLs:   []*url.URL{parseURL(closed.URL), parseURL(primary.URL)},
+			ExpectedError: true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			atomic.StoreInt32(&replicaRequests, 0)
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			provider.ProfileURL = tc.ProfileURLs[0]
+			provider.ProfileURLs = tc.ProfileURLs
+			provider.ProfileURLMaxRetries = -1
+
+			session := &sessions.SessionState{AccessToken: accessToken}
+			err := provider.EnrichSession(context.Background(), session)
+			if tc.ExpectedError {
+				assert.Error(t, err)
+				assert.Equal(t, int32(0), atomic.LoadInt32(&replicaRequests))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new@thing.com", session.Email)
+				assert.Equal(t, int32(1), atomic.LoadInt32(&replicaRequests))
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_getSessionProfileTokenRefresh(t *testing.T) {
+	const freshAccessToken = "fresh.access.token"
+
+	testCases := map[string]struct {
+		Refresher        func(ctx context.Context, s *sessions.SessionState) error
+		ExpectedError    error
+		ExpectedRequests int32
+	}{
+		"Retries After Refresh": {
+			Refresher: func(_ context.Context, s *sessions.SessionState) error {
+				s.AccessToken = freshAccessToken
+				return nil
+			},
+			ExpectedRequests: 2,
+		},
+		"Does Not Retry Without A Refresher": {
+			ExpectedError:    ErrProfileURLUnauthorized,
+			ExpectedRequests: 1,
+		},
+		"Fails When The Refresh Fails": {
+			Refresher: func(_ context.Context, _ *sessions.SessionState) error {
+				return errors.New("refresh token expired")
+			},
+			ExpectedError:    errors.New("unable to refresh the access token for the profile URL: refresh token expired"),
+			ExpectedRequests: 1,
+		},
+		"Retries Only Once": {
+			Refresher: func(_ context.Context, s *sessions.SessionState) error {
+				s.AccessToken = "still.expired.token"
+				return nil
+			},
+			ExpectedError:    ErrProfileURLUnauthorized,
+			ExpectedRequests: 2,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			var profileRequests int32
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&profileRequests, 1)
+				if req.Header.Get("Authorization") != "Bearer "+freshAccessToken {
+					rw.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				rw.Header().Set("Content-Type", "application/json")
+				rw.Write([]byte(`{"email": "new@thing.com"}`))
+			}))
+			defer server.Close()
+
+			provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+			profileURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+			provider.ProfileURL = profileURL
+			provider.ProfileURLTokenRefresher = tc.Refresher
+
+			session := &sessions.SessionState{AccessToken: accessToken}
+			profile, err := provider.getSessionProfile(context.Background(), session)
+			if tc.ExpectedError != nil {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.ExpectedError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "new@thing.com", profile["email"])
+				assert.Equal(t, freshAccessToken, session.AccessToken)
+			}
+			assert.Equal(t, tc.ExpectedRequests, atomic.LoadInt32(&profileRequests))
+		})
+	}
+}
+
+func TestOIDCProvider_RefreshAccessToken(t *testing.T) {
+	server, provider := newTestOIDCSetup([]byte(`{"access_token": "fresh.access.token", "refresh_token": "new.refresh.token", "token_type": "Bearer", "expires_in": 3600}`))
+	defer server.Close()
+
+	session := &sessions.SessionState{AccessToken: accessToken}
+	err := provider.RefreshAccessToken(context.Background(), session)
+	assert.Error(t, err)
+	assert.Equal(t, accessToken, session.AccessToken)
+
+	session.RefreshToken = refreshToken
+	err = provider.RefreshAccessToken(context.Background(), session)
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh.access.token", session.AccessToken)
+	assert.Equal(t, "new.refresh.token", session.RefreshToken)
+}
+
+func TestOIDCProvider_EnrichSessionSavesRawClaims(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"email": "new@thing.com", "department": "engineering"}`))
+	}))
+	defer server.Close()
+
+	provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+	profileURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	provider.ProfileURL = profileURL
+	provider.SaveRawClaims = true
+
+	session := &sessions.SessionState{
+		AccessToken: accessToken,
+		RawClaims:   []byte(`{"email": "janed@me.com", "sub": "123456789"}`),
+	}
+	err = provider.EnrichSession(context.Background(), session)
+	assert.NoError(t, err)
+
+	var rawClaims map[string]interface{}
+	assert.NoError(t, json.Unmarshal(session.RawClaims, &rawClaims))
+	assert.Equal(t, map[string]interface{}{
+		"email":      "janed@me.com",
+		"sub":        "123456789",
+		"department": "engineering",
+	}, rawClaims)
+}
+
+func TestOIDCProvider_GetAllClaims(t *testing.T) {
+	var profileRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&profileRequests, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"email": "new@thing.com", "department": "engineering"}`))
+	}))
+	defer server.Close()
+
+	idToken, err := newSignedTestIDToken(defaultIDToken)
+	assert.NoError(t, err)
+	session := &sessions.SessionState{AccessToken: accessToken, IDToken: idToken}
+
+	t.Run("without a profile URL", func(t *testing.T) {
+		provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+		provider.ProfileURL = &url.URL{}
+
+		claims, err := provider.GetAllClaims(context.Background(), session)
+		assert.NoError(t, err)
+		assert.Equal(t, "janed@me.com", claims["email"])
+		assert.Equal(t, "123456789", claims["sub"])
+		assert.NotContains(t, claims, "department")
+		assert.Equal(t, int32(0), atomic.LoadInt32(&profileRequests))
+	})
+
+	t.Run("with a profile URL", func(t *testing.T) {
+		provider := newOIDCProvider(&url.URL{Scheme: "https", Host: "oauth2proxy.oidctest"})
+		profileURL, err := url.Parse(server.URL)
+		assert.NoError(t, err)
+		provider.ProfileURL = profileURL
+		provider.ProfileCache = NewProfileCache(time.Minute, 0)
+
+		claims, err := provider.GetAllClaims(context.Background(), session)
+		assert.NoError(t, err)
+		assert.Equal(t, "janed@me.com", claims["email"])
+		assert.Equal(t, "engineering", claims["department"])
+		assert.Equal(t, int32(1), atomic.LoadInt32(&profileRequests))
+
+		// The result is a copy, modifying it doesn't affect later calls
+		claims["department"] = "sales"
+		claims, err = provider.GetAllClaims(context.Background(), session)
+		assert.NoError(t, err)
+		assert.Equal(t, "engineering", claims["department"])
+		assert.Equal(t, int32(1), atomic.LoadInt32(&profileRequests))
+	})
+}
+
 func TestOIDCProviderRefreshSessionIfNeededWithoutIdToken(t *testing.T) {
 
 	idToken, _ := newSignedTestIDToken(defaultIDToken)
@@ -530,6 +1757,158 @@ func TestOIDCProviderRefreshSessionIfNeededWithIdToken(t *testing.T) {
 	assert.Equal(t, refreshToken, existingSession.RefreshToken)
 }
 
+func TestOIDCProviderRefreshSessionWithChangedGroups(t *testing.T) {
+	idToken, _ := newSignedTestIDToken(defaultIDToken)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	testCases := map[string]struct {
+		ExistingGroups                []string
+		GroupChangeInvalidatesSession bool
+		ExpectedError                 error
+	}{
+		"Groups unchanged": {
+			ExistingGroups:                []string{"test:b", "test:a"},
+			GroupChangeInvalidatesSession: true,
+			ExpectedError:                 nil,
+		},
+		"Groups changed": {
+			ExistingGroups:                []string{"test:a"},
+			GroupChangeInvalidatesSession: true,
+			ExpectedError:                 ErrGroupMembershipChanged,
+		},
+		"Groups changed without invalidation": {
+			ExistingGroups:                []string{"test:a"},
+			GroupChangeInvalidatesSession: false,
+			ExpectedError:                 nil,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			server, provider := newTestOIDCSetup(body)
+			provider.GroupChangeInvalidatesSession = tc.GroupChangeInvalidatesSession
+			defer server.Close()
+
+			existingSession := &sessions.SessionState{
+				AccessToken:  "changeit",
+				IDToken:      "changeit",
+				RefreshToken: refreshToken,
+				Email:        "changeit",
+				User:         "changeit",
+				Groups:       tc.ExistingGroups,
+			}
+			refreshed, err := provider.RefreshSession(context.Background(), existingSession)
+			if tc.ExpectedError != nil {
+				assert.True(t, errors.Is(err, tc.ExpectedError))
+				assert.False(t, refreshed)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, refreshed)
+			assert.Equal(t, defaultIDToken.Groups, existingSession.Groups)
+		})
+	}
+}
+
+func TestOIDCProviderRefreshSessionWithProfileURLGroups(t *testing.T) {
+	tokenWithoutGroups := defaultIDToken
+	tokenWithoutGroups.Groups = nil
+	idToken, _ := newSignedTestIDToken(tokenWithoutGroups)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	testCases := map[string]struct {
+		ExistingGroups []string
+		ExpectedError  error
+	}{
+		"Profile groups unchanged": {
+			ExistingGroups: []string{"profile:a", "profile:b"},
+		},
+		"Profile groups changed": {
+			ExistingGroups: []string{"profile:a"},
+			ExpectedError:  ErrGroupMembershipChanged,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				if req.URL.Path == "/profile" {
+					_, _ = rw.Write([]byte(`{"groups": ["profile:a", "profile:b"]}`))
+					return
+				}
+				_, _ = rw.Write(body)
+			}))
+			defer server.Close()
+			serverURL, _ := url.Parse(server.URL)
+			provider := newOIDCProvider(serverURL)
+			provider.GroupChangeInvalidatesSession = true
+
+			existingSession := &sessions.SessionState{
+				AccessToken:  "changeit",
+				IDToken:      "changeit",
+				RefreshToken: refreshToken,
+				Email:        defaultIDToken.Email,
+				User:         defaultIDToken.Subject,
+				Groups:       tc.ExistingGroups,
+			}
+			refreshed, err := provider.RefreshSession(context.Background(), existingSession)
+			if tc.ExpectedError != nil {
+				assert.True(t, errors.Is(err, tc.ExpectedError))
+				assert.False(t, refreshed)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, refreshed)
+			assert.Equal(t, []string{"profile:a", "profile:b"}, existingSession.Groups)
+		})
+	}
+}
+
+func TestOIDCProviderRefreshSessionUpdatesClaims(t *testing.T) {
+	refreshedToken := defaultIDToken
+	refreshedToken.OrgID = "new-org"
+	refreshedToken.CostCenter = "new-cost-center"
+	idToken, _ := newSignedTestIDToken(refreshedToken)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	server, provider := newTestOIDCSetup(body)
+	defer server.Close()
+	provider.ActiveOrgClaim = "org_id"
+	provider.CostCenterClaim = "cost_center"
+
+	existingSession := &sessions.SessionState{
+		AccessToken:  "changeit",
+		IDToken:      "changeit",
+		RefreshToken: refreshToken,
+		Email:        defaultIDToken.Email,
+		User:         defaultIDToken.Subject,
+		ActiveOrg:    "old-org",
+		CostCenter:   "old-cost-center",
+	}
+	refreshed, err := provider.RefreshSession(context.Background(), existingSession)
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+	assert.Equal(t, "new-org", existingSession.ActiveOrg)
+	assert.Equal(t, "new-cost-center", existingSession.CostCenter)
+}
+
 func TestOIDCProviderCreateSessionFromToken(t *testing.T) {
 	testCases := map[string]struct {
 		IDToken        idTokenClaims
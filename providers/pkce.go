@@ -0,0 +1,103 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// PKCEMethodS256 sends the base64url encoded SHA-256 hash of the code
+	// verifier as the code challenge
+	PKCEMethodS256 = "S256"
+	// PKCEMethodPlain sends the code verifier itself as the code challenge,
+	// for identity providers that don't support S256
+	PKCEMethodPlain = "plain"
+
+	// pkceVerifierLength is the number of random bytes in a code verifier,
+	// giving a 43 character verifier once encoded
+	pkceVerifierLength = 32
+)
+
+type codeVerifierContextKey struct{}
+
+// NewCodeVerifier generates a random PKCE code verifier (RFC 7636 section
+// 4.1), base64url encoded without padding
+func NewCodeVerifier() (string, error) {
+	b := make([]byte, pkceVerifierLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate code verifier: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ContextWithCodeVerifier stores the PKCE code verifier of the login being
+// completed in the context, so that Redeem can send it to the identity
+// provider
+func ContextWithCodeVerifier(ctx context.Context, verifier string) context.Context {
+	return context.WithValue(ctx, codeVerifierContextKey{}, verifier)
+}
+
+// codeVerifierFromContext returns the PKCE code verifier stored in the
+// context, or an empty string if there is none
+func codeVerifierFromContext(ctx context.Context) string {
+	verifier, _ := ctx.Value(codeVerifierContextKey{}).(string)
+	return verifier
+}
+
+// GetPKCEMethod returns the PKCE code challenge method, defaulting to S256
+// when unset
+func (p *ProviderData) GetPKCEMethod() string {
+	if p.PKCEMethod == "" {
+		return PKCEMethodS256
+	}
+	return p.PKCEMethod
+}
+
+// AddCodeChallenge adds the PKCE `code_challenge` and
+// `code_challenge_method` derived from the code verifier to the login URL.
+// The login URL is returned unchanged if PKCE is disabled.
+func (p *ProviderData) AddCodeChallenge(loginURL, verifier string) string {
+	if !p.PKCEEnabled {
+		return loginURL
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return loginURL
+	}
+
+	challenge := verifier
+	method := p.GetPKCEMethod()
+	if method == PKCEMethodS256 {
+		sum := sha256.Sum256([]byte(verifier))
+		challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	params := u.Query()
+	params.Set("code_challenge", challenge)
+	params.Set("code_challenge_method", method)
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// addCodeVerifier adds the PKCE code verifier in the context to the token
+// redemption parameters, if there is one
+func addCodeVerifier(ctx context.Context, params url.Values) {
+	if verifier := codeVerifierFromContext(ctx); verifier != "" {
+		params.Add("code_verifier", verifier)
+	}
+}
+
+// codeVerifierOptions returns the options to send the PKCE code verifier in
+// the context with an oauth2.Config Exchange, if there is one
+func codeVerifierOptions(ctx context.Context) []oauth2.AuthCodeOption {
+	if verifier := codeVerifierFromContext(ctx); verifier != "" {
+		return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", verifier)}
+	}
+	return nil
+}
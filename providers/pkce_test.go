@@ -0,0 +1,204 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// newPKCEServer creates a mock authorization server that issues codes bound
+// to the code challenge of the authorization request, and only redeems them
+// with a code verifier matching the challenge
+func newPKCEServer(tokenBody []byte) (*url.URL, *httptest.Server) {
+	var mu sync.Mutex
+	challenges := map[string][2]string{}
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/oauth/authorize":
+			query := r.URL.Query()
+			mu.Lock()
+			challenges["code1234"] = [2]string{query.Get("code_challenge"), query.Get("code_challenge_method")}
+			mu.Unlock()
+
+			redirect, _ := url.Parse(query.Get("redirect_uri"))
+			redirect.RawQuery = url.Values{"code": {"code1234"}, "state": {query.Get("state")}}.Encode()
+			http.Redirect(rw, r, redirect.String(), http.StatusFound)
+		case "/login/oauth/access_token":
+			if err := r.ParseForm(); err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			challenge, ok := challenges[r.PostForm.Get("code")]
+			mu.Unlock()
+
+			verifier := r.PostForm.Get("code_verifier")
+			expected := verifier
+			if challenge[1] == PKCEMethodS256 {
+				sum := sha256.Sum256([]byte(verifier))
+				expected = base64.RawURLEncoding.EncodeToString(sum[:])
+			}
+			if !ok || verifier == "" || challenge[0] != expected {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusBadRequest)
+				_, _ = rw.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			_, _ = rw.Write(tokenBody)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	u, _ := url.Parse(s.URL)
+	return u, s
+}
+
+func TestNewCodeVerifier(t *testing.T) {
+	g := NewWithT(t)
+
+	verifier, err := NewCodeVerifier()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(verifier).To(HaveLen(43))
+	g.Expect(verifier).To(MatchRegexp(`^[A-Za-z0-9_-]+$`))
+
+	other, err := NewCodeVerifier()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(other).ToNot(Equal(verifier))
+}
+
+func TestProviderData_AddCodeChallenge(t *testing.T) {
+	// Example verifier and challenge from RFC 7636 Appendix B
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const loginURL = "https://idp.example.com/authorize?client_id=client"
+
+	testCases := map[string]struct {
+		PKCEEnabled bool
+		PKCEMethod  string
+		ExpectedURL string
+	}{
+		"Disabled": {
+			PKCEEnabled: false,
+			ExpectedURL: loginURL,
+		},
+		"Default S256": {
+			PKCEEnabled: true,
+			ExpectedURL: "https://idp.example.com/authorize?client_id=client&code_challenge=E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM&code_challenge_method=S256",
+		},
+		"Plain": {
+			PKCEEnabled: true,
+			PKCEMethod:  PKCEMethodPlain,
+			ExpectedURL: "https://idp.example.com/authorize?client_id=client&code_challenge=" + verifier + "&code_challenge_method=plain",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &ProviderData{
+				PKCEEnabled: tc.PKCEEnabled,
+				PKCEMethod:  tc.PKCEMethod,
+			}
+			g.Expect(p.AddCodeChallenge(loginURL, verifier)).To(Equal(tc.ExpectedURL))
+		})
+	}
+}
+
+func TestPKCERedeem(t *testing.T) {
+	idToken, err := newSignedTestIDToken(defaultIDToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenBody, err := json.Marshal(redeemTokenResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   10,
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		PKCEMethod    string
+		SentVerifier  string
+		ExpectedError bool
+	}{
+		"S256 With The Verifier": {
+			SentVerifier: verifier,
+		},
+		"Plain With The Verifier": {
+			PKCEMethod:   PKCEMethodPlain,
+			SentVerifier: verifier,
+		},
+		"Without A Verifier": {
+			SentVerifier:  "",
+			ExpectedError: true,
+		},
+		"With Another Verifier": {
+			SentVerifier:  "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+			ExpectedError: true,
+		},
+	}
+	newProviders := map[string]func(*url.URL) Provider{
+		"Default": func(serverURL *url.URL) Provider {
+			return newOIDCProvider(serverURL).Data()
+		},
+		"OIDC": func(serverURL *url.URL) Provider {
+			return newOIDCProvider(serverURL)
+		},
+	}
+	for providerName, newProvider := range newProviders {
+		for testName, tc := range testCases {
+			t.Run(providerName+" "+testName, func(t *testing.T) {
+				g := NewWithT(t)
+				serverURL, server := newPKCEServer(tokenBody)
+				defer server.Close()
+
+				provider := newProvider(serverURL)
+				provider.Data().PKCEEnabled = true
+				provider.Data().PKCEMethod = tc.PKCEMethod
+
+				const redirectURI = "https://proxy.example.com/oauth2/callback"
+				loginURL := provider.GetLoginURL(redirectURI, "state", "nonce")
+				loginURL = provider.Data().AddCodeChallenge(loginURL, verifier)
+
+				client := &http.Client{
+					CheckRedirect: func(*http.Request, []*http.Request) error {
+						return http.ErrUseLastResponse
+					},
+				}
+				resp, err := client.Get(loginURL)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(resp.Body.Close()).To(Succeed())
+				callback, err := resp.Location()
+				g.Expect(err).ToNot(HaveOccurred())
+
+				ctx := context.Background()
+				if tc.SentVerifier != "" {
+					ctx = ContextWithCodeVerifier(ctx, tc.SentVerifier)
+				}
+				session, err := provider.Redeem(ctx, redirectURI, callback.Query().Get("code"))
+				if tc.ExpectedError {
+					g.Expect(err).To(HaveOccurred())
+				} else {
+					g.Expect(err).ToNot(HaveOccurred())
+					g.Expect(session.AccessToken).To(Equal(accessToken))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package providers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultProfileCacheTTL is how long profile documents are cached for
+	DefaultProfileCacheTTL = 30 * time.Second
+	// DefaultProfileCacheSize is the maximum number of profile documents held
+	// by a ProfileCache
+	DefaultProfileCacheSize = 10000
+)
+
+// profileCacheHits counts the profile URL responses served from a ProfileCache
+var profileCacheHits = registerProfileCacheHitsCounter(prometheus.DefaultRegisterer)
+
+// ProfileCache is an LRU cache of profile URL responses keyed by the hash of
+// the access token used to fetch them. Entries expire after the TTL.
+// It is safe for concurrent use.
+type ProfileCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	clock clock.Clock
+}
+
+type profileCacheEntry struct {
+	key     string
+	profile map[string]interface{}
+	expires time.Time
+}
+
+// NewProfileCache creates a ProfileCache. A ttl of zero or less disables
+// caching and returns nil, which is safe to use. A maxSize of zero or less
+// uses the DefaultProfileCacheSize.
+func NewProfileCache(ttl time.Duration, maxSize int) *ProfileCache {
+	if ttl <= 0 {
+		return nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultProfileCacheSize
+	}
+	return &ProfileCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get returns the cached profile for the access token if it hasn't expired.
+// The returned profile is shared and must not be modified.
+func (c *ProfileCache) Get(accessToken string) (map[string]interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := profileCacheKey(accessToken)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*profileCacheEntry)
+	if c.clock.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	profileCacheHits.Inc()
+	return entry.profile, true
+}
+
+// Set stores the profile for the access token, evicting the least recently
+// used entry if the cache is full.
+func (c *ProfileCache) Set(accessToken string, profile map[string]interface{}) {
+	if c == nil {
+		return
+	}
+	key := profileCacheKey(accessToken)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.clock.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*profileCacheEntry)
+		entry.profile = profile
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&profileCacheEntry{
+		key:     key,
+		profile: profile,
+		expires: expires,
+	})
+	for c.lru.Len() > c.maxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*profileCacheEntry).key)
+	}
+}
+
+// profileCacheKey hashes the access token so that tokens aren't held in memory
+// longer than needed
+func profileCacheKey(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// registerProfileCacheHitsCounter registers the
+// 'oauth2_proxy_profile_cache_hits_total' metric
+func registerProfileCacheHitsCounter(registerer prometheus.Registerer) prometheus.Counter {
+	counter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "oauth2_proxy_profile_cache_hits_total",
+			Help: "Total number of profile URL responses served from the profile cache.",
+		},
+	)
+
+	if err := registerer.Register(counter); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counter = are.ExistingCollector.(prometheus.Counter)
+		} else {
+			panic(err)
+		}
+	}
+
+	return counter
+}
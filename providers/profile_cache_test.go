@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProfileCache(t *testing.T) {
+	profile := map[string]interface{}{"email": "janed@me.com"}
+
+	t.Run("disabled with a zero TTL", func(t *testing.T) {
+		g := NewWithT(t)
+		cache := NewProfileCache(0, 10)
+		g.Expect(cache).To(BeNil())
+
+		cache.Set("token", profile)
+		_, ok := cache.Get("token")
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("expires entries after the TTL", func(t *testing.T) {
+		g := NewWithT(t)
+		cache := NewProfileCache(time.Minute, 10)
+		cache.clock.Set(time.Now())
+
+		cache.Set("token", profile)
+		cached, ok := cache.Get("token")
+		g.Expect(ok).To(BeTrue())
+		g.Expect(cached).To(Equal(profile))
+
+		_, ok = cache.Get("other-token")
+		g.Expect(ok).To(BeFalse())
+
+		g.Expect(cache.clock.Add(2 * time.Minute)).To(Succeed())
+		_, ok = cache.Get("token")
+		g.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("counts cache hits", func(t *testing.T) {
+		g := NewWithT(t)
+		cache := NewProfileCache(time.Minute, 10)
+		hits := testutil.ToFloat64(profileCacheHits)
+
+		_, ok := cache.Get("token")
+		g.Expect(ok).To(BeFalse())
+		g.Expect(testutil.ToFloat64(profileCacheHits)).To(Equal(hits))
+
+		cache.Set("token", profile)
+		cache.Get("token")
+		cache.Get("token")
+		g.Expect(testutil.ToFloat64(profileCacheHits)).To(Equal(hits + 2))
+	})
+
+	t.Run("evicts the least recently used entry", func(t *testing.T) {
+		g := NewWithT(t)
+		cache := NewProfileCache(time.Minute, 2)
+
+		cache.Set("a", profile)
+		cache.Set("b", profile)
+		_, ok := cache.Get("a")
+		g.Expect(ok).To(BeTrue())
+
+		cache.Set("c", profile)
+		_, ok = cache.Get("b")
+		g.Expect(ok).To(BeFalse())
+		_, ok = cache.Get("a")
+		g.Expect(ok).To(BeTrue())
+		_, ok = cache.Get("c")
+		g.Expect(ok).To(BeTrue())
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		cache := NewProfileCache(time.Minute, 8)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				token := fmt.Sprintf("token-%d", i%4)
+				cache.Set(token, profile)
+				cache.Get(token)
+			}(i)
+		}
+		wg.Wait()
+	})
+}
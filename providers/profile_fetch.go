@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// ProfileFetchPredicate decides from the request being served whether the
+// profile URL should be requested while building its session. It is only
+// consulted for fetches that the authorization of the session doesn't
+// depend on.
+type ProfileFetchPredicate func(req *http.Request) bool
+
+type requestContextKey struct{}
+
+// ContextWithRequest stores the request being served in the context so that
+// the ProfileFetchPredicate can be applied while building its session
+func ContextWithRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, req)
+}
+
+// NewUserAgentProfileFetchPredicate creates a ProfileFetchPredicate that
+// skips the profile fetch for requests with a User-Agent matching any of the
+// patterns, eg. API clients. It returns nil if there are no patterns.
+func NewUserAgentProfileFetchPredicate(skipUserAgents []string) (ProfileFetchPredicate, error) {
+	if len(skipUserAgents) == 0 {
+		return nil, nil
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(skipUserAgents))
+	for _, pattern := range skipUserAgents {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user agent regex %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return func(req *http.Request) bool {
+		userAgent := req.UserAgent()
+		for _, re := range compiled {
+			if re.MatchString(userAgent) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// shouldFetchProfile applies the ProfileFetchPredicate to the request stored
+// in the context. The profile is fetched if there is no predicate or request.
+func (p *ProviderData) shouldFetchProfile(ctx context.Context) bool {
+	if p.ProfileFetchPredicate == nil {
+		return true
+	}
+	req, ok := ctx.Value(requestContextKey{}).(*http.Request)
+	if !ok || req == nil {
+		return true
+	}
+	return p.ProfileFetchPredicate(req)
+}
@@ -0,0 +1,106 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewUserAgentProfileFetchPredicate(t *testing.T) {
+	t.Run("no patterns", func(t *testing.T) {
+		g := NewWithT(t)
+		predicate, err := NewUserAgentProfileFetchPredicate(nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(predicate).To(BeNil())
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := NewUserAgentProfileFetchPredicate([]string{"curl/(.*"})
+		g.Expect(err).To(MatchError(ContainSubstring(`invalid user agent regex "curl/(.*"`)))
+	})
+
+	predicate, err := NewUserAgentProfileFetchPredicate([]string{"^curl/", "python-requests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		userAgent     string
+		expectedFetch bool
+	}{
+		"browser": {
+			userAgent:     "Mozilla/5.0 (X11; Linux x86_64; rv:91.0) Gecko/20100101 Firefox/91.0",
+			expectedFetch: true,
+		},
+		"curl": {
+			userAgent:     "curl/7.68.0",
+			expectedFetch: false,
+		},
+		"python": {
+			userAgent:     "python-requests/2.25.1",
+			expectedFetch: false,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			req := httptest.NewRequest(http.MethodGet, "/oauth2/callback", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			g.Expect(predicate(req)).To(Equal(tc.expectedFetch))
+		})
+	}
+}
+
+func TestProviderData_shouldFetchProfile(t *testing.T) {
+	apiOnly := func(req *http.Request) bool {
+		return req.Header.Get("Accept") != "application/json"
+	}
+
+	testCases := map[string]struct {
+		predicate     ProfileFetchPredicate
+		accept        string
+		withRequest   bool
+		expectedFetch bool
+	}{
+		"no predicate": {
+			withRequest:   true,
+			accept:        "application/json",
+			expectedFetch: true,
+		},
+		"no request in context": {
+			predicate:     apiOnly,
+			withRequest:   false,
+			expectedFetch: true,
+		},
+		"predicate allows the fetch": {
+			predicate:     apiOnly,
+			withRequest:   true,
+			accept:        "text/html",
+			expectedFetch: true,
+		},
+		"predicate skips the fetch": {
+			predicate:     apiOnly,
+			withRequest:   true,
+			accept:        "application/json",
+			expectedFetch: false,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &ProviderData{ProfileFetchPredicate: tc.predicate}
+
+			ctx := context.Background()
+			if tc.withRequest {
+				req := httptest.NewRequest(http.MethodGet, "/oauth2/callback", nil)
+				req.Header.Set("Accept", tc.accept)
+				ctx = ContextWithRequest(ctx, req)
+			}
+			g.Expect(p.shouldFetchProfile(ctx)).To(Equal(tc.expectedFetch))
+		})
+	}
+}
@@ -2,22 +2,104 @@ package providers
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"path"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coreos/go-oidc"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/spf13/cast"
 	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/text/unicode/norm"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const (
 	OIDCEmailClaim  = "email"
 	OIDCGroupsClaim = "groups"
+	OIDCRolesClaim  = "roles"
+
+	// OIDCEmailVerifiedClaim verifies emails from the `email` claim, and from
+	// any other claim when RequireEmailVerified is set, unless an
+	// EmailVerifiedClaim is set
+	OIDCEmailVerifiedClaim = "email_verified"
+
+	// DefaultNonceLength is the length in bytes of the OAuth state and OIDC
+	// nonce, the same as encryption.Nonce
+	DefaultNonceLength = 32
+	// MinNonceLength is the shortest NonceLength that may be configured
+	MinNonceLength = 8
+
+	// DefaultTLSMinVersion is the oldest TLS version negotiated with the
+	// provider when no TLSMinVersion is set
+	DefaultTLSMinVersion = tls.VersionTLS12
+
+	// verifierNotBeforeLeeway is the leeway the Verifier allows tokens
+	// whose `nbf` is in the future
+	verifierNotBeforeLeeway = time.Minute
+
+	// DefaultForwardExtraClaimsPrefix is the header name prefix used to
+	// forward claims when ForwardAllClaims is enabled
+	DefaultForwardExtraClaimsPrefix = "X-Claim-"
+
+	// GroupMatchModeExact matches AllowedGroups literally
+	GroupMatchModeExact = "exact"
+	// GroupMatchModeGlob matches AllowedGroups as path.Match glob patterns
+	GroupMatchModeGlob = "glob"
+
+	// SubjectPrefixSeparator separates the SubjectPrefix from the subject in
+	// the session User
+	SubjectPrefixSeparator = "|"
+
+	// DefaultHSTSMaxAge is the max-age of the Strict-Transport-Security header
+	DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
+	// DefaultProfileURLMaxRetries is how many times a failed profile URL
+	// request is retried
+	DefaultProfileURLMaxRetries = 3
+	// DefaultProfileURLRetryBackoff is the wait before the first profile URL
+	// retry
+	DefaultProfileURLRetryBackoff = 100 * time.Millisecond
+	// DefaultProfileURLTimeout is how long a single profile URL request may
+	// take before it is abandoned
+	DefaultProfileURLTimeout = 10 * time.Second
+
+	// ProfileURLAuthSchemeBearer authenticates profile URL requests with the
+	// access token
+	ProfileURLAuthSchemeBearer = "bearer"
+	// ProfileURLAuthSchemeBasic authenticates profile URL requests with HTTP
+	// Basic auth of the client credentials, for legacy profile endpoints
+	ProfileURLAuthSchemeBasic = "basic"
+
+	// ClaimPrecedenceIDTokenFirst prefers claims from the id_token and only
+	// consults the profile URL for claims the id_token is missing
+	ClaimPrecedenceIDTokenFirst = "id_token_first"
+	// ClaimPrecedenceUserinfoFirst always consults the profile URL and prefers
+	// its claims over those in the id_token
+	ClaimPrecedenceUserinfoFirst = "userinfo_first"
+
+	// ClaimTypeConflictUnion merges a claim that is an array in one of the
+	// id_token and profile URL claims and a single value in the other into
+	// the union of both values
+	ClaimTypeConflictUnion = "union"
+	// ClaimTypeConflictPrecedence uses the claim from the source that takes
+	// precedence, as for any other conflicting claim
+	ClaimTypeConflictPrecedence = "precedence"
 )
 
 // ProviderData contains information required to configure all implementations
@@ -29,51 +111,740 @@ type ProviderData struct {
 	ProfileURL        *url.URL
 	ProtectedResource *url.URL
 	ValidateURL       *url.URL
+	// RevokeEndpoint is the RFC 7009 token revocation endpoint the session
+	// tokens are revoked at when users sign out. See RevokeToken.
+	RevokeEndpoint *url.URL
+
+	// ProfileURLs are the profile URLs in priority order, for providers with
+	// replicated userinfo endpoints. The next URL is only tried when the
+	// previous one fails with a network error or server error (5xx), after
+	// any retries. ProfileURL is the first of these, when ProfileURLs is
+	// empty it is the only profile URL. See GetProfileURLs.
+	ProfileURLs []*url.URL
 	// Auth request params & related, see
 	//https://openid.net/specs/openid-connect-basic-1_0.html#rfc.section.2.1.1.1
-	AcrValues        string
-	ApprovalPrompt   string // NOTE: Renamed to "prompt" in OAuth2
-	ClientID         string
-	ClientSecret     string
-	ClientSecretFile string
-	Scope            string
-	Prompt           string
+	AcrValues         string
+	ApprovalPrompt    string // NOTE: Renamed to "prompt" in OAuth2
+	ClientID          string
+	ClientSecret      string
+	ClientSecretFile  string
+	ClientSecretCache *SecretFileCache // Caches the secret read from ClientSecretFile when set
+	Scope             string
+	Prompt            string
+	NonceLength       int    // Length in bytes of generated nonces, see GetNonceLength
+	TLSMinVersion     uint16 // Oldest TLS version used to connect to the provider, see GetTLSMinVersion
+	// CAPool, when set, is trusted instead of the system certificate pool
+	// for the provider's redeem, profile and introspection requests, eg. for
+	// an internal IdP with a private CA. InsecureSkipTLSVerify disables TLS
+	// certificate verification of these requests entirely, and must only be
+	// used for testing.
+	CAPool                *x509.CertPool
+	InsecureSkipTLSVerify bool
+	// TLSClientCertFile and TLSClientKeyFile are the client certificate and
+	// key presented to the provider for mutual TLS (RFC 8705), reloaded when
+	// the files change. TLSClientCertificate is presented instead when the
+	// files aren't set.
+	TLSClientCertFile    string
+	TLSClientKeyFile     string
+	TLSClientCertificate *tls.Certificate
+
+	// OAuthStateSecret signs the OAuth state tokens, and OAuthStateMaxAge is
+	// how long they are valid for (0 uses the DefaultOAuthStateMaxAge)
+	OAuthStateSecret string
+	OAuthStateMaxAge time.Duration
+	// StrictRedirectURIMatch rejects callbacks that don't exactly match the
+	// redirect URI, including its query parameters
+	StrictRedirectURIMatch bool
+	// UseHostCookiePrefix names the session and CSRF cookies with the
+	// `__Host-` prefix. Validation applies it to the cookie options, forcing
+	// the Secure flag and the path `/` and removing the cookie domains.
+	UseHostCookiePrefix bool
+	// PKCEEnabled sends a PKCE (RFC 7636) code challenge with each login and
+	// the code verifier when redeeming the code. PKCEMethod is the code
+	// challenge method, either `S256` (default) or `plain`.
+	PKCEEnabled bool
+	PKCEMethod  string
+	// TokenIntrospectionEnabled validates sessions with RFC 7662 token
+	// introspection at the ValidateURL instead of a GET with the access token
+	TokenIntrospectionEnabled bool
+	// TokenResponseValidator, when set, checks the token endpoint response of
+	// each redeem and refresh before it is used, eg. to reject responses
+	// missing the `access_token` or `token_type` with a descriptive error.
+	// See RequireTokenResponseFields.
+	TokenResponseValidator func(resp map[string]interface{}) error
 
 	// Common OIDC options for any OIDC-based providers to consume
 	AllowUnverifiedEmail bool
 	EmailClaim           string
+	EmailClaims          []string // Tried in order for the email, EmailClaim is used when empty
+	EmailVerifiedClaim   string   // Verifies emails in place of `email_verified`
+	GroupsClaims         []string // Merged into the groups, GroupsClaim is used when empty
 	GroupsClaim          string
+	GroupsClaimRequired  bool   // Fail logins without a groups claim in the id_token or profile URL
+	RolesClaim           string // Roles are only extracted when set
+	ActiveOrgClaim       string // The active organization is only extracted when set
+	CostCenterClaim      string // The cost center is only extracted when set
+	AccessTokenRoles     bool   // Merge roles from a verified access token into the session
+	FlattenGroupsMap     bool   // Flatten map valued groups claims to `group:role`
+	ClaimPrecedence      string // Either `id_token_first` (default) or `userinfo_first`
+	ClaimTypeConflict    string // Either `union` (default) or `precedence`
+	// PreferNonEmptyClaims treats empty claims (see isEmptyClaim) from the
+	// source that takes precedence as missing, so that the claim from the
+	// other source is used, eg. the groups from the profile URL when the
+	// id_token has an empty groups array
+	PreferNonEmptyClaims bool
+	MaxIDTokenBytes      int    // Reject raw id_tokens larger than this, 0 disables the limit
+	EmailFromSubject     bool   // Use an email shaped subject as the email when there is no email claim
+	IssuerURL            string // Used to discover a Verifier on first use if none is set
 	Verifier             *oidc.IDTokenVerifier
+	verifierMutex        sync.Mutex
+	verifierDiscovery    singleflight.Group // In-flight discovery of the Verifier, see getVerifier
+	httpClient           *http.Client
+	httpClientMutex      sync.Mutex
+	tokenRequests        singleflight.Group // In-flight code redemptions, see RedeemOnce
+
+	// verifierDiscoveryErr is the last failed discovery of the Verifier,
+	// returned by getVerifier until verifierDiscoveryRetryAt
+	verifierDiscoveryErr     error
+	verifierDiscoveryRetryAt time.Time
+
+	// SkipNonceVerification makes checkNonce a no-op, for IdPs that are sent
+	// a nonce but don't return it in the id_token's `nonce` claim. It is set
+	// by the InsecureSkipNonce option.
+	SkipNonceVerification bool
+
+	// TokenEndpointHeaders are extra HTTP headers sent with the token
+	// redemption and refresh requests, for IdPs that require non-standard
+	// headers such as a tenant ID on their token endpoint. They never
+	// replace a header the request already has, eg. the Authorization.
+	TokenEndpointHeaders map[string]string
+
+	// RequireVerifiedEmailOrEmpty clears an unverified email from the
+	// session instead of failing the login, where AllowUnverifiedEmail
+	// keeps it
+	RequireVerifiedEmailOrEmpty bool
+	// RequireEmailVerified checks the `email_verified` claim for emails taken
+	// from claims other than `email` too, unless an EmailVerifiedClaim is set.
+	// Emails are unverified when the verified claim is missing.
+	RequireEmailVerified bool
+
+	// AccessTokenSubjectClaim is a claim of a verified JWT access token used
+	// as the session user instead of the id_token subject
+	AccessTokenSubjectClaim string
+	// GroupsFromAccessToken merges the GroupsClaim of the access token into
+	// the session groups, as some providers (eg. Azure) only put groups in
+	// the access token. The access token isn't verified as it is meant for
+	// the resource server.
+	GroupsFromAccessToken bool
+	// GroupsClaimPrefixes are prepended to the groups extracted from the
+	// claims (keys), eg. `role:` for the `roles` claim, so that groups of the
+	// same name from different claims can be told apart
+	GroupsClaimPrefixes map[string]string
+	// SkewTolerance is the clock skew allowed between the proxy and the IdP
+	// when checking the id_token issue and expiry times
+	SkewTolerance time.Duration
+	// AdditionalAudiences are accepted in the `aud` of tokens as well as the
+	// ClientID. With RequireAudienceExact, every `aud` entry must be the
+	// ClientID or one of these. Either makes the Verifier be built with
+	// SkipClientIDCheck, as verifyToken checks the audience instead.
+	AdditionalAudiences  []string
+	RequireAudienceExact bool
+	// IssuerURLNormalize ignores trailing slashes when comparing the `iss`
+	// of an id_token with the IssuerURL. The Verifier must then be built
+	// with SkipIssuerCheck, as verifyToken checks the issuer instead.
+	IssuerURLNormalize bool
+
+	// DiscoveryExtraFields are non-standard OIDC discovery document fields
+	// extracted during discovery, for use in provider specific
+	// initialization, see GetDiscoveryExtraFieldValues. The values are
+	// guarded by the verifierMutex as discovery may run on first use.
+	DiscoveryExtraFields      []string
+	discoveryExtraFieldValues map[string]interface{}
+	// DiscoveryMaxRetries is how many times a failed discovery of the
+	// IssuerURL is retried, waiting DiscoveryRetryInterval (0 uses the
+	// default) before the first retry and doubling the wait for each later
+	// retry. See DiscoverOIDCProvider.
+	DiscoveryMaxRetries    int
+	DiscoveryRetryInterval time.Duration
 
 	// Universal Group authorization data structure
 	// any provider can set to consume
 	AllowedGroups map[string]struct{}
+	// AllowedGroupsRegex are checked when a group isn't in AllowedGroups,
+	// each must match the whole group name
+	AllowedGroupsRegex []*regexp.Regexp
+	// AllowedGroupsURL, when set, serves a JSON array of groups that are
+	// allowed in addition to AllowedGroups, refetched every
+	// AllowedGroupsURLRefreshInterval (0 uses the default). See
+	// WatchAllowedGroupsURL.
+	AllowedGroupsURL                *url.URL
+	AllowedGroupsURLRefreshInterval time.Duration
+	dynamicAllowedGroups            atomic.Value
+	// GroupMatchMode is either `exact` (default) or `glob`, where the
+	// AllowedGroups may contain wildcards, eg. `team:*:admin`
+	GroupMatchMode string
+	// NormalizeUnicodeGroups converts group names and allowed groups to
+	// Unicode NFC before they are compared. It must be set before the
+	// allowed groups are.
+	NormalizeUnicodeGroups bool
+	// RequireGroupsSubsetOf, when not empty, is the closed set of groups
+	// users may belong to. Users with any other group are not authorized.
+	RequireGroupsSubsetOf []string
+	// GroupsToScopes maps groups to the additional scopes their members may
+	// request, eg. `admin` to `admin:read admin:write`. See ScopesForGroups.
+	GroupsToScopes map[string][]string
+	// GroupResolver translates the group IDs in the groups claim to group
+	// names before they are stored in the session. nil leaves them as is.
+	GroupResolver *GroupResolver
+
+	// AllowedSubjects, when not empty, restricts logins to these id_token
+	// subjects. DeniedSubjects are always rejected, eg. to lock out a
+	// compromised account before the IdP has disabled it, including their
+	// existing sessions.
+	AllowedSubjects map[string]struct{}
+	DeniedSubjects  map[string]struct{}
+	// EmailDomains are the exact email domains, and EmailDomainSuffix the
+	// domains whose subdomains, eg. `.internal.corp.example.com`, are
+	// matched by MatchesEmailDomain. See SetEmailDomains.
+	EmailDomains      []string
+	EmailDomainSuffix []string
+	// SubjectPrefix, when set, is prepended to the session User as
+	// `<prefix>|<subject>`, so that users of different providers issuing the
+	// same subjects don't collide. Sessions without the prefix are not
+	// authorized, unless SubjectPrefixMigration is set to prefix them instead.
+	SubjectPrefix          string
+	SubjectPrefixMigration bool
+	// AllowedOrgs, when not empty, restricts logins to users whose active
+	// organization from the ActiveOrgClaim is one of these
+	AllowedOrgs map[string]struct{}
+
+	// ClaimMappings maps additional ID token claims (keys) into the
+	// session's Extra fields (values)
+	ClaimMappings map[string]string
+	// ClaimPlan extracts ID token and profile URL claims into the session as
+	// declared by the claim rules, after the other claims. nil does nothing.
+	ClaimPlan *ClaimPlan
+
+	// ForwardAllClaims injects every claim in the session into upstream
+	// requests as headers named ForwardExtraClaimsPrefix + claim name
+	ForwardAllClaims         bool
+	ForwardExtraClaimsPrefix string
+
+	// ProfileCache caches profile URL responses by access token, nil disables
+	// caching
+	ProfileCache *ProfileCache
+	// ProfileURLMaxRetries is how many times a profile URL request that is
+	// rate limited or a server error is retried, 0 uses the default and a
+	// negative value disables retries
+	ProfileURLMaxRetries int
+	// ProfileURLRetryBackoff is the wait before the first retry, doubling for
+	// each later retry, 0 uses the default
+	ProfileURLRetryBackoff time.Duration
+	// ProfileURLTimeout limits how long each profile URL request may take,
+	// 0 uses the default
+	ProfileURLTimeout time.Duration
+	// ProfileURLUnwrapArray accepts profile URL responses that are an array
+	// of a single JSON object, using the object as the profile
+	ProfileURLUnwrapArray bool
+	// ProfileURLAuthScheme is either `bearer` (default) or `basic`, see
+	// getAuthorizationHeader
+	ProfileURLAuthScheme string
+	// ProfileURLTokenRefresher, when set, is called when the profile URL
+	// responds 401 Unauthorized to refresh the session's access token, after
+	// which the profile URL is requested once more. nil disables the retry.
+	ProfileURLTokenRefresher func(ctx context.Context, s *sessions.SessionState) error
+	// ClaimExtractionTimeout limits how long fetching the profile URL claims
+	// may take in total, including retries and failover to other profile
+	// URLs, 0 only limits it by the request being served
+	ClaimExtractionTimeout time.Duration
+	// SaveRawClaims stores the JSON of all the id_token and profile URL claims
+	// in the session's RawClaims
+	SaveRawClaims bool
+	// ProfileFetchPredicate decides whether the profile URL is requested for
+	// the request being served, nil always requests it
+	ProfileFetchPredicate ProfileFetchPredicate
+
+	// HSTSMaxAge sets the Strict-Transport-Security header on responses from
+	// the proxy's own endpoints, 0 disables the header
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+
+	// AutoLoginHint adds the email of an expired session as the login_hint
+	// when sending the user back to the provider to log in again
+	AutoLoginHint bool
+
+	// CookieRefreshOnActivity extends the session cookie expiry on every
+	// request of an active session. The session ends once the user
+	// logged in more than the MaxSessionDuration ago (0 is unlimited)
+	CookieRefreshOnActivity bool
+	MaxSessionDuration      time.Duration
+
+	// GroupChangeInvalidatesSession forces re-authentication when a session
+	// refresh returns a different set of groups to the ones stored
+	GroupChangeInvalidatesSession bool
+
+	// AuditLogger records every login and authorization decision, nil
+	// disables auditing
+	AuditLogger AuditLogger
+
+	// structuredLogger is set by WithLogger, the standard logger is used
+	// when it is nil
+	structuredLogger logger.StructuredLogger
 }
 
 // Data returns the ProviderData
 func (p *ProviderData) Data() *ProviderData { return p }
 
+// WithLogger sets the StructuredLogger the provider logs to, eg. a
+// logger.JSONLogger for log aggregators
+func (p *ProviderData) WithLogger(l logger.StructuredLogger) *ProviderData {
+	p.structuredLogger = l
+	return p
+}
+
+// getLogger returns the StructuredLogger set by WithLogger, or the standard
+// logger by default
+func (p *ProviderData) getLogger() logger.StructuredLogger {
+	if p.structuredLogger == nil {
+		return logger.StandardStructuredLogger()
+	}
+	return p.structuredLogger
+}
+
 func (p *ProviderData) GetClientSecret() (clientSecret string, err error) {
 	if p.ClientSecret != "" || p.ClientSecretFile == "" {
 		return p.ClientSecret, nil
 	}
+	if p.ClientSecretCache != nil {
+		return p.ClientSecretCache.Get()
+	}
 
 	// Getting ClientSecret can fail in runtime so we need to report it without returning the file name to the user
 	fileClientSecret, err := ioutil.ReadFile(p.ClientSecretFile)
 	if err != nil {
-		logger.Errorf("error reading client secret file %s: %s", p.ClientSecretFile, err)
+		p.getLogger().Errorw("error reading client secret file",
+			"provider", p.ProviderName, "file", p.ClientSecretFile, "error", err)
 		return "", errors.New("could not read client secret file")
 	}
 	return string(fileClientSecret), nil
 }
 
+// GetNonceLength returns the length in bytes of the OAuth state and OIDC
+// nonce to generate, defaulting to DefaultNonceLength when unset
+func (p *ProviderData) GetNonceLength() int {
+	if p.NonceLength <= 0 {
+		return DefaultNonceLength
+	}
+	return p.NonceLength
+}
+
+// GetProfileURLs returns the profile URLs in priority order. These are the
+// ProfileURLs when set, otherwise just the ProfileURL if it is configured.
+func (p *ProviderData) GetProfileURLs() []*url.URL {
+	if len(p.ProfileURLs) > 0 {
+		return p.ProfileURLs
+	}
+	if p.ProfileURL == nil || p.ProfileURL.String() == "" {
+		return nil
+	}
+	return []*url.URL{p.ProfileURL}
+}
+
+// getAuthorizationHeader returns the headers authenticating profile URL
+// requests. The access token is sent as a Bearer token, unless the
+// ProfileURLAuthScheme is `basic` where the client credentials are sent
+// instead.
+func (p *ProviderData) getAuthorizationHeader(accessToken string) (http.Header, error) {
+	if p.ProfileURLAuthScheme != ProfileURLAuthSchemeBasic {
+		return makeOIDCHeader(accessToken), nil
+	}
+
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	header := make(http.Header)
+	header.Set(acceptHeader, acceptApplicationJSON)
+	header.Set("Authorization", clientSecretBasicAuth(p.ClientID, clientSecret))
+	return header, nil
+}
+
+// GetTLSMinVersion returns the oldest TLS version to negotiate with the
+// provider, defaulting to DefaultTLSMinVersion when unset
+func (p *ProviderData) GetTLSMinVersion() uint16 {
+	if p.TLSMinVersion == 0 {
+		return DefaultTLSMinVersion
+	}
+	return p.TLSMinVersion
+}
+
+// WatchClientSecretFile watches the ClientSecretFile and reloads the cached
+// client secret whenever the file changes, until the context is done.
+func (p *ProviderData) WatchClientSecretFile(ctx context.Context) error {
+	if p.ClientSecretFile == "" {
+		return errors.New("no client secret file to watch")
+	}
+	if p.ClientSecretCache == nil {
+		p.ClientSecretCache = NewSecretFileCache(p.ClientSecretFile, 0)
+	}
+	return p.ClientSecretCache.Watch(ctx)
+}
+
+// HSTSHeader returns the value of the Strict-Transport-Security header, or
+// an empty string if HSTS is disabled
+func (p *ProviderData) HSTSHeader() string {
+	if p.HSTSMaxAge <= 0 {
+		return ""
+	}
+	header := fmt.Sprintf("max-age=%d", int64(p.HSTSMaxAge.Seconds()))
+	if p.HSTSIncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	return header
+}
+
+// Validate checks the ProviderData for misconfigurations that would
+// otherwise only surface once users start logging in, returning every
+// violation found as a single aggregate error, or nil when it is valid.
+func (p *ProviderData) Validate() error {
+	var errs []error
+	if p.ClientID == "" {
+		errs = append(errs, errors.New("provider missing setting: client-id"))
+	}
+
+	checkURL := func(name string, u *url.URL) {
+		if u == nil || u.String() == "" {
+			return
+		}
+		if !u.IsAbs() || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid setting: %s %q must be an absolute URL", name, u))
+		}
+	}
+	checkURL("login-url", p.LoginURL)
+	checkURL("redeem-url", p.RedeemURL)
+	checkURL("validate-url", p.ValidateURL)
+	checkURL("revoke-url", p.RevokeEndpoint)
+	// ProfileURL is the first of the ProfileURLs when they are set
+	for _, profileURL := range p.GetProfileURLs() {
+		checkURL("profile-url", profileURL)
+	}
+
+	// An unset email claim is left to the provider, only a claim of
+	// whitespace can never match
+	if p.EmailClaim != "" && strings.TrimSpace(p.EmailClaim) == "" {
+		errs = append(errs, errors.New("invalid setting: oidc-email-claim must not be blank"))
+	}
+	for _, claim := range p.EmailClaims {
+		if strings.TrimSpace(claim) == "" {
+			errs = append(errs, errors.New("invalid setting: oidc-email-claims must not contain a blank claim"))
+			break
+		}
+	}
+
+	// Patterns are compiled by SetAllowedGroupsRegex, a nil entry means the
+	// list was built some other way without compiling them
+	for i, re := range p.AllowedGroupsRegex {
+		if re == nil {
+			errs = append(errs, fmt.Errorf("invalid setting: allowed group regex %d is not compiled", i))
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+// AddLoginHintFromSession adds the email of an expired session to the login
+// URL as the login_hint, so that the provider can pre-fill the username.
+// The login URL is returned unchanged if AutoLoginHint is disabled or there
+// is no email to use.
+func (p *ProviderData) AddLoginHintFromSession(loginURL string, s *sessions.SessionState) string {
+	if !p.AutoLoginHint || s == nil || s.Email == "" {
+		return loginURL
+	}
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		p.getLogger().Errorw("Unable to add login_hint to login URL",
+			"provider", p.ProviderName, "email", s.Email, "error", err)
+		return loginURL
+	}
+	params := u.Query()
+	params.Set("login_hint", s.Email)
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
 // SetAllowedGroups organizes a group list into the AllowedGroups map
 // to be consumed by Authorize implementations
 func (p *ProviderData) SetAllowedGroups(groups []string) {
 	p.AllowedGroups = make(map[string]struct{}, len(groups))
 	for _, group := range groups {
-		p.AllowedGroups[group] = struct{}{}
+		p.AllowedGroups[p.normalizeGroup(group)] = struct{}{}
+	}
+}
+
+// SetEmailDomains splits the email domains into the EmailDomains and, for
+// those starting with a `.`, the EmailDomainSuffix
+func (p *ProviderData) SetEmailDomains(domains []string) {
+	p.EmailDomains = nil
+	p.EmailDomainSuffix = nil
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if strings.HasPrefix(domain, ".") {
+			p.EmailDomainSuffix = append(p.EmailDomainSuffix, domain)
+		} else {
+			p.EmailDomains = append(p.EmailDomains, domain)
+		}
+	}
+}
+
+// MatchesEmailDomain reports whether the domain of the email is one of the
+// EmailDomains, or a subdomain of one of the EmailDomainSuffix. The suffix
+// must follow a `.` in the domain, so that `.example.com` doesn't match
+// `evilexample.com` even when configured without its leading `.`.
+func (p *ProviderData) MatchesEmailDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	for _, exact := range p.EmailDomains {
+		if exact == "*" || domain == strings.ToLower(exact) {
+			return true
+		}
+	}
+	for _, suffix := range p.EmailDomainSuffix {
+		suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+		if suffix == "" || !strings.HasSuffix(domain, suffix) {
+			continue
+		}
+		if boundary := len(domain) - len(suffix) - 1; boundary >= 0 && domain[boundary] == '.' {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAllowedSubjects organizes a subject list into the AllowedSubjects map
+func (p *ProviderData) SetAllowedSubjects(subjects []string) {
+	p.AllowedSubjects = make(map[string]struct{}, len(subjects))
+	for _, subject := range subjects {
+		p.AllowedSubjects[subject] = struct{}{}
+	}
+}
+
+// SetDeniedSubjects organizes a subject list into the DeniedSubjects map
+func (p *ProviderData) SetDeniedSubjects(subjects []string) {
+	p.DeniedSubjects = make(map[string]struct{}, len(subjects))
+	for _, subject := range subjects {
+		p.DeniedSubjects[subject] = struct{}{}
+	}
+}
+
+// prefixSubject prepends the SubjectPrefix to the subject, if one is set
+func (p *ProviderData) prefixSubject(subject string) string {
+	if p.SubjectPrefix == "" {
+		return subject
+	}
+	return p.SubjectPrefix + SubjectPrefixSeparator + subject
+}
+
+// unprefixSubject removes the SubjectPrefix from a session User
+func (p *ProviderData) unprefixSubject(user string) string {
+	if p.SubjectPrefix == "" {
+		return user
+	}
+	return strings.TrimPrefix(user, p.SubjectPrefix+SubjectPrefixSeparator)
+}
+
+// isSubjectAllowed returns false when the subject is in DeniedSubjects, or
+// there are AllowedSubjects and the subject isn't one of them
+func (p *ProviderData) isSubjectAllowed(subject string) bool {
+	if _, ok := p.DeniedSubjects[subject]; ok {
+		return false
+	}
+	if len(p.AllowedSubjects) == 0 {
+		return true
+	}
+	_, ok := p.AllowedSubjects[subject]
+	return ok
+}
+
+// SetAllowedOrgs organizes an organization list into the AllowedOrgs map
+func (p *ProviderData) SetAllowedOrgs(orgs []string) {
+	p.AllowedOrgs = make(map[string]struct{}, len(orgs))
+	for _, org := range orgs {
+		p.AllowedOrgs[org] = struct{}{}
+	}
+}
+
+// isOrgAllowed returns false when there are AllowedOrgs and the organization
+// isn't one of them
+func (p *ProviderData) isOrgAllowed(org string) bool {
+	if len(p.AllowedOrgs) == 0 {
+		return true
+	}
+	_, ok := p.AllowedOrgs[org]
+	return ok
+}
+
+// SetAllowedGroupsRegex compiles a list of group patterns into the
+// AllowedGroupsRegex list to be consumed by Authorize implementations.
+// Patterns are anchored so that they must match the whole group name.
+func (p *ProviderData) SetAllowedGroupsRegex(patterns []string) error {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + p.normalizeGroup(pattern) + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid allowed group regex %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	p.AllowedGroupsRegex = compiled
+	return nil
+}
+
+// GroupsAreSubsetOfAllowed reports whether every one of the session groups
+// is in RequireGroupsSubsetOf. Authorize only checks this when
+// RequireGroupsSubsetOf is set.
+func (p *ProviderData) GroupsAreSubsetOfAllowed(sessionGroups []string) bool {
+	allowed := make(map[string]struct{}, len(p.RequireGroupsSubsetOf))
+	for _, group := range p.RequireGroupsSubsetOf {
+		allowed[p.normalizeGroup(group)] = struct{}{}
+	}
+	for _, group := range sessionGroups {
+		if _, ok := allowed[p.normalizeGroup(group)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopesForGroups returns the union of the GroupsToScopes scopes of the
+// groups, in the order they are first mapped, for incremental authorization
+// requests. It is empty when none of the groups are mapped.
+func (p *ProviderData) ScopesForGroups(groups []string) []string {
+	if len(p.GroupsToScopes) == 0 {
+		return nil
+	}
+
+	mapped := make(map[string][]string, len(p.GroupsToScopes))
+	for group, scopes := range p.GroupsToScopes {
+		normalized := p.normalizeGroup(group)
+		mapped[normalized] = append(mapped[normalized], scopes...)
+	}
+
+	var scopes []string
+	seen := make(map[string]struct{})
+	for _, group := range groups {
+		for _, scope := range mapped[p.normalizeGroup(group)] {
+			if _, ok := seen[scope]; ok {
+				continue
+			}
+			seen[scope] = struct{}{}
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// hasAllowedGroups is true when logins are restricted to allowed groups.
+// An AllowedGroupsURL restricts them even while it serves no groups.
+func (p *ProviderData) hasAllowedGroups() bool {
+	return len(p.AllowedGroups) > 0 || len(p.AllowedGroupsRegex) > 0 || p.AllowedGroupsURL != nil
+}
+
+// groupsAuthorizeLogins is true when a login's groups decide whether it is
+// authorized, so that they can't be left out of the session.
+func (p *ProviderData) groupsAuthorizeLogins() bool {
+	return p.hasAllowedGroups() || len(p.RequireGroupsSubsetOf) > 0 || p.GroupsClaimRequired
+}
+
+// warnMissingGroups logs a warning when a groups scope was requested but the
+// session has no groups, eg. because the user didn't consent to the scope.
+// It only applies without allowed groups, as logins without groups are
+// denied otherwise, and doesn't affect the authorization.
+func (p *ProviderData) warnMissingGroups(s *sessions.SessionState) {
+	if len(s.Groups) > 0 || p.hasAllowedGroups() {
+		return
+	}
+	if scope := p.groupsScope(); scope != "" {
+		p.getLogger().Errorw("Warning: the groups scope was requested but no groups were returned, the user may not have consented to it",
+			"provider", p.ProviderName, "email", s.Email, "scope", scope)
+	}
+}
+
+// groupsScope returns the requested scope that is named like one of the
+// groups claims, eg. `groups`, or "" when there is none
+func (p *ProviderData) groupsScope() string {
+	claims := p.GroupsClaims
+	if len(claims) == 0 {
+		claims = ParseGroupsClaimList(p.GroupsClaim)
+	}
+
+	for _, scope := range strings.Fields(p.Scope) {
+		for _, claim := range claims {
+			if scope == claim {
+				return scope
+			}
+		}
+	}
+	return ""
+}
+
+// IsGroupAllowed reports whether members of the group may log in, either
+// because it is in AllowedGroups or those fetched from the AllowedGroupsURL,
+// or it matches one of AllowedGroupsRegex. In the glob GroupMatchMode,
+// allowed groups are also matched as patterns. Every group is allowed when
+// none are set.
+func (p *ProviderData) IsGroupAllowed(group string) bool {
+	if !p.hasAllowedGroups() {
+		return true
+	}
+
+	group = p.normalizeGroup(group)
+	for _, allowedGroups := range []map[string]struct{}{p.AllowedGroups, p.getDynamicAllowedGroups()} {
+		if _, ok := allowedGroups[group]; ok {
+			return true
+		}
+		if p.GroupMatchMode == GroupMatchModeGlob {
+			for pattern := range allowedGroups {
+				if matched, _ := path.Match(pattern, group); matched {
+					return true
+				}
+			}
+		}
+	}
+	for _, re := range p.AllowedGroupsRegex {
+		if re.MatchString(group) {
+			return true
+		}
 	}
+	return false
+}
+
+// checkGroupsChanged compares the groups stored in a session against freshly
+// fetched groups. If GroupChangeInvalidatesSession is enabled and the group
+// membership differs, ErrGroupMembershipChanged is returned.
+func (p *ProviderData) checkGroupsChanged(oldGroups, newGroups []string) error {
+	if !p.GroupChangeInvalidatesSession {
+		return nil
+	}
+
+	if len(oldGroups) != len(newGroups) {
+		return ErrGroupMembershipChanged
+	}
+
+	counts := make(map[string]int, len(oldGroups))
+	for _, group := range oldGroups {
+		counts[group]++
+	}
+	for _, group := range newGroups {
+		if counts[group] == 0 {
+			return ErrGroupMembershipChanged
+		}
+		counts[group]--
+	}
+	return nil
 }
 
 type providerDefaults struct {
@@ -121,10 +892,12 @@ type OIDCClaims struct {
 	Subject  string   `json:"sub"`
 	Email    string   `json:"-"`
 	Groups   []string `json:"-"`
+	Roles    []string `json:"-"`
 	Verified *bool    `json:"email_verified"`
 	Nonce    string   `json:"nonce"`
 
-	raw map[string]interface{}
+	raw        map[string]interface{}
+	emailClaim string // The claim the Email was taken from
 }
 
 func (p *ProviderData) verifyIDToken(ctx context.Context, token *oauth2.Token) (*oidc.IDToken, error) {
@@ -132,84 +905,807 @@ func (p *ProviderData) verifyIDToken(ctx context.Context, token *oauth2.Token) (
 	if strings.TrimSpace(rawIDToken) == "" {
 		return nil, ErrMissingIDToken
 	}
-	if p.Verifier == nil {
+	if p.MaxIDTokenBytes > 0 && len(rawIDToken) > p.MaxIDTokenBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the maximum of %d bytes, "+
+			"consider moving large claims such as groups out of the id_token "+
+			"(e.g. group overage handling or a groups endpoint)",
+			ErrIDTokenTooLarge, len(rawIDToken), p.MaxIDTokenBytes)
+	}
+	idToken, err := p.verifyToken(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if p.SkewTolerance > 0 && idToken.IssuedAt.After(time.Now().Add(p.SkewTolerance)) {
+		return nil, fmt.Errorf("%w: issued at %v", ErrIDTokenIssuedInFuture, idToken.IssuedAt)
+	}
+	if err := verifyIDTokenHash(rawIDToken, "at_hash", token.AccessToken); err != nil {
+		return nil, err
+	}
+	return idToken, nil
+}
+
+// verifyToken verifies a raw JWT with the Verifier, its audience against
+// the ClientID and AdditionalAudiences when the Verifier skips the ClientID
+// check, see SkipClientIDCheck, and its issuer against the IssuerURL with
+// IssuerURLNormalize, as the Verifier then skips the issuer check
+func (p *ProviderData) verifyToken(ctx context.Context, rawToken string) (*oidc.IDToken, error) {
+	verifier, err := p.getVerifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	if SkipVerifierExpiryCheck(p.SkewTolerance) {
+		if err := p.verifyTokenTimes(token); err != nil {
+			return nil, err
+		}
+	}
+	if p.SkipClientIDCheck() {
+		if err := p.verifyAudience(token.Audience); err != nil {
+			return nil, err
+		}
+	}
+	if p.IssuerURLNormalize && !issuerURLsMatch(token.Issuer, p.IssuerURL) {
+		return nil, fmt.Errorf("%w: expected %q got %q", ErrIDTokenIssuerMismatch, p.IssuerURL, token.Issuer)
+	}
+	return token, nil
+}
+
+// SkipClientIDCheck is true when the audience of tokens is checked against
+// the AdditionalAudiences or with RequireAudienceExact, which the Verifier
+// can't do, so the Verifier must skip its own ClientID check
+func (p *ProviderData) SkipClientIDCheck() bool {
+	return len(p.AdditionalAudiences) > 0 || p.RequireAudienceExact
+}
+
+// verifyAudience checks that the audience contains the ClientID or one of
+// the AdditionalAudiences, and with RequireAudienceExact that it contains
+// nothing else
+func (p *ProviderData) verifyAudience(audience []string) error {
+	allowed := make(map[string]struct{}, len(p.AdditionalAudiences)+1)
+	allowed[p.ClientID] = struct{}{}
+	for _, aud := range p.AdditionalAudiences {
+		allowed[aud] = struct{}{}
+	}
+
+	found := false
+	for _, aud := range audience {
+		if _, ok := allowed[aud]; ok {
+			found = true
+		} else if p.RequireAudienceExact {
+			return fmt.Errorf("%w: %q isn't an allowed audience", ErrAudienceNotAllowed, aud)
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: expected one of %q in %q", ErrAudienceNotAllowed, p.allowedAudiences(), audience)
+	}
+	return nil
+}
+
+// allowedAudiences lists the ClientID and AdditionalAudiences
+func (p *ProviderData) allowedAudiences() []string {
+	return append([]string{p.ClientID}, p.AdditionalAudiences...)
+}
+
+// issuerURLsMatch compares two issuer URLs ignoring trailing slashes
+func issuerURLsMatch(a, b string) bool {
+	return strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
+
+// SkipVerifierExpiryCheck reports whether an oidc.Config must skip its expiry
+// check for the skew. The Verifier checks `exp` and `nbf` against the same
+// time, so skewing it would relax one and tighten the other. verifyToken
+// checks both itself instead, see verifyTokenTimes.
+func SkipVerifierExpiryCheck(skew time.Duration) bool {
+	return skew > 0
+}
+
+// verifyTokenTimes checks the `exp` and `nbf` claims of a token verified with
+// SkipVerifierExpiryCheck, allowing either to be off by the SkewTolerance.
+// The `nbf` claim keeps the Verifier's own leeway too.
+func (p *ProviderData) verifyTokenTimes(token *oidc.IDToken) error {
+	now := time.Now()
+	if token.Expiry.Before(now.Add(-p.SkewTolerance)) {
+		return fmt.Errorf("oidc: token is expired (Token Expiry: %v)", token.Expiry)
+	}
+
+	var claims struct {
+		NotBefore interface{} `json:"nbf"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return fmt.Errorf("failed to parse token claims: %v", err)
+	}
+	if claims.NotBefore == nil {
+		return nil
+	}
+	var notBefore time.Time
+	if err := coerceClaim(claims.NotBefore, &notBefore); err != nil {
+		return fmt.Errorf("invalid nbf claim: %v", err)
+	}
+	if now.Add(verifierNotBeforeLeeway + p.SkewTolerance).Before(notBefore) {
+		return fmt.Errorf("oidc: current time %v before the nbf (not before) time: %v", now, notBefore)
+	}
+	return nil
+}
+
+// getVerifier returns the OIDC Verifier. If none was configured but an
+// IssuerURL is set, the Verifier is constructed via OIDC discovery on first
+// use and cached for subsequent calls. Discovery runs without holding the
+// lock and is shared by concurrent callers, each of which stops waiting for
+// it once its own context is done. A failed discovery is returned to callers
+// for verifierDiscoveryFailureTTL before it is attempted again.
+func (p *ProviderData) getVerifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	p.verifierMutex.Lock()
+	verifier := p.Verifier
+	discoveryErr := p.verifierDiscoveryErr
+	if discoveryErr != nil && !time.Now().Before(p.verifierDiscoveryRetryAt) {
+		discoveryErr = nil
+	}
+	p.verifierMutex.Unlock()
+	if verifier != nil {
+		return verifier, nil
+	}
+	if discoveryErr != nil {
+		return nil, discoveryErr
+	}
+	if p.IssuerURL == "" {
 		return nil, ErrMissingOIDCVerifier
 	}
-	return p.Verifier.Verify(ctx, rawIDToken)
+
+	discovery := p.verifierDiscovery.DoChan(p.IssuerURL, func() (interface{}, error) {
+		return p.discoverVerifier()
+	})
+	select {
+	case result := <-discovery:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*oidc.IDTokenVerifier), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("unable to construct oidc verifier via discovery of %s: %v", p.IssuerURL, ctx.Err())
+	}
+}
+
+// discoverVerifier constructs the Verifier via OIDC discovery of the
+// IssuerURL, retries included, within verifierDiscoveryTimeout and caches
+// it, or the error when discovery fails
+func (p *ProviderData) discoverVerifier() (*oidc.IDTokenVerifier, error) {
+	verifier, err := p.discoverVerifierWithTimeout()
+
+	p.verifierMutex.Lock()
+	defer p.verifierMutex.Unlock()
+	if err != nil {
+		p.verifierDiscoveryErr = err
+		p.verifierDiscoveryRetryAt = time.Now().Add(verifierDiscoveryFailureTTL)
+		return nil, err
+	}
+	p.Verifier = verifier
+	p.verifierDiscoveryErr = nil
+	return verifier, nil
+}
+
+// discoverVerifierWithTimeout constructs the Verifier from the discovery
+// document of the IssuerURL
+func (p *ProviderData) discoverVerifierWithTimeout() (*oidc.IDTokenVerifier, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), verifierDiscoveryTimeout)
+	defer cancel()
+
+	provider, err := DiscoverOIDCProvider(ctx, p.IssuerURL, p.DiscoveryMaxRetries, p.DiscoveryRetryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct oidc verifier via discovery of %s: %v", p.IssuerURL, err)
+	}
+
+	var document json.RawMessage
+	if err := provider.Claims(&document); err != nil {
+		return nil, fmt.Errorf("unable to parse oidc discovery document of %s: %v", p.IssuerURL, err)
+	}
+	var endpoints struct {
+		Issuer  string `json:"issuer"`
+		JWKSURL string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(document, &endpoints); err != nil {
+		return nil, fmt.Errorf("unable to parse oidc discovery document of %s: %v", p.IssuerURL, err)
+	}
+	if err := p.SetDiscoveryExtraFieldValues(document); err != nil {
+		p.getLogger().Errorw("Warning: unable to extract discovery document fields",
+			"provider", p.ProviderName, "error", err)
+	}
+
+	// The remote key set keeps using its context to refresh keys, so it
+	// must outlive the discovery, unlike the provider's key set
+	keySet := oidc.NewRemoteKeySet(context.Background(), endpoints.JWKSURL)
+	return oidc.NewVerifier(endpoints.Issuer, keySet, &oidc.Config{
+		ClientID:          p.ClientID,
+		SkipClientIDCheck: p.SkipClientIDCheck(),
+		SkipIssuerCheck:   p.IssuerURLNormalize,
+		SkipExpiryCheck:   SkipVerifierExpiryCheck(p.SkewTolerance),
+	}), nil
+}
+
+// SetDiscoveryExtraFieldValues extracts the DiscoveryExtraFields from the raw
+// OIDC discovery document. Fields missing from the document are left out.
+func (p *ProviderData) SetDiscoveryExtraFieldValues(document []byte) error {
+	if len(p.DiscoveryExtraFields) == 0 || len(document) == 0 {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return fmt.Errorf("could not parse OIDC discovery document: %v", err)
+	}
+
+	values := make(map[string]interface{}, len(p.DiscoveryExtraFields))
+	for _, field := range p.DiscoveryExtraFields {
+		if value, ok := getClaim(fields, field); ok {
+			values[field] = value
+		}
+	}
+
+	p.verifierMutex.Lock()
+	defer p.verifierMutex.Unlock()
+	p.discoveryExtraFieldValues = values
+	return nil
+}
+
+// GetDiscoveryExtraFieldValues returns the DiscoveryExtraFields extracted from
+// the OIDC discovery document, keyed by field. It is empty until discovery
+// has completed. The map must not be modified.
+func (p *ProviderData) GetDiscoveryExtraFieldValues() map[string]interface{} {
+	p.verifierMutex.Lock()
+	defer p.verifierMutex.Unlock()
+	return p.discoveryExtraFieldValues
+}
+
+// ClaimError is returned when a session can't be built from the claims.
+// errors.Is matches its Kind, either ErrEmailNotVerified or
+// ErrClaimExtraction, and errors.As/Unwrap give its underlying cause.
+type ClaimError struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+// newClaimError creates a ClaimError with a formatted message
+func newClaimError(kind, cause error, format string, args ...interface{}) *ClaimError {
+	return &ClaimError{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		Err:     cause,
+	}
+}
+
+func (e *ClaimError) Error() string {
+	return e.Message
+}
+
+// Is reports whether the target is the Kind of the ClaimError
+func (e *ClaimError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *ClaimError) Unwrap() error {
+	return e.Err
 }
 
 // buildSessionFromClaims uses IDToken claims to populate a fresh SessionState
-// with non-Token related fields.
+// with non-Token related fields. Errors are ClaimErrors.
 func (p *ProviderData) buildSessionFromClaims(idToken *oidc.IDToken) (*sessions.SessionState, error) {
-	ss := &sessions.SessionState{}
-
 	if idToken == nil {
-		return ss, nil
+		return &sessions.SessionState{}, nil
 	}
 
 	claims, err := p.getClaims(idToken)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't extract claims from id_token (%v)", err)
+		return nil, newClaimError(ErrClaimExtraction, err, "couldn't extract claims from id_token (%v)", err)
+	}
+	return p.buildSessionFromOIDCClaims(claims)
+}
+
+// buildSessionFromOIDCClaims builds a session from already verified claims,
+// applying the same checks and mappings to them as to an id_token
+func (p *ProviderData) buildSessionFromOIDCClaims(claims *OIDCClaims) (*sessions.SessionState, error) {
+	var err error
+	ss := &sessions.SessionState{}
+
+	if !p.isSubjectAllowed(claims.Subject) {
+		return nil, newClaimError(ErrSubjectNotAllowed, nil, "subject in id_token (%s) isn't allowed", claims.Subject)
 	}
 
 	ss.User = claims.Subject
 	ss.Email = claims.Email
+	if ss.Email == "" && p.EmailFromSubject && isEmailAddress(claims.Subject) {
+		ss.Email = claims.Subject
+	}
 	ss.Groups = claims.Groups
+	ss.Roles = claims.Roles
+	// With a profile URL the groups may still come from it, they are then
+	// required once the session is enriched
+	if p.GroupsClaimRequired && ss.Groups == nil && len(p.GetProfileURLs()) == 0 {
+		return nil, newClaimError(ErrMissingGroupsClaim, nil, "id_token has no groups claim")
+	}
+
+	if rawAuthTime, ok := claims.raw["auth_time"]; ok {
+		var authTime time.Time
+		if err := coerceClaim(rawAuthTime, &authTime); err != nil {
+			return nil, newClaimError(ErrClaimExtraction, err, "invalid auth_time claim in id_token: %v", err)
+		}
+		ss.AuthTime = &authTime
+	}
 
 	// TODO (@NickMeves) Deprecate for dynamic claim to session mapping
 	if pref, ok := claims.raw["preferred_username"].(string); ok {
 		ss.PreferredUsername = pref
 	}
 
-	// `email_verified` must be present and explicitly set to `false` to be
-	// considered unverified.
-	verifyEmail := (p.EmailClaim == OIDCEmailClaim) && !p.AllowUnverifiedEmail
-	if verifyEmail && claims.Verified != nil && !*claims.Verified {
-		return nil, fmt.Errorf("email in id_token (%s) isn't verified", claims.Email)
+	if !p.AllowUnverifiedEmail && p.isEmailUnverified(claims) {
+		if !p.RequireVerifiedEmailOrEmpty {
+			return nil, newClaimError(ErrEmailNotVerified, nil, "email in id_token (%s) isn't verified", claims.Email)
+		}
+		ss.Email = ""
+		ss.EmailUnverified = true
+	}
+
+	if p.ActiveOrgClaim != "" {
+		if rawOrg, ok := getClaim(claims.raw, p.ActiveOrgClaim); ok {
+			if err := coerceClaim(rawOrg, &ss.ActiveOrg); err != nil {
+				return nil, newClaimError(ErrClaimExtraction, err, "invalid %s claim in id_token: %v", p.ActiveOrgClaim, err)
+			}
+		}
+	}
+	if !p.isOrgAllowed(ss.ActiveOrg) {
+		return nil, newClaimError(ErrOrgNotAllowed, nil, "active org in id_token (%s) isn't allowed", ss.ActiveOrg)
+	}
+	if p.CostCenterClaim != "" {
+		if rawCostCenter, ok := getClaim(claims.raw, p.CostCenterClaim); ok {
+			if err := coerceClaim(rawCostCenter, &ss.CostCenter); err != nil {
+				return nil, newClaimError(ErrClaimExtraction, err, "invalid %s claim in id_token: %v", p.CostCenterClaim, err)
+			}
+		}
+	}
+
+	p.mapExtraClaims(ss, claims.raw)
+	if err := p.ClaimPlan.Apply(ss, claims.raw); err != nil {
+		return nil, newClaimError(ErrClaimExtraction, err, "couldn't apply claim rules to id_token (%v)", err)
+	}
+	// Prefixed last, as a claim rule may have replaced the user
+	ss.User = p.prefixSubject(ss.User)
+
+	if p.SaveRawClaims {
+		ss.RawClaims, err = json.Marshal(claims.raw)
+		if err != nil {
+			return nil, newClaimError(ErrClaimExtraction, err, "couldn't save raw claims from id_token (%v)", err)
+		}
 	}
 
 	return ss, nil
 }
 
+// mergeRawClaims merges the profile URL claims into the session's RawClaims.
+// The id_token claims win conflicts unless the ClaimPrecedence is
+// `userinfo_first`.
+func (p *ProviderData) mergeRawClaims(s *sessions.SessionState, profile map[string]interface{}) error {
+	if !p.SaveRawClaims {
+		return nil
+	}
+
+	tokenClaims := map[string]interface{}{}
+	if len(s.RawClaims) > 0 {
+		if err := json.Unmarshal(s.RawClaims, &tokenClaims); err != nil {
+			return fmt.Errorf("couldn't parse saved raw claims: %v", err)
+		}
+	}
+
+	merged := p.mergeClaims(profile, tokenClaims)
+	if p.ClaimPrecedence == ClaimPrecedenceUserinfoFirst {
+		merged = p.mergeClaims(tokenClaims, profile)
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("couldn't save raw claims from profile URL: %v", err)
+	}
+	s.RawClaims = raw
+	return nil
+}
+
+// mergeClaims returns a new map of the claims overlaid with the overlay
+// claims, the overlay wins conflicts. Empty overlay claims are ignored when
+// PreferNonEmptyClaims is set. A claim that has a different JSON type
+// in each is logged. When one of them is an array and the other a single
+// value, they are merged into the union of both unless the ClaimTypeConflict
+// is `precedence`.
+func (p *ProviderData) mergeClaims(claims, overlay map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(claims)+len(overlay))
+	for claim, value := range claims {
+		merged[claim] = value
+	}
+	for claim, value := range overlay {
+		existing, ok := merged[claim]
+		if ok && p.PreferNonEmptyClaims && isEmptyClaim(value) {
+			continue
+		}
+		if !ok || existing == nil || value == nil || jsonTypeName(existing) == jsonTypeName(value) {
+			merged[claim] = value
+			continue
+		}
+
+		union := p.ClaimTypeConflict != ClaimTypeConflictPrecedence && isArrayScalarConflict(existing, value)
+		p.getLogger().Errorw("Warning: claim has conflicting types in the id_token and profile URL",
+			"provider", p.ProviderName, "claim", claim,
+			"types", []string{jsonTypeName(existing), jsonTypeName(value)}, "union", union)
+		if union {
+			merged[claim] = unionClaimValues(value, existing)
+		} else {
+			merged[claim] = value
+		}
+	}
+	return merged
+}
+
+// isEmptyClaim is true when the claim value is JSON null, an empty string or
+// an array without any elements. Strings of whitespace, empty objects,
+// `false` and `0` are not empty.
+func isEmptyClaim(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// isArrayScalarConflict is true when one of the claim values is a JSON array
+// and the other is neither an array nor an object
+func isArrayScalarConflict(a, b interface{}) bool {
+	_, aArray := a.([]interface{})
+	_, bArray := b.([]interface{})
+	_, aObject := a.(map[string]interface{})
+	_, bObject := b.(map[string]interface{})
+	return aArray != bArray && !aObject && !bObject
+}
+
+// unionClaimValues coerces both claim values to arrays and returns their
+// union, the values of a first, keeping their order and dropping duplicates
+func unionClaimValues(a, b interface{}) []interface{} {
+	var union []interface{}
+	for _, value := range append(claimValues(a), claimValues(b)...) {
+		duplicate := false
+		for _, existing := range union {
+			if reflect.DeepEqual(existing, value) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			union = append(union, value)
+		}
+	}
+	return union
+}
+
+// claimValues returns the values of an array claim, or a single value claim
+// as an array
+func claimValues(value interface{}) []interface{} {
+	if values, ok := value.([]interface{}); ok {
+		return values
+	}
+	return []interface{}{value}
+}
+
+// standardSessionClaims are the claim names already served by fields
+// on the SessionState. These cannot be used as ClaimMappings targets.
+var standardSessionClaims = map[string]struct{}{
+	"access_token":       {},
+	"id_token":           {},
+	"created_at":         {},
+	"expires_on":         {},
+	"refresh_token":      {},
+	"email":              {},
+	"user":               {},
+	"groups":             {},
+	"roles":              {},
+	"preferred_username": {},
+}
+
+// mapExtraClaims copies any claims configured in ClaimMappings into the
+// session's Extra fields. Missing claims are skipped and non-string claims
+// are stored as JSON.
+func (p *ProviderData) mapExtraClaims(ss *sessions.SessionState, claims map[string]interface{}) {
+	for claim, key := range p.ClaimMappings {
+		if _, ok := standardSessionClaims[key]; ok {
+			p.getLogger().Errorw("Warning: claim mapping collides with a standard session field, skipping",
+				"provider", p.ProviderName, "claim", claim, "field", key)
+			continue
+		}
+
+		rawValue, exists := getClaim(claims, claim)
+		if !exists || rawValue == nil {
+			continue
+		}
+
+		value, err := formatGroup(rawValue)
+		if err != nil {
+			p.getLogger().Errorw("Warning: unable to format claim",
+				"provider", p.ProviderName, "email", ss.Email, "claim", claim,
+				"type", reflect.TypeOf(rawValue), "error", err)
+			continue
+		}
+
+		if ss.Extra == nil {
+			ss.Extra = make(map[string]string, len(p.ClaimMappings))
+		}
+		ss.Extra[key] = value
+	}
+}
+
 // getClaims extracts IDToken claims into an OIDCClaims
 func (p *ProviderData) getClaims(idToken *oidc.IDToken) (*OIDCClaims, error) {
+	var payload json.RawMessage
+	if err := idToken.Claims(&payload); err != nil {
+		return nil, fmt.Errorf("failed to parse id_token claims: %v", err)
+	}
+	return p.parseClaims(payload)
+}
+
+// parseClaims extracts the claims from the JSON payload of a token
+func (p *ProviderData) parseClaims(payload []byte) (*OIDCClaims, error) {
 	claims := &OIDCClaims{}
 
 	// Extract default claims.
-	if err := idToken.Claims(&claims); err != nil {
+	if err := json.Unmarshal(payload, &claims); err != nil {
 		return nil, fmt.Errorf("failed to parse default id_token claims: %v", err)
 	}
 	// Extract custom claims.
-	if err := idToken.Claims(&claims.raw); err != nil {
+	if err := json.Unmarshal(payload, &claims.raw); err != nil {
 		return nil, fmt.Errorf("failed to parse all id_token claims: %v", err)
 	}
 
-	email := claims.raw[p.EmailClaim]
-	if email != nil {
-		claims.Email = fmt.Sprint(email)
-	}
+	claims.Email, claims.emailClaim = p.extractEmail(claims.raw)
 	claims.Groups = p.extractGroups(claims.raw)
+	claims.Roles = p.extractRoles(claims.raw)
 
 	return claims, nil
 }
 
-// checkNonce compares the session's nonce with the IDToken's nonce claim
+// applyAccessTokenClaims updates the session from the access token claims.
+// With AccessTokenRoles the roles are merged into the session, as some
+// providers (eg. Azure) only put app roles in the access token. With an
+// AccessTokenSubjectClaim that claim becomes the session user, as the
+// access token subject may differ from the id_token (eg. Keycloak service
+// accounts). The access token is only used if it is a JWT that passes
+// verification.
+func (p *ProviderData) applyAccessTokenClaims(ctx context.Context, s *sessions.SessionState) {
+	withRoles := p.AccessTokenRoles && p.RolesClaim != ""
+	if (!withRoles && p.AccessTokenSubjectClaim == "") || s.AccessToken == "" {
+		return
+	}
+
+	accessToken, err := p.verifyToken(ctx, s.AccessToken)
+	if err == ErrMissingOIDCVerifier {
+		p.getLogger().Errorw("Unable to verify access token",
+			"provider", p.ProviderName, "email", s.Email, "error", err)
+		return
+	}
+	if err != nil {
+		p.getLogger().Infow("Skipping claims from access token that could not be verified",
+			"provider", p.ProviderName, "email", s.Email, "error", err)
+		return
+	}
+
+	var raw map[string]interface{}
+	if err := accessToken.Claims(&raw); err != nil {
+		p.getLogger().Errorw("Unable to parse access token claims",
+			"provider", p.ProviderName, "email", s.Email, "error", err)
+		return
+	}
+
+	if withRoles {
+		s.Roles = mergeUnique(s.Roles, p.extractRoles(raw))
+	}
+	if p.AccessTokenSubjectClaim != "" {
+		if user, ok := getClaim(raw, p.AccessTokenSubjectClaim); ok && user != nil && fmt.Sprint(user) != "" {
+			s.User = p.prefixSubject(fmt.Sprint(user))
+		}
+	}
+}
+
+// extractRoles extracts the list of roles from the RolesClaim
+func (p *ProviderData) extractRoles(claims map[string]interface{}) []string {
+	if p.RolesClaim == "" {
+		return nil
+	}
+	roles, _, err := getClaimSlice(claims, p.RolesClaim)
+	if err != nil {
+		p.getLogger().Errorw("Warning: unable to format roles claim",
+			"provider", p.ProviderName, "claim", p.RolesClaim, "error", err)
+		return nil
+	}
+	return roles
+}
+
+// mergeAccessTokenGroups merges the GroupsClaim of an unverified JWT access
+// token into the groups. The groups are returned unchanged when the access
+// token can't be parsed, eg. when it is opaque.
+func (p *ProviderData) mergeAccessTokenGroups(groups []string, accessToken string) []string {
+	if accessToken == "" {
+		return groups
+	}
+
+	claims, err := parseJWTClaims(accessToken, "access token")
+	if err != nil {
+		p.getLogger().Errorw("Unable to extract groups from the access token",
+			"provider", p.ProviderName, "error", err)
+		return groups
+	}
+	return mergeUnique(groups, p.extractGroups(claims))
+}
+
+// mergeUnique appends the extra values that aren't already present
+func mergeUnique(values, extra []string) []string {
+	seen := make(map[string]struct{}, len(values)+len(extra))
+	merged := make([]string, 0, len(values)+len(extra))
+	for _, value := range append(values, extra...) {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		merged = append(merged, value)
+	}
+	return merged
+}
+
+// checkNonce compares the session's nonce with the IDToken's nonce claim.
+// Sessions without a stored nonce never requested one (e.g. sessions created
+// from bearer tokens), so there is nothing to compare and the check is skipped.
+// It is skipped entirely with SkipNonceVerification.
 func (p *ProviderData) checkNonce(s *sessions.SessionState, idToken *oidc.IDToken) error {
+	if p.SkipNonceVerification || len(s.Nonce) == 0 {
+		return nil
+	}
+
 	claims, err := p.getClaims(idToken)
 	if err != nil {
 		return fmt.Errorf("id_token claims extraction failed: %v", err)
 	}
+	if claims.Nonce == "" {
+		return errors.New("id_token has no nonce claim to match the session nonce")
+	}
 	if !s.CheckNonce(claims.Nonce) {
 		return errors.New("id_token nonce claim does not match the session nonce")
 	}
 	return nil
 }
 
+// isEmailUnverified checks the EmailVerifiedClaim for the email, or the
+// `email_verified` claim when none is set. Without an EmailVerifiedClaim,
+// emails from claims other than `email` are only checked when
+// RequireEmailVerified is set. The verified claim must be explicitly
+// unverified, e.g. `false` or "unverified", for the email to be considered
+// unverified, unless RequireEmailVerified is set, where a missing verified
+// claim is unverified too.
+func (p *ProviderData) isEmailUnverified(claims *OIDCClaims) bool {
+	verifiedClaim := p.EmailVerifiedClaim
+	if verifiedClaim == "" {
+		if claims.emailClaim == OIDCEmailClaim && !p.RequireEmailVerified {
+			return claims.Verified != nil && !*claims.Verified
+		}
+		if p.RequireEmailVerified {
+			verifiedClaim = OIDCEmailVerifiedClaim
+		}
+	}
+	if claims.emailClaim == "" || verifiedClaim == "" {
+		return false
+	}
+
+	rawVerified, ok := getClaim(claims.raw, verifiedClaim)
+	if !ok || rawVerified == nil {
+		return p.RequireEmailVerified
+	}
+	verified, err := parseEmailVerified(rawVerified)
+	if err != nil {
+		p.getLogger().Errorw("Warning: unable to parse claim as a boolean",
+			"provider", p.ProviderName, "email", claims.Email, "claim", verifiedClaim, "error", err)
+		return true
+	}
+	return !verified
+}
+
+// parseEmailVerified parses an email verified claim, which is a boolean, a
+// boolean string or one of the strings "verified" and "unverified"
+func parseEmailVerified(rawVerified interface{}) (bool, error) {
+	if s, ok := rawVerified.(string); ok {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "verified":
+			return true, nil
+		case "unverified":
+			return false, nil
+		}
+	}
+	return cast.ToBoolE(rawVerified)
+}
+
+// extractEmail returns the first non-empty email from the EmailClaims, or
+// the EmailClaim when no EmailClaims are set, along with the claim it was
+// taken from
+func (p *ProviderData) extractEmail(claims map[string]interface{}) (string, string) {
+	emailClaims := p.EmailClaims
+	if len(emailClaims) == 0 {
+		emailClaims = []string{p.EmailClaim}
+	}
+
+	for _, claim := range emailClaims {
+		rawEmail, _ := getClaim(claims, claim)
+		if rawEmail == nil {
+			continue
+		}
+		if email := fmt.Sprint(rawEmail); email != "" {
+			return email, claim
+		}
+	}
+	return "", ""
+}
+
+// ParseGroupsClaimList splits a comma separated GroupsClaim into the claims
+// to try in order. JMESPath and JSONPath expressions may contain commas, so
+// they are always returned as a single claim.
+func ParseGroupsClaimList(raw string) []string {
+	if strings.HasPrefix(raw, JMESPathClaimPrefix) || strings.HasPrefix(raw, JSONPathClaimPrefix) {
+		return []string{raw}
+	}
+
+	claims := []string{}
+	for _, claim := range strings.Split(raw, ",") {
+		if claim = strings.TrimSpace(claim); claim != "" {
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
 // extractGroups extracts groups from a claim to a list in a type safe manner.
 // If the claim isn't present, `nil` is returned. If the groups claim is
 // present but empty, `[]string{}` is returned.
+// When GroupsClaim lists several claims, the first non-empty one is used.
+// With GroupsClaims, the groups of all the claims that are present are
+// merged in order, deduplicated.
 func (p *ProviderData) extractGroups(claims map[string]interface{}) []string {
-	rawClaim, ok := claims[p.GroupsClaim]
+	if len(p.GroupsClaims) > 0 {
+		var groups []string
+		for _, claim := range p.GroupsClaims {
+			if claimGroups := p.extractGroupsFromClaim(claims, claim); claimGroups != nil {
+				groups = mergeUnique(groups, claimGroups)
+			}
+		}
+		return groups
+	}
+
+	if !strings.Contains(p.GroupsClaim, ",") {
+		return p.extractGroupsFromClaim(claims, p.GroupsClaim)
+	}
+
+	var groups []string
+	for _, claim := range ParseGroupsClaimList(p.GroupsClaim) {
+		claimGroups := p.extractGroupsFromClaim(claims, claim)
+		if len(claimGroups) > 0 {
+			return claimGroups
+		}
+		if claimGroups != nil {
+			groups = claimGroups
+		}
+	}
+	return groups
+}
+
+// extractGroupsFromClaim extracts the groups from a single claim, returning
+// `nil` if the claim isn't present. The groups get the claim's prefix from
+// the GroupsClaimPrefixes.
+func (p *ProviderData) extractGroupsFromClaim(claims map[string]interface{}, claim string) []string {
+	rawClaim, ok := getClaim(claims, claim)
 	if !ok {
 		return nil
 	}
@@ -220,6 +1716,12 @@ func (p *ProviderData) extractGroups(claims map[string]interface{}) []string {
 	switch raw := rawClaim.(type) {
 	case []interface{}:
 		claimGroups = raw
+	case map[string]interface{}:
+		if p.FlattenGroupsMap {
+			claimGroups = p.flattenGroupsMap(raw, claim)
+		} else {
+			claimGroups = []interface{}{raw}
+		}
 	case interface{}:
 		claimGroups = []interface{}{raw}
 	}
@@ -228,11 +1730,52 @@ func (p *ProviderData) extractGroups(claims map[string]interface{}) []string {
 	for _, rawGroup := range claimGroups {
 		formattedGroup, err := formatGroup(rawGroup)
 		if err != nil {
-			logger.Errorf("Warning: unable to format group of type %s with error %s",
-				reflect.TypeOf(rawGroup), err)
+			p.getLogger().Errorw("Warning: unable to format group",
+				"provider", p.ProviderName, "claim", claim, "type", reflect.TypeOf(rawGroup), "error", err)
 			continue
 		}
-		groups = append(groups, formattedGroup)
+		groups = append(groups, p.GroupsClaimPrefixes[claim]+p.normalizeGroup(formattedGroup))
+	}
+	return groups
+}
+
+// normalizeGroup converts a group name to Unicode NFC when
+// NormalizeUnicodeGroups is enabled, so that equivalent names match
+// regardless of how they were encoded
+func (p *ProviderData) normalizeGroup(group string) string {
+	if !p.NormalizeUnicodeGroups {
+		return group
+	}
+	return norm.NFC.String(group)
+}
+
+// flattenGroupsMap converts a map of group to role(s) into a list of
+// qualified `group:role` names, eg. `{"eng": "admin"}` becomes `eng:admin`.
+// Groups with a list of roles produce an entry per role. The claim the map
+// came from is only used for logging.
+func (p *ProviderData) flattenGroupsMap(groupsMap map[string]interface{}, claim string) []interface{} {
+	keys := make([]string, 0, len(groupsMap))
+	for key := range groupsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var groups []interface{}
+	for _, key := range keys {
+		roles, ok := groupsMap[key].([]interface{})
+		if !ok {
+			roles = []interface{}{groupsMap[key]}
+		}
+		for _, rawRole := range roles {
+			role, err := formatGroup(rawRole)
+			if err != nil {
+				p.getLogger().Errorw("Warning: unable to format role of group",
+					"provider", p.ProviderName, "claim", claim, "group", key,
+					"type", reflect.TypeOf(rawRole), "error", err)
+				continue
+			}
+			groups = append(groups, fmt.Sprintf("%s:%s", key, role))
+		}
 	}
 	return groups
 }
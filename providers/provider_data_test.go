@@ -1,14 +1,21 @@
 package providers
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,8 +23,10 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	. "github.com/onsi/gomega"
 	"golang.org/x/oauth2"
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const (
@@ -89,6 +98,23 @@ var (
 	minimalIDToken = idTokenClaims{
 		StandardClaims: standardClaims,
 	}
+
+	orgIDToken = idTokenClaims{
+		Name:           "Jane Dobbs",
+		Email:          "janed@me.com",
+		Groups:         []string{"test:a", "test:b"},
+		Verified:       &verified,
+		OrgID:          "acme",
+		StandardClaims: standardClaims,
+	}
+
+	costCenterIDToken = idTokenClaims{
+		Name:           "Jane Dobbs",
+		Email:          "janed@me.com",
+		Verified:       &verified,
+		StandardClaims: standardClaims,
+		CostCenter:     "cc-1234",
+	}
 )
 
 type idTokenClaims struct {
@@ -100,7 +126,13 @@ type idTokenClaims struct {
 	Roles    interface{} `json:"roles,omitempty"`
 	Verified *bool       `json:"email_verified,omitempty"`
 	Nonce    string      `json:"nonce,omitempty"`
+	AuthTime interface{} `json:"auth_time,omitempty"`
+	OrgID    interface{} `json:"org_id,omitempty"`
+	AtHash   string      `json:"at_hash,omitempty"`
+	CHash    string      `json:"c_hash,omitempty"`
 	jwt.StandardClaims
+
+	CostCenter interface{} `json:"cost_center,omitempty"`
 }
 
 type mockJWKS struct{}
@@ -138,12 +170,17 @@ func newTestOauth2Token() *oauth2.Token {
 func TestProviderData_verifyIDToken(t *testing.T) {
 	failureIDToken := defaultIDToken
 	failureIDToken.Id = failureTokenID
+	atHashIDToken := defaultIDToken
+	atHashIDToken.AtHash = tokenHash(sha256.New(), accessToken)
+	wrongAtHashIDToken := defaultIDToken
+	wrongAtHashIDToken.AtHash = tokenHash(sha256.New(), "other-access-token")
 
 	testCases := map[string]struct {
-		IDToken       *idTokenClaims
-		Verifier      bool
-		ExpectIDToken bool
-		ExpectedError error
+		IDToken         *idTokenClaims
+		Verifier        bool
+		MaxIDTokenBytes int
+		ExpectIDToken   bool
+		ExpectedError   error
 	}{
 		"Valid ID Token": {
 			IDToken:       &defaultIDToken,
@@ -169,6 +206,32 @@ func TestProviderData_verifyIDToken(t *testing.T) {
 			ExpectIDToken: false,
 			ExpectedError: ErrMissingOIDCVerifier,
 		},
+		"ID Token under Size Limit": {
+			IDToken:         &defaultIDToken,
+			Verifier:        true,
+			MaxIDTokenBytes: 8192,
+			ExpectIDToken:   true,
+			ExpectedError:   nil,
+		},
+		"ID Token over Size Limit": {
+			IDToken:         &defaultIDToken,
+			Verifier:        true,
+			MaxIDTokenBytes: 16,
+			ExpectIDToken:   false,
+			ExpectedError:   ErrIDTokenTooLarge,
+		},
+		"Matching Access Token Hash": {
+			IDToken:       &atHashIDToken,
+			Verifier:      true,
+			ExpectIDToken: true,
+			ExpectedError: nil,
+		},
+		"Mismatched Access Token Hash": {
+			IDToken:       &wrongAtHashIDToken,
+			Verifier:      true,
+			ExpectIDToken: false,
+			ExpectedError: fmt.Errorf("%w: at_hash", ErrIDTokenHashMismatch),
+		},
 	}
 
 	for testName, tc := range testCases {
@@ -184,7 +247,7 @@ func TestProviderData_verifyIDToken(t *testing.T) {
 				})
 			}
 
-			provider := &ProviderData{}
+			provider := &ProviderData{MaxIDTokenBytes: tc.MaxIDTokenBytes}
 			if tc.Verifier {
 				provider.Verifier = oidc.NewVerifier(
 					oidcIssuer,
@@ -194,7 +257,7 @@ func TestProviderData_verifyIDToken(t *testing.T) {
 			}
 			verified, err := provider.verifyIDToken(context.Background(), token)
 			if err != nil {
-				g.Expect(err).To(Equal(tc.ExpectedError))
+				g.Expect(err).To(MatchError(tc.ExpectedError))
 			}
 
 			if tc.ExpectIDToken {
@@ -207,14 +270,510 @@ func TestProviderData_verifyIDToken(t *testing.T) {
 	}
 }
 
+func TestProviderData_verifyIDTokenSkewTolerance(t *testing.T) {
+	futureIDToken := defaultIDToken
+	futureIDToken.IssuedAt = time.Now().Add(30 * time.Second).Unix()
+
+	expiredIDToken := defaultIDToken
+	expiredIDToken.IssuedAt = time.Now().Add(-time.Hour).Unix()
+	expiredIDToken.ExpiresAt = time.Now().Add(-30 * time.Second).Unix()
+
+	// Expired by more than the 30 second drift of an NTP synced IdP
+	driftedIDToken := defaultIDToken
+	driftedIDToken.IssuedAt = time.Now().Add(-time.Hour).Unix()
+	driftedIDToken.ExpiresAt = time.Now().Add(-45 * time.Second).Unix()
+
+	// Not valid for another 30 seconds, within the Verifier's own leeway
+	notBeforeIDToken := defaultIDToken
+	notBeforeIDToken.NotBefore = time.Now().Add(30 * time.Second).Unix()
+
+	farNotBeforeIDToken := defaultIDToken
+	farNotBeforeIDToken.NotBefore = time.Now().Add(3 * time.Minute).Unix()
+
+	testCases := map[string]struct {
+		IDToken       idTokenClaims
+		SkewTolerance time.Duration
+		ExpectedError error
+	}{
+		"Issued In The Future Without Tolerance": {
+			IDToken: futureIDToken,
+		},
+		"Issued In The Future Within Tolerance": {
+			IDToken:       futureIDToken,
+			SkewTolerance: time.Minute,
+		},
+		"Issued In The Future Beyond Tolerance": {
+			IDToken:       futureIDToken,
+			SkewTolerance: 10 * time.Second,
+			ExpectedError: ErrIDTokenIssuedInFuture,
+		},
+		"Expired Without Tolerance": {
+			IDToken:       expiredIDToken,
+			ExpectedError: errors.New("token is expired"),
+		},
+		"Expired Within Tolerance": {
+			IDToken:       expiredIDToken,
+			SkewTolerance: time.Minute,
+		},
+		"Expired Beyond Tolerance": {
+			IDToken:       driftedIDToken,
+			SkewTolerance: 30 * time.Second,
+			ExpectedError: errors.New("token is expired"),
+		},
+		"Expired 45 Seconds Ago Within Tolerance": {
+			IDToken:       driftedIDToken,
+			SkewTolerance: time.Minute,
+		},
+		"Not Before In The Future Without Tolerance": {
+			IDToken: notBeforeIDToken,
+		},
+		"Not Before In The Future With Tolerance": {
+			IDToken:       notBeforeIDToken,
+			SkewTolerance: time.Minute,
+		},
+		"Not Before Beyond Leeway And Tolerance": {
+			IDToken:       farNotBeforeIDToken,
+			SkewTolerance: time.Minute,
+			ExpectedError: errors.New("before the nbf (not before) time"),
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			idToken, err := newSignedTestIDToken(tc.IDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+			token := newTestOauth2Token().WithExtra(map[string]interface{}{
+				"id_token": idToken,
+			})
+
+			provider := &ProviderData{
+				SkewTolerance: tc.SkewTolerance,
+				Verifier: oidc.NewVerifier(
+					oidcIssuer,
+					mockJWKS{},
+					&oidc.Config{
+						ClientID:        oidcClientID,
+						SkipExpiryCheck: SkipVerifierExpiryCheck(tc.SkewTolerance),
+					},
+				),
+			}
+			verified, err := provider.verifyIDToken(context.Background(), token)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tc.ExpectedError.Error()))
+				g.Expect(verified).To(BeNil())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(verified).ToNot(BeNil())
+			}
+		})
+	}
+}
+
+func TestProviderData_verifyIDTokenIssuerURLNormalize(t *testing.T) {
+	slashIDToken := defaultIDToken
+	slashIDToken.Issuer = oidcIssuer + "/"
+
+	testCases := map[string]struct {
+		IDToken            idTokenClaims
+		IssuerURL          string
+		IssuerURLNormalize bool
+		ExpectedError      error
+	}{
+		"Trailing Slash In IssuerURL Without Normalize": {
+			IDToken:       defaultIDToken,
+			IssuerURL:     oidcIssuer + "/",
+			ExpectedError: errors.New("id token issued by a different provider"),
+		},
+		"Trailing Slash In IssuerURL": {
+			IDToken:            defaultIDToken,
+			IssuerURL:          oidcIssuer + "/",
+			IssuerURLNormalize: true,
+		},
+		"Trailing Slash In Token Issuer": {
+			IDToken:            slashIDToken,
+			IssuerURL:          oidcIssuer,
+			IssuerURLNormalize: true,
+		},
+		"Same Issuer": {
+			IDToken:            defaultIDToken,
+			IssuerURL:          oidcIssuer,
+			IssuerURLNormalize: true,
+		},
+		"Different Issuer": {
+			IDToken:            defaultIDToken,
+			IssuerURL:          "https://other.example.com/",
+			IssuerURLNormalize: true,
+			ExpectedError:      ErrIDTokenIssuerMismatch,
+		},
+		"Different Path": {
+			IDToken:            defaultIDToken,
+			IssuerURL:          oidcIssuer + "/tenant/",
+			IssuerURLNormalize: true,
+			ExpectedError:      ErrIDTokenIssuerMismatch,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			idToken, err := newSignedTestIDToken(tc.IDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+			token := newTestOauth2Token().WithExtra(map[string]interface{}{
+				"id_token": idToken,
+			})
+
+			provider := &ProviderData{
+				IssuerURL:          tc.IssuerURL,
+				IssuerURLNormalize: tc.IssuerURLNormalize,
+				Verifier: oidc.NewVerifier(
+					tc.IssuerURL,
+					mockJWKS{},
+					&oidc.Config{
+						ClientID:        oidcClientID,
+						SkipIssuerCheck: tc.IssuerURLNormalize,
+					},
+				),
+			}
+			verified, err := provider.verifyIDToken(context.Background(), token)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tc.ExpectedError.Error()))
+				g.Expect(verified).To(BeNil())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(verified).ToNot(BeNil())
+			}
+
+			// The same token used as a bearer token
+			ss, err := provider.CreateSessionFromToken(context.Background(), idToken)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tc.ExpectedError.Error()))
+				g.Expect(ss).To(BeNil())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(ss).ToNot(BeNil())
+			}
+		})
+	}
+}
+
+func TestProviderData_verifyIDTokenAudience(t *testing.T) {
+	apiIDToken := defaultIDToken
+	apiIDToken.Audience = "api.example.com"
+
+	testCases := map[string]struct {
+		IDToken              idTokenClaims
+		AdditionalAudiences  []string
+		RequireAudienceExact bool
+		ExpectedError        error
+	}{
+		"Client ID": {
+			IDToken: defaultIDToken,
+		},
+		"Other Audience": {
+			IDToken:       apiIDToken,
+			ExpectedError: errors.New("expected audience"),
+		},
+		"Additional Audience": {
+			IDToken:             apiIDToken,
+			AdditionalAudiences: []string{"api.example.com"},
+		},
+		"Client ID With Additional Audiences": {
+			IDToken:             defaultIDToken,
+			AdditionalAudiences: []string{"api.example.com"},
+		},
+		"Not An Additional Audience": {
+			IDToken:             apiIDToken,
+			AdditionalAudiences: []string{"other.example.com"},
+			ExpectedError:       ErrAudienceNotAllowed,
+		},
+		"Exact Client ID": {
+			IDToken:              defaultIDToken,
+			RequireAudienceExact: true,
+		},
+		"Exact Other Audience": {
+			IDToken:              apiIDToken,
+			RequireAudienceExact: true,
+			ExpectedError:        ErrAudienceNotAllowed,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			idToken, err := newSignedTestIDToken(tc.IDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+			token := newTestOauth2Token().WithExtra(map[string]interface{}{
+				"id_token": idToken,
+			})
+
+			provider := &ProviderData{
+				ClientID:             oidcClientID,
+				AdditionalAudiences:  tc.AdditionalAudiences,
+				RequireAudienceExact: tc.RequireAudienceExact,
+			}
+			provider.Verifier = oidc.NewVerifier(
+				oidcIssuer,
+				mockJWKS{},
+				&oidc.Config{
+					ClientID:          oidcClientID,
+					SkipClientIDCheck: provider.SkipClientIDCheck(),
+				},
+			)
+			verified, err := provider.verifyIDToken(context.Background(), token)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tc.ExpectedError.Error()))
+				g.Expect(verified).To(BeNil())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(verified).ToNot(BeNil())
+			}
+		})
+	}
+}
+
+func TestProviderData_verifyAudience(t *testing.T) {
+	testCases := map[string]struct {
+		Audience             []string
+		AdditionalAudiences  []string
+		RequireAudienceExact bool
+		ExpectedError        bool
+	}{
+		"Client ID Among Others": {
+			Audience:            []string{"api.example.com", oidcClientID},
+			AdditionalAudiences: []string{"other.example.com"},
+		},
+		"Additional Audience Among Others": {
+			Audience:            []string{"api.example.com", "unknown.example.com"},
+			AdditionalAudiences: []string{"api.example.com"},
+		},
+		"No Allowed Audience": {
+			Audience:            []string{"unknown.example.com"},
+			AdditionalAudiences: []string{"api.example.com"},
+			ExpectedError:       true,
+		},
+		"Empty Audience": {
+			AdditionalAudiences: []string{"api.example.com"},
+			ExpectedError:       true,
+		},
+		"Exact Allowed Audiences": {
+			Audience:             []string{"api.example.com", oidcClientID},
+			AdditionalAudiences:  []string{"api.example.com"},
+			RequireAudienceExact: true,
+		},
+		"Exact With An Unknown Audience": {
+			Audience:             []string{"api.example.com", oidcClientID, "unknown.example.com"},
+			AdditionalAudiences:  []string{"api.example.com"},
+			RequireAudienceExact: true,
+			ExpectedError:        true,
+		},
+		"Exact Empty Audience": {
+			RequireAudienceExact: true,
+			ExpectedError:        true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				ClientID:             oidcClientID,
+				AdditionalAudiences:  tc.AdditionalAudiences,
+				RequireAudienceExact: tc.RequireAudienceExact,
+			}
+			err := provider.verifyAudience(tc.Audience)
+			if tc.ExpectedError {
+				g.Expect(errors.Is(err, ErrAudienceNotAllowed)).To(BeTrue())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestProviderData_verifyIDTokenLazyVerifier(t *testing.T) {
+	g := NewWithT(t)
+
+	var discoveryRequests int32
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/.well-known/openid-configuration" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&discoveryRequests, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q}`,
+			issuer, issuer+"/auth", issuer+"/token", issuer+"/keys")
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	rawIDToken, err := newSignedTestIDToken(defaultIDToken)
+	g.Expect(err).ToNot(HaveOccurred())
+	token := newTestOauth2Token().WithExtra(map[string]interface{}{
+		"id_token": rawIDToken,
+	})
+
+	provider := &ProviderData{
+		ClientID:  oidcClientID,
+		IssuerURL: issuer,
+	}
+	g.Expect(provider.Verifier).To(BeNil())
+
+	// The test token isn't signed by the discovered issuer, so verification
+	// itself still fails
+	_, err = provider.verifyIDToken(context.Background(), token)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err).ToNot(MatchError(ErrMissingOIDCVerifier))
+	g.Expect(provider.Verifier).ToNot(BeNil())
+	g.Expect(atomic.LoadInt32(&discoveryRequests)).To(Equal(int32(1)))
+
+	verifier := provider.Verifier
+	_, err = provider.verifyIDToken(context.Background(), token)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(provider.Verifier).To(BeIdenticalTo(verifier))
+	g.Expect(atomic.LoadInt32(&discoveryRequests)).To(Equal(int32(1)))
+}
+
+func TestProviderData_getVerifierDiscoveryExtraFields(t *testing.T) {
+	g := NewWithT(t)
+
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"issuer":%q,"jwks_uri":%q,"tenant_region_scope":"EU"}`, issuer, issuer+"/keys")
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	provider := &ProviderData{
+		ClientID:             oidcClientID,
+		IssuerURL:            issuer,
+		DiscoveryExtraFields: []string{"tenant_region_scope"},
+	}
+	g.Expect(provider.GetDiscoveryExtraFieldValues()).To(BeEmpty())
+
+	// The values are read while they are set by the lazy discovery
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for len(provider.GetDiscoveryExtraFieldValues()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	_, err := provider.getVerifier(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Eventually(done).Should(BeClosed())
+	g.Expect(provider.GetDiscoveryExtraFieldValues()).To(Equal(map[string]interface{}{"tenant_region_scope": "EU"}))
+}
+
+func TestProviderData_getVerifierSlowDiscovery(t *testing.T) {
+	g := NewWithT(t)
+
+	var discoveryRequests int32
+	var issuer string
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&discoveryRequests, 1)
+		<-release
+		rw.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(rw, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q,"jwks_uri":%q}`,
+			issuer, issuer+"/auth", issuer+"/token", issuer+"/keys")
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	provider := &ProviderData{
+		ClientID:  oidcClientID,
+		IssuerURL: issuer,
+	}
+
+	// Callers stop waiting for a slow discovery once their context is done,
+	// and share the discovery that is in flight
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := provider.getVerifier(ctx)
+		cancel()
+		g.Expect(err).To(MatchError(ContainSubstring(context.DeadlineExceeded.Error())))
+	}
+	g.Expect(atomic.LoadInt32(&discoveryRequests)).To(Equal(int32(1)))
+
+	close(release)
+	verifier, err := provider.getVerifier(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(verifier).ToNot(BeNil())
+	g.Expect(atomic.LoadInt32(&discoveryRequests)).To(Equal(int32(1)))
+}
+
+func TestProviderData_getVerifierFailedDiscovery(t *testing.T) {
+	g := NewWithT(t)
+
+	var discoveryRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&discoveryRequests, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	provider := &ProviderData{
+		ClientID:  oidcClientID,
+		IssuerURL: server.URL,
+	}
+
+	// The failure is returned without discovering again
+	for i := 0; i < 3; i++ {
+		_, err := provider.getVerifier(context.Background())
+		g.Expect(err).To(MatchError(ContainSubstring("unable to construct oidc verifier via discovery")))
+	}
+	g.Expect(atomic.LoadInt32(&discoveryRequests)).To(Equal(int32(1)))
+
+	// Until it expires
+	provider.verifierDiscoveryRetryAt = time.Now()
+	_, err := provider.getVerifier(context.Background())
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(atomic.LoadInt32(&discoveryRequests)).To(Equal(int32(2)))
+}
+
 func TestProviderData_buildSessionFromClaims(t *testing.T) {
+	authTime := time.Unix(1600000000, 0)
+	authTimeIDToken := defaultIDToken
+	authTimeIDToken.AuthTime = authTime.Unix()
+	invalidAuthTimeIDToken := defaultIDToken
+	invalidAuthTimeIDToken.AuthTime = "yesterday"
+	emailSubjectIDToken := defaultIDToken
+	emailSubjectIDToken.Email = ""
+	emailSubjectIDToken.Subject = "janed@me.com"
+	noEmailIDToken := defaultIDToken
+	noEmailIDToken.Email = ""
+
 	testCases := map[string]struct {
-		IDToken         idTokenClaims
-		AllowUnverified bool
-		EmailClaim      string
-		GroupsClaim     string
-		ExpectedError   error
-		ExpectedSession *sessions.SessionState
+		IDToken          idTokenClaims
+		AllowUnverified  bool
+		ClearUnverified  bool
+		EmailClaim       string
+		GroupsClaim      string
+		GroupsRequired   bool
+		EmailFromSubject bool
+		EmailClaims      []string
+		EmailVerified    string
+		ClaimRules       []ClaimRule
+		AllowedSubjects  []string
+		DeniedSubjects   []string
+		ActiveOrgClaim   string
+		AllowedOrgs      []string
+		CostCenterClaim  string
+		ExpectedError    error
+		ExpectedKind     error
+		ExpectedSession  *sessions.SessionState
 	}{
 		"Standard": {
 			IDToken:         defaultIDToken,
@@ -234,6 +793,7 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 			EmailClaim:      "email",
 			GroupsClaim:     "groups",
 			ExpectedError:   errors.New("email in id_token (unverified@email.com) isn't verified"),
+			ExpectedKind:    ErrEmailNotVerified,
 		},
 		"Unverified Allowed": {
 			IDToken:         unverifiedIDToken,
@@ -247,6 +807,45 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Mystery Man",
 			},
 		},
+		"Unverified Cleared": {
+			IDToken:         unverifiedIDToken,
+			ClearUnverified: true,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "",
+				EmailUnverified:   true,
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Mystery Man",
+			},
+		},
+		"Verified Kept When Clearing Unverified": {
+			IDToken:         defaultIDToken,
+			ClearUnverified: true,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Custom Email Claim Unverified Cleared": {
+			IDToken:         unverifiedIDToken,
+			ClearUnverified: true,
+			EmailClaim:      "phone_number",
+			GroupsClaim:     "groups",
+			EmailVerified:   "email_verified",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "",
+				EmailUnverified:   true,
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Mystery Man",
+			},
+		},
 		"Complex Groups": {
 			IDToken:         complexGroupsIDToken,
 			AllowUnverified: true,
@@ -319,66 +918,1563 @@ func TestProviderData_buildSessionFromClaims(t *testing.T) {
 				PreferredUsername: "Jane Dobbs",
 			},
 		},
-	}
-	for testName, tc := range testCases {
-		t.Run(testName, func(t *testing.T) {
-			g := NewWithT(t)
-
-			provider := &ProviderData{
-				Verifier: oidc.NewVerifier(
-					oidcIssuer,
-					mockJWKS{},
-					&oidc.Config{ClientID: oidcClientID},
-				),
-			}
-			provider.AllowUnverifiedEmail = tc.AllowUnverified
-			provider.EmailClaim = tc.EmailClaim
-			provider.GroupsClaim = tc.GroupsClaim
-
-			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
-			g.Expect(err).ToNot(HaveOccurred())
-
-			idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
-			g.Expect(err).ToNot(HaveOccurred())
-
-			ss, err := provider.buildSessionFromClaims(idToken)
-			if err != nil {
-				g.Expect(err).To(Equal(tc.ExpectedError))
-			}
-			if ss != nil {
-				g.Expect(ss).To(Equal(tc.ExpectedSession))
-			}
-		})
+		"With Auth Time": {
+			IDToken:         authTimeIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+				AuthTime:          &authTime,
+			},
+		},
+		"Email Claims Fall Back In Order": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			EmailClaims:     []string{"mail", "phone_number", "email"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "+4798765432",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Email Claims Unverified Denied When Resolved To Email": {
+			IDToken:         unverifiedIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			EmailClaims:     []string{"mail", "email", "phone_number"},
+			ExpectedError:   errors.New("email in id_token (unverified@email.com) isn't verified"),
+			ExpectedKind:    ErrEmailNotVerified,
+		},
+		"Email Claims Unverified Allowed When Resolved To Other Claim": {
+			IDToken:         unverifiedIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			EmailClaims:     []string{"mail", "phone_number", "email"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "+4025205729",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Mystery Man",
+			},
+		},
+		"Custom Email Claim Unverified Denied": {
+			IDToken:         unverifiedIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "phone_number",
+			GroupsClaim:     "groups",
+			EmailVerified:   "email_verified",
+			ExpectedError:   errors.New("email in id_token (+4025205729) isn't verified"),
+			ExpectedKind:    ErrEmailNotVerified,
+		},
+		"Custom Email Claim Unverified Allowed": {
+			IDToken:         unverifiedIDToken,
+			AllowUnverified: true,
+			EmailClaim:      "phone_number",
+			GroupsClaim:     "groups",
+			EmailVerified:   "email_verified",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "+4025205729",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Mystery Man",
+			},
+		},
+		"Custom Email Claim Verified": {
+			IDToken:         defaultIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "phone_number",
+			GroupsClaim:     "groups",
+			EmailVerified:   "email_verified",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "+4798765432",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Email From Email Shaped Subject": {
+			IDToken:          emailSubjectIDToken,
+			AllowUnverified:  false,
+			EmailClaim:       "email",
+			GroupsClaim:      "groups",
+			EmailFromSubject: true,
+			ExpectedSession: &sessions.SessionState{
+				User:              "janed@me.com",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"No Email From Non Email Subject": {
+			IDToken:          noEmailIDToken,
+			AllowUnverified:  false,
+			EmailClaim:       "email",
+			GroupsClaim:      "groups",
+			EmailFromSubject: true,
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"No Email From Subject When Disabled": {
+			IDToken:          emailSubjectIDToken,
+			AllowUnverified:  false,
+			EmailClaim:       "email",
+			GroupsClaim:      "groups",
+			EmailFromSubject: false,
+			ExpectedSession: &sessions.SessionState{
+				User:              "janed@me.com",
+				Email:             "",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Invalid Auth Time": {
+			IDToken:         invalidAuthTimeIDToken,
+			AllowUnverified: false,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			ExpectedError:   errors.New("invalid auth_time claim in id_token: unable to parse \"yesterday\" as a Unix timestamp or RFC3339 date"),
+			ExpectedKind:    ErrClaimExtraction,
+		},
+		"Claim Rules": {
+			IDToken:     defaultIDToken,
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			ClaimRules: []ClaimRule{
+				{Claim: "preferred_username", Target: "user", Required: true, Transform: ClaimRuleTransformLowercase},
+				{Claim: "groups", Target: "roles"},
+				{Claim: "phone_number", Target: "phone"},
+			},
+			ExpectedSession: &sessions.SessionState{
+				User:              "jane dobbs",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				Roles:             []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+				Extra:             map[string]string{"phone": "+4798765432"},
+			},
+		},
+		"Claim Rules Missing Required Claim": {
+			IDToken:     defaultIDToken,
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			ClaimRules: []ClaimRule{
+				{Claim: "employee_id", Target: "employee_id", Required: true},
+			},
+			ExpectedError: errors.New("couldn't apply claim rules to id_token (required claim \"employee_id\" is missing)"),
+			ExpectedKind:  ErrClaimExtraction,
+		},
+		"Denied Subject": {
+			IDToken:        defaultIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			DeniedSubjects: []string{"987654321", "123456789"},
+			ExpectedError:  errors.New("subject in id_token (123456789) isn't allowed"),
+			ExpectedKind:   ErrSubjectNotAllowed,
+		},
+		"Allowed Subject": {
+			IDToken:         defaultIDToken,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			AllowedSubjects: []string{"123456789"},
+			DeniedSubjects:  []string{"987654321"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Subject Not In Allowed Subjects": {
+			IDToken:         defaultIDToken,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			AllowedSubjects: []string{"987654321"},
+			ExpectedError:   errors.New("subject in id_token (123456789) isn't allowed"),
+			ExpectedKind:    ErrSubjectNotAllowed,
+		},
+		"Denied Subject Takes Precedence": {
+			IDToken:         defaultIDToken,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			AllowedSubjects: []string{"123456789"},
+			DeniedSubjects:  []string{"123456789"},
+			ExpectedError:   errors.New("subject in id_token (123456789) isn't allowed"),
+			ExpectedKind:    ErrSubjectNotAllowed,
+		},
+		"Active Org": {
+			IDToken:        orgIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			ActiveOrgClaim: "org_id",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+				ActiveOrg:         "acme",
+			},
+		},
+		"Active Org Not Extracted Without A Claim": {
+			IDToken:     orgIDToken,
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Allowed Org": {
+			IDToken:        orgIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			ActiveOrgClaim: "org_id",
+			AllowedOrgs:    []string{"globex", "acme"},
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+				ActiveOrg:         "acme",
+			},
+		},
+		"Org Not In Allowed Orgs": {
+			IDToken:        orgIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			ActiveOrgClaim: "org_id",
+			AllowedOrgs:    []string{"globex"},
+			ExpectedError:  errors.New("active org in id_token (acme) isn't allowed"),
+			ExpectedKind:   ErrOrgNotAllowed,
+		},
+		"Missing Org With Allowed Orgs": {
+			IDToken:        defaultIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			ActiveOrgClaim: "org_id",
+			AllowedOrgs:    []string{"acme"},
+			ExpectedError:  errors.New("active org in id_token () isn't allowed"),
+			ExpectedKind:   ErrOrgNotAllowed,
+		},
+		"Cost Center": {
+			IDToken:         costCenterIDToken,
+			EmailClaim:      "email",
+			CostCenterClaim: "cost_center",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				PreferredUsername: "Jane Dobbs",
+				CostCenter:        "cc-1234",
+			},
+		},
+		"Cost Center Not Extracted Without A Claim": {
+			IDToken:    costCenterIDToken,
+			EmailClaim: "email",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Missing Cost Center": {
+			IDToken:         defaultIDToken,
+			EmailClaim:      "email",
+			GroupsClaim:     "groups",
+			CostCenterClaim: "cost_center",
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Required Groups Claim": {
+			IDToken:        defaultIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "groups",
+			GroupsRequired: true,
+			ExpectedSession: &sessions.SessionState{
+				User:              "123456789",
+				Email:             "janed@me.com",
+				Groups:            []string{"test:a", "test:b"},
+				PreferredUsername: "Jane Dobbs",
+			},
+		},
+		"Missing Required Groups Claim": {
+			IDToken:        defaultIDToken,
+			EmailClaim:     "email",
+			GroupsClaim:    "teams",
+			GroupsRequired: true,
+			ExpectedError:  errors.New("id_token has no groups claim"),
+			ExpectedKind:   ErrMissingGroupsClaim,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				Verifier: oidc.NewVerifier(
+					oidcIssuer,
+					mockJWKS{},
+					&oidc.Config{ClientID: oidcClientID},
+				),
+			}
+			provider.AllowUnverifiedEmail = tc.AllowUnverified
+			provider.RequireVerifiedEmailOrEmpty = tc.ClearUnverified
+			provider.EmailClaim = tc.EmailClaim
+			provider.GroupsClaim = tc.GroupsClaim
+			provider.GroupsClaimRequired = tc.GroupsRequired
+			provider.EmailFromSubject = tc.EmailFromSubject
+			provider.EmailClaims = tc.EmailClaims
+			provider.EmailVerifiedClaim = tc.EmailVerified
+			provider.SetAllowedSubjects(tc.AllowedSubjects)
+			provider.SetDeniedSubjects(tc.DeniedSubjects)
+			provider.ActiveOrgClaim = tc.ActiveOrgClaim
+			provider.SetAllowedOrgs(tc.AllowedOrgs)
+			provider.CostCenterClaim = tc.CostCenterClaim
+			claimPlan, err := CompileClaimPlan(tc.ClaimRules)
+			g.Expect(err).ToNot(HaveOccurred())
+			provider.ClaimPlan = claimPlan
+
+			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			ss, err := provider.buildSessionFromClaims(idToken)
+			if err != nil {
+				g.Expect(err).To(MatchError(tc.ExpectedError.Error()))
+				g.Expect(errors.Is(err, tc.ExpectedKind)).To(BeTrue())
+			}
+			if ss != nil {
+				g.Expect(ss).To(Equal(tc.ExpectedSession))
+			}
+		})
+	}
+}
+
+func TestProviderData_buildSessionFromClaimsSubjectPrefix(t *testing.T) {
+	g := NewWithT(t)
+
+	provider := &ProviderData{
+		Verifier: oidc.NewVerifier(
+			oidcIssuer,
+			mockJWKS{},
+			&oidc.Config{ClientID: oidcClientID},
+		),
+		EmailClaim:    "email",
+		GroupsClaim:   "groups",
+		SubjectPrefix: "google",
+	}
+
+	claims := standardClaims
+	claims.Subject = "abc"
+	rawIDToken, err := newSignedTestIDToken(idTokenClaims{
+		Email:          "janed@me.com",
+		Verified:       &verified,
+		StandardClaims: claims,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ss, err := provider.buildSessionFromClaims(idToken)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ss.User).To(Equal("google|abc"))
+	g.Expect(ss.Email).To(Equal("janed@me.com"))
+
+	// A claim rule targeting the user is prefixed too
+	provider.ClaimPlan, err = CompileClaimPlan([]ClaimRule{{Claim: "email", Target: "user"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	ss, err = provider.buildSessionFromClaims(idToken)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ss.User).To(Equal("google|janed@me.com"))
+
+	authorized, err := provider.Authorize(context.Background(), ss)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authorized).To(BeTrue())
+}
+
+func TestProviderData_buildSessionFromClaimsGroupsClaimPrefixes(t *testing.T) {
+	g := NewWithT(t)
+
+	provider := &ProviderData{
+		Verifier: oidc.NewVerifier(
+			oidcIssuer,
+			mockJWKS{},
+			&oidc.Config{ClientID: oidcClientID},
+		),
+		EmailClaim:          "email",
+		GroupsClaims:        []string{"groups", "roles"},
+		GroupsClaimPrefixes: map[string]string{"groups": "group:", "roles": "role:"},
+	}
+	provider.SetAllowedGroups([]string{"role:admin"})
+
+	rawIDToken, err := newSignedTestIDToken(idTokenClaims{
+		Email:          "janed@me.com",
+		Verified:       &verified,
+		Groups:         []string{"admin", "users"},
+		Roles:          []string{"admin"},
+		StandardClaims: standardClaims,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ss, err := provider.buildSessionFromClaims(idToken)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ss.Groups).To(Equal([]string{"group:admin", "group:users", "role:admin"}))
+
+	// Only the prefixed role is allowed, not the group of the same name
+	authorized, err := provider.Authorize(context.Background(), ss)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authorized).To(BeTrue())
+
+	ss.Groups = []string{"group:admin", "group:users"}
+	authorized, err = provider.Authorize(context.Background(), ss)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authorized).To(BeFalse())
+}
+
+func TestProviderData_AuthorizeSubjectPrefix(t *testing.T) {
+	testCases := map[string]struct {
+		SubjectPrefix          string
+		SubjectPrefixMigration bool
+		User                   string
+		ExpectedAuthorized     bool
+		ExpectedUser           string
+	}{
+		"No Subject Prefix": {
+			User:               "abc",
+			ExpectedAuthorized: true,
+			ExpectedUser:       "abc",
+		},
+		"Prefixed User": {
+			SubjectPrefix:      "google",
+			User:               "google|abc",
+			ExpectedAuthorized: true,
+			ExpectedUser:       "google|abc",
+		},
+		"Unprefixed User": {
+			SubjectPrefix:      "google",
+			User:               "abc",
+			ExpectedAuthorized: false,
+			ExpectedUser:       "abc",
+		},
+		"User Of Another Prefix": {
+			SubjectPrefix:      "google",
+			User:               "github|abc",
+			ExpectedAuthorized: false,
+			ExpectedUser:       "github|abc",
+		},
+		"Unprefixed User Is Migrated": {
+			SubjectPrefix:          "google",
+			SubjectPrefixMigration: true,
+			User:                   "abc",
+			ExpectedAuthorized:     true,
+			ExpectedUser:           "google|abc",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				SubjectPrefix:          tc.SubjectPrefix,
+				SubjectPrefixMigration: tc.SubjectPrefixMigration,
+			}
+			session := &sessions.SessionState{User: tc.User}
+			authorized, err := provider.Authorize(context.Background(), session)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(authorized).To(Equal(tc.ExpectedAuthorized))
+			g.Expect(session.User).To(Equal(tc.ExpectedUser))
+		})
+	}
+}
+
+func TestProviderData_AuthorizeDeniedSubjects(t *testing.T) {
+	testCases := map[string]struct {
+		SubjectPrefix      string
+		User               string
+		ExpectedAuthorized bool
+	}{
+		"Allowed User": {
+			User:               "abc",
+			ExpectedAuthorized: true,
+		},
+		"Denied User": {
+			User:               "denied",
+			ExpectedAuthorized: false,
+		},
+		"Prefixed Denied User": {
+			SubjectPrefix:      "google",
+			User:               "google|denied",
+			ExpectedAuthorized: false,
+		},
+		"Prefixed Allowed User": {
+			SubjectPrefix:      "google",
+			User:               "google|abc",
+			ExpectedAuthorized: true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{SubjectPrefix: tc.SubjectPrefix}
+			provider.SetDeniedSubjects([]string{"denied"})
+			authorized, err := provider.Authorize(context.Background(), &sessions.SessionState{User: tc.User})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(authorized).To(Equal(tc.ExpectedAuthorized))
+		})
+	}
+}
+
+func TestClaimError(t *testing.T) {
+	g := NewWithT(t)
+
+	cause := errors.New("unable to cast")
+	err := error(newClaimError(ErrClaimExtraction, cause, "invalid auth_time claim in id_token: %v", cause))
+
+	g.Expect(err).To(MatchError("invalid auth_time claim in id_token: unable to cast"))
+	g.Expect(errors.Is(err, ErrClaimExtraction)).To(BeTrue())
+	g.Expect(errors.Is(err, ErrEmailNotVerified)).To(BeFalse())
+	g.Expect(errors.Is(err, cause)).To(BeTrue())
+
+	var claimErr *ClaimError
+	g.Expect(errors.As(fmt.Errorf("could not redeem: %w", err), &claimErr)).To(BeTrue())
+	g.Expect(claimErr.Kind).To(Equal(ErrClaimExtraction))
+	g.Expect(claimErr.Err).To(Equal(cause))
+}
+
+func TestProviderData_SetDiscoveryExtraFieldValues(t *testing.T) {
+	document := []byte(`{
+		"issuer": "https://issuer.example.com",
+		"tenant_region_scope": "EU",
+		"access_token_ttl_max": 3600,
+		"mtls_endpoint_aliases": {"token_endpoint": "https://mtls.example.com/token"}
+	}`)
+
+	testCases := map[string]struct {
+		Fields         []string
+		Document       []byte
+		ExpectedValues map[string]interface{}
+		ExpectedError  string
+	}{
+		"No Fields": {
+			Fields:         nil,
+			Document:       document,
+			ExpectedValues: nil,
+		},
+		"Extracts Fields": {
+			Fields:   []string{"tenant_region_scope", "access_token_ttl_max"},
+			Document: document,
+			ExpectedValues: map[string]interface{}{
+				"tenant_region_scope":  "EU",
+				"access_token_ttl_max": float64(3600),
+			},
+		},
+		"Extracts Nested Fields": {
+			Fields:   []string{"mtls_endpoint_aliases.token_endpoint"},
+			Document: document,
+			ExpectedValues: map[string]interface{}{
+				"mtls_endpoint_aliases.token_endpoint": "https://mtls.example.com/token",
+			},
+		},
+		"Skips Missing Fields": {
+			Fields:   []string{"tenant_region_scope", "missing"},
+			Document: document,
+			ExpectedValues: map[string]interface{}{
+				"tenant_region_scope": "EU",
+			},
+		},
+		"No Document": {
+			Fields:         []string{"tenant_region_scope"},
+			Document:       nil,
+			ExpectedValues: nil,
+		},
+		"Invalid Document": {
+			Fields:        []string{"tenant_region_scope"},
+			Document:      []byte(`{"issuer":`),
+			ExpectedError: "could not parse OIDC discovery document",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			p := &ProviderData{DiscoveryExtraFields: tc.Fields}
+			err := p.SetDiscoveryExtraFieldValues(tc.Document)
+			if tc.ExpectedError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.ExpectedError)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(p.GetDiscoveryExtraFieldValues()).To(Equal(tc.ExpectedValues))
+		})
+	}
+}
+
+func TestProviderData_checkNonce(t *testing.T) {
+	testCases := map[string]struct {
+		Session               *sessions.SessionState
+		IDToken               idTokenClaims
+		SkipNonceVerification bool
+		ExpectedError         error
+	}{
+		"Nonces match": {
+			Session: &sessions.SessionState{
+				Nonce: []byte(oidcNonce),
+			},
+			IDToken:       defaultIDToken,
+			ExpectedError: nil,
+		},
+		"Nonces do not match": {
+			Session: &sessions.SessionState{
+				Nonce: []byte("WrongWrongWrong"),
+			},
+			IDToken:       defaultIDToken,
+			ExpectedError: errors.New("id_token nonce claim does not match the session nonce"),
+		},
+
+		"Missing nonce claim": {
+			Session: &sessions.SessionState{
+				Nonce: []byte(oidcNonce),
+			},
+			IDToken:       minimalIDToken,
+			ExpectedError: errors.New("id_token has no nonce claim to match the session nonce"),
+		},
+		"Missing nonce claim skipping verification": {
+			Session: &sessions.SessionState{
+				Nonce: []byte(oidcNonce),
+			},
+			IDToken:               minimalIDToken,
+			SkipNonceVerification: true,
+			ExpectedError:         nil,
+		},
+		"Nonces do not match skipping verification": {
+			Session: &sessions.SessionState{
+				Nonce: []byte("WrongWrongWrong"),
+			},
+			IDToken:               defaultIDToken,
+			SkipNonceVerification: true,
+			ExpectedError:         nil,
+		},
+		"No nonce set in session": {
+			Session:       &sessions.SessionState{},
+			IDToken:       defaultIDToken,
+			ExpectedError: nil,
+		},
+		"No nonce set in session or claim": {
+			Session:       &sessions.SessionState{},
+			IDToken:       minimalIDToken,
+			ExpectedError: nil,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				Verifier: oidc.NewVerifier(
+					oidcIssuer,
+					mockJWKS{},
+					&oidc.Config{ClientID: oidcClientID},
+				),
+				SkipNonceVerification: tc.SkipNonceVerification,
+			}
+
+			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			err = provider.checkNonce(tc.Session, idToken)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(Equal(tc.ExpectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestProviderData_extractGroups(t *testing.T) {
+	testCases := map[string]struct {
+		Claims           map[string]interface{}
+		GroupsClaim      string
+		GroupsClaims     []string
+		GroupsPrefixes   map[string]string
+		FlattenGroupsMap bool
+		NormalizeUnicode bool
+		ExpectedGroups   []string
+	}{
+		"Normalizes Unicode Groups": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{"cafe\u0301", "caf\u00e9", "plain"},
+			},
+			GroupsClaim:      "groups",
+			NormalizeUnicode: true,
+			ExpectedGroups:   []string{"caf\u00e9", "caf\u00e9", "plain"},
+		},
+		"Standard String Groups": {
+			Claims: map[string]interface{}{
+				"email":  "this@does.not.matter.com",
+				"groups": []interface{}{"three", "string", "groups"},
+			},
+			GroupsClaim:    "groups",
+			ExpectedGroups: []string{"three", "string", "groups"},
+		},
+		"Different Claim Name": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"roles": []interface{}{"three", "string", "roles"},
+			},
+			GroupsClaim:    "roles",
+			ExpectedGroups: []string{"three", "string", "roles"},
+		},
+		"Numeric Groups": {
+			Claims: map[string]interface{}{
+				"email":  "this@does.not.matter.com",
+				"groups": []interface{}{1, 2, 3},
+			},
+			GroupsClaim:    "groups",
+			ExpectedGroups: []string{"1", "2", "3"},
+		},
+		"Complex Groups": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"groups": []interface{}{
+					map[string]interface{}{
+						"groupId": "Admin Group Id",
+						"roles":   []string{"Admin"},
+					},
+					12345,
+					"Just::A::String",
+				},
+			},
+			GroupsClaim: "groups",
+			ExpectedGroups: []string{
+				"{\"groupId\":\"Admin Group Id\",\"roles\":[\"Admin\"]}",
+				"12345",
+				"Just::A::String",
+			},
+		},
+		"Missing Groups Claim Returns Nil": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+			},
+			GroupsClaim:    "groups",
+			ExpectedGroups: nil,
+		},
+		"Non List Groups": {
+			Claims: map[string]interface{}{
+				"email":  "this@does.not.matter.com",
+				"groups": "singleton",
+			},
+			GroupsClaim:    "groups",
+			ExpectedGroups: []string{"singleton"},
+		},
+		"Map Groups": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"groups": map[string]interface{}{
+					"eng": "admin",
+					"ops": "member",
+				},
+			},
+			GroupsClaim:    "groups",
+			ExpectedGroups: []string{"{\"eng\":\"admin\",\"ops\":\"member\"}"},
+		},
+		"Flattened Map Groups": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"groups": map[string]interface{}{
+					"ops": "member",
+					"eng": "admin",
+				},
+			},
+			GroupsClaim:      "groups",
+			FlattenGroupsMap: true,
+			ExpectedGroups:   []string{"eng:admin", "ops:member"},
+		},
+		"Flattened Map Groups With Multiple Roles": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"groups": map[string]interface{}{
+					"eng": []interface{}{"admin", "member"},
+					"ops": 1,
+				},
+			},
+			GroupsClaim:      "groups",
+			FlattenGroupsMap: true,
+			ExpectedGroups:   []string{"eng:admin", "eng:member", "ops:1"},
+		},
+		"Flattening Ignores List Groups": {
+			Claims: map[string]interface{}{
+				"email":  "this@does.not.matter.com",
+				"groups": []interface{}{"three", "string", "groups"},
+			},
+			GroupsClaim:      "groups",
+			FlattenGroupsMap: true,
+			ExpectedGroups:   []string{"three", "string", "groups"},
+		},
+		"Nested Groups Claim": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"resource_access": map[string]interface{}{
+					"my-client": map[string]interface{}{
+						"roles": []interface{}{"admin", "user"},
+					},
+				},
+			},
+			GroupsClaim:    "resource_access.my-client.roles",
+			ExpectedGroups: []string{"admin", "user"},
+		},
+		"Missing Nested Groups Claim Returns Nil": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+				"resource_access": map[string]interface{}{
+					"other-client": map[string]interface{}{
+						"roles": []interface{}{"admin", "user"},
+					},
+				},
+			},
+			GroupsClaim:    "resource_access.my-client.roles",
+			ExpectedGroups: nil,
+		},
+		"Claim List Uses First Claim": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{"group"},
+				"roles":  []interface{}{"role"},
+				"realm_access": map[string]interface{}{
+					"roles": []interface{}{"realm-role"},
+				},
+			},
+			GroupsClaim:    "groups,roles,realm_access.roles",
+			ExpectedGroups: []string{"group"},
+		},
+		"Claim List Falls Back To Second Claim": {
+			Claims: map[string]interface{}{
+				"roles": []interface{}{"role"},
+				"realm_access": map[string]interface{}{
+					"roles": []interface{}{"realm-role"},
+				},
+			},
+			GroupsClaim:    "groups,roles,realm_access.roles",
+			ExpectedGroups: []string{"role"},
+		},
+		"Claim List Falls Back To Nested Claim": {
+			Claims: map[string]interface{}{
+				"realm_access": map[string]interface{}{
+					"roles": []interface{}{"realm-role"},
+				},
+			},
+			GroupsClaim:    "groups,roles,realm_access.roles",
+			ExpectedGroups: []string{"realm-role"},
+		},
+		"Claim List Skips Empty Claims": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{},
+				"roles":  []interface{}{"role"},
+			},
+			GroupsClaim:    "groups,roles,realm_access.roles",
+			ExpectedGroups: []string{"role"},
+		},
+		"Claim List With Only Empty Claims Returns Empty": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{},
+			},
+			GroupsClaim:    "groups,roles,realm_access.roles",
+			ExpectedGroups: []string{},
+		},
+		"Claim List With No Claims Returns Nil": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+			},
+			GroupsClaim:    "groups,roles,realm_access.roles",
+			ExpectedGroups: nil,
+		},
+		"Claim List Trims Whitespace": {
+			Claims: map[string]interface{}{
+				"roles": []interface{}{"role"},
+			},
+			GroupsClaim:    " groups , roles ",
+			ExpectedGroups: []string{"role"},
+		},
+		"Claims Are Merged In Order": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{"admins", "users"},
+				"roles":  []interface{}{"editor", "admins"},
+			},
+			GroupsClaim:    "groups",
+			GroupsClaims:   []string{"groups", "roles"},
+			ExpectedGroups: []string{"admins", "users", "editor"},
+		},
+		"Claims Skip Missing Claims": {
+			Claims: map[string]interface{}{
+				"realm_access": map[string]interface{}{
+					"roles": []interface{}{"realm-role"},
+				},
+			},
+			GroupsClaims:   []string{"groups", "realm_access.roles"},
+			ExpectedGroups: []string{"realm-role"},
+		},
+		"Claims With Only Empty Claims Returns Empty": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{},
+			},
+			GroupsClaims:   []string{"groups", "roles"},
+			ExpectedGroups: []string{},
+		},
+		"Claims Are Prefixed": {
+			Claims: map[string]interface{}{
+				"groups": []interface{}{"admin", "users"},
+				"roles":  []interface{}{"admin"},
+			},
+			GroupsClaims:   []string{"groups", "roles"},
+			GroupsPrefixes: map[string]string{"groups": "group:", "roles": "role:"},
+			ExpectedGroups: []string{"group:admin", "group:users", "role:admin"},
+		},
+		"Claim List Is Prefixed": {
+			Claims: map[string]interface{}{
+				"roles": []interface{}{"admin"},
+			},
+			GroupsClaim:    "groups,roles",
+			GroupsPrefixes: map[string]string{"groups": "group:", "roles": "role:"},
+			ExpectedGroups: []string{"role:admin"},
+		},
+		"Claims With No Claims Returns Nil": {
+			Claims: map[string]interface{}{
+				"email": "this@does.not.matter.com",
+			},
+			GroupsClaims:   []string{"groups", "roles"},
+			ExpectedGroups: nil,
+		},
+		"Attribute Statement Of Name And Values": {
+			Claims: map[string]interface{}{
+				"attributes": []interface{}{
+					map[string]interface{}{
+						"name":   "mail",
+						"values": []interface{}{"janed@me.com"},
+					},
+					map[string]interface{}{
+						"name":   "memberOf",
+						"values": []interface{}{"admins", "users"},
+					},
+				},
+			},
+			GroupsClaim:    "jmespath:attributes[?name=='memberOf'].values[]",
+			ExpectedGroups: []string{"admins", "users"},
+		},
+		"Attribute Statement Of Nested Attribute Values": {
+			Claims: map[string]interface{}{
+				"AttributeStatement": map[string]interface{}{
+					"Attribute": []interface{}{
+						map[string]interface{}{
+							"@Name": "http://schemas.xmlsoap.org/claims/Group",
+							"AttributeValue": []interface{}{
+								map[string]interface{}{"#text": "admins"},
+								map[string]interface{}{"#text": "users"},
+							},
+						},
+						map[string]interface{}{
+							"@Name": "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+							"AttributeValue": []interface{}{
+								map[string]interface{}{"#text": "janed@me.com"},
+							},
+						},
+					},
+				},
+			},
+			GroupsClaim:    "jmespath:AttributeStatement.Attribute[?\"@Name\"=='http://schemas.xmlsoap.org/claims/Group'].AttributeValue[][\"#text\"][]",
+			ExpectedGroups: []string{"admins", "users"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				Verifier: oidc.NewVerifier(
+					oidcIssuer,
+					mockJWKS{},
+					&oidc.Config{ClientID: oidcClientID},
+				),
+			}
+			provider.GroupsClaim = tc.GroupsClaim
+			provider.GroupsClaims = tc.GroupsClaims
+			provider.GroupsClaimPrefixes = tc.GroupsPrefixes
+			provider.FlattenGroupsMap = tc.FlattenGroupsMap
+			provider.NormalizeUnicodeGroups = tc.NormalizeUnicode
+
+			groups := provider.extractGroups(tc.Claims)
+			if tc.ExpectedGroups != nil {
+				g.Expect(groups).To(Equal(tc.ExpectedGroups))
+			} else {
+				g.Expect(groups).To(BeNil())
+			}
+		})
+	}
+}
+
+func TestParseGroupsClaimList(t *testing.T) {
+	testCases := map[string]struct {
+		Raw            string
+		ExpectedClaims []string
+	}{
+		"Empty": {
+			Raw:            "",
+			ExpectedClaims: []string{},
+		},
+		"Single Claim": {
+			Raw:            "groups",
+			ExpectedClaims: []string{"groups"},
+		},
+		"Multiple Claims": {
+			Raw:            "groups,roles,realm_access.roles",
+			ExpectedClaims: []string{"groups", "roles", "realm_access.roles"},
+		},
+		"Whitespace And Empty Entries": {
+			Raw:            " groups, ,roles ,",
+			ExpectedClaims: []string{"groups", "roles"},
+		},
+		"JMESPath Expression": {
+			Raw:            "jmespath:[groups, roles][]",
+			ExpectedClaims: []string{"jmespath:[groups, roles][]"},
+		},
+		"JSONPath Expression": {
+			Raw:            "jsonpath:$['groups','roles']",
+			ExpectedClaims: []string{"jsonpath:$['groups','roles']"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ParseGroupsClaimList(tc.Raw)).To(Equal(tc.ExpectedClaims))
+		})
+	}
+}
+
+func TestProviderData_checkGroupsChanged(t *testing.T) {
+	testCases := map[string]struct {
+		Enabled       bool
+		OldGroups     []string
+		NewGroups     []string
+		ExpectedError error
+	}{
+		"Disabled": {
+			Enabled:       false,
+			OldGroups:     []string{"a", "b"},
+			NewGroups:     []string{"a"},
+			ExpectedError: nil,
+		},
+		"Same Groups": {
+			Enabled:       true,
+			OldGroups:     []string{"a", "b"},
+			NewGroups:     []string{"a", "b"},
+			ExpectedError: nil,
+		},
+		"Same Groups Different Order": {
+			Enabled:       true,
+			OldGroups:     []string{"a", "b"},
+			NewGroups:     []string{"b", "a"},
+			ExpectedError: nil,
+		},
+		"Group Removed": {
+			Enabled:       true,
+			OldGroups:     []string{"a", "b"},
+			NewGroups:     []string{"a"},
+			ExpectedError: ErrGroupMembershipChanged,
+		},
+		"Group Replaced": {
+			Enabled:       true,
+			OldGroups:     []string{"a", "b"},
+			NewGroups:     []string{"a", "c"},
+			ExpectedError: ErrGroupMembershipChanged,
+		},
+		"No Groups Before Or After": {
+			Enabled:       true,
+			OldGroups:     nil,
+			NewGroups:     []string{},
+			ExpectedError: nil,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				GroupChangeInvalidatesSession: tc.Enabled,
+			}
+			err := provider.checkGroupsChanged(tc.OldGroups, tc.NewGroups)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(Equal(tc.ExpectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestProviderData_extractEmail(t *testing.T) {
+	claims := map[string]interface{}{
+		"email": "",
+		"mail":  "janed@me.com",
+		"upn":   "jane.dobbs@corp.example.com",
+	}
+
+	testCases := map[string]struct {
+		EmailClaim    string
+		EmailClaims   []string
+		ExpectedEmail string
+		ExpectedClaim string
+	}{
+		"Single Email Claim": {
+			EmailClaim:    "upn",
+			ExpectedEmail: "jane.dobbs@corp.example.com",
+			ExpectedClaim: "upn",
+		},
+		"Email Claims Skip Empty And Missing Claims": {
+			EmailClaim:    "upn",
+			EmailClaims:   []string{"missing", "email", "mail", "upn"},
+			ExpectedEmail: "janed@me.com",
+			ExpectedClaim: "mail",
+		},
+		"No Email Claims Set": {
+			EmailClaim:    "email",
+			EmailClaims:   []string{"missing", "email"},
+			ExpectedEmail: "",
+			ExpectedClaim: "",
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &ProviderData{
+				EmailClaim:  tc.EmailClaim,
+				EmailClaims: tc.EmailClaims,
+			}
+
+			email, claim := p.extractEmail(claims)
+			g.Expect(email).To(Equal(tc.ExpectedEmail))
+			g.Expect(claim).To(Equal(tc.ExpectedClaim))
+		})
+	}
+}
+
+func TestProviderData_isEmailUnverified(t *testing.T) {
+	testCases := map[string]struct {
+		EmailClaim           string
+		EmailVerifiedClaim   string
+		RequireEmailVerified bool
+		Claims               map[string]interface{}
+		ExpectedUnverified   bool
+	}{
+		"Custom Claim Verified": {
+			EmailClaim:         "mail",
+			EmailVerifiedClaim: "mail_verified",
+			Claims:             map[string]interface{}{"mail": "janed@me.com", "mail_verified": true},
+			ExpectedUnverified: false,
+		},
+		"Custom Claim Unverified": {
+			EmailClaim:         "mail",
+			EmailVerifiedClaim: "mail_verified",
+			Claims:             map[string]interface{}{"mail": "janed@me.com", "mail_verified": false},
+			ExpectedUnverified: true,
+		},
+		"Custom Claim Unverified As String": {
+			EmailClaim:         "mail",
+			EmailVerifiedClaim: "mail_verified",
+			Claims:             map[string]interface{}{"mail": "janed@me.com", "mail_verified": "false"},
+			ExpectedUnverified: true,
+		},
+		"Custom Claim Nested Verified Claim": {
+			EmailClaim:         "mail",
+			EmailVerifiedClaim: "attributes.mail_verified",
+			Claims: map[string]interface{}{
+				"mail":       "janed@me.com",
+				"attributes": map[string]interface{}{"mail_verified": false},
+			},
+			ExpectedUnverified: true,
+		},
+		"Custom Claim Invalid Verified Claim": {
+			EmailClaim:         "mail",
+			EmailVerifiedClaim: "mail_verified",
+			Claims:             map[string]interface{}{"mail": "janed@me.com", "mail_verified": "maybe"},
+			ExpectedUnverified: true,
+		},
+		"Custom Claim Missing Verified Claim": {
+			EmailClaim:         "mail",
+			EmailVerifiedClaim: "mail_verified",
+			Claims:             map[string]interface{}{"mail": "janed@me.com"},
+			ExpectedUnverified: false,
+		},
+		"Custom Claim Without Verified Claim": {
+			EmailClaim:         "mail",
+			Claims:             map[string]interface{}{"mail": "janed@me.com", "email_verified": false},
+			ExpectedUnverified: false,
+		},
+		"Email Claim Verified Claim Unverified": {
+			EmailClaim:         "email",
+			EmailVerifiedClaim: "verified_email",
+			Claims:             map[string]interface{}{"email": "janed@me.com", "verified_email": false},
+			ExpectedUnverified: true,
+		},
+		"Email Claim Verified Claim Verified": {
+			EmailClaim:         "email",
+			EmailVerifiedClaim: "verified_email",
+			Claims:             map[string]interface{}{"email": "janed@me.com", "verified_email": true, "email_verified": false},
+			ExpectedUnverified: false,
+		},
+		"Email Claim Verified Claim Verified As String": {
+			EmailClaim:         "email",
+			EmailVerifiedClaim: "email_state",
+			Claims:             map[string]interface{}{"email": "janed@me.com", "email_state": "verified"},
+			ExpectedUnverified: false,
+		},
+		"Email Claim Verified Claim Unverified As String": {
+			EmailClaim:         "email",
+			EmailVerifiedClaim: "email_state",
+			Claims:             map[string]interface{}{"email": "janed@me.com", "email_state": "Unverified"},
+			ExpectedUnverified: true,
+		},
+		"Email Claim Verified Claim True As String": {
+			EmailClaim:         "email",
+			EmailVerifiedClaim: "email_state",
+			Claims:             map[string]interface{}{"email": "janed@me.com", "email_state": "true"},
+			ExpectedUnverified: false,
+		},
+		"Custom Claim Requiring Email Verified Unverified": {
+			EmailClaim:           "mail",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"mail": "janed@me.com", "email_verified": false},
+			ExpectedUnverified:   true,
+		},
+		"Custom Claim Requiring Email Verified Verified": {
+			EmailClaim:           "mail",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"mail": "janed@me.com", "email_verified": true},
+			ExpectedUnverified:   false,
+		},
+		"Custom Claim Requiring Email Verified Missing": {
+			EmailClaim:           "mail",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"mail": "janed@me.com"},
+			ExpectedUnverified:   true,
+		},
+		"Email Claim Requiring Email Verified Missing": {
+			EmailClaim:           "email",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"email": "janed@me.com"},
+			ExpectedUnverified:   true,
+		},
+		"Email Claim Requiring Email Verified Verified": {
+			EmailClaim:           "email",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"email": "janed@me.com", "email_verified": true},
+			ExpectedUnverified:   false,
+		},
+		"Custom Claim Requiring Email Verified Missing Verified Claim": {
+			EmailClaim:           "mail",
+			EmailVerifiedClaim:   "mail_verified",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"mail": "janed@me.com", "email_verified": true},
+			ExpectedUnverified:   true,
+		},
+		"Custom Claim Requiring Email Verified Prefers Verified Claim": {
+			EmailClaim:           "mail",
+			EmailVerifiedClaim:   "mail_verified",
+			RequireEmailVerified: true,
+			Claims:               map[string]interface{}{"mail": "janed@me.com", "email_verified": false, "mail_verified": true},
+			ExpectedUnverified:   false,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &ProviderData{
+				EmailClaim:           tc.EmailClaim,
+				EmailVerifiedClaim:   tc.EmailVerifiedClaim,
+				RequireEmailVerified: tc.RequireEmailVerified,
+			}
+
+			claims := &OIDCClaims{raw: tc.Claims}
+			claims.Email, claims.emailClaim = p.extractEmail(tc.Claims)
+			g.Expect(p.isEmailUnverified(claims)).To(Equal(tc.ExpectedUnverified))
+		})
+	}
+}
+
+func TestProviderData_mapExtraClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"email":       "janed@me.com",
+		"department":  "engineering",
+		"cost_center": 12345,
+		"address": map[string]interface{}{
+			"country": "US",
+		},
+	}
+
+	testCases := map[string]struct {
+		ClaimMappings map[string]string
+		ExpectedExtra map[string]string
+	}{
+		"No Mappings": {
+			ClaimMappings: nil,
+			ExpectedExtra: nil,
+		},
+		"String Claim": {
+			ClaimMappings: map[string]string{"department": "department"},
+			ExpectedExtra: map[string]string{"department": "engineering"},
+		},
+		"Renamed Non String Claim": {
+			ClaimMappings: map[string]string{"cost_center": "billing"},
+			ExpectedExtra: map[string]string{"billing": "12345"},
+		},
+		"Missing Claim": {
+			ClaimMappings: map[string]string{"location": "location"},
+			ExpectedExtra: nil,
+		},
+		"Nested Object Claim": {
+			ClaimMappings: map[string]string{"address": "address"},
+			ExpectedExtra: map[string]string{"address": "{\"country\":\"US\"}"},
+		},
+		"Nested Claim Path": {
+			ClaimMappings: map[string]string{"address.country": "country"},
+			ExpectedExtra: map[string]string{"country": "US"},
+		},
+		"Collision With Standard Field": {
+			ClaimMappings: map[string]string{
+				"department": "email",
+				"address":    "address",
+			},
+			ExpectedExtra: map[string]string{"address": "{\"country\":\"US\"}"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			provider := &ProviderData{
+				ClaimMappings: tc.ClaimMappings,
+			}
+			ss := &sessions.SessionState{Email: "janed@me.com"}
+			provider.mapExtraClaims(ss, claims)
+
+			g.Expect(ss.Email).To(Equal("janed@me.com"))
+			if tc.ExpectedExtra != nil {
+				g.Expect(ss.Extra).To(Equal(tc.ExpectedExtra))
+			} else {
+				g.Expect(ss.Extra).To(BeNil())
+			}
+		})
+	}
+}
+
+func TestProviderData_WithLogger(t *testing.T) {
+	g := NewWithT(t)
+
+	out := new(bytes.Buffer)
+	provider := (&ProviderData{
+		ProviderName:  "OpenID Connect",
+		ClaimMappings: map[string]string{"department": "email"},
+	}).WithLogger(logger.NewJSONLogger(out))
+	provider.mapExtraClaims(&sessions.SessionState{}, map[string]interface{}{"department": "engineering"})
+
+	var entry map[string]interface{}
+	g.Expect(json.Unmarshal(out.Bytes(), &entry)).To(Succeed())
+	g.Expect(entry).To(HaveKeyWithValue("msg", "Warning: claim mapping collides with a standard session field, skipping"))
+	g.Expect(entry).To(HaveKeyWithValue("provider", "OpenID Connect"))
+	g.Expect(entry).To(HaveKeyWithValue("claim", "department"))
+	g.Expect(entry).To(HaveKeyWithValue("field", "email"))
+}
+
+func TestProviderData_warnMissingGroups(t *testing.T) {
+	testCases := map[string]struct {
+		Scope           string
+		GroupsClaim     string
+		AllowedGroups   []string
+		Groups          []string
+		ExpectedWarning bool
+	}{
+		"Groups Scope Without Groups": {
+			Scope:           "openid email groups",
+			GroupsClaim:     "groups",
+			Groups:          []string{},
+			ExpectedWarning: true,
+		},
+		"Groups Scope Without Groups Claim": {
+			Scope:           "openid email groups",
+			GroupsClaim:     "groups",
+			Groups:          nil,
+			ExpectedWarning: true,
+		},
+		"Groups Scope Of Claim List": {
+			Scope:           "openid roles",
+			GroupsClaim:     "groups,roles",
+			ExpectedWarning: true,
+		},
+		"Groups Scope With Groups": {
+			Scope:       "openid email groups",
+			GroupsClaim: "groups",
+			Groups:      []string{"admins"},
+		},
+		"No Groups Scope": {
+			Scope:       "openid email profile",
+			GroupsClaim: "groups",
+		},
+		"Allowed Groups": {
+			Scope:         "openid email groups",
+			GroupsClaim:   "groups",
+			AllowedGroups: []string{"admins"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			out := new(bytes.Buffer)
+			provider := (&ProviderData{
+				ProviderName: "OpenID Connect",
+				Scope:        tc.Scope,
+				GroupsClaim:  tc.GroupsClaim,
+			}).WithLogger(logger.NewJSONLogger(out))
+			provider.SetAllowedGroups(tc.AllowedGroups)
+
+			provider.warnMissingGroups(&sessions.SessionState{Email: "janed@me.com", Groups: tc.Groups})
+			if !tc.ExpectedWarning {
+				g.Expect(out.String()).To(BeEmpty())
+				return
+			}
+
+			var entry map[string]interface{}
+			g.Expect(json.Unmarshal(out.Bytes(), &entry)).To(Succeed())
+			g.Expect(entry).To(HaveKeyWithValue("msg", "Warning: the groups scope was requested but no groups were returned, the user may not have consented to it"))
+			g.Expect(entry).To(HaveKeyWithValue("email", "janed@me.com"))
+		})
 	}
 }
 
-func TestProviderData_checkNonce(t *testing.T) {
+func TestProviderData_mergeRawClaims(t *testing.T) {
 	testCases := map[string]struct {
-		Session       *sessions.SessionState
-		IDToken       idTokenClaims
-		ExpectedError error
+		SaveRawClaims     bool
+		ClaimPrecedence   string
+		ClaimTypeConflict string
+		PreferNonEmpty    bool
+		RawClaims         []byte
+		Profile           map[string]interface{}
+		ExpectedRawClaims map[string]interface{}
 	}{
-		"Nonces match": {
-			Session: &sessions.SessionState{
-				Nonce: []byte(oidcNonce),
+		"Disabled": {
+			SaveRawClaims:     false,
+			RawClaims:         nil,
+			Profile:           map[string]interface{}{"email": "new@thing.com"},
+			ExpectedRawClaims: nil,
+		},
+		"Token Claims Take Precedence": {
+			SaveRawClaims: true,
+			RawClaims:     []byte(`{"email":"janed@me.com","sub":"123456789"}`),
+			Profile:       map[string]interface{}{"email": "new@thing.com", "department": "engineering"},
+			ExpectedRawClaims: map[string]interface{}{
+				"email":      "janed@me.com",
+				"sub":        "123456789",
+				"department": "engineering",
 			},
-			IDToken:       defaultIDToken,
-			ExpectedError: nil,
 		},
-		"Nonces do not match": {
-			Session: &sessions.SessionState{
-				Nonce: []byte("WrongWrongWrong"),
+		"Profile Claims Take Precedence With Userinfo First": {
+			SaveRawClaims:   true,
+			ClaimPrecedence: ClaimPrecedenceUserinfoFirst,
+			RawClaims:       []byte(`{"email":"janed@me.com","sub":"123456789"}`),
+			Profile:         map[string]interface{}{"email": "new@thing.com", "department": "engineering"},
+			ExpectedRawClaims: map[string]interface{}{
+				"email":      "new@thing.com",
+				"sub":        "123456789",
+				"department": "engineering",
 			},
-			IDToken:       defaultIDToken,
-			ExpectedError: errors.New("id_token nonce claim does not match the session nonce"),
 		},
-
-		"Missing nonce claim": {
-			Session: &sessions.SessionState{
-				Nonce: []byte(oidcNonce),
+		"No Token Claims": {
+			SaveRawClaims:     true,
+			RawClaims:         nil,
+			Profile:           map[string]interface{}{"email": "new@thing.com"},
+			ExpectedRawClaims: map[string]interface{}{"email": "new@thing.com"},
+		},
+		"Array And String Claims Are Merged": {
+			SaveRawClaims: true,
+			RawClaims:     []byte(`{"groups":["admin","eng"]}`),
+			Profile:       map[string]interface{}{"groups": "ops"},
+			ExpectedRawClaims: map[string]interface{}{
+				"groups": []interface{}{"admin", "eng", "ops"},
+			},
+		},
+		"String And Array Claims Are Merged Without Duplicates": {
+			SaveRawClaims:   true,
+			ClaimPrecedence: ClaimPrecedenceUserinfoFirst,
+			RawClaims:       []byte(`{"groups":"eng"}`),
+			Profile:         map[string]interface{}{"groups": []interface{}{"admin", "eng"}},
+			ExpectedRawClaims: map[string]interface{}{
+				"groups": []interface{}{"admin", "eng"},
+			},
+		},
+		"Conflicting Types Use Precedence": {
+			SaveRawClaims:     true,
+			ClaimTypeConflict: ClaimTypeConflictPrecedence,
+			RawClaims:         []byte(`{"groups":["admin","eng"]}`),
+			Profile:           map[string]interface{}{"groups": "ops"},
+			ExpectedRawClaims: map[string]interface{}{
+				"groups": []interface{}{"admin", "eng"},
+			},
+		},
+		"Empty Token Claims Take Precedence": {
+			SaveRawClaims: true,
+			RawClaims:     []byte(`{"groups":[],"department":""}`),
+			Profile:       map[string]interface{}{"groups": []interface{}{"admin"}, "department": "engineering"},
+			ExpectedRawClaims: map[string]interface{}{
+				"groups":     []interface{}{},
+				"department": "",
+			},
+		},
+		"Empty Token Claims Prefer Non Empty Profile Claims": {
+			SaveRawClaims:  true,
+			PreferNonEmpty: true,
+			RawClaims:      []byte(`{"groups":[],"department":"","manager":null,"locale":" "}`),
+			Profile: map[string]interface{}{
+				"groups":     []interface{}{"admin"},
+				"department": "engineering",
+				"manager":    "janed",
+				"locale":     "en",
+			},
+			ExpectedRawClaims: map[string]interface{}{
+				"groups":     []interface{}{"admin"},
+				"department": "engineering",
+				"manager":    "janed",
+				"locale":     " ",
+			},
+		},
+		"Object And Array Claims Use Precedence": {
+			SaveRawClaims: true,
+			RawClaims:     []byte(`{"groups":["admin","eng"]}`),
+			Profile:       map[string]interface{}{"groups": map[string]interface{}{"eng": "admin"}},
+			ExpectedRawClaims: map[string]interface{}{
+				"groups": []interface{}{"admin", "eng"},
 			},
-			IDToken:       minimalIDToken,
-			ExpectedError: errors.New("id_token nonce claim does not match the session nonce"),
 		},
 	}
 	for testName, tc := range testCases {
@@ -386,113 +2482,491 @@ func TestProviderData_checkNonce(t *testing.T) {
 			g := NewWithT(t)
 
 			provider := &ProviderData{
-				Verifier: oidc.NewVerifier(
-					oidcIssuer,
-					mockJWKS{},
-					&oidc.Config{ClientID: oidcClientID},
-				),
+				SaveRawClaims:        tc.SaveRawClaims,
+				ClaimPrecedence:      tc.ClaimPrecedence,
+				ClaimTypeConflict:    tc.ClaimTypeConflict,
+				PreferNonEmptyClaims: tc.PreferNonEmpty,
 			}
+			ss := &sessions.SessionState{RawClaims: tc.RawClaims}
+			g.Expect(provider.mergeRawClaims(ss, tc.Profile)).To(Succeed())
 
-			rawIDToken, err := newSignedTestIDToken(tc.IDToken)
-			g.Expect(err).ToNot(HaveOccurred())
+			if tc.ExpectedRawClaims == nil {
+				g.Expect(ss.RawClaims).To(BeNil())
+				return
+			}
+			var rawClaims map[string]interface{}
+			g.Expect(json.Unmarshal(ss.RawClaims, &rawClaims)).To(Succeed())
+			g.Expect(rawClaims).To(Equal(tc.ExpectedRawClaims))
+		})
+	}
+}
 
-			idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
-			g.Expect(err).ToNot(HaveOccurred())
+func TestProviderData_AddLoginHintFromSession(t *testing.T) {
+	const loginURL = "https://provider.example.com/authorize?client_id=client&state=abc"
 
-			err = provider.checkNonce(tc.Session, idToken)
-			if err != nil {
-				g.Expect(err).To(Equal(tc.ExpectedError))
-			} else {
-				g.Expect(err).ToNot(HaveOccurred())
+	testCases := map[string]struct {
+		autoLoginHint    bool
+		session          *sessions.SessionState
+		expectedLoginURL string
+	}{
+		"Adds the expired session email": {
+			autoLoginHint:    true,
+			session:          &sessions.SessionState{Email: "janed@me.com"},
+			expectedLoginURL: "https://provider.example.com/authorize?client_id=client&login_hint=janed%40me.com&state=abc",
+		},
+		"Disabled": {
+			autoLoginHint:    false,
+			session:          &sessions.SessionState{Email: "janed@me.com"},
+			expectedLoginURL: loginURL,
+		},
+		"No expired session": {
+			autoLoginHint:    true,
+			session:          nil,
+			expectedLoginURL: loginURL,
+		},
+		"Expired session without an email": {
+			autoLoginHint:    true,
+			session:          &sessions.SessionState{User: "janed"},
+			expectedLoginURL: loginURL,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &ProviderData{AutoLoginHint: tc.autoLoginHint}
+			g.Expect(p.AddLoginHintFromSession(loginURL, tc.session)).To(Equal(tc.expectedLoginURL))
+		})
+	}
+}
+
+func TestProviderData_GroupsAreSubsetOfAllowed(t *testing.T) {
+	testCases := map[string]struct {
+		RequireGroupsSubsetOf []string
+		NormalizeUnicode      bool
+		SessionGroups         []string
+		Expected              bool
+	}{
+		"All Groups In The Set": {
+			RequireGroupsSubsetOf: []string{"eng", "ops", "sales"},
+			SessionGroups:         []string{"eng", "ops"},
+			Expected:              true,
+		},
+		"A Group Outside The Set": {
+			RequireGroupsSubsetOf: []string{"eng", "ops"},
+			SessionGroups:         []string{"eng", "admin"},
+			Expected:              false,
+		},
+		"No Session Groups": {
+			RequireGroupsSubsetOf: []string{"eng"},
+			SessionGroups:         nil,
+			Expected:              true,
+		},
+		"Empty Set": {
+			RequireGroupsSubsetOf: nil,
+			SessionGroups:         []string{"eng"},
+			Expected:              false,
+		},
+		"Normalized Unicode Groups": {
+			RequireGroupsSubsetOf: []string{"caf\u00e9"},
+			NormalizeUnicode:      true,
+			SessionGroups:         []string{"cafe\u0301"},
+			Expected:              true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			p := &ProviderData{
+				RequireGroupsSubsetOf:  tc.RequireGroupsSubsetOf,
+				NormalizeUnicodeGroups: tc.NormalizeUnicode,
 			}
+			g.Expect(p.GroupsAreSubsetOfAllowed(tc.SessionGroups)).To(Equal(tc.Expected))
 		})
 	}
 }
 
-func TestProviderData_extractGroups(t *testing.T) {
+func TestProviderData_ScopesForGroups(t *testing.T) {
+	groupsToScopes := map[string][]string{
+		"admin":     {"admin:read", "admin:write"},
+		"auditors":  {"admin:read", "audit:read"},
+		"caf\u00e9": {"coffee"},
+	}
+
 	testCases := map[string]struct {
-		Claims         map[string]interface{}
-		GroupsClaim    string
-		ExpectedGroups []string
+		GroupsToScopes   map[string][]string
+		NormalizeUnicode bool
+		Groups           []string
+		Expected         []string
 	}{
-		"Standard String Groups": {
-			Claims: map[string]interface{}{
-				"email":  "this@does.not.matter.com",
-				"groups": []interface{}{"three", "string", "groups"},
-			},
-			GroupsClaim:    "groups",
-			ExpectedGroups: []string{"three", "string", "groups"},
+		"Mapped Group": {
+			GroupsToScopes: groupsToScopes,
+			Groups:         []string{"admin"},
+			Expected:       []string{"admin:read", "admin:write"},
 		},
-		"Different Claim Name": {
-			Claims: map[string]interface{}{
-				"email": "this@does.not.matter.com",
-				"roles": []interface{}{"three", "string", "roles"},
-			},
-			GroupsClaim:    "roles",
-			ExpectedGroups: []string{"three", "string", "roles"},
+		"Union Of Mapped Groups": {
+			GroupsToScopes: groupsToScopes,
+			Groups:         []string{"auditors", "eng", "admin"},
+			Expected:       []string{"admin:read", "audit:read", "admin:write"},
 		},
-		"Numeric Groups": {
-			Claims: map[string]interface{}{
-				"email":  "this@does.not.matter.com",
-				"groups": []interface{}{1, 2, 3},
-			},
-			GroupsClaim:    "groups",
-			ExpectedGroups: []string{"1", "2", "3"},
+		"No Mapped Groups": {
+			GroupsToScopes: groupsToScopes,
+			Groups:         []string{"eng"},
+			Expected:       nil,
 		},
-		"Complex Groups": {
-			Claims: map[string]interface{}{
-				"email": "this@does.not.matter.com",
-				"groups": []interface{}{
-					map[string]interface{}{
-						"groupId": "Admin Group Id",
-						"roles":   []string{"Admin"},
-					},
-					12345,
-					"Just::A::String",
-				},
+		"No Mapping": {
+			GroupsToScopes: nil,
+			Groups:         []string{"admin"},
+			Expected:       nil,
+		},
+		"Normalized Unicode Groups": {
+			GroupsToScopes:   groupsToScopes,
+			NormalizeUnicode: true,
+			Groups:           []string{"cafe\u0301"},
+			Expected:         []string{"coffee"},
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			p := &ProviderData{
+				GroupsToScopes:         tc.GroupsToScopes,
+				NormalizeUnicodeGroups: tc.NormalizeUnicode,
+			}
+			g.Expect(p.ScopesForGroups(tc.Groups)).To(Equal(tc.Expected))
+		})
+	}
+}
+
+func TestProviderData_MatchesEmailDomain(t *testing.T) {
+	testCases := map[string]struct {
+		EmailDomains      []string
+		EmailDomainSuffix []string
+		Email             string
+		Expected          bool
+	}{
+		"Exact Domain": {
+			EmailDomains: []string{"example.com"},
+			Email:        "janed@example.com",
+			Expected:     true,
+		},
+		"Exact Domain Ignores Case": {
+			EmailDomains: []string{"Example.com"},
+			Email:        "janed@EXAMPLE.com",
+			Expected:     true,
+		},
+		"Exact Domain Doesn't Match Subdomain": {
+			EmailDomains: []string{"example.com"},
+			Email:        "janed@eu.example.com",
+			Expected:     false,
+		},
+		"Any Domain": {
+			EmailDomains: []string{"*"},
+			Email:        "janed@example.org",
+			Expected:     true,
+		},
+		"Suffix Subdomain": {
+			EmailDomainSuffix: []string{".internal.corp.example.com"},
+			Email:             "janed@eu.internal.corp.example.com",
+			Expected:          true,
+		},
+		"Suffix Nested Subdomain": {
+			EmailDomainSuffix: []string{".example.com"},
+			Email:             "janed@a.b.example.com",
+			Expected:          true,
+		},
+		"Suffix Doesn't Match The Domain Itself": {
+			EmailDomainSuffix: []string{".example.com"},
+			Email:             "janed@example.com",
+			Expected:          false,
+		},
+		"Suffix Doesn't Match Partial Label": {
+			EmailDomainSuffix: []string{".example.com"},
+			Email:             "janed@evilexample.com",
+			Expected:          false,
+		},
+		"Suffix Without Leading Dot Doesn't Match Partial Label": {
+			EmailDomainSuffix: []string{"example.com"},
+			Email:             "janed@evilexample.com",
+			Expected:          false,
+		},
+		"Suffix Without Leading Dot Matches Subdomain": {
+			EmailDomainSuffix: []string{"example.com"},
+			Email:             "janed@eu.example.com",
+			Expected:          true,
+		},
+		"Suffix In Local Part": {
+			EmailDomainSuffix: []string{".example.com"},
+			Email:             "janed.example.com@example.org",
+			Expected:          false,
+		},
+		"Not An Email": {
+			EmailDomains:      []string{"example.com"},
+			EmailDomainSuffix: []string{".example.com"},
+			Email:             "janed",
+			Expected:          false,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			p := &ProviderData{
+				EmailDomains:      tc.EmailDomains,
+				EmailDomainSuffix: tc.EmailDomainSuffix,
+			}
+			g.Expect(p.MatchesEmailDomain(tc.Email)).To(Equal(tc.Expected))
+		})
+	}
+}
+
+func TestProviderData_SetEmailDomains(t *testing.T) {
+	g := NewWithT(t)
+
+	p := &ProviderData{}
+	p.SetEmailDomains([]string{"Example.com", ".Internal.corp.example.com", "*"})
+	g.Expect(p.EmailDomains).To(Equal([]string{"example.com", "*"}))
+	g.Expect(p.EmailDomainSuffix).To(Equal([]string{".internal.corp.example.com"}))
+}
+
+func TestProviderData_getAuthorizationHeader(t *testing.T) {
+	testCases := map[string]struct {
+		AuthScheme     string
+		ExpectedHeader http.Header
+	}{
+		"Default Scheme": {
+			ExpectedHeader: http.Header{
+				"Accept":        []string{"application/json"},
+				"Authorization": []string{"Bearer a1234"},
 			},
-			GroupsClaim: "groups",
-			ExpectedGroups: []string{
-				"{\"groupId\":\"Admin Group Id\",\"roles\":[\"Admin\"]}",
-				"12345",
-				"Just::A::String",
+		},
+		"Bearer Scheme": {
+			AuthScheme: ProfileURLAuthSchemeBearer,
+			ExpectedHeader: http.Header{
+				"Accept":        []string{"application/json"},
+				"Authorization": []string{"Bearer a1234"},
 			},
 		},
-		"Missing Groups Claim Returns Nil": {
-			Claims: map[string]interface{}{
-				"email": "this@does.not.matter.com",
+		"Basic Scheme": {
+			AuthScheme: ProfileURLAuthSchemeBasic,
+			ExpectedHeader: http.Header{
+				"Accept":        []string{"application/json"},
+				"Authorization": []string{"Basic Y2xpZW50LWlkOmNsaWVudC1zZWNyZXQ="},
 			},
-			GroupsClaim:    "groups",
-			ExpectedGroups: nil,
 		},
-		"Non List Groups": {
-			Claims: map[string]interface{}{
-				"email":  "this@does.not.matter.com",
-				"groups": "singleton",
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			p := &ProviderData{
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				ProfileURLAuthScheme: tc.AuthScheme,
+			}
+			header, err := p.getAuthorizationHeader("a1234")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(header).To(Equal(tc.ExpectedHeader))
+		})
+	}
+}
+
+func TestProviderData_Validate(t *testing.T) {
+	testCases := map[string]struct {
+		ClientID           string
+		RedeemURL          string
+		ProfileURLs        []string
+		EmailClaim         string
+		AllowedGroupsRegex []*regexp.Regexp
+		ExpectedErrors     []string
+	}{
+		"Valid": {
+			ClientID:       "client",
+			RedeemURL:      "https://idp.example.com/token",
+			EmailClaim:     "email",
+			ExpectedErrors: nil,
+		},
+		"Unset Optional Settings": {
+			ClientID:       "client",
+			ExpectedErrors: nil,
+		},
+		"Missing Client ID": {
+			RedeemURL:      "https://idp.example.com/token",
+			ExpectedErrors: []string{"provider missing setting: client-id"},
+		},
+		"Relative Redeem URL": {
+			ClientID:       "client",
+			RedeemURL:      "idp.example.com/token",
+			ExpectedErrors: []string{`invalid setting: redeem-url "idp.example.com/token" must be an absolute URL`},
+		},
+		"Relative Profile URL Failover": {
+			ClientID:       "client",
+			ProfileURLs:    []string{"https://idp.example.com/userinfo", "/userinfo"},
+			ExpectedErrors: []string{`invalid setting: profile-url "/userinfo" must be an absolute URL`},
+		},
+		"Blank Email Claim": {
+			ClientID:       "client",
+			EmailClaim:     "  ",
+			ExpectedErrors: []string{"invalid setting: oidc-email-claim must not be blank"},
+		},
+		"Uncompiled Allowed Groups Regex": {
+			ClientID:           "client",
+			AllowedGroupsRegex: []*regexp.Regexp{regexp.MustCompile("^eng$"), nil},
+			ExpectedErrors:     []string{"invalid setting: allowed group regex 1 is not compiled"},
+		},
+		"Multiple Violations": {
+			RedeemURL:  "idp.example.com/token",
+			EmailClaim: " ",
+			ExpectedErrors: []string{
+				"provider missing setting: client-id",
+				`invalid setting: redeem-url "idp.example.com/token" must be an absolute URL`,
+				"invalid setting: oidc-email-claim must not be blank",
 			},
-			GroupsClaim:    "groups",
-			ExpectedGroups: []string{"singleton"},
 		},
 	}
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
 			g := NewWithT(t)
 
-			provider := &ProviderData{
-				Verifier: oidc.NewVerifier(
-					oidcIssuer,
-					mockJWKS{},
-					&oidc.Config{ClientID: oidcClientID},
-				),
+			redeemURL, err := url.Parse(tc.RedeemURL)
+			g.Expect(err).ToNot(HaveOccurred())
+			p := &ProviderData{
+				ClientID:           tc.ClientID,
+				RedeemURL:          redeemURL,
+				EmailClaim:         tc.EmailClaim,
+				AllowedGroupsRegex: tc.AllowedGroupsRegex,
+			}
+			for _, profileURL := range tc.ProfileURLs {
+				u, _ := url.Parse(profileURL)
+				p.ProfileURLs = append(p.ProfileURLs, u)
 			}
-			provider.GroupsClaim = tc.GroupsClaim
 
-			groups := provider.extractGroups(tc.Claims)
-			if tc.ExpectedGroups != nil {
-				g.Expect(groups).To(Equal(tc.ExpectedGroups))
-			} else {
-				g.Expect(groups).To(BeNil())
+			err = p.Validate()
+			if tc.ExpectedErrors == nil {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			agg, ok := err.(k8serrors.Aggregate)
+			g.Expect(ok).To(BeTrue())
+			var messages []string
+			for _, e := range agg.Errors() {
+				messages = append(messages, e.Error())
+			}
+			g.Expect(messages).To(Equal(tc.ExpectedErrors))
+		})
+	}
+}
+
+func TestProviderData_IsGroupAllowed(t *testing.T) {
+	testCases := map[string]struct {
+		allowedGroups      []string
+		allowedGroupsRegex []string
+		groupMatchMode     string
+		normalizeUnicode   bool
+		group              string
+		expectedAllowed    bool
+	}{
+		"No restrictions": {
+			group:           "app-prod-readers",
+			expectedAllowed: true,
+		},
+		"Exact match": {
+			allowedGroups:      []string{"admins"},
+			allowedGroupsRegex: []string{"^app-.*-readers$"},
+			group:              "admins",
+			expectedAllowed:    true,
+		},
+		"Regex match": {
+			allowedGroups:      []string{"admins"},
+			allowedGroupsRegex: []string{"^app-.*-readers$"},
+			group:              "app-staging-readers",
+			expectedAllowed:    true,
+		},
+		"No match": {
+			allowedGroups:      []string{"admins"},
+			allowedGroupsRegex: []string{"^app-.*-readers$"},
+			group:              "app-prod-writers",
+			expectedAllowed:    false,
+		},
+		"Regex only, no match": {
+			allowedGroupsRegex: []string{"^app-.*-readers$"},
+			group:              "admins",
+			expectedAllowed:    false,
+		},
+		"Glob in exact mode is literal": {
+			allowedGroups:   []string{"team:*:admin"},
+			groupMatchMode:  GroupMatchModeExact,
+			group:           "team:eng:admin",
+			expectedAllowed: false,
+		},
+		"Literal star in exact mode": {
+			allowedGroups:   []string{"team:*:admin"},
+			groupMatchMode:  GroupMatchModeExact,
+			group:           "team:*:admin",
+			expectedAllowed: true,
+		},
+		"Glob match": {
+			allowedGroups:   []string{"team:*:admin", "ops-*"},
+			groupMatchMode:  GroupMatchModeGlob,
+			group:           "team:eng:admin",
+			expectedAllowed: true,
+		},
+		"Second glob match": {
+			allowedGroups:   []string{"team:*:admin", "ops-*"},
+			groupMatchMode:  GroupMatchModeGlob,
+			group:           "ops-oncall",
+			expectedAllowed: true,
+		},
+		"Glob no match": {
+			allowedGroups:   []string{"team:*:admin", "ops-*"},
+			groupMatchMode:  GroupMatchModeGlob,
+			group:           "team:eng:viewer",
+			expectedAllowed: false,
+		},
+		// "caf\u00e9" is NFC, "cafe\u0301" is the equivalent NFD
+		"Differently normalized without normalization": {
+			allowedGroups:   []string{"caf\u00e9"},
+			group:           "cafe\u0301",
+			expectedAllowed: false,
+		},
+		"Differently normalized with normalization": {
+			allowedGroups:    []string{"caf\u00e9"},
+			normalizeUnicode: true,
+			group:            "cafe\u0301",
+			expectedAllowed:  true,
+		},
+		"Decomposed allowed group with normalization": {
+			allowedGroups:    []string{"cafe\u0301"},
+			normalizeUnicode: true,
+			group:            "caf\u00e9",
+			expectedAllowed:  true,
+		},
+		"Differently normalized glob with normalization": {
+			allowedGroups:    []string{"caf\u00e9-*"},
+			groupMatchMode:   GroupMatchModeGlob,
+			normalizeUnicode: true,
+			group:            "cafe\u0301-staff",
+			expectedAllowed:  true,
+		},
+		"Differently normalized regex with normalization": {
+			allowedGroupsRegex: []string{"caf\u00e9-.*"},
+			normalizeUnicode:   true,
+			group:              "cafe\u0301-staff",
+			expectedAllowed:    true,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &ProviderData{
+				GroupMatchMode:         tc.groupMatchMode,
+				NormalizeUnicodeGroups: tc.normalizeUnicode,
 			}
+			p.SetAllowedGroups(tc.allowedGroups)
+			g.Expect(p.SetAllowedGroupsRegex(tc.allowedGroupsRegex)).To(Succeed())
+
+			g.Expect(p.IsGroupAllowed(tc.group)).To(Equal(tc.expectedAllowed))
 		})
 	}
 }
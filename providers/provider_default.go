@@ -6,12 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
 )
 
+// redeemOnceTimeout limits how long a code redemption shared by RedeemOnce
+// may take, as it isn't cancelled with the request that started it
+const redeemOnceTimeout = 30 * time.Second
+
 var (
 	// ErrNotImplemented is returned when a provider did not override a default
 	// implementation method that doesn't have sensible defaults
@@ -29,6 +35,60 @@ var (
 	// but an attempt to call `Verifier.Verify` was about to be made.
 	ErrMissingOIDCVerifier = errors.New("oidc verifier is not configured")
 
+	// ErrGroupMembershipChanged is returned when a session refresh detects
+	// that the user's groups differ from those stored in the session and
+	// `GroupChangeInvalidatesSession` is enabled.
+	ErrGroupMembershipChanged = errors.New("group membership changed")
+
+	// ErrIDTokenTooLarge is returned when the raw id_token is larger than
+	// the configured `MaxIDTokenBytes`.
+	ErrIDTokenTooLarge = errors.New("id_token too large")
+
+	// ErrIDTokenIssuedInFuture is returned when a `SkewTolerance` is set
+	// and the id_token was issued later than now plus the tolerance.
+	ErrIDTokenIssuedInFuture = errors.New("id_token used before issued")
+
+	// ErrIDTokenIssuerMismatch is returned when the `iss` of an id_token or
+	// bearer token doesn't match the IssuerURL with `IssuerURLNormalize` set.
+	ErrIDTokenIssuerMismatch = errors.New("id_token issuer mismatch")
+
+	// ErrAudienceNotAllowed is returned when the `aud` of a token has none
+	// of the ClientID and `AdditionalAudiences`, or with
+	// `RequireAudienceExact` has any other audience.
+	ErrAudienceNotAllowed = errors.New("audience isn't allowed")
+
+	// ErrIDTokenHashMismatch is returned when the `at_hash` or `c_hash` of
+	// the id_token doesn't match the access token or authorization code.
+	ErrIDTokenHashMismatch = errors.New("id_token hash mismatch")
+
+	// ErrEmailNotVerified is matched by a ClaimError when the email in the
+	// claims is explicitly marked as unverified.
+	ErrEmailNotVerified = errors.New("email isn't verified")
+
+	// ErrClaimExtraction is matched by a ClaimError when the claims can't be
+	// extracted into a session.
+	ErrClaimExtraction = errors.New("claim extraction failed")
+
+	// ErrSubjectNotAllowed is matched by a ClaimError when the subject in the
+	// claims is denied, or isn't one of the allowed subjects.
+	ErrSubjectNotAllowed = errors.New("subject isn't allowed")
+
+	// ErrOrgNotAllowed is matched by a ClaimError when the active
+	// organization in the claims isn't one of the allowed organizations.
+	ErrOrgNotAllowed = errors.New("organization isn't allowed")
+
+	// ErrMissingGroupsClaim is matched by a ClaimError when GroupsClaimRequired
+	// is set and neither the id_token nor the profile URL has a groups claim.
+	ErrMissingGroupsClaim = errors.New("groups claim is missing")
+
+	// ErrClaimExtractionTimeout is returned when fetching the profile URL
+	// claims takes longer than the configured `ClaimExtractionTimeout`.
+	ErrClaimExtractionTimeout = errors.New("claim extraction timed out")
+
+	// ErrProfileURLUnauthorized is returned when the profile URL responds
+	// 401 Unauthorized, eg. as the access token has expired.
+	ErrProfileURLUnauthorized = errors.New("profile URL request unauthorized")
+
 	_ Provider = (*ProviderData)(nil)
 )
 
@@ -39,6 +99,88 @@ func (p *ProviderData) GetLoginURL(redirectURI, state, _ string) string {
 	return loginURL.String()
 }
 
+// RedeemOnce redeems the authorization code with redeem, unless a redemption
+// of the same code is already in flight, eg. when a browser retries the
+// callback, in which case its result is shared. Each code is then only sent
+// to the IdP once, which would reject the second redemption. The in-flight
+// redemption keeps the values of the context of the request that started it,
+// but not its cancellation, so that a browser aborting that request doesn't
+// fail the retry too. It is limited to redeemOnceTimeout instead.
+//
+// Redemptions are only shared between callbacks with the same redirect URL
+// and PKCE code verifier in the context, so that a callback with an
+// intercepted code can't join the redemption of the user it was issued to.
+func (p *ProviderData) RedeemOnce(ctx context.Context, redirectURL, code string, redeem func(context.Context) (*sessions.SessionState, error)) (*sessions.SessionState, error) {
+	key := strings.Join([]string{code, codeVerifierFromContext(ctx), redirectURL}, "\x00")
+	v, err, shared := p.tokenRequests.Do(key, func() (interface{}, error) {
+		redeemCtx, cancel := context.WithTimeout(detachedContext{ctx}, redeemOnceTimeout)
+		defer cancel()
+		return redeem(redeemCtx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := v.(*sessions.SessionState)
+	if shared && s != nil {
+		// Each callback request goes on to modify its own session
+		return copySession(s), nil
+	}
+	return s, nil
+}
+
+// copySession returns a deep copy of the session, so that neither can modify
+// the slices, maps or times of the other
+func copySession(s *sessions.SessionState) *sessions.SessionState {
+	copied := *s
+	copied.CreatedAt = copyTime(s.CreatedAt)
+	copied.ExpiresOn = copyTime(s.ExpiresOn)
+	copied.AuthTime = copyTime(s.AuthTime)
+	copied.LoggedInAt = copyTime(s.LoggedInAt)
+	copied.Groups = copyStrings(s.Groups)
+	copied.Roles = copyStrings(s.Roles)
+	if s.Nonce != nil {
+		copied.Nonce = append([]byte{}, s.Nonce...)
+	}
+	if s.RawClaims != nil {
+		copied.RawClaims = append([]byte{}, s.RawClaims...)
+	}
+	if s.Extra != nil {
+		copied.Extra = make(map[string]string, len(s.Extra))
+		for key, value := range s.Extra {
+			copied.Extra[key] = value
+		}
+	}
+	return &copied
+}
+
+// copyStrings copies a slice, keeping a nil slice nil as a missing claim is
+// told apart from an empty one
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string{}, values...)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// detachedContext keeps the values of its parent context but is never
+// cancelled with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
 // Redeem provides a default implementation of the OAuth2 token redemption process
 func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (*sessions.SessionState, error) {
 	if code == "" {
@@ -55,12 +197,14 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (*s
 	params.Add("client_secret", clientSecret)
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	addCodeVerifier(ctx, params)
 	if p.ProtectedResource != nil && p.ProtectedResource.String() != "" {
 		params.Add("resource", p.ProtectedResource.String())
 	}
 
 	result := requests.New(p.RedeemURL.String()).
 		WithContext(ctx).
+		WithClient(p.getTokenHTTPClient()).
 		WithMethod("POST").
 		WithBody(bytes.NewBufferString(params.Encode())).
 		SetHeader("Content-Type", "application/x-www-form-urlencoded").
@@ -68,6 +212,9 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (*s
 	if result.Error() != nil {
 		return nil, result.Error()
 	}
+	if err := p.validateTokenResponseBody(result.Body()); err != nil {
+		return nil, err
+	}
 
 	// blindly try json and x-www-form-urlencoded
 	var jsonResponse struct {
@@ -110,22 +257,73 @@ func (p *ProviderData) EnrichSession(_ context.Context, _ *sessions.SessionState
 
 // Authorize performs global authorization on an authenticated session.
 // This is not used for fine-grained per route authorization rules.
-func (p *ProviderData) Authorize(_ context.Context, s *sessions.SessionState) (bool, error) {
-	if len(p.AllowedGroups) == 0 {
-		return true, nil
+func (p *ProviderData) Authorize(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	authorized, reason := p.authorizeSubjectPrefix(s)
+	if authorized {
+		authorized, reason = p.authorizeDeniedSubject(s)
+	}
+	if authorized {
+		authorized, reason = p.authorizeGroups(s)
+	}
+	p.logAuthDecision(ctx, s, authorized, reason)
+	return authorized, nil
+}
+
+// authorizeSubjectPrefix checks that the session User has the SubjectPrefix.
+// In SubjectPrefixMigration mode, sessions created before the prefix was
+// configured are prefixed instead, which is persisted the next time the
+// session is saved.
+func (p *ProviderData) authorizeSubjectPrefix(s *sessions.SessionState) (bool, string) {
+	if p.SubjectPrefix == "" || p.unprefixSubject(s.User) != s.User {
+		return true, ""
+	}
+	if p.SubjectPrefixMigration {
+		s.User = p.prefixSubject(s.User)
+		return true, ""
+	}
+	return false, fmt.Sprintf("user isn't prefixed with the subject prefix %q", p.SubjectPrefix)
+}
+
+// authorizeDeniedSubject rejects sessions of DeniedSubjects, so that a
+// subject denied after its session was created is locked out too
+func (p *ProviderData) authorizeDeniedSubject(s *sessions.SessionState) (bool, string) {
+	if _, ok := p.DeniedSubjects[p.unprefixSubject(s.User)]; ok {
+		return false, "subject is denied"
+	}
+	return true, ""
+}
+
+// authorizeGroups checks the session groups against the allowed groups,
+// returning the reason for the decision
+func (p *ProviderData) authorizeGroups(s *sessions.SessionState) (bool, string) {
+	if len(p.RequireGroupsSubsetOf) > 0 && s.GroupsIncomplete {
+		// Fewer groups would pass the subset check, so fail closed
+		return false, "groups couldn't be fetched to check they are a subset of the required groups"
+	}
+	if len(p.RequireGroupsSubsetOf) > 0 && !p.GroupsAreSubsetOfAllowed(s.Groups) {
+		return false, "groups aren't a subset of the required groups"
+	}
+
+	if !p.hasAllowedGroups() {
+		return true, "no allowed groups are configured"
 	}
 
 	for _, group := range s.Groups {
-		if _, ok := p.AllowedGroups[group]; ok {
-			return true, nil
+		if p.IsGroupAllowed(group) {
+			return true, fmt.Sprintf("group %q is allowed", group)
 		}
 	}
 
-	return false, nil
+	return false, "no group is allowed"
 }
 
-// ValidateSession validates the AccessToken
+// ValidateSession validates the AccessToken. Introspection here can't
+// refresh a challenged token, providers implementing RefreshSession must
+// introspect in their own ValidateSession.
 func (p *ProviderData) ValidateSession(ctx context.Context, s *sessions.SessionState) bool {
+	if p.TokenIntrospectionEnabled {
+		return introspectSession(ctx, p, s)
+	}
 	return validateToken(ctx, p, s.AccessToken, nil)
 }
 
@@ -136,8 +334,9 @@ func (p *ProviderData) RefreshSession(_ context.Context, _ *sessions.SessionStat
 
 // CreateSessionFromToken converts Bearer IDTokens into sessions
 func (p *ProviderData) CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error) {
-	if p.Verifier != nil {
-		return middleware.CreateTokenToSessionFunc(p.Verifier.Verify)(ctx, token)
+	ss, err := middleware.CreateTokenToSessionFunc(p.verifyToken)(ctx, token)
+	if errors.Is(err, ErrMissingOIDCVerifier) {
+		return nil, ErrNotImplemented
 	}
-	return nil, ErrNotImplemented
+	return ss, err
 }
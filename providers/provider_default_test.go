@@ -2,7 +2,10 @@ package providers
 
 import (
 	"context"
+	"fmt"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -68,10 +71,12 @@ func TestProviderDataEnrichSession(t *testing.T) {
 
 func TestProviderDataAuthorize(t *testing.T) {
 	testCases := []struct {
-		name          string
-		allowedGroups []string
-		groups        []string
-		expectedAuthZ bool
+		name               string
+		allowedGroups      []string
+		allowedGroupsRegex []string
+		groupsSubsetOf     []string
+		groups             []string
+		expectedAuthZ      bool
 	}{
 		{
 			name:          "NoAllowedGroups",
@@ -97,6 +102,44 @@ func TestProviderDataAuthorize(t *testing.T) {
 			groups:        []string{"baz", "foo"},
 			expectedAuthZ: false,
 		},
+		{
+			name:               "UserInAllowedGroupRegex",
+			allowedGroups:      []string{"bar"},
+			allowedGroupsRegex: []string{"CN=engineering-.*,OU=Groups,.*"},
+			groups:             []string{"foo", "CN=engineering-backend,OU=Groups,DC=corp,DC=example,DC=com"},
+			expectedAuthZ:      true,
+		},
+		{
+			name:               "UserNotInAllowedGroupRegex",
+			allowedGroupsRegex: []string{"CN=engineering-.*,OU=Groups,.*"},
+			groups:             []string{"CN=sales,OU=Groups,DC=corp,DC=example,DC=com"},
+			expectedAuthZ:      false,
+		},
+		{
+			name:               "AllowedGroupRegexMatchesWholeGroup",
+			allowedGroupsRegex: []string{"engineering"},
+			groups:             []string{"engineering-backend"},
+			expectedAuthZ:      false,
+		},
+		{
+			name:           "UserGroupsSubsetOfRequiredGroups",
+			groupsSubsetOf: []string{"foo", "bar", "baz"},
+			groups:         []string{"foo", "bar"},
+			expectedAuthZ:  true,
+		},
+		{
+			name:           "UserGroupsNotSubsetOfRequiredGroups",
+			groupsSubsetOf: []string{"foo", "bar"},
+			groups:         []string{"foo", "qux"},
+			expectedAuthZ:  false,
+		},
+		{
+			name:           "UserGroupsSubsetOfRequiredGroupsButNotInAllowedGroup",
+			allowedGroups:  []string{"baz"},
+			groupsSubsetOf: []string{"foo", "bar", "baz"},
+			groups:         []string{"foo"},
+			expectedAuthZ:  false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -106,8 +149,9 @@ func TestProviderDataAuthorize(t *testing.T) {
 			session := &sessions.SessionState{
 				Groups: tc.groups,
 			}
-			p := &ProviderData{}
+			p := &ProviderData{RequireGroupsSubsetOf: tc.groupsSubsetOf}
 			p.SetAllowedGroups(tc.allowedGroups)
+			g.Expect(p.SetAllowedGroupsRegex(tc.allowedGroupsRegex)).To(Succeed())
 
 			authorized, err := p.Authorize(context.Background(), session)
 			g.Expect(err).ToNot(HaveOccurred())
@@ -115,3 +159,217 @@ func TestProviderDataAuthorize(t *testing.T) {
 		})
 	}
 }
+
+func TestProviderDataSetAllowedGroupsRegexInvalid(t *testing.T) {
+	g := NewWithT(t)
+
+	p := &ProviderData{}
+	err := p.SetAllowedGroupsRegex([]string{"CN=engineering-.*", "CN=(sales"})
+	g.Expect(err).To(MatchError(ContainSubstring(`invalid allowed group regex "CN=(sales"`)))
+	g.Expect(p.AllowedGroupsRegex).To(BeEmpty())
+}
+
+func TestProviderData_RedeemOnce(t *testing.T) {
+	g := NewWithT(t)
+	p := &ProviderData{}
+
+	var redemptions int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	redeem := func(context.Context) (*sessions.SessionState, error) {
+		if atomic.AddInt32(&redemptions, 1) == 1 {
+			close(entered)
+		}
+		<-release
+		return &sessions.SessionState{AccessToken: "a1234"}, nil
+	}
+
+	const callbacks = 3
+	results := make(chan *sessions.SessionState, callbacks)
+	var wg sync.WaitGroup
+	for i := 0; i < callbacks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := p.RedeemOnce(context.Background(), "https://example.com/oauth2/callback", "code1234", redeem)
+			g.Expect(err).ToNot(HaveOccurred())
+			results <- s
+		}()
+	}
+	<-entered
+	// Give the other callbacks time to join the in-flight redemption
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	g.Expect(atomic.LoadInt32(&redemptions)).To(Equal(int32(1)))
+	seen := map[*sessions.SessionState]struct{}{}
+	for s := range results {
+		g.Expect(s.AccessToken).To(Equal("a1234"))
+		seen[s] = struct{}{}
+	}
+	// Each callback gets its own session
+	g.Expect(seen).To(HaveLen(callbacks))
+
+	// Once finished, the code is redeemed again
+	_, err := p.RedeemOnce(context.Background(), "https://example.com/oauth2/callback", "code1234", redeem)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(atomic.LoadInt32(&redemptions)).To(Equal(int32(2)))
+}
+
+func TestProviderData_RedeemOnceAbortedCallback(t *testing.T) {
+	g := NewWithT(t)
+	p := &ProviderData{}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	redeem := func(ctx context.Context) (*sessions.SessionState, error) {
+		close(entered)
+		<-release
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return &sessions.SessionState{AccessToken: "token-" + codeVerifierFromContext(ctx)}, nil
+	}
+
+	// The browser aborts the first callback while its redemption is in flight
+	ctx, cancel := context.WithCancel(ContextWithCodeVerifier(context.Background(), "verifier"))
+	first := make(chan error, 1)
+	go func() {
+		_, err := p.RedeemOnce(ctx, "https://example.com/oauth2/callback", "code1234", redeem)
+		first <- err
+	}()
+	<-entered
+
+	retry := make(chan *sessions.SessionState, 1)
+	go func() {
+		retryCtx := ContextWithCodeVerifier(context.Background(), "verifier")
+		s, err := p.RedeemOnce(retryCtx, "https://example.com/oauth2/callback", "code1234", redeem)
+		g.Expect(err).ToNot(HaveOccurred())
+		retry <- s
+	}()
+	// Give the retry time to join the in-flight redemption
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+
+	g.Expect(<-first).ToNot(HaveOccurred())
+	s := <-retry
+	g.Expect(s).ToNot(BeNil())
+	g.Expect(s.AccessToken).To(Equal("token-verifier"))
+}
+
+func TestProviderData_RedeemOnceConcurrentEnrichment(t *testing.T) {
+	g := NewWithT(t)
+	p := &ProviderData{}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	redeem := func(context.Context) (*sessions.SessionState, error) {
+		close(entered)
+		<-release
+		// Spare capacity, so appends write to the same backing array
+		groups := make([]string, 1, 4)
+		groups[0] = "redeemed"
+		return &sessions.SessionState{
+			Groups: groups,
+			Extra:  map[string]string{"claim": "redeemed"},
+		}, nil
+	}
+
+	const callbacks = 2
+	results := make(chan *sessions.SessionState, callbacks)
+	var wg sync.WaitGroup
+	for i := 0; i < callbacks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := p.RedeemOnce(context.Background(), "https://example.com/oauth2/callback", "code1234", redeem)
+			g.Expect(err).ToNot(HaveOccurred())
+			// Enrich the session like a provider's EnrichSession does
+			s.Groups = append(s.Groups, fmt.Sprintf("enriched-%d", i))
+			s.Extra[fmt.Sprintf("enriched-%d", i)] = "true"
+			results <- s
+		}(i)
+	}
+	<-entered
+	// Give the other callback time to join the in-flight redemption
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for s := range results {
+		g.Expect(s.Groups).To(HaveLen(2))
+		g.Expect(s.Groups[0]).To(Equal("redeemed"))
+		g.Expect(s.Extra).To(HaveLen(2))
+	}
+}
+
+func TestCopySession(t *testing.T) {
+	g := NewWithT(t)
+	created := time.Now()
+	s := &sessions.SessionState{
+		CreatedAt: &created,
+		Groups:    []string{},
+		Roles:     nil,
+		Extra:     map[string]string{"claim": "value"},
+		RawClaims: []byte(`{}`),
+	}
+
+	copied := copySession(s)
+	g.Expect(copied).To(Equal(s))
+	// Empty and missing groups and roles stay distinct
+	g.Expect(copied.Groups).ToNot(BeNil())
+	g.Expect(copied.Roles).To(BeNil())
+
+	copied.Extra["claim"] = "changed"
+	*copied.CreatedAt = created.Add(time.Hour)
+	g.Expect(s.Extra["claim"]).To(Equal("value"))
+	g.Expect(*s.CreatedAt).To(Equal(created))
+}
+
+func TestProviderData_RedeemOnceCodeVerifier(t *testing.T) {
+	g := NewWithT(t)
+	p := &ProviderData{}
+
+	var redemptions int32
+	release := make(chan struct{})
+	redeemed := make(chan struct{}, 2)
+	redeem := func(verifier string) func(context.Context) (*sessions.SessionState, error) {
+		return func(context.Context) (*sessions.SessionState, error) {
+			atomic.AddInt32(&redemptions, 1)
+			redeemed <- struct{}{}
+			<-release
+			return &sessions.SessionState{AccessToken: "token-" + verifier}, nil
+		}
+	}
+
+	// The same code with the code verifiers of two different logins
+	results := make(chan *sessions.SessionState, 2)
+	var wg sync.WaitGroup
+	for _, verifier := range []string{"victim-verifier", "attacker-verifier"} {
+		wg.Add(1)
+		go func(verifier string) {
+			defer wg.Done()
+			ctx := ContextWithCodeVerifier(context.Background(), verifier)
+			s, err := p.RedeemOnce(ctx, "https://example.com/oauth2/callback", "code1234", redeem(verifier))
+			g.Expect(err).ToNot(HaveOccurred())
+			results <- s
+		}(verifier)
+	}
+	// Both redemptions are in flight at once, neither joined the other
+	<-redeemed
+	<-redeemed
+	close(release)
+	wg.Wait()
+	close(results)
+
+	g.Expect(atomic.LoadInt32(&redemptions)).To(Equal(int32(2)))
+	tokens := []string{}
+	for s := range results {
+		tokens = append(tokens, s.AccessToken)
+	}
+	g.Expect(tokens).To(ConsistOf("token-victim-verifier", "token-attacker-verifier"))
+}
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
 )
@@ -18,10 +19,21 @@ type Provider interface {
 	ValidateSession(ctx context.Context, s *sessions.SessionState) bool
 	RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error)
 	CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error)
+	RevokeToken(ctx context.Context, token string) error
 }
 
-// New provides a new Provider based on the configured provider string
-func New(provider string, p *ProviderData) Provider {
+// New provides a new Provider based on the configured provider string. The
+// ProviderData is validated once the provider has applied its defaults, so
+// that misconfigurations are reported at startup rather than on first login.
+func New(provider string, p *ProviderData) (Provider, error) {
+	prov := newProvider(provider, p)
+	if prov == nil {
+		return nil, fmt.Errorf("provider '%s' is not available", provider)
+	}
+	return prov, p.Validate()
+}
+
+func newProvider(provider string, p *ProviderData) Provider {
 	switch provider {
 	case "linkedin":
 		return NewLinkedInProvider(p)
@@ -49,6 +61,8 @@ func New(provider string, p *ProviderData) Provider {
 		return NewDigitalOceanProvider(p)
 	case "google":
 		return NewGoogleProvider(p)
+	case "alb":
+		return NewALBProvider(p)
 	default:
 		return nil
 	}
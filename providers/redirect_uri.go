@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrRedirectURIMismatch is returned when a callback doesn't exactly match
+// the registered redirect URI, eg. it has extra query parameters appended
+var ErrRedirectURIMismatch = errors.New("callback URI does not match the redirect URI")
+
+// oauthCallbackParams are the query parameters an identity provider adds to
+// the redirect URI when it sends the user back with the authorization
+// response (RFC 6749, OIDC Session Management and RFC 9207)
+var oauthCallbackParams = []string{
+	"code",
+	"state",
+	"error",
+	"error_description",
+	"error_uri",
+	"session_state",
+	"iss",
+}
+
+// ValidateCallbackURI checks that the callback request URI matches the
+// registered redirect URI when StrictRedirectURIMatch is set. The path and
+// query must match exactly once the authorization response parameters are
+// removed from the callback. The host isn't compared as the callback has
+// already been routed to the proxy.
+func (p *ProviderData) ValidateCallbackURI(callback *url.URL, redirectURI string) error {
+	if !p.StrictRedirectURIMatch {
+		return nil
+	}
+
+	registered, err := url.Parse(redirectURI)
+	if err != nil {
+		return fmt.Errorf("invalid redirect URI %q: %v", redirectURI, err)
+	}
+
+	query := callback.Query()
+	for _, param := range oauthCallbackParams {
+		query.Del(param)
+	}
+
+	if callback.EscapedPath() != registered.EscapedPath() ||
+		query.Encode() != registered.Query().Encode() {
+		return ErrRedirectURIMismatch
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"net/url"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderData_ValidateCallbackURI(t *testing.T) {
+	testCases := map[string]struct {
+		Callback      string
+		RedirectURI   string
+		Strict        bool
+		ExpectedError error
+	}{
+		"Not Strict Allows Appended Parameters": {
+			Callback:    "/oauth2/callback?code=abc&state=xyz&next=https://evil.example.com",
+			RedirectURI: "https://proxy.example.com/oauth2/callback",
+			Strict:      false,
+		},
+		"Exact Match": {
+			Callback:    "/oauth2/callback?code=abc&state=xyz",
+			RedirectURI: "https://proxy.example.com/oauth2/callback",
+			Strict:      true,
+		},
+		"Exact Match With Response Parameters": {
+			Callback:    "/oauth2/callback?code=abc&state=xyz&session_state=123&iss=https%3A%2F%2Fidp.example.com",
+			RedirectURI: "https://proxy.example.com/oauth2/callback",
+			Strict:      true,
+		},
+		"Exact Match With Error Response": {
+			Callback:    "/oauth2/callback?error=access_denied&error_description=denied&state=xyz",
+			RedirectURI: "https://proxy.example.com/oauth2/callback",
+			Strict:      true,
+		},
+		"Exact Match With Registered Query": {
+			Callback:    "/oauth2/callback?tenant=a&code=abc&state=xyz",
+			RedirectURI: "https://proxy.example.com/oauth2/callback?tenant=a",
+			Strict:      true,
+		},
+		"Appended Parameter": {
+			Callback:      "/oauth2/callback?code=abc&state=xyz&next=https://evil.example.com",
+			RedirectURI:   "https://proxy.example.com/oauth2/callback",
+			Strict:        true,
+			ExpectedError: ErrRedirectURIMismatch,
+		},
+		"Changed Registered Query": {
+			Callback:      "/oauth2/callback?tenant=b&code=abc&state=xyz",
+			RedirectURI:   "https://proxy.example.com/oauth2/callback?tenant=a",
+			Strict:        true,
+			ExpectedError: ErrRedirectURIMismatch,
+		},
+		"Missing Registered Query": {
+			Callback:      "/oauth2/callback?code=abc&state=xyz",
+			RedirectURI:   "https://proxy.example.com/oauth2/callback?tenant=a",
+			Strict:        true,
+			ExpectedError: ErrRedirectURIMismatch,
+		},
+		"Different Path": {
+			Callback:      "/oauth2/callback/extra?code=abc&state=xyz",
+			RedirectURI:   "https://proxy.example.com/oauth2/callback",
+			Strict:        true,
+			ExpectedError: ErrRedirectURIMismatch,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			callback, err := url.Parse(tc.Callback)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			p := &ProviderData{StrictRedirectURIMatch: tc.Strict}
+			err = p.ValidateCallbackURI(callback, tc.RedirectURI)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(Equal(tc.ExpectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+const (
+	// revokeTokenMaxRetries is how many times a revocation request is
+	// retried while the RevokeEndpoint is unavailable
+	revokeTokenMaxRetries = 2
+	// revokeTokenRetryBackoff is the wait before the first revocation retry,
+	// doubling for each later retry
+	revokeTokenRetryBackoff = 100 * time.Millisecond
+)
+
+// RevokeToken revokes the token at the RevokeEndpoint as an RFC 7009 token
+// revocation request, authenticated with the client credentials. Requests
+// are retried while the endpoint is unavailable. Tokens the endpoint rejects
+// as a bad request are most likely already revoked, so that is only logged.
+// It does nothing when there is no RevokeEndpoint.
+func (p *ProviderData) RevokeToken(ctx context.Context, token string) error {
+	if token == "" || p.RevokeEndpoint == nil || p.RevokeEndpoint.String() == "" {
+		return nil
+	}
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Add("token", token)
+	params.Add("token_type_hint", "refresh_token")
+
+	backoff := revokeTokenRetryBackoff
+	for retry := 0; ; retry++ {
+		result := requests.New(p.RevokeEndpoint.String()).
+			WithContext(ctx).
+			WithClient(p.getHTTPClient()).
+			WithMethod("POST").
+			WithBody(bytes.NewBufferString(params.Encode())).
+			SetHeader("Content-Type", "application/x-www-form-urlencoded").
+			SetHeader("Authorization", clientSecretBasicAuth(p.ClientID, clientSecret)).
+			Do()
+		if result.Error() != nil {
+			return fmt.Errorf("token revocation failed: %v", result.Error())
+		}
+
+		switch result.StatusCode() {
+		case http.StatusOK:
+			return nil
+		case http.StatusBadRequest:
+			logger.Printf("Token revocation returned status %d, the token may already be revoked: %s", result.StatusCode(), result.Body())
+			return nil
+		case http.StatusServiceUnavailable:
+			if retry < revokeTokenMaxRetries {
+				logger.Printf("Token revocation returned status %d, retrying in %s", result.StatusCode(), backoff)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				continue
+			}
+		}
+		return fmt.Errorf("token revocation failed: unexpected status %d: %s", result.StatusCode(), result.Body())
+	}
+}
@@ -0,0 +1,99 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderData_RevokeToken(t *testing.T) {
+	testCases := map[string]struct {
+		Statuses         []int
+		ExpectedError    error
+		ExpectedRequests int
+	}{
+		"Revoked": {
+			Statuses:         []int{http.StatusOK},
+			ExpectedRequests: 1,
+		},
+		"Already Revoked": {
+			Statuses:         []int{http.StatusBadRequest},
+			ExpectedRequests: 1,
+		},
+		"Revoked After Unavailable": {
+			Statuses:         []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			ExpectedRequests: 3,
+		},
+		"Unavailable": {
+			Statuses:         []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			ExpectedError:    errors.New("token revocation failed: unexpected status 503: unavailable"),
+			ExpectedRequests: 3,
+		},
+		"Server Error": {
+			Statuses:         []int{http.StatusInternalServerError},
+			ExpectedError:    errors.New("token revocation failed: unexpected status 500: unavailable"),
+			ExpectedRequests: 1,
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var requests []url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				clientID, clientSecret, ok := req.BasicAuth()
+				clientSecret, _ = url.QueryUnescape(clientSecret)
+				if req.Method != http.MethodPost || !ok || clientID != "client-id" || clientSecret != "client secret" {
+					rw.WriteHeader(http.StatusForbidden)
+					return
+				}
+				g.Expect(req.ParseForm()).To(Succeed())
+				requests = append(requests, req.PostForm)
+
+				status := tc.Statuses[len(requests)-1]
+				rw.WriteHeader(status)
+				if status != http.StatusOK {
+					_, _ = rw.Write([]byte("unavailable"))
+				}
+			}))
+			defer server.Close()
+			revokeURL, err := url.Parse(server.URL)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			p := &ProviderData{
+				ClientID:       "client-id",
+				ClientSecret:   "client secret",
+				RevokeEndpoint: revokeURL,
+			}
+			err = p.RevokeToken(context.Background(), "refresh1234")
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(MatchError(tc.ExpectedError.Error()))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+
+			g.Expect(requests).To(HaveLen(tc.ExpectedRequests))
+			for _, request := range requests {
+				g.Expect(request).To(Equal(url.Values{
+					"token":           []string{"refresh1234"},
+					"token_type_hint": []string{"refresh_token"},
+				}))
+			}
+		})
+	}
+}
+
+func TestProviderData_RevokeTokenWithoutEndpoint(t *testing.T) {
+	g := NewWithT(t)
+
+	p := &ProviderData{}
+	g.Expect(p.RevokeToken(context.Background(), "refresh1234")).To(Succeed())
+
+	p.RevokeEndpoint = &url.URL{}
+	g.Expect(p.RevokeToken(context.Background(), "refresh1234")).To(Succeed())
+}
@@ -0,0 +1,163 @@
+package providers
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultSecretFileCacheTTL is how long a secret read from a file is
+	// cached before the file is read again
+	DefaultSecretFileCacheTTL = 60 * time.Second
+
+	secretFileReadRetries = 3
+	secretFileReadBackoff = 100 * time.Millisecond
+)
+
+// clientSecretReloads counts the reloads of client secret files by result
+var clientSecretReloads = registerClientSecretReloadCounter(prometheus.DefaultRegisterer)
+
+// SecretFileCache caches a secret read from a file for a TTL.
+// Reads are retried with an exponential backoff so that transient failures,
+// such as an empty file during an atomic Kubernetes secret rotation, fall
+// back to the last successfully read secret.
+type SecretFileCache struct {
+	path    string
+	ttl     time.Duration
+	backoff time.Duration
+
+	mu       sync.Mutex
+	secret   string
+	loadedAt time.Time
+	// readAt is when the read of the secret started, reads that started
+	// earlier don't replace it
+	readAt time.Time
+
+	// reloads shares a reload of an expired secret between callers
+	reloads singleflight.Group
+
+	// While the file is being watched, the secret is kept in watched and
+	// served without checking the TTL.
+	watching int32
+	watched  atomic.Value
+}
+
+// NewSecretFileCache creates a SecretFileCache for the given file.
+// A ttl of zero or less uses the DefaultSecretFileCacheTTL.
+func NewSecretFileCache(path string, ttl time.Duration) *SecretFileCache {
+	if ttl <= 0 {
+		ttl = DefaultSecretFileCacheTTL
+	}
+	return &SecretFileCache{
+		path:    path,
+		ttl:     ttl,
+		backoff: secretFileReadBackoff,
+	}
+}
+
+// Get returns the cached secret, reloading it from the file once the TTL
+// has expired.
+func (c *SecretFileCache) Get() (string, error) {
+	if secret, ok := c.watched.Load().(string); ok && atomic.LoadInt32(&c.watching) == 1 {
+		return secret, nil
+	}
+
+	c.mu.Lock()
+	if c.secret != "" && time.Since(c.loadedAt) < c.ttl {
+		defer c.mu.Unlock()
+		return c.secret, nil
+	}
+	c.mu.Unlock()
+
+	secret, err, _ := c.reloads.Do(c.path, func() (interface{}, error) {
+		return c.reload()
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret.(string), nil
+}
+
+// ForceReload reads the secret from the file regardless of the TTL.
+func (c *SecretFileCache) ForceReload() (string, error) {
+	return c.reload()
+}
+
+// reload reads the secret file, retrying with an exponential backoff.
+// If every attempt fails, the last good secret is returned if there is one.
+// The file is read without holding the mutex, so that the backoff doesn't
+// block other callers.
+func (c *SecretFileCache) reload() (string, error) {
+	readAt := time.Now()
+	secret, err := c.read()
+	for attempt, delay := 0, c.backoff; err != nil && attempt < secretFileReadRetries; attempt, delay = attempt+1, delay*2 {
+		time.Sleep(delay)
+		secret, err = c.read()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		clientSecretReloads.WithLabelValues("error").Inc()
+		logger.Errorf("error reading client secret file %s: %v", c.path, err)
+		if c.secret != "" {
+			return c.secret, nil
+		}
+		return "", errors.New("could not read client secret file")
+	}
+
+	clientSecretReloads.WithLabelValues("success").Inc()
+	if readAt.Before(c.readAt) {
+		// The file was read again while this read was retrying
+		return c.secret, nil
+	}
+	c.readAt = readAt
+	c.secret = secret
+	c.loadedAt = time.Now()
+	if atomic.LoadInt32(&c.watching) == 1 {
+		c.watched.Store(secret)
+	}
+	return c.secret, nil
+}
+
+// read reads the secret file, treating an empty file as an error
+func (c *SecretFileCache) read() (string, error) {
+	content, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return "", errors.New("file is empty")
+	}
+	return string(content), nil
+}
+
+// registerClientSecretReloadCounter registers 'oauth2_proxy_client_secret_reload_total'
+// This keeps a tally of client secret file reloads bucketed by their result
+func registerClientSecretReloadCounter(registerer prometheus.Registerer) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oauth2_proxy_client_secret_reload_total",
+			Help: "Total number of client secret file reloads by result.",
+		},
+		[]string{"result"},
+	)
+
+	if err := registerer.Register(counter); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counter = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			panic(err)
+		}
+	}
+
+	return counter
+}
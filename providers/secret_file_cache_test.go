@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestSecretFileCache(t *testing.T, ttl time.Duration) (*SecretFileCache, string) {
+	dir, err := ioutil.TempDir("", "secret-file-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "secret")
+	cache := NewSecretFileCache(path, ttl)
+	cache.backoff = time.Millisecond
+	return cache, path
+}
+
+func TestSecretFileCache(t *testing.T) {
+	t.Run("caches the secret until the TTL expires", func(t *testing.T) {
+		g := NewWithT(t)
+		cache, path := newTestSecretFileCache(t, time.Hour)
+
+		g.Expect(ioutil.WriteFile(path, []byte("first"), 0600)).To(Succeed())
+		g.Expect(cache.Get()).To(Equal("first"))
+
+		g.Expect(ioutil.WriteFile(path, []byte("second"), 0600)).To(Succeed())
+		g.Expect(cache.Get()).To(Equal("first"))
+
+		g.Expect(cache.ForceReload()).To(Equal("second"))
+		g.Expect(cache.Get()).To(Equal("second"))
+	})
+
+	t.Run("reloads the secret after the TTL expires", func(t *testing.T) {
+		g := NewWithT(t)
+		cache, path := newTestSecretFileCache(t, time.Nanosecond)
+
+		g.Expect(ioutil.WriteFile(path, []byte("first"), 0600)).To(Succeed())
+		g.Expect(cache.Get()).To(Equal("first"))
+
+		g.Expect(ioutil.WriteFile(path, []byte("second"), 0600)).To(Succeed())
+		g.Expect(cache.Get()).To(Equal("second"))
+	})
+
+	t.Run("falls back to the last good secret", func(t *testing.T) {
+		g := NewWithT(t)
+		cache, path := newTestSecretFileCache(t, time.Hour)
+		errors := testutil.ToFloat64(clientSecretReloads.WithLabelValues("error"))
+
+		g.Expect(ioutil.WriteFile(path, []byte("first"), 0600)).To(Succeed())
+		g.Expect(cache.Get()).To(Equal("first"))
+
+		g.Expect(ioutil.WriteFile(path, []byte(""), 0600)).To(Succeed())
+		g.Expect(cache.ForceReload()).To(Equal("first"))
+
+		g.Expect(os.Remove(path)).To(Succeed())
+		g.Expect(cache.ForceReload()).To(Equal("first"))
+
+		g.Expect(testutil.ToFloat64(clientSecretReloads.WithLabelValues("error"))).To(Equal(errors + 2))
+	})
+
+	t.Run("errors without a last good secret", func(t *testing.T) {
+		g := NewWithT(t)
+		cache, _ := newTestSecretFileCache(t, time.Hour)
+
+		secret, err := cache.Get()
+		g.Expect(err).To(MatchError("could not read client secret file"))
+		g.Expect(secret).To(BeEmpty())
+	})
+	t.Run("doesn't block other reloads while retrying", func(t *testing.T) {
+		g := NewWithT(t)
+		cache, path := newTestSecretFileCache(t, time.Nanosecond)
+		cache.backoff = 200 * time.Millisecond
+
+		g.Expect(ioutil.WriteFile(path, []byte("first"), 0600)).To(Succeed())
+		g.Expect(cache.Get()).To(Equal("first"))
+
+		// The expired secret is reloaded from an empty file, which is retried
+		g.Expect(ioutil.WriteFile(path, []byte(""), 0600)).To(Succeed())
+		done := make(chan string)
+		go func() {
+			secret, _ := cache.Get()
+			done <- secret
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		g.Expect(ioutil.WriteFile(path, []byte("second"), 0600)).To(Succeed())
+		start := time.Now()
+		g.Expect(cache.ForceReload()).To(Equal("second"))
+		g.Expect(time.Since(start)).To(BeNumerically("<", cache.backoff))
+
+		// The earlier reload doesn't replace the newer secret
+		g.Eventually(done, 5*time.Second).Should(Receive(Equal("second")))
+		g.Expect(cache.secret).To(Equal("second"))
+	})
+}
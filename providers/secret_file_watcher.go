@@ -0,0 +1,69 @@
+// +build go1.3,!plan9,!solaris
+
+package providers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// Watch reloads the secret whenever the file changes until the context is
+// done. While watching, Get serves the last good secret without a TTL.
+//
+// The parent directory is watched rather than the file itself so that the
+// watch survives the file being deleted and recreated, including the symlink
+// swaps Kubernetes uses to rotate mounted secrets.
+func (c *SecretFileCache) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher for %s: %v", c.path, err)
+	}
+	if err := watcher.Add(filepath.Dir(c.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to add %s to watcher: %v", c.path, err)
+	}
+
+	atomic.StoreInt32(&c.watching, 1)
+	if _, err := c.ForceReload(); err != nil {
+		atomic.StoreInt32(&c.watching, 0)
+		watcher.Close()
+		return err
+	}
+
+	go c.watch(ctx, watcher)
+	logger.Printf("watching %s for updates", c.path)
+	return nil
+}
+
+func (c *SecretFileCache) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer func() {
+		atomic.StoreInt32(&c.watching, 0)
+		if err := watcher.Close(); err != nil {
+			logger.Errorf("error closing watcher for %s: %v", c.path, err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Printf("Shutting down watcher for: %s", c.path)
+			return
+		case event := <-watcher.Events:
+			// Removals are followed by a Create once the file is replaced,
+			// so only reload once there is something to read.
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if _, err := c.ForceReload(); err != nil {
+				logger.Errorf("error reloading %s after event %s: %v", c.path, event, err)
+			}
+		case err := <-watcher.Errors:
+			logger.Errorf("error watching %s: %v", c.path, err)
+		}
+	}
+}
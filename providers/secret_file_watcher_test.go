@@ -0,0 +1,85 @@
+// +build go1.3,!plan9,!solaris
+
+package providers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderData_WatchClientSecretFile(t *testing.T) {
+	newWatchedProvider := func(t *testing.T, g *WithT) (*ProviderData, string) {
+		dir, err := ioutil.TempDir("", "secret-file-watcher")
+		g.Expect(err).ToNot(HaveOccurred())
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		path := filepath.Join(dir, "secret")
+		g.Expect(ioutil.WriteFile(path, []byte("first"), 0600)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		p := &ProviderData{
+			ClientSecretFile:  path,
+			ClientSecretCache: NewSecretFileCache(path, time.Hour),
+		}
+		g.Expect(p.WatchClientSecretFile(ctx)).To(Succeed())
+		g.Expect(p.GetClientSecret()).To(Equal("first"))
+		return p, path
+	}
+
+	t.Run("reloads the secret when the file is rewritten", func(t *testing.T) {
+		g := NewWithT(t)
+		p, path := newWatchedProvider(t, g)
+
+		g.Expect(ioutil.WriteFile(path, []byte("second"), 0600)).To(Succeed())
+		g.Eventually(p.GetClientSecret).Should(Equal("second"))
+	})
+
+	t.Run("reloads the secret when the file is deleted and recreated", func(t *testing.T) {
+		g := NewWithT(t)
+		p, path := newWatchedProvider(t, g)
+
+		g.Expect(os.Remove(path)).To(Succeed())
+		g.Consistently(p.GetClientSecret, 100*time.Millisecond).Should(Equal("first"))
+
+		g.Expect(ioutil.WriteFile(path, []byte("second"), 0600)).To(Succeed())
+		g.Eventually(p.GetClientSecret).Should(Equal("second"))
+
+		g.Expect(ioutil.WriteFile(path, []byte("third"), 0600)).To(Succeed())
+		g.Eventually(p.GetClientSecret).Should(Equal("third"))
+	})
+
+	t.Run("reloads the secret when a symlinked file is swapped", func(t *testing.T) {
+		g := NewWithT(t)
+		p, path := newWatchedProvider(t, g)
+		dir := filepath.Dir(path)
+
+		// Mimic a Kubernetes secret mount: path -> ..data/secret, with the
+		// ..data symlink atomically replaced on rotation
+		g.Expect(os.Mkdir(filepath.Join(dir, "v1"), 0700)).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "v1", "secret"), []byte("second"), 0600)).To(Succeed())
+		g.Expect(os.Symlink("v1", filepath.Join(dir, "..data"))).To(Succeed())
+		g.Expect(os.Remove(path)).To(Succeed())
+		g.Expect(os.Symlink(filepath.Join("..data", "secret"), path)).To(Succeed())
+		g.Eventually(p.GetClientSecret).Should(Equal("second"))
+
+		g.Expect(os.Mkdir(filepath.Join(dir, "v2"), 0700)).To(Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "v2", "secret"), []byte("third"), 0600)).To(Succeed())
+		g.Expect(os.Symlink("v2", filepath.Join(dir, "..data_tmp"))).To(Succeed())
+		g.Expect(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))).To(Succeed())
+		g.Eventually(p.GetClientSecret).Should(Equal("third"))
+	})
+
+	t.Run("errors without a client secret file", func(t *testing.T) {
+		g := NewWithT(t)
+		p := &ProviderData{}
+		g.Expect(p.WatchClientSecretFile(context.Background())).To(MatchError("no client secret file to watch"))
+	})
+}
@@ -0,0 +1,13 @@
+// +build !go1.3 plan9 solaris
+
+package providers
+
+import (
+	"context"
+	"errors"
+)
+
+// Watch is not supported on this platform.
+func (c *SecretFileCache) Watch(ctx context.Context) error {
+	return errors.New("file watching not implemented on this platform")
+}
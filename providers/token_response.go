@@ -0,0 +1,73 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenResponseFields are the token endpoint response fields of RFC 6749
+// section 5.1 and OpenID Connect that are passed to a TokenResponseValidator
+// when the response was parsed by the oauth2 library
+var tokenResponseFields = []string{"access_token", "token_type", "expires_in", "refresh_token", "scope", "id_token"}
+
+// RequireTokenResponseFields creates a TokenResponseValidator that rejects
+// token endpoint responses missing any of the fields, or where they are empty
+func RequireTokenResponseFields(fields ...string) func(resp map[string]interface{}) error {
+	return func(resp map[string]interface{}) error {
+		for _, field := range fields {
+			if value, ok := resp[field]; !ok || value == nil || value == "" {
+				return fmt.Errorf("token response missing %s", field)
+			}
+		}
+		return nil
+	}
+}
+
+// validateTokenResponse checks the token endpoint response with the
+// TokenResponseValidator, if one is configured
+func (p *ProviderData) validateTokenResponse(resp map[string]interface{}) error {
+	if p.TokenResponseValidator == nil {
+		return nil
+	}
+	return p.TokenResponseValidator(resp)
+}
+
+// validateTokenResponseBody checks a JSON or x-www-form-urlencoded token
+// endpoint response body with the TokenResponseValidator
+func (p *ProviderData) validateTokenResponseBody(body []byte) error {
+	if p.TokenResponseValidator == nil {
+		return nil
+	}
+
+	resp := map[string]interface{}{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return fmt.Errorf("unable to parse token response: %v", err)
+		}
+		for key := range values {
+			resp[key] = values.Get(key)
+		}
+	}
+	return p.TokenResponseValidator(resp)
+}
+
+// validateOAuth2Token checks the token endpoint response an oauth2.Token was
+// parsed from with the TokenResponseValidator. Only the standard
+// tokenResponseFields are available to the validator.
+func (p *ProviderData) validateOAuth2Token(token *oauth2.Token) error {
+	if p.TokenResponseValidator == nil {
+		return nil
+	}
+
+	resp := map[string]interface{}{}
+	for _, field := range tokenResponseFields {
+		if value := token.Extra(field); value != nil {
+			resp[field] = value
+		}
+	}
+	return p.TokenResponseValidator(resp)
+}
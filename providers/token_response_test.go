@@ -0,0 +1,131 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRequireTokenResponseFields(t *testing.T) {
+	testCases := map[string]struct {
+		Response      map[string]interface{}
+		ExpectedError error
+	}{
+		"All Fields": {
+			Response: map[string]interface{}{"access_token": "a1234", "token_type": "Bearer"},
+		},
+		"Missing Access Token": {
+			Response:      map[string]interface{}{"token_type": "Bearer"},
+			ExpectedError: errors.New("token response missing access_token"),
+		},
+		"Empty Token Type": {
+			Response:      map[string]interface{}{"access_token": "a1234", "token_type": ""},
+			ExpectedError: errors.New("token response missing token_type"),
+		},
+		"Null Token Type": {
+			Response:      map[string]interface{}{"access_token": "a1234", "token_type": nil},
+			ExpectedError: errors.New("token response missing token_type"),
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := RequireTokenResponseFields("access_token", "token_type")(tc.Response)
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(MatchError(tc.ExpectedError.Error()))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestProviderData_RedeemTokenResponseValidator(t *testing.T) {
+	testCases := map[string]struct {
+		ContentType   string
+		Body          string
+		ExpectedError error
+	}{
+		"JSON Response": {
+			ContentType: "application/json",
+			Body:        `{"access_token": "a1234", "token_type": "Bearer"}`,
+		},
+		"JSON Response Missing Token Type": {
+			ContentType:   "application/json",
+			Body:          `{"access_token": "a1234"}`,
+			ExpectedError: errors.New("token response missing token_type"),
+		},
+		"Form Response": {
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        "access_token=a1234&token_type=Bearer",
+		},
+		"Form Response Missing Token Type": {
+			ContentType:   "application/x-www-form-urlencoded",
+			Body:          "access_token=a1234",
+			ExpectedError: errors.New("token response missing token_type"),
+		},
+	}
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Header().Set("Content-Type", tc.ContentType)
+				_, _ = rw.Write([]byte(tc.Body))
+			}))
+			defer server.Close()
+			redeemURL, err := url.Parse(server.URL)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			p := &ProviderData{
+				RedeemURL:              redeemURL,
+				TokenResponseValidator: RequireTokenResponseFields("access_token", "token_type"),
+			}
+			session, err := p.Redeem(context.Background(), "https://example.com/oauth2/callback", "code1234")
+			if tc.ExpectedError != nil {
+				g.Expect(err).To(MatchError(tc.ExpectedError.Error()))
+				g.Expect(session).To(BeNil())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(session.AccessToken).To(Equal("a1234"))
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_RedeemTokenResponseValidator(t *testing.T) {
+	g := NewWithT(t)
+
+	idToken, err := newSignedTestIDToken(defaultIDToken)
+	g.Expect(err).ToNot(HaveOccurred())
+	body, err := json.Marshal(map[string]interface{}{
+		"access_token": accessToken,
+		"expires_in":   10,
+		"id_token":     idToken,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server, provider := newTestOIDCSetup(body)
+	defer server.Close()
+
+	var validated map[string]interface{}
+	provider.TokenResponseValidator = func(resp map[string]interface{}) error {
+		validated = resp
+		return RequireTokenResponseFields("access_token", "token_type")(resp)
+	}
+
+	_, err = provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	g.Expect(err).To(MatchError("token exchange failed: token response missing token_type"))
+	g.Expect(validated).To(Equal(map[string]interface{}{
+		"access_token": accessToken,
+		"expires_in":   float64(10),
+		"id_token":     idToken,
+	}))
+}
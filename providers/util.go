@@ -1,12 +1,23 @@
 package providers
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"net/mail"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/bitly/go-simplejson"
+	"github.com/jmespath/go-jmespath"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/ohler55/ojg/jp"
+	"github.com/spf13/cast"
 	"golang.org/x/oauth2"
 )
 
@@ -16,8 +27,19 @@ const (
 
 	acceptHeader          = "Accept"
 	acceptApplicationJSON = "application/json"
+
+	// JMESPathClaimPrefix marks a claim name as a JMESPath expression that
+	// should be evaluated against the claims rather than a claim key
+	JMESPathClaimPrefix = "jmespath:"
+
+	// JSONPathClaimPrefix marks a claim name as a JSONPath expression that
+	// should be evaluated against the claims rather than a claim key
+	JSONPathClaimPrefix = "jsonpath:"
 )
 
+// jsonPathExpressions caches compiled JSONPath claim expressions
+var jsonPathExpressions sync.Map
+
 func makeAuthorizationHeader(prefix, token string, extraHeaders map[string]string) http.Header {
 	header := make(http.Header)
 	for key, value := range extraHeaders {
@@ -70,6 +92,39 @@ func getIDToken(token *oauth2.Token) string {
 	return idToken
 }
 
+// parseIDTokenClaims decodes the claims of an id_token without verifying it.
+// It must only be used on id_tokens that were verified when the session was
+// created.
+func parseIDTokenClaims(rawIDToken string) (map[string]interface{}, error) {
+	return parseJWTClaims(rawIDToken, "id_token")
+}
+
+// parseJWTClaims decodes the claims of a JWT without verifying it. The name
+// of the token is used in the errors.
+func parseJWTClaims(rawToken, name string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed %s: expected 3 parts, got %d", name, len(parts))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s payload: %v", name, err)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse %s claims: %v", name, err)
+	}
+	return claims, nil
+}
+
+// isEmailAddress is true when the value is a bare email address, without a
+// display name
+func isEmailAddress(value string) bool {
+	addr, err := mail.ParseAddress(value)
+	return err == nil && addr.Address == value
+}
+
 // formatGroup coerces an OIDC groups claim into a string
 // If it is non-string, marshal it into JSON.
 func formatGroup(rawGroup interface{}) (string, error) {
@@ -84,17 +139,278 @@ func formatGroup(rawGroup interface{}) (string, error) {
 	return string(jsonGroup), nil
 }
 
-// coerceArray extracts a field from simplejson.Json that might be a
-// singleton or a list and coerces it into a list.
-func coerceArray(sj *simplejson.Json, key string) []interface{} {
-	array, err := sj.Get(key).Array()
-	if err == nil {
-		return array
+// getClaimSlice looks up a claim with getClaim and coerces it into a list of
+// strings. A list claim has each value formatted with formatGroup and any
+// other value becomes a single element list. It returns nil and false if the
+// claim doesn't exist.
+func getClaimSlice(claims map[string]interface{}, claim string) ([]string, bool, error) {
+	rawClaim, ok := getClaim(claims, claim)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var values []string
+	if err := coerceClaim(rawClaim, &values); err != nil {
+		return nil, true, err
+	}
+	return values, true, nil
+}
+
+// coerceClaim converts a raw claim value into the destination, which must
+// be a *string, *[]string, *bool, *int, *int64, *float64 or *time.Time.
+// Strings and lists are formatted as in formatGroup and getClaimSlice,
+// numbers may be JSON numbers or numeric strings and times are parsed with
+// coerceTimeClaim. The destination is left unchanged if the value can't be
+// converted.
+func coerceClaim(rawClaim interface{}, dst interface{}) error {
+	switch d := dst.(type) {
+	case *string:
+		value, err := formatGroup(rawClaim)
+		if err != nil {
+			return fmt.Errorf("unable to format value of type %s: %v", reflect.TypeOf(rawClaim), err)
+		}
+		*d = value
+	case *[]string:
+		values, err := coerceClaimSlice(rawClaim)
+		if err != nil {
+			return err
+		}
+		*d = values
+	case *bool:
+		value, err := cast.ToBoolE(rawClaim)
+		if err != nil {
+			return err
+		}
+		*d = value
+	case *int:
+		value, err := cast.ToIntE(rawClaim)
+		if err != nil {
+			return err
+		}
+		*d = value
+	case *int64:
+		value, err := cast.ToInt64E(rawClaim)
+		if err != nil {
+			return err
+		}
+		*d = value
+	case *float64:
+		value, err := cast.ToFloat64E(rawClaim)
+		if err != nil {
+			return err
+		}
+		*d = value
+	case *time.Time:
+		value, err := coerceTimeClaim(rawClaim)
+		if err != nil {
+			return err
+		}
+		*d = value
+	default:
+		return fmt.Errorf("unsupported claim destination of type %T", dst)
+	}
+	return nil
+}
+
+// coerceTimeClaim parses a timestamp claim such as `auth_time` or `iat`.
+// Numbers and numeric strings are Unix seconds, other strings are parsed as
+// RFC3339, falling back to RFC3339Nano.
+func coerceTimeClaim(rawClaim interface{}) (time.Time, error) {
+	switch raw := rawClaim.(type) {
+	case nil, bool:
+		return time.Time{}, fmt.Errorf("unable to convert value of type %T to a time", rawClaim)
+	case string:
+		if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+			return unixSeconds(seconds), nil
+		}
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to parse %q as a Unix timestamp or RFC3339 date", raw)
+		}
+		return t, nil
+	default:
+		seconds, err := cast.ToFloat64E(raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to convert value of type %T to a time", rawClaim)
+		}
+		return unixSeconds(seconds), nil
+	}
+}
+
+// unixSeconds converts fractional Unix seconds into a time
+func unixSeconds(seconds float64) time.Time {
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64((seconds-whole)*float64(time.Second)))
+}
+
+// coerceClaimSlice formats each value of a list claim, any other value
+// becomes a single element list
+func coerceClaimSlice(rawClaim interface{}) ([]string, error) {
+	var rawValues []interface{}
+	switch raw := rawClaim.(type) {
+	case []interface{}:
+		rawValues = raw
+	case interface{}:
+		rawValues = []interface{}{raw}
+	}
+
+	values := make([]string, 0, len(rawValues))
+	for _, rawValue := range rawValues {
+		value, err := formatGroup(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("unable to format value of type %s: %v", reflect.TypeOf(rawValue), err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// getClaim looks up a claim by name from a set of claims.
+// If no claim exists with the literal name and the name contains a `.`
+// (eg. `resource_access.my-client.roles`), it is treated as a path into
+// nested claim objects. Numeric path segments index into arrays
+// (eg. `addresses.0.locality`). If any segment along the path is missing, or
+// can't be descended into, the claim is reported as not existing.
+// Claim names prefixed with `jmespath:` or `jsonpath:` are evaluated as
+// JMESPath or JSONPath expressions respectively.
+func getClaim(claims map[string]interface{}, claim string) (interface{}, bool) {
+	if strings.HasPrefix(claim, JMESPathClaimPrefix) {
+		value, err := searchClaims(claims, strings.TrimPrefix(claim, JMESPathClaimPrefix))
+		if err != nil {
+			logger.Errorf("Warning: unable to evaluate claim expression %q: %v", claim, err)
+			return nil, false
+		}
+		return value, value != nil
+	}
+
+	if strings.HasPrefix(claim, JSONPathClaimPrefix) {
+		value, err := evaluateJSONPath(claims, strings.TrimPrefix(claim, JSONPathClaimPrefix))
+		if err != nil {
+			logger.Errorf("Warning: unable to evaluate claim expression %q: %v", claim, err)
+			return nil, false
+		}
+		return value, value != nil
+	}
+
+	if value, exists := claims[claim]; exists || !strings.Contains(claim, ".") {
+		return value, exists
+	}
+
+	var current interface{} = claims
+	for _, segment := range strings.Split(claim, ".") {
+		switch obj := current.(type) {
+		case map[string]interface{}:
+			value, exists := obj[segment]
+			if !exists {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(obj) {
+				return nil, false
+			}
+			current = obj[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// searchClaims evaluates a JMESPath expression against a set of claims.
+// Malformed expressions are reported as errors rather than panics.
+func searchClaims(claims map[string]interface{}, expression string) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			value = nil
+			err = fmt.Errorf("invalid expression: %v", r)
+		}
+	}()
+	return jmespath.Search(expression, claims)
+}
+
+// evaluateJSONPath evaluates a JSONPath expression against a set of claims.
+// Expressions matching multiple nodes return a list, expressions matching
+// a single node return that node's value.
+func evaluateJSONPath(claims map[string]interface{}, expression string) (interface{}, error) {
+	expr, err := compileJSONPath(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := expr.Get(claims)
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	default:
+		return nodes, nil
 	}
+}
 
-	single := sj.Get(key).Interface()
-	if single == nil {
+// compileJSONPath compiles a JSONPath expression, caching the result
+func compileJSONPath(expression string) (jp.Expr, error) {
+	if cached, ok := jsonPathExpressions.Load(expression); ok {
+		return cached.(jp.Expr), nil
+	}
+
+	expr, err := jp.ParseString(expression)
+	if err != nil {
+		return nil, err
+	}
+	jsonPathExpressions.Store(expression, expr)
+	return expr, nil
+}
+
+// ValidateClaimExpression checks that a claim name prefixed as a JMESPath or
+// JSONPath expression can be compiled. Plain claim names are always valid.
+func ValidateClaimExpression(claim string) error {
+	switch {
+	case strings.HasPrefix(claim, JMESPathClaimPrefix):
+		expression := strings.TrimPrefix(claim, JMESPathClaimPrefix)
+		_, err := compileJMESPath(expression)
+		return err
+	case strings.HasPrefix(claim, JSONPathClaimPrefix):
+		_, err := compileJSONPath(strings.TrimPrefix(claim, JSONPathClaimPrefix))
+		return err
+	default:
 		return nil
 	}
-	return []interface{}{single}
+}
+
+// compileJMESPath compiles a JMESPath expression.
+// Malformed expressions are reported as errors rather than panics.
+func compileJMESPath(expression string) (compiled *jmespath.JMESPath, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			compiled = nil
+			err = fmt.Errorf("invalid expression: %v", r)
+		}
+	}()
+	return jmespath.Compile(expression)
+}
+
+// jsonTypeName returns the name of the JSON type of a decoded JSON value
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
 }
@@ -2,7 +2,9 @@ package providers
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"golang.org/x/oauth2"
@@ -111,3 +113,458 @@ func Test_formatGroup(t *testing.T) {
 		})
 	}
 }
+
+func Test_getClaim(t *testing.T) {
+	claims := map[string]interface{}{
+		"email": "flat@example.com",
+		"address": map[string]interface{}{
+			"country":     "US",
+			"postal_code": "10001",
+		},
+		"resource_access": map[string]interface{}{
+			"my-client": map[string]interface{}{
+				"roles": []interface{}{"admin", "user"},
+			},
+		},
+		"addresses": []interface{}{
+			map[string]interface{}{
+				"locality": "New York",
+			},
+		},
+		"profile": map[string]interface{}{
+			"contact": map[string]interface{}{
+				"email": "nested@example.com",
+			},
+		},
+		"profile.contact.email": "literal@example.com",
+		"roles": []interface{}{
+			map[string]interface{}{"resource": "app", "name": "admin"},
+			map[string]interface{}{"resource": "other", "name": "user"},
+			map[string]interface{}{"resource": "app", "name": "viewer"},
+		},
+	}
+
+	testCases := map[string]struct {
+		claim          string
+		expectedValue  interface{}
+		expectedExists bool
+	}{
+		"Flat Claim": {
+			claim:          "email",
+			expectedValue:  "flat@example.com",
+			expectedExists: true,
+		},
+		"Missing Flat Claim": {
+			claim:          "name",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"Two Level Nesting": {
+			claim:          "address.country",
+			expectedValue:  "US",
+			expectedExists: true,
+		},
+		"Three Level Nesting": {
+			claim:          "resource_access.my-client.roles",
+			expectedValue:  []interface{}{"admin", "user"},
+			expectedExists: true,
+		},
+		"Nested Object": {
+			claim: "resource_access.my-client",
+			expectedValue: map[string]interface{}{
+				"roles": []interface{}{"admin", "user"},
+			},
+			expectedExists: true,
+		},
+		"Missing Intermediate Key": {
+			claim:          "resource_access.other-client.roles",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"Missing Leaf Key": {
+			claim:          "address.locality",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"Array At Intermediate Level": {
+			claim:          "addresses.locality",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"Array Index In Path": {
+			claim:          "addresses.0.locality",
+			expectedValue:  "New York",
+			expectedExists: true,
+		},
+		"Array Index Out Of Range": {
+			claim:          "addresses.1.locality",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"Negative Array Index": {
+			claim:          "addresses.-1.locality",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"Literal Dotted Key Wins": {
+			claim:          "profile.contact.email",
+			expectedValue:  "literal@example.com",
+			expectedExists: true,
+		},
+		"Path Through Non Object": {
+			claim:          "email.domain",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"JMESPath Flattened Projection": {
+			claim:          "jmespath:resource_access.*.roles[]",
+			expectedValue:  []interface{}{"admin", "user"},
+			expectedExists: true,
+		},
+		"JMESPath Array Index": {
+			claim:          "jmespath:addresses[0].locality",
+			expectedValue:  "New York",
+			expectedExists: true,
+		},
+		"JMESPath No Match": {
+			claim:          "jmespath:profile.email",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"JSONPath Filter Multiple Nodes": {
+			claim:          "jsonpath:$.roles[?(@.resource=='app')].name",
+			expectedValue:  []interface{}{"admin", "viewer"},
+			expectedExists: true,
+		},
+		"JSONPath Filter Single Node": {
+			claim:          "jsonpath:$.roles[?(@.resource=='other')].name",
+			expectedValue:  "user",
+			expectedExists: true,
+		},
+		"JSONPath Scalar": {
+			claim:          "jsonpath:$.address.country",
+			expectedValue:  "US",
+			expectedExists: true,
+		},
+		"JSONPath No Match": {
+			claim:          "jsonpath:$.roles[?(@.resource=='none')].name",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"JSONPath Missing Key": {
+			claim:          "jsonpath:$.profile.phone",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+		"JMESPath Malformed Expression": {
+			claim:          "jmespath:resource_access.[",
+			expectedValue:  nil,
+			expectedExists: false,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			value, exists := getClaim(claims, tc.claim)
+			g.Expect(exists).To(Equal(tc.expectedExists))
+			if nodes, ok := tc.expectedValue.([]interface{}); ok {
+				// Node ordering isn't guaranteed for filtered expressions
+				g.Expect(value).To(ConsistOf(nodes...))
+			} else if tc.expectedValue != nil {
+				g.Expect(value).To(Equal(tc.expectedValue))
+			} else {
+				g.Expect(value).To(BeNil())
+			}
+		})
+	}
+}
+
+func Test_getClaimSlice(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups":  []interface{}{"admins", "users"},
+		"role":    "admin",
+		"level":   float64(3),
+		"empty":   []interface{}{},
+		"null":    nil,
+		"complex": []interface{}{map[string]interface{}{"id": "a"}},
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"offline_access"},
+		},
+	}
+
+	testCases := map[string]struct {
+		claim          string
+		expectedValues []string
+		expectedExists bool
+	}{
+		"List Claim": {
+			claim:          "groups",
+			expectedValues: []string{"admins", "users"},
+			expectedExists: true,
+		},
+		"Scalar String Claim": {
+			claim:          "role",
+			expectedValues: []string{"admin"},
+			expectedExists: true,
+		},
+		"Scalar Number Claim": {
+			claim:          "level",
+			expectedValues: []string{"3"},
+			expectedExists: true,
+		},
+		"Empty List Claim": {
+			claim:          "empty",
+			expectedValues: []string{},
+			expectedExists: true,
+		},
+		"Null Claim": {
+			claim:          "null",
+			expectedValues: []string{},
+			expectedExists: true,
+		},
+		"Complex List Claim": {
+			claim:          "complex",
+			expectedValues: []string{`{"id":"a"}`},
+			expectedExists: true,
+		},
+		"Nested Claim": {
+			claim:          "realm_access.roles",
+			expectedValues: []string{"offline_access"},
+			expectedExists: true,
+		},
+		"Missing Claim": {
+			claim:          "missing",
+			expectedValues: nil,
+			expectedExists: false,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			values, exists, err := getClaimSlice(claims, tc.claim)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(values).To(Equal(tc.expectedValues))
+			g.Expect(exists).To(Equal(tc.expectedExists))
+		})
+	}
+}
+
+func Test_coerceClaim(t *testing.T) {
+	testCases := map[string]struct {
+		rawClaim      interface{}
+		dst           interface{}
+		expected      interface{}
+		expectedError string
+	}{
+		"JSON Number To Int": {
+			rawClaim: float64(1600000000),
+			dst:      new(int),
+			expected: 1600000000,
+		},
+		"Numeric String To Int": {
+			rawClaim: "42",
+			dst:      new(int),
+			expected: 42,
+		},
+		"Non Numeric String To Int": {
+			rawClaim:      "yesterday",
+			dst:           new(int),
+			expected:      0,
+			expectedError: "unable to cast \"yesterday\" of type string to int",
+		},
+		"JSON Number To Int64": {
+			rawClaim: float64(1600000000),
+			dst:      new(int64),
+			expected: int64(1600000000),
+		},
+		"Numeric String To Int64": {
+			rawClaim: "1600000000",
+			dst:      new(int64),
+			expected: int64(1600000000),
+		},
+		"Non Numeric String To Int64": {
+			rawClaim:      "yesterday",
+			dst:           new(int64),
+			expected:      int64(0),
+			expectedError: "unable to cast \"yesterday\" of type string to int64",
+		},
+		"JSON Number To Float64": {
+			rawClaim: 1.5,
+			dst:      new(float64),
+			expected: 1.5,
+		},
+		"Numeric String To Float64": {
+			rawClaim: "1.5",
+			dst:      new(float64),
+			expected: 1.5,
+		},
+		"Non Numeric String To Float64": {
+			rawClaim:      "one and a half",
+			dst:           new(float64),
+			expected:      float64(0),
+			expectedError: "unable to cast \"one and a half\" of type string to float64",
+		},
+		"Bool String To Bool": {
+			rawClaim: "true",
+			dst:      new(bool),
+			expected: true,
+		},
+		"Number To String": {
+			rawClaim: float64(3),
+			dst:      new(string),
+			expected: "3",
+		},
+		"Scalar To Slice": {
+			rawClaim: "admin",
+			dst:      new([]string),
+			expected: []string{"admin"},
+		},
+		"JSON Number To Time": {
+			rawClaim: float64(1600000000),
+			dst:      new(time.Time),
+			expected: time.Unix(1600000000, 0),
+		},
+		"Fractional JSON Number To Time": {
+			rawClaim: 1600000000.5,
+			dst:      new(time.Time),
+			expected: time.Unix(1600000000, int64(500*time.Millisecond)),
+		},
+		"Integer To Time": {
+			rawClaim: int64(1600000000),
+			dst:      new(time.Time),
+			expected: time.Unix(1600000000, 0),
+		},
+		"Numeric String To Time": {
+			rawClaim: "1600000000",
+			dst:      new(time.Time),
+			expected: time.Unix(1600000000, 0),
+		},
+		"RFC3339 String To Time": {
+			rawClaim: "2020-09-13T12:26:40Z",
+			dst:      new(time.Time),
+			expected: time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC),
+		},
+		"RFC3339Nano String To Time": {
+			rawClaim: "2020-09-13T12:26:40.123456789Z",
+			dst:      new(time.Time),
+			expected: time.Date(2020, 9, 13, 12, 26, 40, 123456789, time.UTC),
+		},
+		"Non Date String To Time": {
+			rawClaim:      "yesterday",
+			dst:           new(time.Time),
+			expected:      time.Time{},
+			expectedError: "unable to parse \"yesterday\" as a Unix timestamp or RFC3339 date",
+		},
+		"Bool To Time": {
+			rawClaim:      true,
+			dst:           new(time.Time),
+			expected:      time.Time{},
+			expectedError: "unable to convert value of type bool to a time",
+		},
+		"Unsupported Destination": {
+			rawClaim:      "admin",
+			dst:           new(uint),
+			expected:      uint(0),
+			expectedError: "unsupported claim destination of type *uint",
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			err := coerceClaim(tc.rawClaim, tc.dst)
+			if tc.expectedError != "" {
+				g.Expect(err).To(MatchError(tc.expectedError))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(reflect.ValueOf(tc.dst).Elem().Interface()).To(Equal(tc.expected))
+		})
+	}
+}
+
+func Test_searchClaimsMalformedExpressions(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups": []interface{}{"a", "b"},
+		"resource_access": map[string]interface{}{
+			"my-client": map[string]interface{}{
+				"roles": []interface{}{"admin"},
+			},
+		},
+	}
+
+	expressions := []string{
+		"",
+		"[",
+		"]",
+		"groups[",
+		"groups[?",
+		"groups[?@ ==",
+		"resource_access.*.roles[",
+		"resource_access.{",
+		"foo.bar.",
+		"`",
+		"'unterminated",
+		"groups | | groups",
+		"&&",
+		"[*].[*].[",
+		"length(",
+		"unknown_function(groups)",
+		"groups[0:0:0]",
+		"\x00\xff",
+	}
+
+	for _, expression := range expressions {
+		t.Run(expression, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(func() {
+				value, err := searchClaims(claims, expression)
+				if err != nil {
+					g.Expect(value).To(BeNil())
+				}
+			}).ToNot(Panic())
+		})
+	}
+}
+
+func Test_ValidateClaimExpression(t *testing.T) {
+	testCases := map[string]struct {
+		claim       string
+		expectError bool
+	}{
+		"Plain Claim": {
+			claim:       "groups",
+			expectError: false,
+		},
+		"Valid JMESPath": {
+			claim:       "jmespath:resource_access.*.roles[]",
+			expectError: false,
+		},
+		"Invalid JMESPath": {
+			claim:       "jmespath:resource_access.*.roles[",
+			expectError: true,
+		},
+		"Valid JSONPath": {
+			claim:       "jsonpath:$.roles[?(@.resource=='app')].name",
+			expectError: false,
+		},
+		"Invalid JSONPath": {
+			claim:       "jsonpath:$.roles[?(@.resource=='app'",
+			expectError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			g := NewWithT(t)
+			err := ValidateClaimExpression(tc.claim)
+			if tc.expectError {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}